@@ -0,0 +1,169 @@
+// Package api defines request/response pairs of every service,
+// services register handlers and callers send requests using the same Endpoint
+package api
+
+import (
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+// Client service endpoints
+var (
+	ClientAdd    = rpc.NewEndpoint[model.Client, rpc.Empty]("client.add", "client-queue")
+	ClientGet    = rpc.NewEndpoint[string, model.Client]("client.get", "client-queue")
+	ClientUpdate = rpc.NewEndpoint[model.Client, rpc.Empty]("client.update", "client-queue")
+	ClientList   = rpc.NewEndpoint[rpc.Empty, []model.Client]("client.list", "client-queue")
+	ClientDelete = rpc.NewEndpoint[string, rpc.Empty]("client.delete", "client-queue")
+
+	// ClientReserve withdraws funds for the Task being created
+	ClientReserve = rpc.NewEndpoint[model.Reservation, model.Reservation]("client.reserve", "client-queue")
+	// ClientConfirm confirms Reservation by ID once the Task is created
+	ClientConfirm = rpc.NewEndpoint[string, model.Reservation]("client.confirm", "client-queue")
+	// ClientRelease returns reserved funds when the Task could not be created
+	ClientRelease = rpc.NewEndpoint[model.Reservation, model.Reservation]("client.release", "client-queue")
+)
+
+// Freelancer service endpoints
+var (
+	FreelancerAdd    = rpc.NewEndpoint[model.Freelancer, rpc.Empty]("freelancer.add", "freelancer-queue")
+	FreelancerGet    = rpc.NewEndpoint[string, model.Freelancer]("freelancer.get", "freelancer-queue")
+	FreelancerUpdate = rpc.NewEndpoint[model.Freelancer, rpc.Empty]("freelancer.update", "freelancer-queue")
+	FreelancerList   = rpc.NewEndpoint[rpc.Empty, []model.Freelancer]("freelancer.list", "freelancer-queue")
+	FreelancerDelete = rpc.NewEndpoint[string, rpc.Empty]("freelancer.delete", "freelancer-queue")
+	// FreelancerSkills replaces Skills declared by the Freelancer by ID
+	FreelancerSkills = rpc.NewEndpoint[model.Freelancer, rpc.Empty]("freelancer.skills", "freelancer-queue")
+	// FreelancerSearch finds Freelancers by declared Skills, proficiency and Category
+	FreelancerSearch = rpc.NewEndpoint[model.FreelancerQuery, []model.Freelancer]("freelancer.search", "freelancer-queue")
+)
+
+// Skill service endpoints, the taxonomy of Categories and Skills
+var (
+	CategoryList = rpc.NewEndpoint[rpc.Empty, []model.Category]("category.list", "skill-queue")
+	// CategoryAdd creates the Category or renames existing one
+	CategoryAdd = rpc.NewEndpoint[model.Category, model.Category]("category.add", "skill-queue")
+	// SkillList returns Skills of the Category by ID, empty ID returns every Skill
+	SkillList = rpc.NewEndpoint[string, []model.Skill]("skill.list", "skill-queue")
+	// SkillAdd creates the Skill or updates existing one
+	SkillAdd = rpc.NewEndpoint[model.Skill, model.Skill]("skill.add", "skill-queue")
+)
+
+// Task service endpoints
+var (
+	TaskAdd    = rpc.NewEndpoint[model.Task, rpc.Empty]("task.add", "task-queue")
+	TaskGet    = rpc.NewEndpoint[string, model.Task]("task.get", "task-queue")
+	TaskUpdate = rpc.NewEndpoint[model.Task, rpc.Empty]("task.update", "task-queue")
+	TaskList   = rpc.NewEndpoint[string, []model.Task]("task.list", "task-queue")
+	TaskDelete = rpc.NewEndpoint[string, rpc.Empty]("task.delete", "task-queue")
+	TaskCharge = rpc.NewEndpoint[model.Charge, model.Payment]("task.charge", "task-queue")
+	// TaskSearch finds open Tasks by text of description, tags, category, skills, fee and deadline
+	TaskSearch = rpc.NewEndpoint[model.TaskQuery, model.TaskSearchResult]("task.search", "task-queue")
+	// TaskForceClose closes the Task whatever its status, funds held for it are paid to Freelancer
+	TaskForceClose = rpc.NewEndpoint[model.TaskAction, model.Task]("task.force_close", "task-queue")
+	// TaskRefund closes the Task returning funds held for it to Client
+	TaskRefund = rpc.NewEndpoint[model.TaskAction, model.Task]("task.refund", "task-queue")
+)
+
+// Invoice service endpoints
+var (
+	InvoiceGet  = rpc.NewEndpoint[string, model.Invoice]("invoice.get", "invoice-queue")
+	InvoiceList = rpc.NewEndpoint[string, []model.Invoice]("invoice.list", "invoice-queue")
+)
+
+// Dispute service endpoints
+var (
+	DisputeOpen      = rpc.NewEndpoint[model.Dispute, rpc.Empty]("dispute.open", "dispute-queue")
+	DisputeGet       = rpc.NewEndpoint[string, model.Dispute]("dispute.get", "dispute-queue")
+	DisputeList      = rpc.NewEndpoint[string, []model.Dispute]("dispute.list", "dispute-queue")
+	DisputeStatement = rpc.NewEndpoint[model.DisputeStatement, rpc.Empty]("dispute.statement", "dispute-queue")
+	DisputeResolve   = rpc.NewEndpoint[model.Dispute, rpc.Empty]("dispute.resolve", "dispute-queue")
+)
+
+// Wallet service endpoints
+var (
+	WalletList = rpc.NewEndpoint[string, []model.Wallet]("wallet.list", "wallet-queue")
+	// WalletAdjust credits or withdraws funds of Client or Freelancer by operator's decision
+	WalletAdjust = rpc.NewEndpoint[model.Adjustment, model.Adjustment]("wallet.adjust", "wallet-queue")
+	// WalletAdjustments lists Adjustments of the account by owner ID, newest first
+	WalletAdjustments = rpc.NewEndpoint[string, []model.Adjustment]("wallet.adjustments", "wallet-queue")
+)
+
+// Audit service endpoints
+var (
+	// AuditList returns entries of the audit log matching the query, newest first
+	AuditList = rpc.NewEndpoint[model.AuditQuery, []model.AuditEntry]("audit.list", "audit-queue")
+)
+
+// Timesheet service endpoints
+var (
+	TimesheetLog     = rpc.NewEndpoint[model.TimeEntry, rpc.Empty]("timesheet.log", "timesheet-queue")
+	TimesheetGet     = rpc.NewEndpoint[model.Timesheet, model.Timesheet]("timesheet.get", "timesheet-queue")
+	TimesheetApprove = rpc.NewEndpoint[model.Timesheet, rpc.Empty]("timesheet.approve", "timesheet-queue")
+	TimesheetReject  = rpc.NewEndpoint[model.Timesheet, rpc.Empty]("timesheet.reject", "timesheet-queue")
+	TimesheetBill    = rpc.NewEndpoint[time.Time, rpc.Empty]("timesheet.bill", "timesheet-queue")
+)
+
+// Webhook service endpoints
+var (
+	// WebhookAdd registers Client's Webhook, the response contains generated Secret
+	WebhookAdd  = rpc.NewEndpoint[model.Webhook, model.Webhook]("webhook.add", "webhook-queue")
+	WebhookList = rpc.NewEndpoint[string, []model.Webhook]("webhook.list", "webhook-queue")
+	// WebhookDelete stops deliveries to the Webhook by ID of the Client
+	WebhookDelete = rpc.NewEndpoint[model.WebhookAccess, rpc.Empty]("webhook.delete", "webhook-queue")
+	// WebhookDeliveries lists Deliveries of the Client's Webhook by ID along with their attempts, newest first
+	WebhookDeliveries = rpc.NewEndpoint[model.WebhookAccess, []model.Delivery]("webhook.deliveries", "webhook-queue")
+	// WebhookReplay schedules Delivery by ID to the Client's Webhook to be sent again
+	WebhookReplay = rpc.NewEndpoint[model.WebhookAccess, model.Delivery]("webhook.replay", "webhook-queue")
+)
+
+// Message service endpoints
+var (
+	// MessageSend sends Message to the other party of the Task
+	MessageSend = rpc.NewEndpoint[model.Message, model.Message]("message.send", "message-queue")
+	// MessageList returns Thread of the Task by TaskID as seen by UserID
+	MessageList = rpc.NewEndpoint[model.Thread, model.Thread]("message.list", "message-queue")
+	// MessageRead marks Messages of the Thread sent to UserID as read
+	MessageRead = rpc.NewEndpoint[model.Thread, rpc.Empty]("message.read", "message-queue")
+	// MessageUnread returns Threads of the user by ID that have unread Messages, without Messages
+	MessageUnread = rpc.NewEndpoint[string, []model.Thread]("message.unread", "message-queue")
+)
+
+// Attachment service endpoints, content of the files is kept in blob storage by the gateway
+var (
+	// AttachmentAuthorize checks that UploaderID may attach Attachment of the Kind to the Task before its content is stored
+	AttachmentAuthorize = rpc.NewEndpoint[model.Attachment, rpc.Empty]("attachment.authorize", "attachment-queue")
+	// AttachmentAdd records Attachment uploaded by UploaderID
+	AttachmentAdd = rpc.NewEndpoint[model.Attachment, model.Attachment]("attachment.add", "attachment-queue")
+	// AttachmentList returns Attachments of the Task by TaskID to UserID, oldest first
+	AttachmentList = rpc.NewEndpoint[model.AttachmentAccess, []model.Attachment]("attachment.list", "attachment-queue")
+	// AttachmentGet returns Attachment by ID to UserID
+	AttachmentGet = rpc.NewEndpoint[model.AttachmentAccess, model.Attachment]("attachment.get", "attachment-queue")
+)
+
+// Match service endpoints
+var (
+	// MatchFreelancers recommends Freelancers for the open Task by ID, best Match first
+	MatchFreelancers = rpc.NewEndpoint[model.MatchQuery, []model.Match]("match.freelancers", "match-queue")
+	// MatchTasks recommends open Tasks to the Freelancer by ID, best Match first
+	MatchTasks = rpc.NewEndpoint[model.MatchQuery, []model.Match]("match.tasks", "match-queue")
+	// RatingAdd records Rating given by Client to Freelancer of the closed Task
+	RatingAdd = rpc.NewEndpoint[model.Rating, model.Rating]("rating.add", "match-queue")
+)
+
+// NotificationSubject returns NATS subject new Notifications of the user by ID are published on as JSON
+func NotificationSubject(userID string) string {
+	return "notifications." + userID
+}
+
+// Notification service endpoints
+var (
+	// EmailPreferencesGet returns EmailPreferences of the Client or Freelancer by ID
+	EmailPreferencesGet = rpc.NewEndpoint[string, model.EmailPreferences]("notify.preferences.get", "notify-queue")
+	// EmailPreferencesSet replaces EmailPreferences of the user
+	EmailPreferencesSet = rpc.NewEndpoint[model.EmailPreferences, model.EmailPreferences]("notify.preferences.set", "notify-queue")
+	// NotificationList returns page of the user's in-app Notifications, the latest first
+	NotificationList = rpc.NewEndpoint[model.NotificationQuery, model.NotificationPage]("notification.list", "notify-queue")
+	// NotificationRead marks Notifications of the user as read
+	NotificationRead = rpc.NewEndpoint[model.NotificationRead, rpc.Empty]("notification.read", "notify-queue")
+)
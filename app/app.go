@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/jetstream"
+	"github.com/kylycht/md/migrate"
+	"github.com/kylycht/md/pb"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/storage"
+	"github.com/nats-io/nats-server/v2/server"
+	nats "github.com/nats-io/nats.go"
+
+	// postgres driver
+	_ "github.com/lib/pq"
+)
+
+// OpenDB connects to the database, creates missing tables and applies pending migrations
+func OpenDB(ds string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("postgres", ds)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := InitDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := migrate.Up(context.Background(), db, Migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// RunNATS starts embedded NATS server and waits until it accepts connections
+func RunNATS(cfg NATSConfig) (*server.Server, error) {
+	srv, err := server.NewServer(&server.Options{
+		Host:      cfg.Host,
+		Port:      cfg.Port,
+		JetStream: cfg.JetStream,
+		StoreDir:  cfg.StoreDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	srv.ConfigureLogger()
+	go srv.Start()
+	if !srv.ReadyForConnections(time.Second * 10) {
+		srv.Shutdown()
+		return nil, errors.New("nats server is not ready")
+	}
+	return srv, nil
+}
+
+// Connect connects to NATS server, in JetStream mode the streams are created
+// and JetStream context is returned, otherwise it is nil.
+// Requests are encoded with configured encoding, replies use encoding of the request
+func Connect(cfg Config) (*nats.EncodedConn, nats.JetStreamContext, error) {
+	conn, err := nats.Connect(cfg.NATS, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, nil, err
+	}
+	encoder := nats.JSON_ENCODER
+	if cfg.Encoding == EncodingProtobuf {
+		encoder = pb.EncoderName
+		rpc.SetCodec(pb.Codec{})
+	}
+	encConn, err := nats.NewEncodedConn(conn, encoder)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !cfg.JetStream {
+		return encConn, nil, nil
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := jetstream.Setup(js); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return encConn, js, nil
+}
+
+// RunRelay starts outbox relay publishing domain events written by the services,
+// relays of several processes share the outbox safely
+func RunRelay(db *sqlx.DB, conn *nats.EncodedConn, js nats.JetStreamContext, interval time.Duration) *events.Relay {
+	var publisher events.Publisher = events.NewNATSPublisher(conn.Conn)
+	if js != nil {
+		publisher = jetstream.NewPublisher(js)
+	}
+	relay := events.NewRelay(db, publisher, interval)
+	go relay.Run()
+	return relay
+}
+
+// OpenBlobStore returns S3 store when bucket is configured, local store otherwise
+func OpenBlobStore(cfg StorageConfig) (storage.BlobStore, error) {
+	if cfg.Bucket != "" {
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  cfg.Endpoint,
+			Region:    cfg.Region,
+			Bucket:    cfg.Bucket,
+			AccessKey: cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
+		}, nil), nil
+	}
+	return storage.NewLocal(cfg.Dir)
+}
+
+// Wait blocks until the process is interrupted or terminated
+func Wait() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	signal.Stop(sig)
+}
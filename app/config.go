@@ -0,0 +1,203 @@
+// Package app wires services, database and NATS together,
+// commands in cmd/ run any subset of them in a single process
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/auth"
+)
+
+// Encodings of the requests sent over NATS
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Config represents settings shared by the commands, read from environment variables
+type Config struct {
+	// DB is postgres connection string(DB_CONN)
+	DB string
+	// NATS is URL of NATS server(NATS_URL)
+	NATS string
+	// JetStream enables durable commands and events(JETSTREAM)
+	JetStream bool
+	// OutboxInterval is how often the relay polls the outbox(OUTBOX_INTERVAL)
+	OutboxInterval time.Duration
+	// Encoding is encoding of requests sent over NATS, json or protobuf(NATS_ENCODING)
+	Encoding string
+}
+
+// LoadConfig reads shared settings from environment
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		DB:        Env("DB_CONN", "dbname=bar sslmode=disable"),
+		NATS:      Env("NATS_URL", "nats://127.0.0.1:4222"),
+		JetStream: EnvSet("JETSTREAM"),
+		Encoding:  Env("NATS_ENCODING", EncodingJSON),
+	}
+	if cfg.Encoding != EncodingJSON && cfg.Encoding != EncodingProtobuf {
+		return cfg, fmt.Errorf("unknown NATS_ENCODING %q", cfg.Encoding)
+	}
+	var err error
+	cfg.OutboxInterval, err = EnvDuration("OUTBOX_INTERVAL", time.Second)
+	return cfg, err
+}
+
+// NATSConfig represents settings of the embedded NATS server
+type NATSConfig struct {
+	// Host is interface the server listens on(NATS_HOST)
+	Host string
+	// Port is port the server listens on(NATS_PORT)
+	Port int
+	// JetStream enables JetStream(JETSTREAM)
+	JetStream bool
+	// StoreDir is directory streams are stored in(JETSTREAM_DIR)
+	StoreDir string
+}
+
+// LoadNATSConfig reads settings of the embedded NATS server from environment
+func LoadNATSConfig() (NATSConfig, error) {
+	cfg := NATSConfig{
+		Host:      Env("NATS_HOST", "127.0.0.1"),
+		JetStream: EnvSet("JETSTREAM"),
+		StoreDir:  Env("JETSTREAM_DIR", filepath.Join(os.TempDir(), "md-jetstream")),
+	}
+	var err error
+	cfg.Port, err = strconv.Atoi(Env("NATS_PORT", "4222"))
+	return cfg, err
+}
+
+// TaskConfig represents settings of the task service
+type TaskConfig struct {
+	// ReviewPeriod is period after which completed Task is closed, zero disables auto-approval(REVIEW_PERIOD)
+	ReviewPeriod time.Duration
+	// ReviewReminder is how long before review deadline Client is reminded(REVIEW_REMINDER)
+	ReviewReminder time.Duration
+	// BillingInterval is how often approved hours are billed(BILLING_INTERVAL)
+	BillingInterval time.Duration
+	// Rates is path of exchange rates file(FX_RATES)
+	Rates string
+}
+
+// LoadTaskConfig reads settings of the task service from environment
+func LoadTaskConfig() (TaskConfig, error) {
+	cfg := TaskConfig{Rates: Env("FX_RATES", "")}
+	var err error
+	if cfg.ReviewPeriod, err = EnvDuration("REVIEW_PERIOD", 0); err != nil {
+		return cfg, err
+	}
+	if cfg.ReviewReminder, err = EnvDuration("REVIEW_REMINDER", 0); err != nil {
+		return cfg, err
+	}
+	cfg.BillingInterval, err = EnvDuration("BILLING_INTERVAL", time.Hour)
+	return cfg, err
+}
+
+// NotifyConfig represents settings of email notifications
+type NotifyConfig struct {
+	// SMTPAddr is host:port of SMTP server, emails are only logged when it is not set(SMTP_ADDR)
+	SMTPAddr string
+	// SMTPFrom is sender's address(SMTP_FROM)
+	SMTPFrom string
+	// SMTPUser and SMTPPassword authenticate the sender, no authentication when user is not set(SMTP_USER, SMTP_PASSWORD)
+	SMTPUser     string
+	SMTPPassword string
+	// DeadlineReminder is how long before deadline of the started Task Freelancer is notified, zero disables it(DEADLINE_REMINDER)
+	DeadlineReminder time.Duration
+}
+
+// LoadNotifyConfig reads settings of email notifications from environment
+func LoadNotifyConfig() (NotifyConfig, error) {
+	cfg := NotifyConfig{
+		SMTPAddr:     Env("SMTP_ADDR", ""),
+		SMTPFrom:     Env("SMTP_FROM", "noreply@localhost"),
+		SMTPUser:     Env("SMTP_USER", ""),
+		SMTPPassword: Env("SMTP_PASSWORD", ""),
+	}
+	var err error
+	cfg.DeadlineReminder, err = EnvDuration("DEADLINE_REMINDER", time.Hour*24)
+	return cfg, err
+}
+
+// StorageConfig represents settings of blob storage of the gateway
+type StorageConfig struct {
+	// Dir is directory blobs are stored in when no bucket is set(BLOB_DIR)
+	Dir string
+	// Bucket enables S3-compatible storage(S3_BUCKET)
+	Bucket string
+	// Endpoint is URL of S3-compatible storage(S3_ENDPOINT)
+	Endpoint string
+	// Region is region requests to the storage are signed for(S3_REGION)
+	Region string
+	// AccessKey and SecretKey are credentials of the storage(S3_ACCESS_KEY, S3_SECRET_KEY)
+	AccessKey string
+	SecretKey string
+	// MaxUpload is maximum size of uploaded file in bytes, zero means default(UPLOAD_MAX_SIZE)
+	MaxUpload int64
+	// UploadTypes are media types accepted in uploads, empty means default(UPLOAD_TYPES)
+	UploadTypes []string
+}
+
+// LoadStorageConfig reads settings of blob storage from environment
+func LoadStorageConfig() (StorageConfig, error) {
+	cfg := StorageConfig{
+		Dir:       Env("BLOB_DIR", filepath.Join(os.TempDir(), "md-blobs")),
+		Bucket:    Env("S3_BUCKET", ""),
+		Endpoint:  Env("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		Region:    Env("S3_REGION", "us-east-1"),
+		AccessKey: Env("S3_ACCESS_KEY", ""),
+		SecretKey: Env("S3_SECRET_KEY", ""),
+	}
+	if types := Env("UPLOAD_TYPES", ""); types != "" {
+		cfg.UploadTypes = strings.Split(types, ",")
+	}
+	var err error
+	if v := Env("UPLOAD_MAX_SIZE", ""); v != "" {
+		cfg.MaxUpload, err = strconv.ParseInt(v, 10, 64)
+	}
+	return cfg, err
+}
+
+// AuthConfig represents settings of access tokens shared by the gateway and mdctl
+type AuthConfig struct {
+	// Secret signs and verifies access tokens, at least 32 bytes(AUTH_SECRET)
+	Secret []byte
+}
+
+// LoadAuthConfig reads settings of access tokens from environment, the secret is required
+func LoadAuthConfig() (AuthConfig, error) {
+	cfg := AuthConfig{Secret: []byte(Env("AUTH_SECRET", ""))}
+	if len(cfg.Secret) < auth.MinSecret {
+		return cfg, fmt.Errorf("AUTH_SECRET of at least %d bytes is required", auth.MinSecret)
+	}
+	return cfg, nil
+}
+
+// Env returns value of environment variable or def when it is not set
+func Env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// EnvSet reports whether environment variable is set
+func EnvSet(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
+}
+
+// EnvDuration returns duration from environment variable or def when it is not set
+func EnvDuration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,187 @@
+package app
+
+import "github.com/kylycht/md/migrate"
+
+// Migrations change the schema created by InitDB, new changes are appended with the next version
+var Migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "task search",
+		// the index expression must match the one used by task search to be used
+		Up: `ALTER TABLE TASK ADD COLUMN IF NOT EXISTS TAGS text[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS TASK_SEARCH ON TASK USING GIN (to_tsvector('english', COALESCE(DESCRIPTION, '')));
+CREATE INDEX IF NOT EXISTS TASK_TAGS ON TASK USING GIN (TAGS);
+CREATE INDEX IF NOT EXISTS TASK_OPEN ON TASK (CREATED_AT) WHERE STATUS = 'open' AND DELETED_AT IS NULL`,
+	},
+	{
+		Version: 2,
+		Name:    "skills taxonomy",
+		// Tasks refer to skills by ID in SKILLS array, the task service checks they exist
+		Up: `CREATE TABLE IF NOT EXISTS CATEGORY (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL
+);
+CREATE TABLE IF NOT EXISTS SKILL (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	CATEGORY_ID varchar(64) NOT NULL REFERENCES CATEGORY (ID)
+);
+CREATE INDEX IF NOT EXISTS SKILL_CATEGORY ON SKILL (CATEGORY_ID);
+CREATE TABLE IF NOT EXISTS FREELANCER_SKILL (
+	FREELANCER_ID varchar(36) NOT NULL,
+	SKILL_ID varchar(64) NOT NULL REFERENCES SKILL (ID),
+	LEVEL int NOT NULL CHECK (LEVEL BETWEEN 1 AND 3),
+	PRIMARY KEY (FREELANCER_ID, SKILL_ID)
+);
+CREATE INDEX IF NOT EXISTS FREELANCER_SKILL_LEVEL ON FREELANCER_SKILL (SKILL_ID, LEVEL);
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS CATEGORY varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS SKILLS text[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS TASK_SKILLS ON TASK USING GIN (SKILLS);
+INSERT INTO CATEGORY (ID, NAME) VALUES
+	('development', 'Development'),
+	('design', 'Design'),
+	('data', 'Data'),
+	('writing', 'Writing'),
+	('marketing', 'Marketing')
+ON CONFLICT (ID) DO NOTHING;
+INSERT INTO SKILL (ID, NAME, CATEGORY_ID) VALUES
+	('go', 'Go', 'development'),
+	('python', 'Python', 'development'),
+	('javascript', 'JavaScript', 'development'),
+	('typescript', 'TypeScript', 'development'),
+	('java', 'Java', 'development'),
+	('postgresql', 'PostgreSQL', 'development'),
+	('docker', 'Docker', 'development'),
+	('kubernetes', 'Kubernetes', 'development'),
+	('ios', 'iOS', 'development'),
+	('android', 'Android', 'development'),
+	('ui-design', 'UI Design', 'design'),
+	('ux-research', 'UX Research', 'design'),
+	('logo-design', 'Logo Design', 'design'),
+	('illustration', 'Illustration', 'design'),
+	('machine-learning', 'Machine Learning', 'data'),
+	('data-analysis', 'Data Analysis', 'data'),
+	('sql', 'SQL', 'data'),
+	('copywriting', 'Copywriting', 'writing'),
+	('technical-writing', 'Technical Writing', 'writing'),
+	('translation', 'Translation', 'writing'),
+	('seo', 'SEO', 'marketing'),
+	('social-media', 'Social Media', 'marketing')
+ON CONFLICT (ID) DO NOTHING`,
+	},
+	{
+		Version: 3,
+		Name:    "ratings",
+		// history of the freelancer is used in matching
+		Up: `CREATE TABLE IF NOT EXISTS RATING (
+	TASK_ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36) NOT NULL,
+	SCORE smallint NOT NULL CHECK (SCORE BETWEEN 1 AND 5),
+	CREATED_AT timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS RATING_FREELANCER ON RATING (FREELANCER_ID);
+CREATE INDEX IF NOT EXISTS TASK_FREELANCER ON TASK (FREELANCER_ID)`,
+	},
+	{
+		Version: 4,
+		Name:    "email notifications",
+		// the unique key keeps users from being emailed twice about the same event or deadline
+		Up: `CREATE TABLE IF NOT EXISTS EMAIL_LOG (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	KIND varchar(32) NOT NULL,
+	REF varchar(36) NOT NULL,
+	USER_ID varchar(36) NOT NULL,
+	EMAIL varchar(128) NOT NULL,
+	SUBJECT text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	SENT_AT timestamp,
+	UNIQUE (KIND, REF, USER_ID)
+);
+CREATE TABLE IF NOT EXISTS EMAIL_PREFERENCE (
+	USER_ID varchar(36) PRIMARY KEY NOT NULL,
+	OPT_OUT text[] NOT NULL DEFAULT '{}'
+)`,
+	},
+	{
+		Version: 5,
+		Name:    "notification inbox",
+		// the inbox is listed by user, the latest first
+		Up: `CREATE TABLE IF NOT EXISTS NOTIFICATION (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	USER_ID varchar(36) NOT NULL,
+	KIND varchar(32) NOT NULL,
+	REF varchar(36) NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	TITLE text NOT NULL,
+	TEXT text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	READ_AT timestamp,
+	UNIQUE (KIND, REF, USER_ID)
+);
+CREATE INDEX IF NOT EXISTS NOTIFICATION_INBOX ON NOTIFICATION (USER_ID, CREATED_AT DESC);
+CREATE INDEX IF NOT EXISTS NOTIFICATION_UNREAD ON NOTIFICATION (USER_ID) WHERE READ_AT IS NULL`,
+	},
+	{
+		Version: 6,
+		Name:    "balance adjustments",
+		// adjustments are the audit trail of balances changed by operators, rows are never updated
+		Up: `CREATE TABLE IF NOT EXISTS BALANCE_ADJUSTMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER varchar(16) NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	AMOUNT MONEY_AMOUNT NOT NULL,
+	REASON text NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	CREATED_AT timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS BALANCE_ADJUSTMENT_OWNER ON BALANCE_ADJUSTMENT (OWNER_ID, CREATED_AT DESC)`,
+	},
+	{
+		Version: 7,
+		Name:    "audit log",
+		// the log is append-only, the trigger rejects updates and deletes of entries even by the services
+		Up: `CREATE TABLE IF NOT EXISTS AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS AUDIT_LOG_ENTITY ON AUDIT_LOG (ENTITY, ENTITY_ID, SEQ DESC);
+CREATE INDEX IF NOT EXISTS AUDIT_LOG_ACTOR ON AUDIT_LOG (ACTOR, SEQ DESC);
+CREATE OR REPLACE FUNCTION AUDIT_LOG_APPEND_ONLY() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit log is append-only';
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS AUDIT_LOG_APPEND_ONLY ON AUDIT_LOG;
+CREATE TRIGGER AUDIT_LOG_APPEND_ONLY BEFORE UPDATE OR DELETE OR TRUNCATE ON AUDIT_LOG
+	FOR EACH STATEMENT EXECUTE PROCEDURE AUDIT_LOG_APPEND_ONLY()`,
+	},
+	{
+		Version: 8,
+		Name:    "money amounts",
+		// amounts stored before currencies were supported are in minor units of the default currency
+		Up: `ALTER TABLE TASK ALTER COLUMN FEE TYPE MONEY_AMOUNT USING ROW(FEE, 'USD')::MONEY_AMOUNT;
+ALTER TABLE BILLING ALTER COLUMN AMOUNT TYPE MONEY_AMOUNT USING ROW(AMOUNT, 'USD')::MONEY_AMOUNT;
+ALTER TABLE CLIENT ALTER COLUMN BALANCE TYPE MONEY_AMOUNT USING ROW(BALANCE, 'USD')::MONEY_AMOUNT;
+ALTER TABLE FREELANCER ALTER COLUMN BALANCE TYPE MONEY_AMOUNT USING ROW(BALANCE, 'USD')::MONEY_AMOUNT`,
+	},
+	{
+		Version: 9,
+		Name:    "task review and hourly contracts",
+		// existing tasks are fixed fee, charges of the weekly billing runs are unique by reference
+		Up: `ALTER TABLE TASK ADD COLUMN IF NOT EXISTS COMPLETED_AT timestamp;
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS REVIEW_DEADLINE timestamp;
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS REMINDED_AT timestamp;
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS CONTRACT varchar DEFAULT 'fixed';
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS HOURLY_RATE MONEY_AMOUNT;
+ALTER TABLE TASK ADD COLUMN IF NOT EXISTS WEEKLY_CAP int8;
+ALTER TABLE BILLING ADD COLUMN IF NOT EXISTS REFERENCE varchar(128) UNIQUE`,
+	},
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"expvar"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/controller"
+	"github.com/kylycht/md/storage"
+	nats "github.com/nats-io/nats.go"
+)
+
+// NewRouter returns REST API of the gateway, in JetStream mode commands are submitted to JetStream.
+// Attachments are stored in the blobs store, users are identified by access tokens signed with the auth secret
+func NewRouter(conn *nats.EncodedConn, js nats.JetStreamContext, blobs storage.BlobStore, cfg StorageConfig, authCfg AuthConfig) *mux.Router {
+	ctrlOpts := []controller.Option{controller.WithBlobStore(blobs), controller.WithUploadLimits(cfg.MaxUpload, cfg.UploadTypes...)}
+	if js != nil {
+		ctrlOpts = append(ctrlOpts, controller.WithJetStream(js))
+	}
+	ctrl := controller.New(conn, ctrlOpts...)
+	router := mux.NewRouter()
+	router.Use(controller.Authenticate(authCfg.Secret), controller.Actor)
+
+	router.HandleFunc("/client", ctrl.CreateClient).Methods("POST")
+	router.HandleFunc("/client/{id}", ctrl.GetClient).Methods("GET")
+	router.HandleFunc("/client/{id}/wallets", ctrl.ListWallets).Methods("GET")
+	router.HandleFunc("/client/{id}/webhooks", ctrl.CreateWebhook).Methods("POST")
+	router.HandleFunc("/client/{id}/webhooks", ctrl.ListWebhooks).Methods("GET")
+	router.HandleFunc("/client/{id}/email-preferences", ctrl.GetEmailPreferences).Methods("GET")
+	router.HandleFunc("/client/{id}/email-preferences", ctrl.SetEmailPreferences).Methods("PUT")
+
+	router.HandleFunc("/webhook/{id}", ctrl.DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/webhook/{id}/deliveries", ctrl.ListDeliveries).Methods("GET")
+	router.HandleFunc("/webhook/delivery/{id}/replay", ctrl.ReplayDelivery).Methods("POST")
+
+	router.HandleFunc("/task", ctrl.CreateTask).Methods("POST")
+	router.HandleFunc("/tasks/search", ctrl.SearchTasks).Methods("GET")
+	router.HandleFunc("/task/{id}", ctrl.GetTask).Methods("GET")
+	router.HandleFunc("/task/{id}", ctrl.UpdateTask).Methods("PUT")
+	router.HandleFunc("/task/{id}/invoice", ctrl.GetInvoice).Methods("GET")
+	router.HandleFunc("/task/{id}/time", ctrl.LogTime).Methods("POST")
+	router.HandleFunc("/task/{id}/timesheet", ctrl.GetTimesheet).Methods("GET")
+	router.HandleFunc("/task/{id}/timesheet/approve", ctrl.ApproveTimesheet).Methods("PUT")
+	router.HandleFunc("/task/{id}/timesheet/reject", ctrl.RejectTimesheet).Methods("PUT")
+
+	router.HandleFunc("/task/{id}/messages", ctrl.SendMessage).Methods("POST")
+	router.HandleFunc("/task/{id}/messages", ctrl.ListMessages).Methods("GET")
+	router.HandleFunc("/task/{id}/messages/read", ctrl.ReadMessages).Methods("PUT")
+	router.HandleFunc("/client/{id}/messages/unread", ctrl.UnreadMessages).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/messages/unread", ctrl.UnreadMessages).Methods("GET")
+	router.HandleFunc("/task/{id}/attachments", ctrl.UploadAttachment).Methods("POST")
+	router.HandleFunc("/task/{id}/attachments", ctrl.ListAttachments).Methods("GET")
+	router.HandleFunc("/attachment/{id}", ctrl.DownloadAttachment).Methods("GET")
+	router.HandleFunc("/task/{id}/events", ctrl.StreamTasks).Methods("GET")
+	router.HandleFunc("/task/{id}/recommended-freelancers", ctrl.RecommendFreelancers).Methods("GET")
+	router.HandleFunc("/task/{id}/rating", ctrl.RateTask).Methods("POST")
+	router.HandleFunc("/events/tasks", ctrl.StreamTasks).Methods("GET")
+
+	router.HandleFunc("/task/{id}/dispute", ctrl.OpenDispute).Methods("POST")
+	router.HandleFunc("/dispute/{id}", ctrl.GetDispute).Methods("GET")
+	router.HandleFunc("/dispute/{id}/statement", ctrl.AddDisputeStatement).Methods("POST")
+	router.HandleFunc("/dispute/{id}/resolve", ctrl.ResolveDispute).Methods("PUT")
+
+	router.HandleFunc("/freelancer", ctrl.CreateFreelancer).Methods("POST")
+	router.HandleFunc("/freelancer/{id}", ctrl.GetFreelancer)
+	router.HandleFunc("/freelancer/{id}/wallets", ctrl.ListWallets).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/skills", ctrl.SetFreelancerSkills).Methods("PUT")
+	router.HandleFunc("/freelancers/search", ctrl.SearchFreelancers).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/recommended-tasks", ctrl.RecommendTasks).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/email-preferences", ctrl.GetEmailPreferences).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/email-preferences", ctrl.SetEmailPreferences).Methods("PUT")
+
+	router.HandleFunc("/notifications", ctrl.ListNotifications).Methods("GET")
+	router.HandleFunc("/notifications/read", ctrl.ReadNotifications).Methods("PUT")
+	router.HandleFunc("/notifications/stream", ctrl.StreamNotifications).Methods("GET")
+
+	router.HandleFunc("/categories", ctrl.ListCategories).Methods("GET")
+	router.HandleFunc("/categories", ctrl.CreateCategory).Methods("POST")
+	router.HandleFunc("/skills", ctrl.ListSkills).Methods("GET")
+	router.HandleFunc("/skills", ctrl.CreateSkill).Methods("POST")
+
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	return router
+}
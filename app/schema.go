@@ -0,0 +1,244 @@
+package app
+
+import "github.com/jmoiron/sqlx"
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE bigint,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp
+)`
+
+var billingSchema = `CREATE TABLE BILLING (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36),
+	PAID_DATE timestamp,
+	STATUS varchar,
+	AMOUNT bigint,
+	TASK_ID varchar(36)
+)`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE bigint,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	BALANCE bigint,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var walletSchema = `CREATE TABLE WALLET (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	BALANCE MONEY_AMOUNT NOT NULL
+)`
+
+var walletIndex = `CREATE UNIQUE INDEX WALLET_OWNER_CURRENCY ON WALLET (OWNER_ID, ((BALANCE).CURRENCY))`
+
+var invoiceSeq = `CREATE SEQUENCE INVOICE_NUMBER_SEQ`
+
+var invoiceSchema = `CREATE TABLE INVOICE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	NUMBER bigint UNIQUE NOT NULL,
+	TASK_ID varchar(36) UNIQUE NOT NULL,
+	PAYMENT_ID varchar(36) NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	CLIENT_EMAIL varchar(128),
+	FREELANCER_ID varchar(36) NOT NULL,
+	FREELANCER_EMAIL varchar(128),
+	DESCRIPTION text,
+	AMOUNT MONEY_AMOUNT,
+	PAID_DATE timestamp,
+	ISSUED_AT timestamp
+)`
+
+var disputeSchema = `CREATE TABLE DISPUTE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	OPENED_BY varchar(36) NOT NULL,
+	REASON text,
+	STATUS varchar,
+	TASK_STATUS varchar,
+	FREELANCER_AMOUNT MONEY_AMOUNT,
+	CLIENT_AMOUNT MONEY_AMOUNT,
+	RESOLUTION text,
+	RESOLVED_BY varchar(36),
+	CREATED_AT timestamp,
+	RESOLVED_AT timestamp
+)`
+
+var disputeStatementSchema = `CREATE TABLE DISPUTE_STATEMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	DISPUTE_ID varchar(36) NOT NULL,
+	AUTHOR_ID varchar(36) NOT NULL,
+	STATEMENT text,
+	EVIDENCE text[],
+	CREATED_AT timestamp
+)`
+
+var timeEntrySchema = `CREATE TABLE TIME_ENTRY (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36) NOT NULL,
+	DATE date NOT NULL,
+	DURATION int8 NOT NULL,
+	NOTE text,
+	STATUS varchar,
+	PAYMENT_ID varchar(36),
+	CREATED_AT timestamp
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+var reservationSchema = `CREATE TABLE RESERVATION (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	AMOUNT MONEY_AMOUNT,
+	WITHDRAWN MONEY_AMOUNT,
+	STATUS varchar NOT NULL,
+	CREATED_AT timestamp,
+	UPDATED_AT timestamp
+)`
+
+var sagaSchema = `CREATE TABLE SAGA (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	STATE varchar NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	LAST_ERROR text,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	CREATED_AT timestamp NOT NULL,
+	UPDATED_AT timestamp NOT NULL
+)`
+
+var sagaIndex = `CREATE INDEX SAGA_PENDING ON SAGA (UPDATED_AT) WHERE STATE NOT IN ('completed', 'failed')`
+
+var webhookSchema = `CREATE TABLE WEBHOOK (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	URL text NOT NULL,
+	SECRET varchar(128) NOT NULL,
+	EVENTS text[] NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	DELETED_AT timestamp
+)`
+
+var webhookDeliverySchema = `CREATE TABLE WEBHOOK_DELIVERY (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	WEBHOOK_ID varchar(36) NOT NULL,
+	EVENT_ID varchar(36) NOT NULL,
+	EVENT_TYPE varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	STATUS varchar NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	CREATED_AT timestamp NOT NULL,
+	DELIVERED_AT timestamp,
+	UNIQUE (WEBHOOK_ID, EVENT_ID)
+)`
+
+var webhookDeliveryIndex = `CREATE INDEX WEBHOOK_DELIVERY_PENDING ON WEBHOOK_DELIVERY (NEXT_ATTEMPT_AT) WHERE STATUS = 'pending'`
+
+var webhookAttemptSchema = `CREATE TABLE WEBHOOK_ATTEMPT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	DELIVERY_ID varchar(36) NOT NULL,
+	STATUS_CODE int NOT NULL,
+	ERROR text,
+	DURATION bigint NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+var webhookAttemptIndex = `CREATE INDEX WEBHOOK_ATTEMPT_DELIVERY ON WEBHOOK_ATTEMPT (DELIVERY_ID)`
+
+var messageSchema = `CREATE TABLE MESSAGE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	SENDER_ID varchar(36) NOT NULL,
+	BODY text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	READ_AT timestamp
+)`
+
+var messageIndex = `CREATE INDEX MESSAGE_TASK ON MESSAGE (TASK_ID, CREATED_AT)`
+
+var messageUnreadIndex = `CREATE INDEX MESSAGE_UNREAD ON MESSAGE (TASK_ID) WHERE READ_AT IS NULL`
+
+var attachmentSchema = `CREATE TABLE ATTACHMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	UPLOADER_ID varchar(36) NOT NULL,
+	KIND varchar(16) NOT NULL,
+	NAME varchar(255) NOT NULL,
+	CONTENT_TYPE varchar(255) NOT NULL,
+	SIZE bigint NOT NULL,
+	CHECKSUM char(64) NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+var attachmentIndex = `CREATE INDEX ATTACHMENT_TASK ON ATTACHMENT (TASK_ID, CREATED_AT)`
+
+// InitDB creates missing types, tables and indexes, existing ones are left untouched
+func InitDB(db *sqlx.DB) error {
+
+	db.Exec(moneyType)
+
+	db.Exec(taskSchema)
+	db.Exec(billingSchema)
+	db.Exec(clientSchema)
+	db.Exec(freelancerSchema)
+	db.Exec(walletSchema)
+	db.Exec(walletIndex)
+	db.Exec(invoiceSeq)
+	db.Exec(invoiceSchema)
+	db.Exec(disputeSchema)
+	db.Exec(disputeStatementSchema)
+	db.Exec(timeEntrySchema)
+	db.Exec(outboxSchema)
+	db.Exec(outboxIndex)
+	db.Exec(reservationSchema)
+	db.Exec(sagaSchema)
+	db.Exec(sagaIndex)
+	db.Exec(webhookSchema)
+	db.Exec(webhookDeliverySchema)
+	db.Exec(webhookDeliveryIndex)
+	db.Exec(webhookAttemptSchema)
+	db.Exec(webhookAttemptIndex)
+	db.Exec(messageSchema)
+	db.Exec(messageIndex)
+	db.Exec(messageUnreadIndex)
+	db.Exec(attachmentSchema)
+	db.Exec(attachmentIndex)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/fx"
+	"github.com/kylycht/md/services/attachment"
+	"github.com/kylycht/md/services/audit"
+	"github.com/kylycht/md/services/client"
+	"github.com/kylycht/md/services/dispute"
+	"github.com/kylycht/md/services/freelancer"
+	"github.com/kylycht/md/services/invoice"
+	"github.com/kylycht/md/services/match"
+	"github.com/kylycht/md/services/message"
+	"github.com/kylycht/md/services/notify"
+	"github.com/kylycht/md/services/skill"
+	"github.com/kylycht/md/services/task"
+	"github.com/kylycht/md/services/timesheet"
+	"github.com/kylycht/md/services/wallet"
+	"github.com/kylycht/md/services/webhook"
+	nats "github.com/nats-io/nats.go"
+)
+
+// LoadRates loads exchange rates from the file, empty path means no rates
+func LoadRates(path string) (fx.Source, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return fx.LoadFile(path)
+}
+
+// StartTask starts task service along with invoice, dispute, timesheet, message, attachment and match services
+// working on the Task, every instance joins the same queue groups.
+// Returned func stops background processing
+func StartTask(db *sqlx.DB, conn *nats.EncodedConn, js nats.JetStreamContext, cfg TaskConfig) (func(), error) {
+	rates, err := LoadRates(cfg.Rates)
+	if err != nil {
+		return nil, err
+	}
+	opts := []task.Option{task.WithReviewPeriod(cfg.ReviewPeriod), task.WithReminder(cfg.ReviewReminder)}
+	if rates != nil {
+		opts = append(opts, task.WithRates(rates))
+	}
+	if js != nil {
+		opts = append(opts, task.WithJetStream(js))
+	}
+	taskSrv, err := task.NewService(db, conn, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := invoice.NewService(db, conn); err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	if _, err := dispute.NewService(db, conn); err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	if _, err := message.NewService(db, conn); err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	if _, err := attachment.NewService(db, conn); err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	if _, err := match.NewService(db, conn); err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	tsSrv, err := timesheet.NewService(db, conn, cfg.BillingInterval)
+	if err != nil {
+		taskSrv.Close()
+		return nil, err
+	}
+	return func() {
+		taskSrv.Close()
+		tsSrv.Close()
+	}, nil
+}
+
+// StartNotify starts notification service emailing users about their Tasks and Payments.
+// Returned func stops checking of deadlines
+func StartNotify(db *sqlx.DB, conn *nats.EncodedConn, js nats.JetStreamContext, cfg NotifyConfig) (func(), error) {
+	var sender notify.Sender = notify.LogSender{}
+	if cfg.SMTPAddr != "" {
+		sender = notify.NewSMTPSender(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUser, cfg.SMTPPassword)
+	}
+	opts := []notify.Option{notify.WithDeadlineReminder(cfg.DeadlineReminder)}
+	if js != nil {
+		opts = append(opts, notify.WithJetStream(js))
+	}
+	srv, err := notify.NewService(db, conn, sender, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Close, nil
+}
+
+// StartClient starts client service along with wallet, audit and webhook services,
+// rates path is used to convert reserved funds.
+// Returned func stops delivery of webhooks
+func StartClient(db *sqlx.DB, conn *nats.EncodedConn, js nats.JetStreamContext, ratesPath string) (func(), error) {
+	rates, err := LoadRates(ratesPath)
+	if err != nil {
+		return nil, err
+	}
+	var opts []client.Option
+	if rates != nil {
+		opts = append(opts, client.WithRates(rates))
+	}
+	if _, err := client.NewService(db, conn, opts...); err != nil {
+		return nil, err
+	}
+	if _, err := wallet.NewService(db, conn); err != nil {
+		return nil, err
+	}
+	if _, err := audit.NewService(db, conn); err != nil {
+		return nil, err
+	}
+	var whOpts []webhook.Option
+	if js != nil {
+		whOpts = append(whOpts, webhook.WithJetStream(js))
+	}
+	whSrv, err := webhook.NewService(db, conn, whOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return whSrv.Close, nil
+}
+
+// StartFreelancer starts freelancer service along with skill service owning the taxonomy
+func StartFreelancer(db *sqlx.DB, conn *nats.EncodedConn) error {
+	if _, err := freelancer.NewService(db, conn); err != nil {
+		return err
+	}
+	_, err := skill.NewService(db, conn)
+	return err
+}
@@ -0,0 +1,114 @@
+// Package auth issues and verifies access tokens of the gateway.
+//
+// Token is base64url encoded JSON claims and their HMAC-SHA256 signature joined by a dot,
+// the gateway and mdctl issuing the tokens share the secret:
+//
+//	eyJzdWIiOiI2ZjE...fQ.kT2v0Qp...
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Role represents kind of the token's subject
+type Role string
+
+const (
+	// User is Client or Freelancer, subject is the user's ID
+	User Role = "user"
+	// Operator runs the marketplace, subject is the operator's name
+	Operator Role = "operator"
+)
+
+const (
+	// MaxSubject represents maximum length of the subject, it is recorded as the actor of the audit log
+	// and may not be longer than rpc.MaxActor
+	MaxSubject = 128
+	// MinSecret represents minimum length of the secret in bytes
+	MinSecret = 32
+)
+
+var (
+	// ErrInvalidToken represents error returned for malformed token or token with wrong signature
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired represents error returned for expired token
+	ErrExpired = errors.New("token expired")
+	// ErrInvalidIdentity represents error returned when identity can not be issued
+	ErrInvalidIdentity = errors.New("invalid identity")
+	// ErrShortSecret represents error returned for secret shorter than MinSecret
+	ErrShortSecret = errors.New("secret is too short")
+)
+
+// Identity represents verified user or operator making the request
+type Identity struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// valid reports whether the identity may be issued, user's subject is the user's ID
+func (id Identity) valid() bool {
+	switch id.Role {
+	case User:
+		return len(id.Subject) == 36
+	case Operator:
+		return strings.TrimSpace(id.Subject) != "" && len(id.Subject) <= MaxSubject
+	}
+	return false
+}
+
+// Sign returns token of the identity valid for ttl
+func Sign(secret []byte, id Identity, ttl time.Duration) (string, error) {
+	if len(secret) < MinSecret {
+		return "", ErrShortSecret
+	}
+	if !id.valid() || ttl <= 0 {
+		return "", ErrInvalidIdentity
+	}
+	id.ExpiresAt = time.Now().Add(ttl).Unix()
+	claims, err := json.Marshal(id)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload)), nil
+}
+
+// Verify returns identity of the token signed with the secret
+func Verify(secret []byte, token string, now time.Time) (Identity, error) {
+	var id Identity
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return id, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, sign(secret, payload)) {
+		return id, ErrInvalidToken
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return id, ErrInvalidToken
+	}
+	dec := json.NewDecoder(bytes.NewReader(claims))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&id); err != nil || !id.valid() {
+		return Identity{}, ErrInvalidToken
+	}
+	if now.Unix() >= id.ExpiresAt {
+		return Identity{}, ErrExpired
+	}
+	return id, nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
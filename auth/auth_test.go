@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/model"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignVerify(t *testing.T) {
+	user := Identity{Subject: model.NewID(), Role: User}
+	token, err := Sign(secret, user, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Verify(secret, token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != user.Subject || got.Role != User {
+		t.Errorf("expected=%+v got=%+v", user, got)
+	}
+	if _, err := Verify(secret, token, time.Now().Add(time.Hour)); err != ErrExpired {
+		t.Errorf("expected=%v got=%v", ErrExpired, err)
+	}
+	if _, err := Verify([]byte("fedcba9876543210fedcba9876543210"), token, time.Now()); err != ErrInvalidToken {
+		t.Errorf("other secret: expected=%v got=%v", ErrInvalidToken, err)
+	}
+
+	// claims can not be changed without the secret
+	operator, err := Sign(secret, Identity{Subject: "ops", Role: Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _, _ := strings.Cut(operator, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	for _, tampered := range []string{payload + "." + signature, token + "x", "", ".", payload} {
+		if _, err := Verify(secret, tampered, time.Now()); err != ErrInvalidToken {
+			t.Errorf("%q: expected=%v got=%v", tampered, ErrInvalidToken, err)
+		}
+	}
+}
+
+func TestSign_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		id     Identity
+		want   error
+	}{
+		{name: "short secret", secret: []byte("secret"), id: Identity{Subject: "ops", Role: Operator}, want: ErrShortSecret},
+		{name: "user without ID", secret: secret, id: Identity{Subject: "alice", Role: User}, want: ErrInvalidIdentity},
+		{name: "long operator", secret: secret, id: Identity{Subject: strings.Repeat("o", MaxSubject+1), Role: Operator}, want: ErrInvalidIdentity},
+		{name: "blank operator", secret: secret, id: Identity{Subject: " ", Role: Operator}, want: ErrInvalidIdentity},
+		{name: "unknown role", secret: secret, id: Identity{Subject: "ops", Role: "admin"}, want: ErrInvalidIdentity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sign(tt.secret, tt.id, time.Hour); err != tt.want {
+				t.Errorf("expected=%v got=%v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Command all-in-one runs embedded NATS server, every service and the gateway in a single process
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kylycht/md/app"
+)
+
+type config struct {
+	app.Config
+	NATS    app.NATSConfig
+	Task    app.TaskConfig
+	Notify  app.NotifyConfig
+	Storage app.StorageConfig
+	Auth    app.AuthConfig
+	// Addr is address HTTP server listens on(HTTP_ADDR)
+	Addr string
+}
+
+func loadConfig() (config, error) {
+	cfg := config{Addr: app.Env("HTTP_ADDR", ":8000")}
+	var err error
+	if cfg.Config, err = app.LoadConfig(); err != nil {
+		return cfg, err
+	}
+	if cfg.NATS, err = app.LoadNATSConfig(); err != nil {
+		return cfg, err
+	}
+	if cfg.Task, err = app.LoadTaskConfig(); err != nil {
+		return cfg, err
+	}
+	if cfg.Notify, err = app.LoadNotifyConfig(); err != nil {
+		return cfg, err
+	}
+	if cfg.Storage, err = app.LoadStorageConfig(); err != nil {
+		return cfg, err
+	}
+	cfg.Auth, err = app.LoadAuthConfig()
+	return cfg, err
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ns, err := app.RunNATS(cfg.NATS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ns.Shutdown()
+	cfg.Config.NATS = ns.ClientURL()
+
+	db, err := app.OpenDB(cfg.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	conn, js, err := app.Connect(cfg.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Drain()
+
+	stop, err := app.StartTask(db, conn, js, cfg.Task)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+	stopNotify, err := app.StartNotify(db, conn, js, cfg.Notify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopNotify()
+	if err := app.StartFreelancer(db, conn); err != nil {
+		log.Fatal(err)
+	}
+	stopClient, err := app.StartClient(db, conn, js, cfg.Task.Rates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopClient()
+	relay := app.RunRelay(db, conn, js, cfg.OutboxInterval)
+	defer relay.Close()
+
+	blobs, err := app.OpenBlobStore(cfg.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Addr: cfg.Addr, Handler: app.NewRouter(conn, js, blobs, cfg.Storage, cfg.Auth)}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	app.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
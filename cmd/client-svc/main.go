@@ -0,0 +1,46 @@
+// Command client-svc runs client, wallet and webhook services
+package main
+
+import (
+	"log"
+
+	"github.com/kylycht/md/app"
+)
+
+type config struct {
+	app.Config
+	// Rates is path of exchange rates file(FX_RATES)
+	Rates string
+}
+
+func loadConfig() (config, error) {
+	shared, err := app.LoadConfig()
+	return config{Config: shared, Rates: app.Env("FX_RATES", "")}, err
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := app.OpenDB(cfg.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	conn, js, err := app.Connect(cfg.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Drain()
+
+	stop, err := app.StartClient(db, conn, js, cfg.Rates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+	relay := app.RunRelay(db, conn, js, cfg.OutboxInterval)
+	defer relay.Close()
+
+	app.Wait()
+}
@@ -0,0 +1,33 @@
+// Command freelancer-svc runs freelancer and skill services
+package main
+
+import (
+	"log"
+
+	"github.com/kylycht/md/app"
+)
+
+func main() {
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := app.OpenDB(cfg.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	conn, js, err := app.Connect(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Drain()
+
+	if err := app.StartFreelancer(db, conn); err != nil {
+		log.Fatal(err)
+	}
+	relay := app.RunRelay(db, conn, js, cfg.OutboxInterval)
+	defer relay.Close()
+
+	app.Wait()
+}
@@ -0,0 +1,60 @@
+// Command gateway serves REST API and forwards requests to the services over NATS
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kylycht/md/app"
+)
+
+type config struct {
+	app.Config
+	Storage app.StorageConfig
+	Auth    app.AuthConfig
+	// Addr is address HTTP server listens on(HTTP_ADDR)
+	Addr string
+}
+
+func loadConfig() (config, error) {
+	cfg := config{Addr: app.Env("HTTP_ADDR", ":8000")}
+	var err error
+	if cfg.Config, err = app.LoadConfig(); err != nil {
+		return cfg, err
+	}
+	if cfg.Storage, err = app.LoadStorageConfig(); err != nil {
+		return cfg, err
+	}
+	cfg.Auth, err = app.LoadAuthConfig()
+	return cfg, err
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, js, err := app.Connect(cfg.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Drain()
+
+	blobs, err := app.OpenBlobStore(cfg.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Addr: cfg.Addr, Handler: app.NewRouter(conn, js, blobs, cfg.Storage, cfg.Auth)}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	app.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
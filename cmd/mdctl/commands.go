@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/app"
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+// errNoChanges represents error returned by update without any field to change
+var errNoChanges = errors.New("nothing to update")
+
+func listClients(c *cli, args []string) error {
+	if err := flag.NewFlagSet("clients list", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	clients, err := call(c, api.ClientList, rpc.Empty{})
+	if err != nil {
+		return err
+	}
+	return c.print(clients, clientsTable(clients...))
+}
+
+func getClient(c *cli, args []string) error {
+	id, err := parse(flag.NewFlagSet("clients get", flag.ContinueOnError), args, "client ID")
+	if err != nil {
+		return err
+	}
+	client, err := call(c, api.ClientGet, id)
+	if err != nil {
+		return err
+	}
+	return c.print(client, clientsTable(client))
+}
+
+// updateClient changes Email of the Client, balance is changed by adjust only
+func updateClient(c *cli, args []string) error {
+	flags := flag.NewFlagSet("clients update", flag.ContinueOnError)
+	email := flags.String("email", "", "new email")
+	id, err := parse(flags, args, "client ID")
+	if err != nil {
+		return err
+	}
+	if *email == "" {
+		return errNoChanges
+	}
+	if _, err := call(c, api.ClientUpdate, model.Client{ID: id, Email: *email}); err != nil {
+		return err
+	}
+	return getClient(c, []string{id})
+}
+
+func listFreelancers(c *cli, args []string) error {
+	if err := flag.NewFlagSet("freelancers list", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+	freelancers, err := call(c, api.FreelancerList, rpc.Empty{})
+	if err != nil {
+		return err
+	}
+	return c.print(freelancers, freelancersTable(freelancers...))
+}
+
+func getFreelancer(c *cli, args []string) error {
+	id, err := parse(flag.NewFlagSet("freelancers get", flag.ContinueOnError), args, "freelancer ID")
+	if err != nil {
+		return err
+	}
+	freelancer, err := call(c, api.FreelancerGet, id)
+	if err != nil {
+		return err
+	}
+	return c.print(freelancer, freelancersTable(freelancer))
+}
+
+// updateFreelancer changes Email, Description or Details of the Freelancer,
+// the service updates Description and Details together, so unchanged one keeps its current value
+func updateFreelancer(c *cli, args []string) error {
+	flags := flag.NewFlagSet("freelancers update", flag.ContinueOnError)
+	email := flags.String("email", "", "new email")
+	description := flags.String("description", "", "new description")
+	details := flags.String("details", "", "new details")
+	id, err := parse(flags, args, "freelancer ID")
+	if err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if len(set) == 0 {
+		return errNoChanges
+	}
+	f, err := call(c, api.FreelancerGet, id)
+	if err != nil {
+		return err
+	}
+	update := model.Freelancer{ID: id, Email: *email}
+	if set["description"] || set["details"] {
+		update.Description, update.Details = f.Description, f.Details
+		if set["description"] {
+			update.Description = sql.NullString{String: *description, Valid: true}
+		}
+		if set["details"] {
+			update.Details = sql.NullString{String: *details, Valid: true}
+		}
+		// unset column is written as NULL otherwise
+		update.Description.Valid, update.Details.Valid = true, true
+	}
+	if _, err := call(c, api.FreelancerUpdate, update); err != nil {
+		return err
+	}
+	return getFreelancer(c, []string{id})
+}
+
+func listTasks(c *cli, args []string) error {
+	clientID, err := parse(flag.NewFlagSet("tasks list", flag.ContinueOnError), args, "client ID")
+	if err != nil {
+		return err
+	}
+	tasks, err := call(c, api.TaskList, clientID)
+	if err != nil {
+		return err
+	}
+	return c.print(tasks, tasksTable(tasks...))
+}
+
+func getTask(c *cli, args []string) error {
+	id, err := parse(flag.NewFlagSet("tasks get", flag.ContinueOnError), args, "task ID")
+	if err != nil {
+		return err
+	}
+	task, err := call(c, api.TaskGet, id)
+	if err != nil {
+		return err
+	}
+	return c.print(task, tasksTable(task))
+}
+
+// updateTask changes the Task the way PUT /task/{id} does, closed status pays Freelancer
+func updateTask(c *cli, args []string) error {
+	flags := flag.NewFlagSet("tasks update", flag.ContinueOnError)
+	status := flags.String("status", "", "new status: open, started, completed or closed")
+	description := flags.String("description", "", "new description")
+	freelancer := flags.String("freelancer", "", "ID of assigned freelancer")
+	deadline := flags.Duration("deadline", 0, "new deadline, e.g. 72h")
+	fee := flags.String("fee", "", "new fee, e.g. \"1500.00 USD\"")
+	id, err := parse(flags, args, "task ID")
+	if err != nil {
+		return err
+	}
+	t := model.Task{ID: id, Status: model.TaskStatus(*status), Description: *description, FreelancerID: *freelancer, Deadline: *deadline}
+	if *fee != "" {
+		if t.Fee, err = model.ParseMoney(*fee); err != nil {
+			return err
+		}
+	}
+	if t.Status == "" && t.Description == "" && t.FreelancerID == "" && t.Deadline == 0 && t.Fee.IsZero() {
+		return errNoChanges
+	}
+	if _, err := call(c, api.TaskUpdate, t); err != nil {
+		return err
+	}
+	return getTask(c, []string{id})
+}
+
+// settleTask returns command closing the Task, funds held for it are returned to Client
+// when refund is set and paid to Freelancer otherwise
+func settleTask(refund bool) command {
+	e, name := api.TaskForceClose, "tasks force-close"
+	if refund {
+		e, name = api.TaskRefund, "tasks refund"
+	}
+	return func(c *cli, args []string) error {
+		flags := flag.NewFlagSet(name, flag.ContinueOnError)
+		reason := flags.String("reason", "", "why the task is settled by operator (required)")
+		id, err := parse(flags, args, "task ID")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(*reason) == "" {
+			return errors.New(name + ": -reason is required")
+		}
+		task, err := call(c, e, model.TaskAction{TaskID: id, Reason: *reason, Actor: c.actor})
+		if err != nil {
+			return err
+		}
+		return c.print(task, tasksTable(task))
+	}
+}
+
+// adjust returns command crediting or withdrawing funds of the owner's account
+func adjust(owner string) command {
+	return func(c *cli, args []string) error {
+		flags := flag.NewFlagSet(owner+"s adjust", flag.ContinueOnError)
+		amount := flags.String("amount", "", "credited amount, negative amount is withdrawn, e.g. \"-25.00 EUR\" (required)")
+		reason := flags.String("reason", "", "why the balance is adjusted (required)")
+		id, err := parse(flags, args, owner+" ID")
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(*reason) == "" {
+			return errors.New(flags.Name() + ": -reason is required")
+		}
+		m, err := model.ParseMoney(*amount)
+		if err != nil {
+			return err
+		}
+		a, err := call(c, api.WalletAdjust, model.Adjustment{Owner: owner, OwnerID: id, Amount: m, Reason: *reason, Actor: c.actor})
+		if err != nil {
+			return err
+		}
+		return c.print(a, adjustmentsTable(a))
+	}
+}
+
+func adjustments(c *cli, args []string) error {
+	id, err := parse(flag.NewFlagSet("adjustments", flag.ContinueOnError), args, "owner ID")
+	if err != nil {
+		return err
+	}
+	list, err := call(c, api.WalletAdjustments, id)
+	if err != nil {
+		return err
+	}
+	return c.print(list, adjustmentsTable(list...))
+}
+
+func wallets(c *cli, args []string) error {
+	id, err := parse(flag.NewFlagSet("wallets", flag.ContinueOnError), args, "owner ID")
+	if err != nil {
+		return err
+	}
+	list, err := call(c, api.WalletList, id)
+	if err != nil {
+		return err
+	}
+	return c.print(list, walletsTable(list...))
+}
+
+// listAudit prints the audit log newest first, filtered by entity, its ID and actor
+func listAudit(c *cli, args []string) error {
+	flags := flag.NewFlagSet("audit list", flag.ContinueOnError)
+	q := model.AuditQuery{}
+	flags.StringVar(&q.Entity, "entity", "", "entity type: client, freelancer or task")
+	flags.StringVar(&q.EntityID, "id", "", "ID of the entity")
+	flags.StringVar(&q.Actor, "actor", "", "user or operator who made the changes")
+	flags.IntVar(&q.Limit, "limit", 50, "number of entries")
+	flags.IntVar(&q.Offset, "offset", 0, "number of newest entries to skip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("audit list: unexpected arguments")
+	}
+	entries, err := call(c, api.AuditList, q)
+	if err != nil {
+		return err
+	}
+	return c.print(entries, auditTable(entries...))
+}
+
+// issueToken prints access token of the REST API for the user or the operator
+func issueToken(c *cli, args []string) error {
+	flags := flag.NewFlagSet("tokens issue", flag.ContinueOnError)
+	role := flags.String("role", string(auth.User), "user for Client or Freelancer ID, operator for operator's name")
+	ttl := flags.Duration("ttl", time.Hour*24, "how long the token is valid")
+	subject, err := parse(flags, args, "user ID or operator name")
+	if err != nil {
+		return err
+	}
+	cfg, err := app.LoadAuthConfig()
+	if err != nil {
+		return err
+	}
+	token, err := auth.Sign(cfg.Secret, auth.Identity{Subject: subject, Role: auth.Role(*role)}, *ttl)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, token)
+	return err
+}
@@ -0,0 +1,167 @@
+// Command mdctl operates the marketplace over NATS: it lists, shows and updates Clients, Freelancers
+// and Tasks, adjusts balances, force-closes or refunds Tasks and browses the audit log. Balance
+// adjustments and Task interventions require a reason and are recorded along with the operator,
+// every change made by mdctl is audited on behalf of the operator. It also issues access tokens
+// of the REST API signed with AUTH_SECRET shared with the gateway.
+//
+// Usage:
+//
+//	mdctl [-o table|json] [-actor name] <resource> <command> [flags] [id]
+//
+// NATS server and encoding are read from NATS_URL and NATS_ENCODING like the services do
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/app"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+)
+
+// cli represents connection and options shared by the commands
+type cli struct {
+	conn    *nats.Conn
+	out     io.Writer
+	format  string
+	actor   string
+	timeout time.Duration
+}
+
+// command runs with arguments following resource and command names
+type command func(c *cli, args []string) error
+
+var commands = map[string]map[string]command{
+	"audit": {
+		"list": listAudit,
+	},
+	"clients": {
+		"list":        listClients,
+		"get":         getClient,
+		"update":      updateClient,
+		"adjust":      adjust("client"),
+		"adjustments": adjustments,
+		"wallets":     wallets,
+	},
+	"freelancers": {
+		"list":        listFreelancers,
+		"get":         getFreelancer,
+		"update":      updateFreelancer,
+		"adjust":      adjust("freelancer"),
+		"adjustments": adjustments,
+		"wallets":     wallets,
+	},
+	"tasks": {
+		"list":        listTasks,
+		"get":         getTask,
+		"update":      updateTask,
+		"force-close": settleTask(false),
+		"refund":      settleTask(true),
+	},
+	"tokens": {
+		"issue": issueToken,
+	},
+}
+
+func main() {
+	flags := flag.NewFlagSet("mdctl", flag.ExitOnError)
+	format := flags.String("o", "table", "output format, table or json")
+	actor := flags.String("actor", currentUser(), "operator recorded in the audit trail")
+	timeout := flags.Duration("timeout", time.Second*10, "timeout of every request")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if *format != "table" && *format != "json" {
+		fail(fmt.Errorf("unknown output format %q", *format))
+	}
+	if strings.TrimSpace(*actor) == "" || len(*actor) > rpc.MaxActor {
+		fail(fmt.Errorf("actor of 1 to %d characters is required", rpc.MaxActor))
+	}
+	if flags.NArg() < 2 {
+		usage(flags)
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)][flags.Arg(1)]
+	if !ok {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		fail(err)
+	}
+	// requests only, streams are set up by the services
+	cfg.JetStream = false
+	conn, _, err := app.Connect(cfg)
+	if err != nil {
+		fail(err)
+	}
+	defer conn.Close()
+
+	c := &cli{conn: conn.Conn, out: os.Stdout, format: *format, actor: *actor, timeout: *timeout}
+	if err := cmd(c, flags.Args()[2:]); err != nil {
+		conn.Close()
+		fail(err)
+	}
+}
+
+func usage(flags *flag.FlagSet) {
+	w := flags.Output()
+	fmt.Fprintln(w, "Usage: mdctl [-o table|json] [-actor name] <resource> <command> [flags] [id]")
+	fmt.Fprintln(w)
+	resources := make([]string, 0, len(commands))
+	for r := range commands {
+		resources = append(resources, r)
+	}
+	sort.Strings(resources)
+	for _, r := range resources {
+		names := make([]string, 0, len(commands[r]))
+		for name := range commands[r] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "  %-12s %s\n", r, strings.Join(names, ", "))
+	}
+	fmt.Fprintln(w)
+	flags.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mdctl:", err)
+	os.Exit(1)
+}
+
+// currentUser returns login of the user running the command
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// call sends the request on behalf of the operator and waits for the response no longer than the timeout
+func call[Req, Resp any](c *cli, e rpc.Endpoint[Req, Resp], req Req) (Resp, error) {
+	ctx, cancel := context.WithTimeout(rpc.WithActor(context.Background(), c.actor), c.timeout)
+	defer cancel()
+	return rpc.Call(ctx, c.conn, e, req)
+}
+
+// parse parses command flags and returns the single positional argument, usually ID
+func parse(flags *flag.FlagSet, args []string, arg string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("%s: expected %s", flags.Name(), arg)
+	}
+	return flags.Arg(0), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/lib/pq"
+)
+
+// descriptionWidth represents number of characters of Task description shown in tables
+const descriptionWidth = 40
+
+// table represents rows printed in table format
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print writes v as indented JSON or the table depending on output format
+func (c *cli) print(v interface{}, t table) error {
+	if c.format == "json" {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func clientsTable(clients ...model.Client) table {
+	t := table{header: []string{"ID", "EMAIL", "BALANCE", "DELETED"}}
+	for _, c := range clients {
+		t.rows = append(t.rows, []string{c.ID, c.Email, c.Balance.String(), nullTime(c.DeletedAt)})
+	}
+	return t
+}
+
+func freelancersTable(freelancers ...model.Freelancer) table {
+	t := table{header: []string{"ID", "EMAIL", "BALANCE", "SKILLS", "DELETED"}}
+	for _, f := range freelancers {
+		skills := make([]string, 0, len(f.Skills))
+		for _, s := range f.Skills {
+			skills = append(skills, fmt.Sprintf("%s:%d", s.SkillID, s.Level))
+		}
+		t.rows = append(t.rows, []string{f.ID, f.Email, f.Balance.String(), strings.Join(skills, ","), nullTime(f.DeletedAt)})
+	}
+	return t
+}
+
+func tasksTable(tasks ...model.Task) table {
+	t := table{header: []string{"ID", "STATUS", "CONTRACT", "FEE", "CLIENT", "FREELANCER", "DESCRIPTION"}}
+	for _, task := range tasks {
+		fee := task.Fee.String()
+		if task.Contract == model.HourlyContract {
+			fee = task.HourlyRate.String() + "/h"
+		}
+		description := []rune(strings.Join(strings.Fields(task.Description), " "))
+		if len(description) > descriptionWidth {
+			description = append(description[:descriptionWidth-1], '…')
+		}
+		t.rows = append(t.rows, []string{task.ID, string(task.Status), string(task.Contract), fee, task.ClientID, task.FreelancerID, string(description)})
+	}
+	return t
+}
+
+func adjustmentsTable(adjustments ...model.Adjustment) table {
+	t := table{header: []string{"ID", "CREATED", "OWNER", "AMOUNT", "ACTOR", "REASON"}}
+	for _, a := range adjustments {
+		t.rows = append(t.rows, []string{a.ID, a.CreatedAt.Format(time.RFC3339), a.Owner + " " + a.OwnerID, a.Amount.String(), a.Actor, a.Reason})
+	}
+	return t
+}
+
+func walletsTable(wallets ...model.Wallet) table {
+	t := table{header: []string{"ID", "BALANCE"}}
+	for _, w := range wallets {
+		t.rows = append(t.rows, []string{w.ID, w.Balance.String()})
+	}
+	return t
+}
+
+// auditTable shows changed fields as field: before -> after, field missing on one side is shown as -
+func auditTable(entries ...model.AuditEntry) table {
+	t := table{header: []string{"CREATED", "ACTOR", "ACTION", "ENTITY", "ENTITY_ID", "CHANGES"}}
+	for _, e := range entries {
+		t.rows = append(t.rows, []string{e.CreatedAt.Format(time.RFC3339), e.Actor, string(e.Action), e.Entity, e.EntityID, changes(e.Before, e.After)})
+	}
+	return t
+}
+
+// changes returns fields of before and after JSON objects as sorted list of changes
+func changes(before, after json.RawMessage) string {
+	b, a := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	// the database returns JSON with spaces
+	json.Unmarshal(before, &b)
+	json.Unmarshal(after, &a)
+	for _, m := range []map[string]json.RawMessage{b, a} {
+		for k, v := range m {
+			var buf bytes.Buffer
+			if json.Compact(&buf, v) == nil {
+				m[k] = buf.Bytes()
+			}
+		}
+	}
+	names := make([]string, 0, len(b)+len(a))
+	for k := range b {
+		names = append(names, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	list := make([]string, 0, len(names))
+	for _, k := range names {
+		from, to := "-", "-"
+		if v, ok := b[k]; ok {
+			from = string(v)
+		}
+		if v, ok := a[k]; ok {
+			to = string(v)
+		}
+		list = append(list, fmt.Sprintf("%s: %s -> %s", k, from, to))
+	}
+	return strings.Join(list, "; ")
+}
+
+func nullTime(t pq.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Format(time.RFC3339)
+}
@@ -0,0 +1,23 @@
+// Command nats-embedded runs standalone NATS server the other commands connect to
+package main
+
+import (
+	"log"
+
+	"github.com/kylycht/md/app"
+)
+
+func main() {
+	cfg, err := app.LoadNATSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := app.RunNATS(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("nats listening on %s", srv.ClientURL())
+
+	app.Wait()
+	srv.Shutdown()
+}
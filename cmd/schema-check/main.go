@@ -0,0 +1,44 @@
+// Command schema-check compares payload types sent over NATS with stored JSON schema snapshots
+// and fails on changes that require a version bump or a snapshot update
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/kylycht/md/schema"
+)
+
+func main() {
+	dir := flag.String("dir", "schema/snapshots", "directory snapshots are stored in")
+	update := flag.Bool("update", false, "store snapshots of new and compatibly changed types")
+	flag.Parse()
+
+	results, err := schema.Check(*dir, schema.Payloads)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for _, r := range results {
+		err := r.Err()
+		if err == nil {
+			continue
+		}
+		if *update {
+			if uErr := schema.Update(*dir, r); uErr == nil {
+				fmt.Printf("%s: version %d stored\n", r.Name, r.Version)
+				continue
+			}
+		}
+		failed = true
+		fmt.Printf("%s: %v\n", r.Name, err)
+		for _, c := range r.Changes {
+			fmt.Printf("\t%s\n", c)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+// Command task-svc runs task, invoice, dispute, timesheet, message, attachment, match and notification services,
+// requests are balanced between instances by NATS queue groups
+package main
+
+import (
+	"log"
+
+	"github.com/kylycht/md/app"
+)
+
+type config struct {
+	app.Config
+	Task   app.TaskConfig
+	Notify app.NotifyConfig
+}
+
+func loadConfig() (config, error) {
+	shared, err := app.LoadConfig()
+	if err != nil {
+		return config{}, err
+	}
+	task, err := app.LoadTaskConfig()
+	if err != nil {
+		return config{}, err
+	}
+	notify, err := app.LoadNotifyConfig()
+	return config{Config: shared, Task: task, Notify: notify}, err
+}
+
+func main() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := app.OpenDB(cfg.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	conn, js, err := app.Connect(cfg.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Drain()
+
+	stop, err := app.StartTask(db, conn, js, cfg.Task)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+	stopNotify, err := app.StartNotify(db, conn, js, cfg.Notify)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopNotify()
+	relay := app.RunRelay(db, conn, js, cfg.OutboxInterval)
+	defer relay.Close()
+
+	app.Wait()
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// ChecksumHeader holds hex encoded SHA-256 of downloaded attachment
+const ChecksumHeader = "X-Checksum-SHA256"
+
+var (
+	// maxUpload represents default maximum size of uploaded file in bytes
+	maxUpload int64 = 25 << 20
+	// uploadTypes represents media types accepted by default, type is detected from the content
+	uploadTypes = []string{"application/pdf", "application/zip", "application/x-gzip", "image/png", "image/jpeg", "image/gif", "text/plain"}
+	// formMemory represents part of multipart form kept in memory, the rest is buffered in temporary files
+	formMemory int64 = 1 << 20
+	// formOverhead represents size of multipart form allowed in addition to the file
+	formOverhead int64 = 64 << 10
+)
+
+// UploadAttachment handles POST /task/{id}/attachments, multipart form contains
+// the file, kind of the attachment, brief or deliverable, and optional hex encoded SHA-256 checksum
+// verified after upload. Size and media type of the file are limited
+func (c *Controller) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if c.blobs == nil {
+		logrus.Error("blob store is not configured")
+		w.WriteHeader(501)
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, c.maxUpload+formOverhead)
+	if err := r.ParseMultipartForm(formMemory); err != nil {
+		logrus.Error(err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(413)
+			return
+		}
+		w.WriteHeader(400)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	defer file.Close()
+	if header.Size > c.maxUpload {
+		logrus.Errorf("file of %d bytes exceeds %d bytes", header.Size, c.maxUpload)
+		w.WriteHeader(413)
+		return
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	if !c.accepted(contentType) {
+		logrus.Errorf("media type %s is not accepted", contentType)
+		w.WriteHeader(415)
+		return
+	}
+
+	params := mux.Vars(r)
+	a := model.Attachment{
+		ID:          model.NewID(),
+		TaskID:      params["id"],
+		UploaderID:  user,
+		Kind:        model.AttachmentKind(r.FormValue("kind")),
+		Name:        header.Filename,
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+	// content is stored only when the user may attach it
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	_, err = rpc.Call(ctx, c.conn.Conn, api.AttachmentAuthorize, a)
+	cancel()
+	if err != nil {
+		fail(w, api.AttachmentAuthorize.Subject, err)
+		return
+	}
+	hash := sha256.New()
+	content := io.TeeReader(io.MultiReader(bytes.NewReader(head[:n]), file), hash)
+	if err := c.blobs.Put(r.Context(), a.Key(), content, a.Size, a.ContentType); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	a.Checksum = hex.EncodeToString(hash.Sum(nil))
+	if sum := r.FormValue("checksum"); sum != "" && !strings.EqualFold(sum, a.Checksum) {
+		logrus.Errorf("checksum mismatch expected=%s got=%s", sum, a.Checksum)
+		c.discard(a)
+		w.WriteHeader(400)
+		return
+	}
+	ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	recorded, err := rpc.Call(ctx, c.conn.Conn, api.AttachmentAdd, a)
+	if err != nil {
+		// on timeout the Attachment may have been recorded, its content is kept
+		if rpc.CodeOf(err) != rpc.CodeTimeout {
+			c.discard(a)
+		}
+		fail(w, api.AttachmentAdd.Subject, err)
+		return
+	}
+	writeJSON(w, recorded)
+}
+
+// ListAttachments handles GET /task/{id}/attachments
+func (c *Controller) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	attachments, err := rpc.Call(ctx, c.conn.Conn, api.AttachmentList, model.AttachmentAccess{TaskID: params["id"], UserID: user})
+	if err != nil {
+		fail(w, api.AttachmentList.Subject, err)
+		return
+	}
+	writeJSON(w, attachments)
+}
+
+// DownloadAttachment handles GET /attachment/{id}, checksum of the content is sent as ETag
+func (c *Controller) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	if c.blobs == nil {
+		logrus.Error("blob store is not configured")
+		w.WriteHeader(501)
+		return
+	}
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	a, err := rpc.Call(ctx, c.conn.Conn, api.AttachmentGet, model.AttachmentAccess{ID: params["id"], UserID: user})
+	if err != nil {
+		fail(w, api.AttachmentGet.Subject, err)
+		return
+	}
+	etag := `"` + a.Checksum + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(304)
+		return
+	}
+	content, err := c.blobs.Open(r.Context(), a.Key())
+	if err != nil {
+		logrus.WithField("attachment", a.ID).Error(err)
+		if err == storage.ErrNotFound {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(500)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", etag)
+	w.Header().Set(ChecksumHeader, a.Checksum)
+	if _, err := io.Copy(w, content); err != nil {
+		logrus.WithField("attachment", a.ID).Error(err)
+	}
+}
+
+// accepted reports whether media type of the content is accepted in uploads, parameters are ignored
+func (c *Controller) accepted(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.uploadTypes {
+		if strings.EqualFold(strings.TrimSpace(t), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// discard removes content of the Attachment that was not recorded
+func (c *Controller) discard(a model.Attachment) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.blobs.Delete(ctx, a.Key()); err != nil {
+		logrus.WithField("attachment", a.ID).Error(err)
+	}
+}
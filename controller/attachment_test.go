@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/storage"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var pdf = []byte("%PDF-1.4\n%deliverable\n")
+
+// countingStore counts blobs stored through it
+type countingStore struct {
+	storage.BlobStore
+	puts atomic.Int32
+}
+
+func (s *countingStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	s.puts.Add(1)
+	return s.BlobStore.Put(ctx, key, r, size, contentType)
+}
+
+type attachmentEnv struct {
+	srv   *httptest.Server
+	dir   string
+	blobs *countingStore
+	task  model.Task
+	mu    sync.Mutex
+	added map[string]model.Attachment
+}
+
+// setUpAttachments serves attachment endpoints backed by the Task with assigned Freelancer,
+// the handlers allow only parties of the Task
+func setUpAttachments(t *testing.T, opts ...Option) (*attachmentEnv, func()) {
+	nsOpts := natstest.DefaultTestOptions
+	nsOpts.Port = -1
+	ns := natstest.RunServer(&nsOpts)
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &attachmentEnv{dir: dir, blobs: &countingStore{BlobStore: blobs}, added: map[string]model.Attachment{}}
+	env.task = model.NewTask(0, model.NewMoney(100, model.USD), model.NewID(), "golang app")
+	env.task.FreelancerID = model.NewID()
+	party := func(userID string) bool { return userID == env.task.ClientID || userID == env.task.FreelancerID }
+
+	srv := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.AttachmentAuthorize, func(_ context.Context, a model.Attachment) (rpc.Empty, error) {
+		if !party(a.UploaderID) {
+			return rpc.Empty{}, rpc.Errorf(rpc.CodePermission, "not a participant of the task")
+		}
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(srv, api.AttachmentAdd, func(_ context.Context, a model.Attachment) (model.Attachment, error) {
+		if !party(a.UploaderID) {
+			return a, rpc.Errorf(rpc.CodePermission, "not a participant of the task")
+		}
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		env.added[a.ID] = a
+		return a, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(srv, api.AttachmentGet, func(_ context.Context, req model.AttachmentAccess) (model.Attachment, error) {
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		a, ok := env.added[req.ID]
+		if !ok {
+			return a, sql.ErrNoRows
+		}
+		if !party(req.UserID) {
+			return model.Attachment{}, rpc.Errorf(rpc.CodePermission, "not a participant of the task")
+		}
+		return a, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl := New(encConn, append([]Option{WithBlobStore(env.blobs)}, opts...)...)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/attachments", ctrl.UploadAttachment).Methods("POST")
+	router.HandleFunc("/attachment/{id}", ctrl.DownloadAttachment).Methods("GET")
+	env.srv = httptest.NewServer(router)
+	return env, func() {
+		env.srv.Close()
+		conn.Close()
+		ns.Shutdown()
+	}
+}
+
+// upload posts multipart form with the file and fields, decoded Attachment is returned on success
+func (env *attachmentEnv) upload(t *testing.T, userID string, content []byte, fields map[string]string) (int, model.Attachment) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	part, err := form.CreateFormFile("file", "result.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req, err := http.NewRequest("POST", env.srv.URL+"/task/"+env.task.ID+"/attachments", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if userID != "" {
+		req.Header.Set("Authorization", "Bearer "+token(t, userID))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var a model.Attachment
+	if resp.StatusCode == 200 {
+		if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, a
+}
+
+func TestAttachments(t *testing.T) {
+	env, destroy := setUpAttachments(t)
+	defer destroy()
+
+	sum := sha256.Sum256(pdf)
+	checksum := hex.EncodeToString(sum[:])
+	status, a := env.upload(t, env.task.FreelancerID, pdf, map[string]string{"kind": "deliverable", "checksum": checksum})
+	if status != 200 {
+		t.Fatalf("expected=200 got=%d", status)
+	}
+	if a.Checksum != checksum || a.ContentType != "application/pdf" || a.Size != int64(len(pdf)) ||
+		a.Name != "result.pdf" || a.Kind != model.Deliverable || a.UploaderID != env.task.FreelancerID {
+		t.Errorf("unexpected attachment: %+v", a)
+	}
+
+	resp, err := http.Get(env.srv.URL + "/attachment/" + a.ID + "?access_token=" + token(t, env.task.ClientID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !bytes.Equal(content, pdf) || resp.Header.Get(ChecksumHeader) != checksum ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename=result.pdf` {
+		t.Errorf("unexpected download %d %v %q", resp.StatusCode, resp.Header, content)
+	}
+
+	req, _ := http.NewRequest("GET", env.srv.URL+"/attachment/"+a.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token(t, env.task.ClientID))
+	req.Header.Set("If-None-Match", `"`+checksum+`"`)
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 304 {
+		t.Errorf("expected=304 got=%d", resp.StatusCode)
+	}
+	if resp, err = http.Get(env.srv.URL + "/attachment/" + a.ID + "?access_token=" + token(t, model.NewID())); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Errorf("expected=403 got=%d", resp.StatusCode)
+	}
+}
+
+func TestAttachments_Rejected(t *testing.T) {
+	env, destroy := setUpAttachments(t, WithUploadLimits(int64(len(pdf)), "application/pdf"))
+	defer destroy()
+
+	for _, c := range []struct {
+		name    string
+		userID  string
+		content []byte
+		fields  map[string]string
+		status  int
+		stored  bool
+	}{
+		{"not a participant", model.NewID(), pdf, map[string]string{"kind": "deliverable"}, 403, false},
+		{"anonymous", "", pdf, map[string]string{"kind": "deliverable"}, 401, false},
+		{"checksum mismatch", env.task.FreelancerID, pdf, map[string]string{"kind": "deliverable", "checksum": strings.Repeat("0", 64)}, 400, true},
+		{"media type", env.task.FreelancerID, []byte("\x89PNG\r\n\x1a\n"), map[string]string{"kind": "deliverable"}, 415, false},
+		{"too large", env.task.FreelancerID, append(pdf, ' '), map[string]string{"kind": "deliverable"}, 413, false},
+	} {
+		puts := env.blobs.puts.Load()
+		if status, _ := env.upload(t, c.userID, c.content, c.fields); status != c.status {
+			t.Errorf("%s: expected=%d got=%d", c.name, c.status, status)
+		}
+		if stored := env.blobs.puts.Load() != puts; stored != c.stored {
+			t.Errorf("%s: expected stored=%t got=%t", c.name, c.stored, stored)
+		}
+	}
+	// content of rejected uploads is not kept
+	for _, a := range env.added {
+		t.Errorf("unexpected attachment recorded: %+v", a)
+	}
+	filepath.WalkDir(env.dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			t.Errorf("unexpected blob %s", path)
+		}
+		return err
+	})
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// identityKey represents key of verified identity in request context
+type identityKey struct{}
+
+// Authenticate verifies access token sent in Authorization header as "Bearer {token}" or
+// in access_token parameter, as EventSource cannot set headers. Requests without token are anonymous,
+// requests with invalid or expired token are rejected with 401
+func Authenticate(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.URL.Query().Get("access_token")
+			if h := r.Header.Get("Authorization"); h != "" {
+				var ok bool
+				if token, ok = strings.CutPrefix(h, "Bearer "); !ok {
+					w.WriteHeader(401)
+					return
+				}
+			}
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := auth.Verify(secret, token, time.Now())
+			if err != nil {
+				logrus.WithField("path", r.URL.Path).Error(err)
+				w.WriteHeader(401)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+		})
+	}
+}
+
+// identity returns verified identity of the request, zero for anonymous request
+func identity(r *http.Request) auth.Identity {
+	id, _ := r.Context().Value(identityKey{}).(auth.Identity)
+	return id
+}
+
+// userID returns ID of the Client or Freelancer making the request, empty for anonymous request and operators
+func userID(r *http.Request) string {
+	if id := identity(r); id.Role == auth.User {
+		return id.Subject
+	}
+	return ""
+}
+
+// requireOperator returns name of the operator making the request, anonymous request is answered
+// with 401 and request of the user with 403
+func requireOperator(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := identity(r)
+	switch id.Role {
+	case auth.Operator:
+		return id.Subject, true
+	case auth.User:
+		w.WriteHeader(403)
+	default:
+		w.WriteHeader(401)
+	}
+	return "", false
+}
+
+// requireUser returns ID of the Client or Freelancer making the request,
+// anonymous request is answered with 401
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user := userID(r)
+	if user == "" {
+		w.WriteHeader(401)
+	}
+	return user, user != ""
+}
+
+// requireOwner reports whether the request is made by the user by given ID, anonymous request
+// is answered with 401 and request of other user with 403
+func requireOwner(w http.ResponseWriter, r *http.Request, id string) bool {
+	user, ok := requireUser(w, r)
+	if ok && user != id {
+		w.WriteHeader(403)
+		return false
+	}
+	return ok
+}
+
+// requireParty reports whether the request is made by the Client or the Freelancer of the Task by given ID
+// or by an operator, anonymous request is answered with 401 and request of other user with 403
+func (c *Controller) requireParty(w http.ResponseWriter, r *http.Request, taskID string) bool {
+	id := identity(r)
+	switch id.Role {
+	case auth.Operator:
+		return true
+	case auth.User:
+	default:
+		w.WriteHeader(401)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	task, err := rpc.Call(ctx, c.conn.Conn, api.TaskGet, taskID)
+	if err != nil {
+		fail(w, api.TaskGet.Subject, err)
+		return false
+	}
+	if id.Subject != task.ClientID && id.Subject != task.FreelancerID {
+		w.WriteHeader(403)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// token returns access token of the user
+func token(t *testing.T, userID string) string {
+	token, err := auth.Sign(testSecret, auth.Identity{Subject: userID, Role: auth.User}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	user := model.NewID()
+	operator, err := auth.Sign(testSecret, auth.Identity{Subject: "ops", Role: auth.Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := auth.Sign([]byte("fedcba9876543210fedcba9876543210"), auth.Identity{Subject: user, Role: auth.User}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Authenticate(testSecret)(Actor(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(userID(r) + "|" + rpc.ActorOf(r.Context())))
+	})))
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		status int
+		body   string
+	}{
+		{name: "anonymous", status: 200, body: "|anonymous"},
+		{name: "user", header: "Bearer " + token(t, user), status: 200, body: user + "|" + user},
+		{name: "query", query: "?access_token=" + token(t, user), status: 200, body: user + "|" + user},
+		{name: "operator", header: "Bearer " + operator, status: 200, body: "|ops"},
+		{name: "forged", header: "Bearer " + forged, status: 401},
+		{name: "scheme", header: "Basic " + token(t, user), status: 401},
+		{name: "user_id", query: "?user_id=" + user, status: 200, body: "|anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected=%d got=%d", tt.status, rec.Code)
+			}
+			if tt.status == 200 && rec.Body.String() != tt.body {
+				t.Errorf("expected=%q got=%q", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -1,38 +1,96 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/gorilla/mux"
 
+	"github.com/kylycht/md/api"
 	"github.com/kylycht/md/model"
-	nats "github.com/nats-io/go-nats"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/invoice"
+	"github.com/kylycht/md/storage"
+	nats "github.com/nats-io/nats.go"
 )
 
 var (
 	timeout = time.Second * 10
 )
 
+const dateLayout = "2006-01-02"
+
 // Controller represents REST controller
 type Controller struct {
 	conn *nats.EncodedConn
+	js   nats.JetStreamContext
+	hub  *hub
+
+	blobs       storage.BlobStore
+	maxUpload   int64
+	uploadTypes []string
+}
+
+// Option represents optional configuration of Controller
+type Option func(*Controller)
+
+// WithJetStream makes Controller submit commands to JetStream,
+// such requests are accepted once the command is stored and processed asynchronously
+func WithJetStream(js nats.JetStreamContext) Option {
+	return func(c *Controller) {
+		c.js = js
+	}
+}
+
+// WithBlobStore makes Controller store content of attachments in the store,
+// without it uploads are rejected
+func WithBlobStore(store storage.BlobStore) Option {
+	return func(c *Controller) {
+		c.blobs = store
+	}
 }
 
-// New returns new instance of Controller
-func New(conn *nats.EncodedConn) *Controller {
-	return &Controller{conn: conn}
+// WithUploadLimits sets maximum size in bytes and accepted media types of uploaded files,
+// zero size and no types keep the defaults
+func WithUploadLimits(maxSize int64, types ...string) Option {
+	return func(c *Controller) {
+		if maxSize > 0 {
+			c.maxUpload = maxSize
+		}
+		if len(types) > 0 {
+			c.uploadTypes = types
+		}
+	}
+}
+
+// New returns new instance of Controller, it subscribes to task events streamed to the users
+func New(conn *nats.EncodedConn, opts ...Option) *Controller {
+	c := &Controller{conn: conn, hub: newHub(conn.Conn), maxUpload: maxUpload, uploadTypes: uploadTypes}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.hub.start(); err != nil {
+		logrus.Error(err)
+	}
+	return c
 }
 
 // CreateFreelancer handles POST /freelancer
 func (c *Controller) CreateFreelancer(w http.ResponseWriter, r *http.Request) {
 	var req = struct {
-		Email       string `json:"email"`
-		Description string `json:"description"`
-		Details     string `json:"details"`
+		Email       string                  `json:"email"`
+		Description string                  `json:"description"`
+		Details     string                  `json:"details"`
+		Skills      []model.FreelancerSkill `json:"skills"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(500)
@@ -40,17 +98,12 @@ func (c *Controller) CreateFreelancer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	freelancer := model.NewFreelancer(req.Email, req.Description, req.Details)
-	reply := &model.NATSMsg{}
+	freelancer.Skills = req.Skills
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if err := c.conn.Request("freelancer.add", freelancer, reply, timeout); err != nil {
-		logrus.Error(err)
-		w.WriteHeader(500)
-		return
-	}
-
-	if !reply.Success {
-		logrus.Error(reply.Message)
-		w.WriteHeader(500)
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.FreelancerAdd, freelancer); err != nil {
+		fail(w, api.FreelancerAdd.Subject, err)
 		return
 	}
 	w.Write([]byte(`{"id":"` + freelancer.ID + `"}`))
@@ -61,20 +114,15 @@ func (c *Controller) GetFreelancer(w http.ResponseWriter, r *http.Request) {
 	var freelancer model.Freelancer
 	params := mux.Vars(r)
 	freelancer.ID = params["id"]
-	reply := &model.NATSMsg{}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if err := c.conn.Request("freelancer.get", freelancer.ID, reply, timeout); err != nil {
-		logrus.Error(err)
-		w.WriteHeader(500)
-		return
-	}
-
-	if !reply.Success {
-		logrus.Error(reply.Message)
-		w.WriteHeader(500)
+	freelancer, err := rpc.Call(ctx, c.conn.Conn, api.FreelancerGet, freelancer.ID)
+	if err != nil {
+		fail(w, api.FreelancerGet.Subject, err)
 		return
 	}
-	w.Write(reply.Data)
+	writeJSON(w, freelancer)
 }
 
 // GetClient handles GET /client/{id}
@@ -92,18 +140,29 @@ func (c *Controller) GetClient(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		return
 	}
-	reply := &model.NATSMsg{}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if err := c.conn.Request("client.get", client.ID, reply, timeout); err != nil {
-		w.WriteHeader(500)
+	client, err := rpc.Call(ctx, c.conn.Conn, api.ClientGet, client.ID)
+	if err != nil {
+		fail(w, api.ClientGet.Subject, err)
 		return
 	}
+	writeJSON(w, client)
+}
 
-	if !reply.Success {
-		w.WriteHeader(500)
+// ListWallets handles GET /client/{id}/wallets and GET /freelancer/{id}/wallets
+func (c *Controller) ListWallets(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	wallets, err := rpc.Call(ctx, c.conn.Conn, api.WalletList, params["id"])
+	if err != nil {
+		fail(w, api.WalletList.Subject, err)
 		return
 	}
-	w.Write(reply.Data)
+	writeJSON(w, wallets)
 }
 
 // GetTask handles GET /task/{id}
@@ -117,51 +176,113 @@ func (c *Controller) GetTask(w http.ResponseWriter, r *http.Request) {
 		logrus.Error("missing ID")
 		return
 	}
-	reply := &model.NATSMsg{}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if err := c.conn.Request("task.get", task.ID, reply, timeout); err != nil {
-		logrus.Error(err)
-		w.WriteHeader(500)
+	task, err := rpc.Call(ctx, c.conn.Conn, api.TaskGet, task.ID)
+	if err != nil {
+		fail(w, api.TaskGet.Subject, err)
 		return
 	}
+	writeJSON(w, task)
+}
+
+// SearchTasks handles GET /tasks/search?q={text}&tags={tag,tag}&category={id}&skills={id,id}&min_fee={amount}&max_fee={amount}&currency={code}&max_deadline={seconds}&limit={n}&offset={n},
+// every parameter is optional, fees are in minor units of the currency(USD by default)
+func (c *Controller) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := model.TaskQuery{Text: params.Get("q"), Tags: listParam(params, "tags"), Category: params.Get("category"), Skills: listParam(params, "skills")}
+	currency := model.DefaultCurrency
+	if v := params.Get("currency"); v != "" {
+		currency = model.Currency(strings.ToUpper(v))
+	}
+	numbers := map[string]int64{}
+	for _, name := range []string{"min_fee", "max_fee", "max_deadline", "limit", "offset"} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(400)
+			return
+		}
+		numbers[name] = n
+	}
+	query.MinFee = model.NewMoney(numbers["min_fee"], currency)
+	query.MaxFee = model.NewMoney(numbers["max_fee"], currency)
+	query.MaxDeadline = time.Duration(numbers["max_deadline"]) * time.Second
+	query.Limit, query.Offset = int(numbers["limit"]), int(numbers["offset"])
 
-	if !reply.Success {
-		logrus.Error(reply.Message)
-		w.WriteHeader(500)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	result, err := rpc.Call(ctx, c.conn.Conn, api.TaskSearch, query)
+	if err != nil {
+		fail(w, api.TaskSearch.Subject, err)
 		return
 	}
-	w.Write(reply.Data)
+	writeJSON(w, result)
 }
 
 // CreateTask handles POST /task
 func (c *Controller) CreateTask(w http.ResponseWriter, r *http.Request) {
 	var req = struct {
-		ClientID    string `json:"client_id"`
-		Description string `json:"description"`
-		Deadline    int64  `json:"deadline"`
-		Fee         int64  `json:"fee"`
+		ClientID    string      `json:"client_id"`
+		Description string      `json:"description"`
+		Deadline    int64       `json:"deadline"`
+		Fee         model.Money `json:"fee"`
+		Contract    string      `json:"contract"`
+		HourlyRate  model.Money `json:"hourly_rate"`
+		WeeklyCap   int64       `json:"weekly_cap"`
+		Tags        []string    `json:"tags"`
+		Category    string      `json:"category"`
+		Skills      []string    `json:"skills"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logrus.Error(err)
 		w.WriteHeader(500)
 		return
 	}
-	task := model.NewTask(time.Duration(req.Deadline)*time.Second, req.Fee, req.ClientID, req.Description)
-	reply := &model.NATSMsg{}
-	if err := c.conn.Request("task.add", task, reply, timeout); err != nil {
-		w.WriteHeader(500)
-		logrus.WithField("endpoint", "task.add").WithField("msg", reply.Message).Error(err)
+	deadline := time.Duration(req.Deadline) * time.Second
+	task := model.NewTask(deadline, req.Fee, req.ClientID, req.Description)
+	if model.ContractType(req.Contract) == model.HourlyContract {
+		task = model.NewHourlyTask(deadline, req.HourlyRate, time.Duration(req.WeeklyCap)*time.Second, req.ClientID, req.Description)
+	}
+	task.Tags, task.Category, task.Skills = req.Tags, req.Category, req.Skills
+	if c.js != nil {
+		c.submit(w, api.TaskAdd.Subject, task.ID, task)
 		return
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if !reply.Success {
-		logrus.WithField("msg", reply.Message).Error()
-		w.WriteHeader(500)
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.TaskAdd, task); err != nil {
+		fail(w, api.TaskAdd.Subject, err)
 		return
 	}
 	w.Write([]byte(`{"id":"` + task.ID + `"}`))
 }
 
+// submit stores command in JetStream and responds with 202 Accepted,
+// command ID is used as message ID so retried requests are not processed twice
+func (c *Controller) submit(w http.ResponseWriter, subject, id string, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	if _, err := c.js.Publish(subject, d, nats.MsgId(id)); err != nil {
+		logrus.WithField("endpoint", subject).Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(202)
+	w.Write([]byte(`{"id":"` + id + `"}`))
+}
+
 // UpdateTask handles PUT /task/{id}
 func (c *Controller) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	var task model.Task
@@ -181,20 +302,327 @@ func (c *Controller) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reply := &model.NATSMsg{}
-	if err := c.conn.Request("task.update", task, reply, timeout); err != nil {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.TaskUpdate, task); err != nil {
+		fail(w, api.TaskUpdate.Subject, err)
+		return
+	}
+
+	w.Write([]byte(`{"id":"` + task.ID + `"}`))
+}
+
+// GetInvoice handles GET /task/{id}/invoice?format={pdf|csv}, only the Task's parties and operators get the Invoice
+func (c *Controller) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	taskID := params["id"]
+	if taskID == "" {
+		logrus.Error("missing ID")
+		w.WriteHeader(500)
+		return
+	}
+	if !c.requireParty(w, r, taskID) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "csv" {
+		logrus.WithField("format", format).Error("unsupported invoice format")
+		w.WriteHeader(400)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	inv, err := rpc.Call(ctx, c.conn.Conn, api.InvoiceGet, taskID)
+	if err != nil {
+		fail(w, api.InvoiceGet.Subject, err)
+		return
+	}
+
+	// invoice is rendered before the response is started, so invoice that can not be rendered gets error status
+	var doc bytes.Buffer
+	contentType := "application/pdf"
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+		err = invoice.RenderCSV(&doc, inv)
+	default:
+		err = invoice.RenderPDF(&doc, inv)
+	}
+	if err != nil {
+		fail(w, "invoice.render", err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+inv.Code()+"."+format+`"`)
+	doc.WriteTo(w)
+}
+
+// LogTime handles POST /task/{id}/time
+func (c *Controller) LogTime(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		Date     string `json:"date"`
+		Duration int64  `json:"duration"`
+		Note     string `json:"note"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	date, err := time.Parse(dateLayout, req.Date)
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	params := mux.Vars(r)
+	entry := model.NewTimeEntry(params["id"], user, date, time.Duration(req.Duration)*time.Second, req.Note)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.TimesheetLog, entry); err != nil {
+		fail(w, api.TimesheetLog.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + entry.ID + `"}`))
+}
+
+// GetTimesheet handles GET /task/{id}/timesheet?week={YYYY-MM-DD}, only the Task's parties and operators see the Timesheet
+func (c *Controller) GetTimesheet(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	week, err := parseWeek(r.URL.Query().Get("week"))
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	if !c.requireParty(w, r, params["id"]) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	ts, err := rpc.Call(ctx, c.conn.Conn, api.TimesheetGet, model.Timesheet{TaskID: params["id"], Week: week})
+	if err != nil {
+		fail(w, api.TimesheetGet.Subject, err)
+		return
+	}
+	writeJSON(w, ts)
+}
+
+// ApproveTimesheet handles PUT /task/{id}/timesheet/approve
+func (c *Controller) ApproveTimesheet(w http.ResponseWriter, r *http.Request) {
+	c.reviewTimesheet(w, r, api.TimesheetApprove)
+}
+
+// RejectTimesheet handles PUT /task/{id}/timesheet/reject
+func (c *Controller) RejectTimesheet(w http.ResponseWriter, r *http.Request) {
+	c.reviewTimesheet(w, r, api.TimesheetReject)
+}
+
+func (c *Controller) reviewTimesheet(w http.ResponseWriter, r *http.Request, e rpc.Endpoint[model.Timesheet, rpc.Empty]) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		Week string `json:"week"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logrus.Error(err)
 		w.WriteHeader(500)
 		return
 	}
+	week, err := parseWeek(req.Week)
+	if err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if !reply.Success {
-		logrus.Error(reply.Message)
+	if _, err := rpc.Call(ctx, c.conn.Conn, e, model.Timesheet{TaskID: params["id"], ClientID: user, Week: week}); err != nil {
+		fail(w, e.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + params["id"] + `"}`))
+}
+
+// fail logs failed request and responds with HTTP status matching the error code
+func fail(w http.ResponseWriter, subject string, err error) {
+	logrus.WithField("endpoint", subject).Error(err)
+	switch rpc.CodeOf(err) {
+	case rpc.CodeNotFound:
+		w.WriteHeader(404)
+	case rpc.CodeInvalid:
+		w.WriteHeader(400)
+	case rpc.CodePermission:
+		w.WriteHeader(403)
+	case rpc.CodeTimeout:
+		w.WriteHeader(504)
+	default:
+		w.WriteHeader(500)
+	}
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		logrus.Error(err)
 		w.WriteHeader(500)
 		return
 	}
+	w.Write(d)
+}
 
-	w.Write([]byte(`{"id":"` + task.ID + `"}`))
+// listParam returns values of the query parameter given as comma separated list or repeated
+func listParam(params url.Values, name string) []string {
+	var values []string
+	for _, v := range params[name] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// parseWeek parses week given as any day of it, empty value means current week
+func parseWeek(v string) (time.Time, error) {
+	if v == "" {
+		return model.WeekStart(time.Now()), nil
+	}
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		return t, err
+	}
+	return model.WeekStart(t), nil
+}
+
+// OpenDispute handles POST /task/{id}/dispute
+func (c *Controller) OpenDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	params := mux.Vars(r)
+	if params["id"] == "" {
+		logrus.Error("missing ID")
+		w.WriteHeader(500)
+		return
+	}
+	dispute := model.NewDispute(params["id"], user, req.Reason)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.DisputeOpen, dispute); err != nil {
+		fail(w, api.DisputeOpen.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + dispute.ID + `"}`))
+}
+
+// GetDispute handles GET /dispute/{id}, only the Task's parties and operators see the Dispute
+func (c *Controller) GetDispute(w http.ResponseWriter, r *http.Request) {
+	if identity(r).Role == "" {
+		w.WriteHeader(401)
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	dispute, err := rpc.Call(ctx, c.conn.Conn, api.DisputeGet, params["id"])
+	if err != nil {
+		fail(w, api.DisputeGet.Subject, err)
+		return
+	}
+	if !c.requireParty(w, r, dispute.TaskID) {
+		return
+	}
+	writeJSON(w, dispute)
+}
+
+// AddDisputeStatement handles POST /dispute/{id}/statement
+func (c *Controller) AddDisputeStatement(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var statement model.DisputeStatement
+	if err := json.NewDecoder(r.Body).Decode(&statement); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	params := mux.Vars(r)
+	statement.ID = model.NewID()
+	statement.DisputeID = params["id"]
+	statement.AuthorID = user
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.DisputeStatement, statement); err != nil {
+		fail(w, api.DisputeStatement.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + statement.ID + `"}`))
+}
+
+// ResolveDispute handles PUT /dispute/{id}/resolve, only operators resolve Disputes
+func (c *Controller) ResolveDispute(w http.ResponseWriter, r *http.Request) {
+	operator, ok := requireOperator(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		FreelancerAmount model.Money `json:"freelancer_amount"`
+		ClientAmount     model.Money `json:"client_amount"`
+		Resolution       string      `json:"resolution"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(500)
+		return
+	}
+	params := mux.Vars(r)
+	dispute := model.Dispute{
+		ID:               params["id"],
+		FreelancerAmount: req.FreelancerAmount,
+		ClientAmount:     req.ClientAmount,
+		Resolution:       sql.NullString{String: req.Resolution, Valid: req.Resolution != ""},
+		ResolvedBy:       sql.NullString{String: operator, Valid: true},
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.DisputeResolve, dispute); err != nil {
+		fail(w, api.DisputeResolve.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + dispute.ID + `"}`))
 }
 
 // CreateClient handles POST /client
@@ -206,18 +634,203 @@ func (c *Controller) CreateClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client.ID = model.NewID()
-	reply := &model.NATSMsg{}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
 
-	if err := c.conn.Request("client.add", client, reply, timeout); err != nil {
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.ClientAdd, client); err != nil {
+		fail(w, api.ClientAdd.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + client.ID + `"}`))
+}
+
+// CreateWebhook handles POST /client/{id}/webhooks of the authenticated Client,
+// the response contains secret deliveries are signed with
+func (c *Controller) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	var req = struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logrus.Error(err)
 		w.WriteHeader(500)
 		return
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	webhook, err := rpc.Call(ctx, c.conn.Conn, api.WebhookAdd, model.Webhook{ClientID: params["id"], URL: req.URL, Events: req.Events})
+	if err != nil {
+		fail(w, api.WebhookAdd.Subject, err)
+		return
+	}
+	writeJSON(w, webhook)
+}
 
-	if !reply.Success {
-		logrus.Error(reply.Message)
+// ListWebhooks handles GET /client/{id}/webhooks of the authenticated Client
+func (c *Controller) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	webhooks, err := rpc.Call(ctx, c.conn.Conn, api.WebhookList, params["id"])
+	if err != nil {
+		fail(w, api.WebhookList.Subject, err)
+		return
+	}
+	writeJSON(w, webhooks)
+}
+
+// DeleteWebhook handles DELETE /webhook/{id} of the authenticated Client
+func (c *Controller) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.WebhookDelete, model.WebhookAccess{ID: params["id"], ClientID: user}); err != nil {
+		fail(w, api.WebhookDelete.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + params["id"] + `"}`))
+}
+
+// ListDeliveries handles GET /webhook/{id}/deliveries of the authenticated Client
+func (c *Controller) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	deliveries, err := rpc.Call(ctx, c.conn.Conn, api.WebhookDeliveries, model.WebhookAccess{ID: params["id"], ClientID: user})
+	if err != nil {
+		fail(w, api.WebhookDeliveries.Subject, err)
+		return
+	}
+	writeJSON(w, deliveries)
+}
+
+// ReplayDelivery handles POST /webhook/delivery/{id}/replay of the authenticated Client
+func (c *Controller) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	delivery, err := rpc.Call(ctx, c.conn.Conn, api.WebhookReplay, model.WebhookAccess{ID: params["id"], ClientID: user})
+	if err != nil {
+		fail(w, api.WebhookReplay.Subject, err)
+		return
+	}
+	writeJSON(w, delivery)
+}
+
+// anonymous represents actor of the requests made without access token
+const anonymous = "anonymous"
+
+// Actor passes the authenticated user or operator making the request to the services it calls,
+// so changes made by the request are audited on behalf of them. It runs after Authenticate,
+// which verifies the identity and bounds its length by the length of the audit log's actor
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := identity(r).Subject
+		if actor == "" {
+			actor = anonymous
+		}
+		next.ServeHTTP(w, r.WithContext(rpc.WithActor(r.Context(), actor)))
+	})
+}
+
+// SendMessage handles POST /task/{id}/messages, the message is sent by the authenticated user
+func (c *Controller) SendMessage(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		Body string `json:"body"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
 		w.WriteHeader(500)
 		return
 	}
-	w.Write([]byte(`{"id":"` + client.ID + `"}`))
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	msg, err := rpc.Call(ctx, c.conn.Conn, api.MessageSend, model.Message{TaskID: params["id"], SenderID: user, Body: req.Body})
+	if err != nil {
+		fail(w, api.MessageSend.Subject, err)
+		return
+	}
+	writeJSON(w, msg)
+}
+
+// ListMessages handles GET /task/{id}/messages, the thread is read by the authenticated user
+func (c *Controller) ListMessages(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	thread, err := rpc.Call(ctx, c.conn.Conn, api.MessageList, model.Thread{TaskID: params["id"], UserID: user})
+	if err != nil {
+		fail(w, api.MessageList.Subject, err)
+		return
+	}
+	writeJSON(w, thread)
+}
+
+// ReadMessages handles PUT /task/{id}/messages/read, messages are marked read for the authenticated user
+func (c *Controller) ReadMessages(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.MessageRead, model.Thread{TaskID: params["id"], UserID: user}); err != nil {
+		fail(w, api.MessageRead.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + params["id"] + `"}`))
+}
+
+// UnreadMessages handles GET /client/{id}/messages/unread and GET /freelancer/{id}/messages/unread
+func (c *Controller) UnreadMessages(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	threads, err := rpc.Call(ctx, c.conn.Conn, api.MessageUnread, params["id"])
+	if err != nil {
+		fail(w, api.MessageUnread.Subject, err)
+		return
+	}
+	writeJSON(w, threads)
 }
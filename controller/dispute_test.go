@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestResolveDispute_Operator(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := make(chan model.Dispute, 1)
+	if err := rpc.Register(rpc.NewServer(conn, rpc.Defaults()...), api.DisputeResolve, func(ctx context.Context, d model.Dispute) (rpc.Empty, error) {
+		resolved <- d
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/dispute/{id}/resolve", New(encConn).ResolveDispute).Methods("PUT")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	operator, err := auth.Sign(testSecret, auth.Identity{Subject: "ops", Role: auth.Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: 401},
+		{name: "user", token: token(t, model.NewID()), status: 403},
+		{name: "operator", token: operator, status: 200},
+	} {
+		req, err := http.NewRequest("PUT", srv.URL+"/dispute/"+model.NewID()+"/resolve",
+			strings.NewReader(`{"client_amount":{"amount":500,"currency":"EUR"},"resolution":"refund","resolved_by":"someone"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+	// only the operator's request reaches the service, resolver is the operator
+	select {
+	case d := <-resolved:
+		if d.ResolvedBy.String != "ops" {
+			t.Errorf("expected=%s got=%s", "ops", d.ResolvedBy.String)
+		}
+	default:
+		t.Error("dispute was not resolved")
+	}
+}
+
+func TestDispute_Party(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, freelancer, other := model.NewID(), model.NewID(), model.NewID()
+	task := model.Task{ID: model.NewID(), ClientID: client, FreelancerID: freelancer}
+	server := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(server, api.TaskGet, func(_ context.Context, id string) (model.Task, error) {
+		return task, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(server, api.DisputeGet, func(_ context.Context, id string) (model.Dispute, error) {
+		return model.Dispute{ID: id, TaskID: task.ID}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan model.Dispute, 1)
+	if err := rpc.Register(server, api.DisputeOpen, func(_ context.Context, d model.Dispute) (rpc.Empty, error) {
+		opened <- d
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	submitted := make(chan model.DisputeStatement, 1)
+	if err := rpc.Register(server, api.DisputeStatement, func(_ context.Context, s model.DisputeStatement) (rpc.Empty, error) {
+		submitted <- s
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/dispute", c.OpenDispute).Methods("POST")
+	router.HandleFunc("/dispute/{id}", c.GetDispute).Methods("GET")
+	router.HandleFunc("/dispute/{id}/statement", c.AddDisputeStatement).Methods("POST")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	operator, err := auth.Sign(testSecret, auth.Identity{Subject: "ops", Role: auth.Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body, token string) int {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: 401},
+		{name: "other user", token: token(t, other), status: 403},
+		{name: "client", token: token(t, client), status: 200},
+		{name: "freelancer", token: token(t, freelancer), status: 200},
+		{name: "operator", token: operator, status: 200},
+	} {
+		if status := do("GET", "/dispute/"+model.NewID(), "", tt.token); status != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, status)
+		}
+	}
+
+	// parties in the body are ignored
+	if status := do("POST", "/task/"+task.ID+"/dispute", `{"opened_by":"`+other+`","reason":"late"}`, ""); status != 401 {
+		t.Errorf("expected=401 got=%d", status)
+	}
+	if status := do("POST", "/task/"+task.ID+"/dispute", `{"opened_by":"`+other+`","reason":"late"}`, token(t, client)); status != 200 {
+		t.Fatalf("expected=200 got=%d", status)
+	}
+	if d := <-opened; d.OpenedBy != client {
+		t.Errorf("expected=%s got=%s", client, d.OpenedBy)
+	}
+	if status := do("POST", "/dispute/"+model.NewID()+"/statement", `{"author_id":"`+other+`","statement":"done"}`, ""); status != 401 {
+		t.Errorf("expected=401 got=%d", status)
+	}
+	if status := do("POST", "/dispute/"+model.NewID()+"/statement", `{"author_id":"`+other+`","statement":"done"}`, token(t, freelancer)); status != 200 {
+		t.Fatalf("expected=200 got=%d", status)
+	}
+	if s := <-submitted; s.AuthorID != freelancer {
+		t.Errorf("expected=%s got=%s", freelancer, s.AuthorID)
+	}
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/invoice"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestGetInvoice_Status(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, unpaid, unicode := model.NewID(), model.NewID(), model.NewID()
+	client, freelancer, other := model.NewID(), model.NewID(), model.NewID()
+	server := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(server, api.TaskGet, func(_ context.Context, id string) (model.Task, error) {
+		return model.Task{ID: id, ClientID: client, FreelancerID: freelancer}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(server, api.InvoiceGet, func(_ context.Context, taskID string) (model.Invoice, error) {
+		switch taskID {
+		case unknown:
+			return model.Invoice{}, sql.ErrNoRows
+		case unpaid:
+			return model.Invoice{}, invoice.ErrNotPaid
+		}
+		return model.Invoice{ID: model.NewID(), Number: 1, TaskID: taskID, Description: "移动应用", Amount: model.NewMoney(100, model.USD)}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/invoice", New(encConn).GetInvoice).Methods("GET")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	operator, err := auth.Sign(testSecret, auth.Identity{Subject: "ops", Role: auth.Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "unknown task", path: "/task/" + unknown + "/invoice", token: operator, status: 404},
+		{name: "unpaid task", path: "/task/" + unpaid + "/invoice", token: operator, status: 400},
+		{name: "pdf of unsupported text", path: "/task/" + unicode + "/invoice", token: operator, status: 400},
+		{name: "csv of unsupported text", path: "/task/" + unicode + "/invoice?format=csv", token: operator, status: 200},
+		{name: "anonymous", path: "/task/" + unicode + "/invoice?format=csv", status: 401},
+		{name: "other user", path: "/task/" + unicode + "/invoice?format=csv", token: token(t, other), status: 403},
+		{name: "client", path: "/task/" + unicode + "/invoice?format=csv", token: token(t, client), status: 200},
+		{name: "freelancer", path: "/task/" + unicode + "/invoice?format=csv", token: token(t, freelancer), status: 200},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, resp.StatusCode)
+		}
+		if tt.status != 200 && resp.Header.Get("Content-Disposition") != "" {
+			t.Errorf("%s: failed response has attachment", tt.name)
+		}
+	}
+}
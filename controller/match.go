@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// RecommendFreelancers handles GET /task/{id}/recommended-freelancers?limit={n}
+func (c *Controller) RecommendFreelancers(w http.ResponseWriter, r *http.Request) {
+	c.recommend(w, r, api.MatchFreelancers)
+}
+
+// RecommendTasks handles GET /freelancer/{id}/recommended-tasks?limit={n}
+func (c *Controller) RecommendTasks(w http.ResponseWriter, r *http.Request) {
+	c.recommend(w, r, api.MatchTasks)
+}
+
+func (c *Controller) recommend(w http.ResponseWriter, r *http.Request, e rpc.Endpoint[model.MatchQuery, []model.Match]) {
+	params := mux.Vars(r)
+	query := model.MatchQuery{ID: params["id"]}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(400)
+			return
+		}
+		query.Limit = n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	matches, err := rpc.Call(ctx, c.conn.Conn, e, query)
+	if err != nil {
+		fail(w, e.Subject, err)
+		return
+	}
+	writeJSON(w, matches)
+}
+
+// RateTask handles POST /task/{id}/rating, authenticated Client rates Freelancer of the closed Task
+func (c *Controller) RateTask(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		Score int `json:"score"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	params := mux.Vars(r)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	rating, err := rpc.Call(ctx, c.conn.Conn, api.RatingAdd, model.Rating{TaskID: params["id"], ClientID: user, Score: req.Score})
+	if err != nil {
+		fail(w, api.RatingAdd.Subject, err)
+		return
+	}
+	writeJSON(w, rating)
+}
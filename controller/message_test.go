@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestSendMessage_Sender(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(rpc.NewServer(conn, rpc.Defaults()...), api.MessageSend, func(_ context.Context, m model.Message) (model.Message, error) {
+		return m, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/messages", New(encConn).SendMessage).Methods("POST")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	sender, other := model.NewID(), model.NewID()
+	send := func(token string) *http.Response {
+		req, err := http.NewRequest("POST", srv.URL+"/task/"+model.NewID()+"/messages",
+			strings.NewReader(`{"sender_id":"`+other+`","body":"hi"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// sender in the body is ignored
+	resp := send(token(t, sender))
+	defer resp.Body.Close()
+	var msg model.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.SenderID != sender {
+		t.Errorf("expected=%s got=%s", sender, msg.SenderID)
+	}
+	anonymous := send("")
+	anonymous.Body.Close()
+	if anonymous.StatusCode != 401 {
+		t.Errorf("expected=401 got=%d", anonymous.StatusCode)
+	}
+}
+
+func TestUnreadMessages_Owner(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(rpc.NewServer(conn, rpc.Defaults()...), api.MessageUnread, func(_ context.Context, id string) ([]model.Thread, error) {
+		return []model.Thread{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/client/{id}/messages/unread", New(encConn).UnreadMessages).Methods("GET")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	client := model.NewID()
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: 401},
+		{name: "other user", token: token(t, model.NewID()), status: 403},
+		{name: "owner", token: token(t, client), status: 200},
+	} {
+		req, err := http.NewRequest("GET", srv.URL+"/client/"+client+"/messages/unread", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// GetEmailPreferences handles GET /client/{id}/email-preferences and GET /freelancer/{id}/email-preferences
+func (c *Controller) GetEmailPreferences(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	prefs, err := rpc.Call(ctx, c.conn.Conn, api.EmailPreferencesGet, params["id"])
+	if err != nil {
+		fail(w, api.EmailPreferencesGet.Subject, err)
+		return
+	}
+	writeJSON(w, prefs)
+}
+
+// SetEmailPreferences handles PUT /client/{id}/email-preferences and PUT /freelancer/{id}/email-preferences
+func (c *Controller) SetEmailPreferences(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	var req = struct {
+		OptOut []string `json:"opt_out"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	prefs, err := rpc.Call(ctx, c.conn.Conn, api.EmailPreferencesSet, model.EmailPreferences{UserID: params["id"], OptOut: req.OptOut})
+	if err != nil {
+		fail(w, api.EmailPreferencesSet.Subject, err)
+		return
+	}
+	writeJSON(w, prefs)
+}
+
+// ListNotifications handles GET /notifications?unread={bool}&limit={n}&offset={n},
+// it returns the authenticated user's inbox, the latest Notifications first
+func (c *Controller) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	query := model.NotificationQuery{UserID: user}
+	if v := params.Get("unread"); v != "" {
+		unread, err := strconv.ParseBool(v)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(400)
+			return
+		}
+		query.Unread = unread
+	}
+	numbers := map[string]int{}
+	for _, name := range []string{"limit", "offset"} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(400)
+			return
+		}
+		numbers[name] = n
+	}
+	query.Limit, query.Offset = numbers["limit"], numbers["offset"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	page, err := rpc.Call(ctx, c.conn.Conn, api.NotificationList, query)
+	if err != nil {
+		fail(w, api.NotificationList.Subject, err)
+		return
+	}
+	writeJSON(w, page)
+}
+
+// ReadNotifications handles PUT /notifications/read, it marks the user's Notifications
+// listed in ids as read, or every Notification of the user when all is set
+func (c *Controller) ReadNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	var req = struct {
+		IDs []string `json:"ids"`
+		All bool     `json:"all"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.NotificationRead, model.NotificationRead{UserID: user, IDs: req.IDs, All: req.All}); err != nil {
+		fail(w, api.NotificationRead.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"user_id":"` + user + `"}`))
+}
+
+// StreamNotifications handles GET /notifications/stream, it pushes new Notifications
+// of the authenticated user as Server-Sent Events named notification. Notifications created while the stream
+// was disconnected are not replayed, reconnected clients fetch the inbox again
+func (c *Controller) StreamNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logrus.Error("streaming is not supported")
+		w.WriteHeader(500)
+		return
+	}
+
+	msgs := make(chan *nats.Msg, subscriberBuffer)
+	sub, err := c.conn.Conn.ChanSubscribe(api.NotificationSubject(user), msgs)
+	if err != nil {
+		fail(w, api.NotificationSubject(user), err)
+		return
+	}
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-msgs:
+			// Notifications are published as JSON
+			var n model.Notification
+			if err := json.Unmarshal(msg.Data, &n); err != nil {
+				logrus.Error(err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: notification\ndata: %s\n\n", n.ID, msg.Data)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestEmailPreferences_Owner(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.EmailPreferencesGet, func(_ context.Context, id string) (model.EmailPreferences, error) {
+		return model.EmailPreferences{UserID: id}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(srv, api.EmailPreferencesSet, func(_ context.Context, p model.EmailPreferences) (model.EmailPreferences, error) {
+		return p, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/freelancer/{id}/email-preferences", ctrl.GetEmailPreferences).Methods("GET")
+	router.HandleFunc("/freelancer/{id}/email-preferences", ctrl.SetEmailPreferences).Methods("PUT")
+	gateway := httptest.NewServer(router)
+	defer gateway.Close()
+
+	freelancer := model.NewID()
+	for _, method := range []string{"GET", "PUT"} {
+		for _, tt := range []struct {
+			name   string
+			token  string
+			status int
+		}{
+			{name: "anonymous", status: 401},
+			{name: "other user", token: token(t, model.NewID()), status: 403},
+			{name: "owner", token: token(t, freelancer), status: 200},
+		} {
+			req, err := http.NewRequest(method, gateway.URL+"/freelancer/"+freelancer+"/email-preferences", strings.NewReader(`{"opt_out":["*"]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s %s: expected=%d got=%d", method, tt.name, tt.status, resp.StatusCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// ListCategories handles GET /categories
+func (c *Controller) ListCategories(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	categories, err := rpc.Call(ctx, c.conn.Conn, api.CategoryList, rpc.Empty{})
+	if err != nil {
+		fail(w, api.CategoryList.Subject, err)
+		return
+	}
+	writeJSON(w, categories)
+}
+
+// CreateCategory handles POST /categories, existing Category is renamed, only operators manage Categories
+func (c *Controller) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperator(w, r); !ok {
+		return
+	}
+	var category model.Category
+	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	category, err := rpc.Call(ctx, c.conn.Conn, api.CategoryAdd, category)
+	if err != nil {
+		fail(w, api.CategoryAdd.Subject, err)
+		return
+	}
+	writeJSON(w, category)
+}
+
+// ListSkills handles GET /skills?category={id}, every Skill is returned without category
+func (c *Controller) ListSkills(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	skills, err := rpc.Call(ctx, c.conn.Conn, api.SkillList, r.URL.Query().Get("category"))
+	if err != nil {
+		fail(w, api.SkillList.Subject, err)
+		return
+	}
+	writeJSON(w, skills)
+}
+
+// CreateSkill handles POST /skills, existing Skill is updated, only operators manage Skills
+func (c *Controller) CreateSkill(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOperator(w, r); !ok {
+		return
+	}
+	var skill model.Skill
+	if err := json.NewDecoder(r.Body).Decode(&skill); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	skill, err := rpc.Call(ctx, c.conn.Conn, api.SkillAdd, skill)
+	if err != nil {
+		fail(w, api.SkillAdd.Subject, err)
+		return
+	}
+	writeJSON(w, skill)
+}
+
+// SetFreelancerSkills handles PUT /freelancer/{id}/skills, declared Skills are replaced
+func (c *Controller) SetFreelancerSkills(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !requireOwner(w, r, params["id"]) {
+		return
+	}
+	var req = struct {
+		Skills []model.FreelancerSkill `json:"skills"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.Error(err)
+		w.WriteHeader(400)
+		return
+	}
+	freelancer := model.Freelancer{ID: params["id"], Skills: req.Skills}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	if _, err := rpc.Call(ctx, c.conn.Conn, api.FreelancerSkills, freelancer); err != nil {
+		fail(w, api.FreelancerSkills.Subject, err)
+		return
+	}
+	w.Write([]byte(`{"id":"` + freelancer.ID + `"}`))
+}
+
+// SearchFreelancers handles GET /freelancers/search?skills={id,id}&min_level={1-3}&category={id}&limit={n}&offset={n},
+// every parameter is optional
+func (c *Controller) SearchFreelancers(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := model.FreelancerQuery{Skills: listParam(params, "skills"), Category: params.Get("category")}
+	numbers := map[string]int{}
+	for _, name := range []string{"min_level", "limit", "offset"} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			logrus.Error(err)
+			w.WriteHeader(400)
+			return
+		}
+		numbers[name] = n
+	}
+	query.MinLevel = model.SkillLevel(numbers["min_level"])
+	query.Limit, query.Offset = numbers["limit"], numbers["offset"]
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	freelancers, err := rpc.Call(ctx, c.conn.Conn, api.FreelancerSearch, query)
+	if err != nil {
+		fail(w, api.FreelancerSearch.Subject, err)
+		return
+	}
+	writeJSON(w, freelancers)
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/auth"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestSkills_Access(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.CategoryAdd, func(_ context.Context, c model.Category) (model.Category, error) {
+		return c, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(srv, api.SkillAdd, func(_ context.Context, s model.Skill) (model.Skill, error) {
+		return s, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(srv, api.FreelancerSkills, func(_ context.Context, f model.Freelancer) (rpc.Empty, error) {
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/categories", ctrl.CreateCategory).Methods("POST")
+	router.HandleFunc("/skills", ctrl.CreateSkill).Methods("POST")
+	router.HandleFunc("/freelancer/{id}/skills", ctrl.SetFreelancerSkills).Methods("PUT")
+	gateway := httptest.NewServer(router)
+	defer gateway.Close()
+
+	operator, err := auth.Sign(testSecret, auth.Identity{Subject: "ops", Role: auth.Operator}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	freelancer := model.NewID()
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+	}{
+		{name: "anonymous category", method: "POST", path: "/categories", body: `{"id":"audio","name":"Audio"}`, status: 401},
+		{name: "user category", method: "POST", path: "/categories", body: `{"id":"audio","name":"Audio"}`, token: token(t, freelancer), status: 403},
+		{name: "operator category", method: "POST", path: "/categories", body: `{"id":"audio","name":"Audio"}`, token: operator, status: 200},
+		{name: "anonymous skill", method: "POST", path: "/skills", body: `{"id":"mixing","name":"Mixing"}`, status: 401},
+		{name: "user skill", method: "POST", path: "/skills", body: `{"id":"mixing","name":"Mixing"}`, token: token(t, freelancer), status: 403},
+		{name: "operator skill", method: "POST", path: "/skills", body: `{"id":"mixing","name":"Mixing"}`, token: operator, status: 200},
+		{name: "anonymous freelancer skills", method: "PUT", path: "/freelancer/" + freelancer + "/skills", body: `{"skills":[]}`, status: 401},
+		{name: "other freelancer skills", method: "PUT", path: "/freelancer/" + freelancer + "/skills", body: `{"skills":[]}`, token: token(t, model.NewID()), status: 403},
+		{name: "own skills", method: "PUT", path: "/freelancer/" + freelancer + "/skills", body: `{"skills":[]}`, token: token(t, freelancer), status: 200},
+	} {
+		req, err := http.NewRequest(tt.method, gateway.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// bufferSize represents number of the latest task events kept to resume streams
+	bufferSize = 1000
+	// subscriberBuffer represents number of events queued for a stream,
+	// slow stream is closed when it overflows and resumes on reconnect
+	subscriberBuffer = 64
+	// keepAlive represents how often idle streams receive a comment, so proxies do not close them
+	keepAlive = time.Second * 15
+)
+
+// taskEvent represents task event along with parties of the Task
+type taskEvent struct {
+	Seq          uint64
+	ClientID     string
+	FreelancerID string
+	Envelope     events.Envelope
+}
+
+// visible reports whether user owns or is assigned to the event's Task
+func (e taskEvent) visible(userID, taskID string) bool {
+	if taskID != "" && e.Envelope.AggregateID != taskID {
+		return false
+	}
+	return userID == e.ClientID || userID == e.FreelancerID
+}
+
+// subscriber represents open stream of the user's task events
+type subscriber struct {
+	userID string
+	taskID string
+	events chan taskEvent
+	// dropped is closed when the stream could not keep up
+	dropped chan struct{}
+}
+
+// hub bridges task events published on NATS to the streams.
+// Events are numbered sequentially and the latest ones are buffered,
+// so reconnected stream receives events it missed. Event IDs are prefixed with
+// hub's instance, IDs issued by other gateway instances cannot be resumed
+type hub struct {
+	conn     *nats.Conn
+	instance string
+
+	mu      sync.Mutex
+	seq     uint64
+	evicted uint64
+	buf     []taskEvent
+	subs    map[*subscriber]struct{}
+}
+
+func newHub(conn *nats.Conn) *hub {
+	return &hub{conn: conn, instance: model.NewID()[:8], subs: map[*subscriber]struct{}{}}
+}
+
+// start subscribes to task events
+func (h *hub) start() error {
+	_, err := h.conn.Subscribe(events.SubjectPrefix+"task.>", func(msg *nats.Msg) {
+		if err := h.handle(msg.Data); err != nil {
+			logrus.WithField("subject", msg.Subject).Error(err)
+		}
+	})
+	return err
+}
+
+func (h *hub) handle(data []byte) error {
+	var e events.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	// every task event carries parties of the Task, model.Task and events.StatusChange alike
+	var parties struct {
+		ClientID     string `json:"client_id"`
+		FreelancerID string `json:"freelancer_id"`
+	}
+	if err := e.Decode(&parties); err != nil {
+		return err
+	}
+	h.publish(taskEvent{ClientID: parties.ClientID, FreelancerID: parties.FreelancerID, Envelope: e})
+	return nil
+}
+
+// publish numbers and buffers the event and queues it to the streams it is visible to
+func (h *hub) publish(ev taskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev.Seq = h.seq
+	if len(h.buf) == bufferSize {
+		h.evicted = h.buf[0].Seq
+		h.buf = append(h.buf[:0], h.buf[1:]...)
+	}
+	h.buf = append(h.buf, ev)
+	for sub := range h.subs {
+		if !ev.visible(sub.userID, sub.taskID) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			delete(h.subs, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// subscribe registers the stream and returns buffered events following lastID.
+// When events after lastID are no longer buffered or lastID was issued
+// by other instance, resumed is false and no events are returned
+func (h *hub) subscribe(userID, taskID, lastID string) (sub *subscriber, backlog []taskEvent, resumed bool) {
+	sub = &subscriber{userID: userID, taskID: taskID, events: make(chan taskEvent, subscriberBuffer), dropped: make(chan struct{})}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = struct{}{}
+	if lastID == "" {
+		return sub, nil, true
+	}
+	seq, ok := h.parseID(lastID)
+	if !ok || seq < h.evicted || seq > h.seq {
+		return sub, nil, false
+	}
+	for _, ev := range h.buf {
+		if ev.Seq > seq && ev.visible(userID, taskID) {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog, true
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// id returns ID of the event sent to the streams
+func (h *hub) id(ev taskEvent) string {
+	return h.instance + "-" + strconv.FormatUint(ev.Seq, 10)
+}
+
+func (h *hub) parseID(id string) (uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != h.instance {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return seq, err == nil
+}
+
+// StreamTasks handles GET /events/tasks and GET /task/{id}/events,
+// it streams events of the Tasks the authenticated user owns or is assigned to as Server-Sent Events.
+// Reconnected stream resumes after Last-Event-ID, reset event is sent when missed events
+// are no longer available and the Tasks should be fetched again
+func (c *Controller) StreamTasks(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logrus.Error("streaming is not supported")
+		w.WriteHeader(500)
+		return
+	}
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		taskID = mux.Vars(r)["id"]
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, backlog, resumed := c.hub.subscribe(user, taskID, lastID)
+	defer c.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	if !resumed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, ev := range backlog {
+		c.writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.dropped:
+			return
+		case ev := <-sub.events:
+			c.writeEvent(w, ev)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes event envelope as Server-Sent Event named after event type
+func (c *Controller) writeEvent(w http.ResponseWriter, ev taskEvent) {
+	d, err := json.Marshal(ev.Envelope)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", c.hub.id(ev), ev.Envelope.Type, d)
+}
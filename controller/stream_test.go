@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+// sse represents received Server-Sent Event
+type sse struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+type streamEnv struct {
+	conn *nats.Conn
+	srv  *httptest.Server
+}
+
+func setUpStream(t *testing.T) (*streamEnv, func()) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &streamEnv{conn: conn}
+	ctrl := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/events", ctrl.StreamTasks).Methods("GET")
+	router.HandleFunc("/events/tasks", ctrl.StreamTasks).Methods("GET")
+	env.srv = httptest.NewServer(router)
+	return env, func() {
+		env.srv.Close()
+		conn.Close()
+		ns.Shutdown()
+	}
+}
+
+// open connects to the stream, the stream is registered once the response is received
+func (env *streamEnv) open(t *testing.T, path, userID, lastID string) (*bufio.Reader, func()) {
+	req, err := http.NewRequest("GET", env.srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token(t, userID))
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := (&http.Client{Timeout: time.Second * 5}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+}
+
+// publish publishes task event, events are routed by parties in their payload
+func (env *streamEnv) publish(t *testing.T, typ events.Type, taskID string, payload interface{}) events.Envelope {
+	e, err := events.New("task", typ, taskID, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.conn.Publish(typ.Subject(), d); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// next reads the next event skipping comments
+func next(t *testing.T, r *bufio.Reader) sse {
+	var ev sse
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.Event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamTasks(t *testing.T) {
+	env, destroy := setUpStream(t)
+	defer destroy()
+
+	clientID, freelancerID := model.NewID(), model.NewID()
+	stream, closeStream := env.open(t, "/events/tasks", clientID, "")
+	defer closeStream()
+
+	task := model.NewTask(time.Hour, model.NewMoney(100, model.USD), clientID, "golang app")
+	created := env.publish(t, events.TaskCreated, task.ID, task)
+	// other Client's Task is not streamed
+	other := model.NewTask(time.Hour, model.NewMoney(100, model.USD), model.NewID(), "other")
+	env.publish(t, events.TaskCreated, other.ID, other)
+	changed := env.publish(t, events.TaskStatusChanged, task.ID,
+		events.StatusChange{TaskID: task.ID, From: model.Open, To: model.Started, ClientID: clientID, FreelancerID: freelancerID})
+
+	first := next(t, stream)
+	second := next(t, stream)
+	for i, c := range []struct {
+		got  sse
+		want events.Envelope
+	}{{first, created}, {second, changed}} {
+		var e events.Envelope
+		if err := json.Unmarshal([]byte(c.got.Data), &e); err != nil {
+			t.Fatal(err)
+		}
+		if c.got.Event != string(c.want.Type) || e.ID != c.want.ID || c.got.ID == "" {
+			t.Errorf("%d: expected=%s got=%+v", i, c.want.Type, c.got)
+		}
+	}
+
+	// assigned Freelancer resumes after the first event
+	resumed, closeResumed := env.open(t, "/task/"+task.ID+"/events", freelancerID, first.ID)
+	defer closeResumed()
+	if got := next(t, resumed); got.ID != second.ID {
+		t.Errorf("expected=%+v got=%+v", second, got)
+	}
+}
+
+func TestStreamTasks_Reset(t *testing.T) {
+	env, destroy := setUpStream(t)
+	defer destroy()
+
+	stream, closeStream := env.open(t, "/events/tasks", model.NewID(), "a1b2c3d4-42")
+	defer closeStream()
+	if got := next(t, stream); got.Event != "reset" {
+		t.Errorf("expected reset, got %+v", got)
+	}
+}
+
+func TestStreamTasks_Unauthenticated(t *testing.T) {
+	env, destroy := setUpStream(t)
+	defer destroy()
+
+	// user's ID alone does not identify the user
+	for _, query := range []string{"", "?user_id=" + model.NewID(), "?access_token=forged"} {
+		resp, err := http.Get(env.srv.URL + "/events/tasks" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 401 {
+			t.Errorf("%q: expected=401 got=%d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestHub_Resume(t *testing.T) {
+	defer func(size int) { bufferSize = size }(bufferSize)
+	bufferSize = 2
+
+	h := newHub(nil)
+	userID := model.NewID()
+	for i := 0; i < 3; i++ {
+		h.publish(taskEvent{ClientID: userID})
+	}
+	// the first event was evicted, events after it are still buffered
+	if _, backlog, resumed := h.subscribe(userID, "", h.id(taskEvent{Seq: 1})); !resumed || len(backlog) != 2 {
+		t.Errorf("expected 2 events, got resumed=%v %+v", resumed, backlog)
+	}
+	if _, _, resumed := h.subscribe(userID, "", h.id(taskEvent{Seq: 0})); resumed {
+		t.Error("expected reset after evicted event")
+	}
+	if _, _, resumed := h.subscribe(userID, "", h.id(taskEvent{Seq: 4})); resumed {
+		t.Error("expected reset after unknown event")
+	}
+	if _, backlog, _ := h.subscribe(model.NewID(), "", h.id(taskEvent{Seq: 1})); len(backlog) != 0 {
+		t.Errorf("other user received %+v", backlog)
+	}
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestTimesheet_Party(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, freelancer, other := model.NewID(), model.NewID(), model.NewID()
+	task := model.Task{ID: model.NewID(), ClientID: client, FreelancerID: freelancer}
+	server := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(server, api.TaskGet, func(_ context.Context, id string) (model.Task, error) {
+		return task, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpc.Register(server, api.TimesheetGet, func(_ context.Context, ts model.Timesheet) (model.Timesheet, error) {
+		return ts, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	logged := make(chan model.TimeEntry, 1)
+	if err := rpc.Register(server, api.TimesheetLog, func(_ context.Context, e model.TimeEntry) (rpc.Empty, error) {
+		logged <- e
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	approved := make(chan model.Timesheet, 1)
+	if err := rpc.Register(server, api.TimesheetApprove, func(_ context.Context, ts model.Timesheet) (rpc.Empty, error) {
+		approved <- ts
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/task/{id}/time", c.LogTime).Methods("POST")
+	router.HandleFunc("/task/{id}/timesheet", c.GetTimesheet).Methods("GET")
+	router.HandleFunc("/task/{id}/timesheet/approve", c.ApproveTimesheet).Methods("PUT")
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	do := func(method, path, body, token string) int {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: 401},
+		{name: "other user", token: token(t, other), status: 403},
+		{name: "client", token: token(t, client), status: 200},
+		{name: "freelancer", token: token(t, freelancer), status: 200},
+	} {
+		if status := do("GET", "/task/"+task.ID+"/timesheet?week=2018-10-01", "", tt.token); status != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, status)
+		}
+	}
+
+	// parties in the body are ignored
+	entry := `{"freelancer_id":"` + other + `","date":"2018-10-01","duration":3600}`
+	if status := do("POST", "/task/"+task.ID+"/time", entry, ""); status != 401 {
+		t.Errorf("expected=401 got=%d", status)
+	}
+	if status := do("POST", "/task/"+task.ID+"/time", entry, token(t, freelancer)); status != 200 {
+		t.Fatalf("expected=200 got=%d", status)
+	}
+	if e := <-logged; e.FreelancerID != freelancer {
+		t.Errorf("expected=%s got=%s", freelancer, e.FreelancerID)
+	}
+	review := `{"client_id":"` + other + `","week":"2018-10-01"}`
+	if status := do("PUT", "/task/"+task.ID+"/timesheet/approve", review, ""); status != 401 {
+		t.Errorf("expected=401 got=%d", status)
+	}
+	if status := do("PUT", "/task/"+task.ID+"/timesheet/approve", review, token(t, client)); status != 200 {
+		t.Fatalf("expected=200 got=%d", status)
+	}
+	if ts := <-approved; ts.ClientID != client {
+		t.Errorf("expected=%s got=%s", client, ts.ClientID)
+	}
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestWebhooks_Owner(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := rpc.NewServer(conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.WebhookAdd, func(_ context.Context, w model.Webhook) (model.Webhook, error) {
+		return w, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	deleted := make(chan model.WebhookAccess, 1)
+	if err := rpc.Register(srv, api.WebhookDelete, func(_ context.Context, a model.WebhookAccess) (rpc.Empty, error) {
+		deleted <- a
+		return rpc.Empty{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctrl := New(encConn)
+	router := mux.NewRouter()
+	router.Use(Authenticate(testSecret))
+	router.HandleFunc("/client/{id}/webhooks", ctrl.CreateWebhook).Methods("POST")
+	router.HandleFunc("/webhook/{id}", ctrl.DeleteWebhook).Methods("DELETE")
+	gateway := httptest.NewServer(router)
+	defer gateway.Close()
+
+	do := func(method, path, token string) int {
+		req, err := http.NewRequest(method, gateway.URL+path, strings.NewReader(`{"url":"https://example.com/hook"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	client := model.NewID()
+	for _, tt := range []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: 401},
+		{name: "other client", token: token(t, model.NewID()), status: 403},
+		{name: "owner", token: token(t, client), status: 200},
+	} {
+		if got := do("POST", "/client/"+client+"/webhooks", tt.token); got != tt.status {
+			t.Errorf("%s: expected=%d got=%d", tt.name, tt.status, got)
+		}
+	}
+
+	// Webhook is deleted on behalf of the authenticated Client
+	webhookID := model.NewID()
+	if got := do("DELETE", "/webhook/"+webhookID, token(t, client)); got != 200 {
+		t.Fatalf("expected=200 got=%d", got)
+	}
+	if a := <-deleted; a.ID != webhookID || a.ClientID != client {
+		t.Errorf("unexpected access: %+v", a)
+	}
+}
@@ -0,0 +1,120 @@
+// Package events defines domain events published by the services
+// whenever state of Tasks, Payments, Clients, Freelancers or Disputes changes.
+//
+// Services write events to the outbox in the same transaction as the state change
+// and Relay publishes them afterwards, so an event is published if and only if
+// the change is committed.
+//
+// Every event is wrapped into versioned Envelope and published on
+// "events.<type>" subject, e.g. events.task.created, so consumers can
+// subscribe to a single event type or to all of them with "events.>"
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/kylycht/md/model"
+)
+
+// Version represents current version of the Envelope and event payloads,
+// it is incremented on every breaking change of the payloads
+const Version = 1
+
+// SubjectPrefix represents prefix of the subjects events are published on
+const SubjectPrefix = "events."
+
+// Type represents type of the domain event
+type Type string
+
+const (
+	// TaskCreated is published when Client created the Task, payload is model.Task
+	TaskCreated = Type("task.created")
+	// TaskStatusChanged is published when status of the Task changed, payload is StatusChange
+	TaskStatusChanged = Type("task.status_changed")
+	// TaskDeleted is published when the Task was deleted, payload is model.Task with ID and parties only
+	TaskDeleted = Type("task.deleted")
+	// TaskReviewReminder is published shortly before review deadline of the completed Task, payload is model.Task
+	TaskReviewReminder = Type("task.review_reminder")
+	// PaymentLocked is published when funds were locked in escrow, payload is model.Payment
+	PaymentLocked = Type("payment.locked")
+	// PaymentPaid is published when funds were transferred to Freelancer, payload is model.Payment
+	PaymentPaid = Type("payment.paid")
+	// PaymentRefunded is published when operator returned funds held for the Task to Client, payload is model.Payment
+	PaymentRefunded = Type("payment.refunded")
+	// BalanceAdjusted is published when operator adjusted funds of Client or Freelancer, payload is model.Adjustment
+	BalanceAdjusted = Type("balance.adjusted")
+	// ClientCreated is published when new Client registered, payload is model.Client
+	ClientCreated = Type("client.created")
+	// ClientUpdated is published when Client was updated, payload is model.Client with updated fields
+	ClientUpdated = Type("client.updated")
+	// ClientDeleted is published when Client was deleted, payload is model.Client with ID only
+	ClientDeleted = Type("client.deleted")
+	// FreelancerCreated is published when new Freelancer registered, payload is model.Freelancer
+	FreelancerCreated = Type("freelancer.created")
+	// FreelancerUpdated is published when Freelancer was updated, payload is model.Freelancer with updated fields
+	FreelancerUpdated = Type("freelancer.updated")
+	// FreelancerDeleted is published when Freelancer was deleted, payload is model.Freelancer with ID only
+	FreelancerDeleted = Type("freelancer.deleted")
+	// DisputeOpened is published when Dispute was opened on the Task, payload is model.Dispute
+	DisputeOpened = Type("dispute.opened")
+	// DisputeResolved is published when Dispute was resolved, payload is model.Dispute
+	DisputeResolved = Type("dispute.resolved")
+	// MessageSent is published when party of the Task sent a Message, payload is model.Message
+	MessageSent = Type("message.sent")
+	// AttachmentAdded is published when file was attached to the Task, payload is model.Attachment
+	AttachmentAdded = Type("attachment.added")
+	// RatingAdded is published when Client rated Freelancer of the closed Task, payload is model.Rating
+	RatingAdded = Type("rating.added")
+)
+
+// Subject returns NATS subject the events of the Type are published on
+func (t Type) Subject() string {
+	return SubjectPrefix + string(t)
+}
+
+// Envelope represents domain event with metadata
+type Envelope struct {
+	ID          string          `json:"id"`           // ID represents unique identifier of the event, consumers use it to drop duplicates
+	Type        Type            `json:"type"`         // Type represents type of the event
+	Version     int             `json:"version"`      // Version represents version of the Envelope and payload
+	Source      string          `json:"source"`       // Source represents service that published the event
+	AggregateID string          `json:"aggregate_id"` // AggregateID represents ID of the entity the event is about
+	OccurredAt  time.Time       `json:"occurred_at"`  // OccurredAt represents datetime when the state changed
+	Data        json.RawMessage `json:"data"`         // Data represents JSON encoded payload
+}
+
+// StatusChange represents payload of TaskStatusChanged event
+type StatusChange struct {
+	TaskID string           `json:"task_id"`
+	From   model.TaskStatus `json:"from"`
+	To     model.TaskStatus `json:"to"`
+	// ClientID and FreelancerID are parties of the Task, so consumers route the event without looking the Task up
+	ClientID     string `json:"client_id"`
+	FreelancerID string `json:"freelancer_id"`
+	// Reason and Actor are set when operator changed the status
+	Reason string `json:"reason,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+// New returns new Envelope of the given type with JSON encoded payload
+func New(source string, t Type, aggregateID string, payload interface{}) (Envelope, error) {
+	d, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		ID:          model.NewID(),
+		Type:        t,
+		Version:     Version,
+		Source:      source,
+		AggregateID: aggregateID,
+		OccurredAt:  time.Now().UTC(),
+		Data:        d,
+	}, nil
+}
+
+// Decode decodes payload of the event into v
+func (e Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
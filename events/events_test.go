@@ -0,0 +1,48 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kylycht/md/model"
+)
+
+func TestType_Subject(t *testing.T) {
+	if got := TaskStatusChanged.Subject(); got != "events.task.status_changed" {
+		t.Errorf("expected=%s got=%s", "events.task.status_changed", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	change := StatusChange{TaskID: model.NewID(), From: model.Open, To: model.Started}
+	e, err := New("task", TaskStatusChanged, change.TaskID, change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.ID) != 36 {
+		t.Errorf("invalid event id: %s", e.ID)
+	}
+	if e.Version != Version || e.Source != "task" || e.AggregateID != change.TaskID {
+		t.Errorf("unexpected envelope: %+v", e)
+	}
+
+	// envelope survives the wire
+	d, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := Envelope{}
+	if err := json.Unmarshal(d, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got := StatusChange{}
+	if err := decoded.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != change {
+		t.Errorf("expected=%+v got=%+v", change, got)
+	}
+	if !decoded.OccurredAt.Equal(e.OccurredAt) {
+		t.Errorf("expected=%s got=%s", e.OccurredAt, decoded.OccurredAt)
+	}
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatch = 100
+	maxBackoff   = time.Minute * 5
+)
+
+// Record wraps payload into Envelope and writes it to the outbox within given transaction,
+// so the event is published by Relay if and only if the transaction is committed
+func Record(tx *sqlx.Tx, source string, t Type, aggregateID string, payload interface{}) error {
+	e, err := New(source, t, aggregateID, payload)
+	if err != nil {
+		return err
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	insertS := "INSERT INTO outbox (id, subject, payload, created_at, next_attempt_at) VALUES($1, $2, $3, $4, $4)"
+	_, err = tx.Exec(insertS, e.ID, t.Subject(), d, e.OccurredAt)
+	return err
+}
+
+// Publisher publishes events read from the outbox
+type Publisher interface {
+	// Publish publishes event with the given ID on the subject
+	Publish(id, subject string, data []byte) error
+	// Flush waits until published events are received by the server
+	Flush() error
+}
+
+// NATSPublisher publishes events with core NATS
+type NATSPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATSPublisher returns new instance of NATSPublisher
+func NewNATSPublisher(conn *nats.Conn) *NATSPublisher {
+	return &NATSPublisher{conn: conn}
+}
+
+// Publish publishes event on the subject
+func (p *NATSPublisher) Publish(id, subject string, data []byte) error {
+	return p.conn.Publish(subject, data)
+}
+
+// Flush waits until published events are received by the server
+func (p *NATSPublisher) Flush() error {
+	return p.conn.Flush()
+}
+
+// outboxRow represents pending event stored in the outbox
+type outboxRow struct {
+	Seq      int64  `db:"seq"`
+	ID       string `db:"id"`
+	Subject  string `db:"subject"`
+	Payload  []byte `db:"payload"`
+	Attempts int    `db:"attempts"`
+}
+
+// Relay publishes events written to the outbox to NATS and marks them sent.
+// Failed events are retried with exponential backoff, event may be published
+// more than once, consumers drop duplicates by Envelope ID
+type Relay struct {
+	db       *sqlx.DB
+	pub      Publisher
+	interval time.Duration
+	batch    int
+	done     chan struct{}
+}
+
+// NewRelay returns new instance of Relay polling the outbox every interval
+func NewRelay(db *sqlx.DB, pub Publisher, interval time.Duration) *Relay {
+	return &Relay{db: db, pub: pub, interval: interval, batch: defaultBatch, done: make(chan struct{})}
+}
+
+// Run publishes pending events until Close is called
+func (r *Relay) Run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			// drain the outbox batch by batch
+			for {
+				n, err := r.Flush()
+				if err != nil {
+					logrus.Error(err)
+				}
+				if err != nil || n < r.batch {
+					break
+				}
+			}
+		}
+	}
+}
+
+// Close stops the Relay
+func (r *Relay) Close() {
+	close(r.done)
+}
+
+// Flush publishes single batch of pending events and returns number of processed events.
+// Rows are locked so concurrent relays do not publish the same batch
+func (r *Relay) Flush() (int, error) {
+	now := time.Now().UTC()
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	rows := []outboxRow{}
+	query := "SELECT seq, id, subject, payload, attempts FROM outbox WHERE sent_at IS NULL AND next_attempt_at <= $1 " +
+		"ORDER BY seq LIMIT $2 FOR UPDATE SKIP LOCKED"
+	if err := tx.Select(&rows, query, now, r.batch); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var sent []int64
+	for _, row := range rows {
+		if err := r.pub.Publish(row.ID, row.Subject, row.Payload); err != nil {
+			logrus.WithField("event_id", row.ID).WithField("attempts", row.Attempts+1).Error(err)
+			if _, err := tx.Exec("UPDATE outbox SET attempts=attempts+1, last_error=$1, next_attempt_at=$2 WHERE seq=$3",
+				err.Error(), now.Add(r.backoff(row.Attempts+1)), row.Seq); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+			continue
+		}
+		sent = append(sent, row.Seq)
+	}
+	if len(sent) > 0 {
+		// events are marked sent only after the server has received them
+		if err := r.pub.Flush(); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		query, args, err := sqlx.In("UPDATE outbox SET sent_at=?, attempts=attempts+1 WHERE seq IN (?)", now, sent)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if _, err := tx.Exec(tx.Rebind(query), args...); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(rows), tx.Commit()
+}
+
+// backoff returns delay before the next attempt to publish event
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.interval
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,163 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/model"
+	_ "github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+func startServer() *server.Server {
+	return natstest.RunDefaultServer()
+}
+
+func setUp(t *testing.T) (*sqlx.DB, *nats.EncodedConn, func()) {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(outboxSchema)
+	db.Exec(outboxIndex)
+	// previous runs must not leak into assertions
+	db.Exec("UPDATE outbox SET sent_at=now() WHERE sent_at IS NULL")
+
+	natsServer := startServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, conn, func() {
+		conn.Close()
+		db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+func record(t *testing.T, db *sqlx.DB, commit bool) Envelope {
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := model.NewClient("client@email.com", model.NewMoney(100, model.EUR))
+	if err := Record(tx, "client", ClientCreated, client.ID, client); err != nil {
+		t.Fatal(err)
+	}
+	e := Envelope{AggregateID: client.ID}
+	if !commit {
+		tx.Rollback()
+		return e
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestRelay_Flush(t *testing.T) {
+	db, conn, destroy := setUp(t)
+	defer destroy()
+
+	received := make(chan Envelope, 2)
+	if _, err := conn.Subscribe(ClientCreated.Subject(), func(e *Envelope) {
+		received <- *e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	committed := record(t, db, true)
+	record(t, db, false)
+
+	relay := NewRelay(db, NewNATSPublisher(conn.Conn), time.Second)
+	n, err := relay.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected=%d events, got=%d", 1, n)
+	}
+	select {
+	case e := <-received:
+		if e.AggregateID != committed.AggregateID {
+			t.Errorf("expected=%s got=%s", committed.AggregateID, e.AggregateID)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("event was not published")
+	}
+	// sent events are not published again
+	if n, err := relay.Flush(); err != nil || n != 0 {
+		t.Errorf("expected=%d events, got=%d err=%v", 0, n, err)
+	}
+	select {
+	case e := <-received:
+		t.Errorf("unexpected event: %+v", e)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestRelay_Retry(t *testing.T) {
+	db, conn, destroy := setUp(t)
+	defer destroy()
+
+	e := record(t, db, true)
+	// publish fails on closed connection
+	conn.Close()
+	relay := NewRelay(db, NewNATSPublisher(conn.Conn), time.Second)
+	if _, err := relay.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var row struct {
+		Attempts  int       `db:"attempts"`
+		NextRetry time.Time `db:"next_attempt_at"`
+		Sent      bool      `db:"sent"`
+	}
+	if err := db.Get(&row, "SELECT attempts, next_attempt_at, sent_at IS NOT NULL AS sent FROM outbox WHERE convert_from(payload, 'UTF8') LIKE '%' || $1 || '%'",
+		e.AggregateID); err != nil {
+		t.Fatal(err)
+	}
+	if row.Sent || row.Attempts != 1 {
+		t.Errorf("unexpected outbox row: %+v", row)
+	}
+	if !row.NextRetry.After(time.Now()) {
+		t.Errorf("next attempt is not delayed: %s", row.NextRetry)
+	}
+}
+
+func TestRelay_Backoff(t *testing.T) {
+	relay := NewRelay(nil, nil, time.Second)
+	tests := map[int]time.Duration{
+		1:  time.Second,
+		2:  time.Second * 2,
+		4:  time.Second * 8,
+		20: maxBackoff,
+	}
+	for attempts, want := range tests {
+		if got := relay.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) expected=%s got=%s", attempts, want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package fx provides foreign exchange rates used to convert Money between currencies
+package fx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/kylycht/md/model"
+)
+
+var (
+	// ErrNoRate represents error returned when exchange rate for currency pair is unknown
+	ErrNoRate = errors.New("exchange rate not found")
+)
+
+// Source represents provider of exchange rates
+type Source interface {
+	// Rate returns how many units of `to` currency one unit of `from` currency costs
+	Rate(from, to model.Currency) (*big.Rat, error)
+}
+
+// StaticSource represents Source with fixed rates relative to the base currency
+type StaticSource struct {
+	base  model.Currency
+	rates map[model.Currency]*big.Rat
+}
+
+// NewStaticSource returns new instance of StaticSource,
+// rates are decimal strings of `base` currency price, e.g. {"EUR":"0.92"} for USD base
+func NewStaticSource(base model.Currency, rates map[model.Currency]string) (*StaticSource, error) {
+	if !base.Valid() {
+		return nil, model.ErrInvalidCurrency
+	}
+	src := &StaticSource{base: base, rates: map[model.Currency]*big.Rat{base: big.NewRat(1, 1)}}
+	for c, v := range rates {
+		if !c.Valid() {
+			return nil, model.ErrInvalidCurrency
+		}
+		r, ok := new(big.Rat).SetString(v)
+		if !ok || r.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s", v, c)
+		}
+		src.rates[c] = r
+	}
+	return src, nil
+}
+
+// LoadFile reads StaticSource from JSON file in the following format:
+//
+//	{"base":"USD","rates":{"EUR":"0.92","GBP":"0.79"}}
+func LoadFile(path string) (*StaticSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var v struct {
+		Base  model.Currency            `json:"base"`
+		Rates map[model.Currency]string `json:"rates"`
+	}
+	if err := json.NewDecoder(f).Decode(&v); err != nil {
+		return nil, err
+	}
+	return NewStaticSource(v.Base, v.Rates)
+}
+
+// Rate implements Source
+func (s *StaticSource) Rate(from, to model.Currency) (*big.Rat, error) {
+	f, ok := s.rates[from]
+	if !ok {
+		return nil, ErrNoRate
+	}
+	t, ok := s.rates[to]
+	if !ok {
+		return nil, ErrNoRate
+	}
+	return new(big.Rat).Quo(t, f), nil
+}
+
+// Convert converts m to the given currency using rate from src,
+// result is rounded half away from zero to the minor units of the target currency
+func Convert(src Source, m model.Money, to model.Currency) (model.Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+	if src == nil {
+		return m, model.ErrCurrencyMismatch
+	}
+	if !to.Valid() {
+		return m, model.ErrInvalidCurrency
+	}
+	rate, err := src.Rate(m.Currency, to)
+	if err != nil {
+		return m, err
+	}
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	// adjust for difference in minor units, e.g. JPY has none while USD has cents
+	exp := to.MinorUnits() - m.Currency.MinorUnits()
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil))
+	if exp >= 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+	return model.NewMoney(round(v), to), nil
+}
+
+// round rounds v half away from zero
+func round(v *big.Rat) int64 {
+	num := new(big.Int).Abs(v.Num())
+	q, r := new(big.Int).QuoRem(num, v.Denom(), new(big.Int))
+	if r.Mul(r, big.NewInt(2)).Cmp(v.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if v.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package fx
+
+import (
+	"testing"
+
+	"github.com/kylycht/md/model"
+)
+
+func TestConvert(t *testing.T) {
+	src, err := LoadFile("testdata/rates.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		m       model.Money
+		to      model.Currency
+		want    model.Money
+		wantErr error
+	}{
+		{name: "same-currency", m: model.NewMoney(2000, model.EUR), to: model.EUR, want: model.NewMoney(2000, model.EUR)},
+		{name: "from-base", m: model.NewMoney(10000, model.USD), to: model.EUR, want: model.NewMoney(9200, model.EUR)},
+		{name: "to-base", m: model.NewMoney(9200, model.EUR), to: model.USD, want: model.NewMoney(10000, model.USD)},
+		{name: "cross-rate-rounded", m: model.NewMoney(1000, model.EUR), to: model.GBP, want: model.NewMoney(859, model.GBP)},
+		{name: "no-minor-units", m: model.NewMoney(100, model.USD), to: model.JPY, want: model.NewMoney(150, model.JPY)},
+		{name: "from-no-minor-units", m: model.NewMoney(1495, model.JPY), to: model.USD, want: model.NewMoney(1000, model.USD)},
+		{name: "unknown-rate", m: model.NewMoney(1000, model.CHF), to: model.USD, wantErr: ErrNoRate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(src, tt.m, tt.to)
+			if err != tt.wantErr {
+				t.Errorf("Convert() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Convert() expected=%s got=%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConvert_NoSource(t *testing.T) {
+	if _, err := Convert(nil, model.NewMoney(1000, model.EUR), model.USD); err != model.ErrCurrencyMismatch {
+		t.Errorf("expected=%v got=%v", model.ErrCurrencyMismatch, err)
+	}
+}
+
+func TestNewStaticSource_Invalid(t *testing.T) {
+	if _, err := NewStaticSource(model.USD, map[model.Currency]string{model.EUR: "-1"}); err == nil {
+		t.Error("expected error on negative rate")
+	}
+	if _, err := NewStaticSource(model.Currency("XYZ"), nil); err != model.ErrInvalidCurrency {
+		t.Errorf("expected=%v got=%v", model.ErrInvalidCurrency, err)
+	}
+}
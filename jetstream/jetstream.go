@@ -0,0 +1,150 @@
+// Package jetstream provides durable messaging on top of NATS JetStream.
+//
+// Commands are stored in CommandStream and processed by durable consumers,
+// message is acked after it has been handled and redelivered after transient failure.
+// Messages that failed MaxDeliver times or were rejected as invalid are moved
+// to DeadLetterStream on "dlq.<subject>" with the last error in the Error header.
+// Domain events are stored in EventStream and deduplicated by event ID
+package jetstream
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CommandStream represents stream of the commands processed by the services
+	CommandStream = "COMMANDS"
+	// EventStream represents stream of the domain events
+	EventStream = "EVENTS"
+	// DeadLetterStream represents stream of the messages that could not be processed
+	DeadLetterStream = "DEADLETTER"
+	// DeadLetterPrefix represents prefix of the subjects in DeadLetterStream
+	DeadLetterPrefix = "dlq."
+	// DefaultMaxDeliver represents how many times message is delivered before it is dead lettered
+	DefaultMaxDeliver = 5
+
+	eventRetention = time.Hour * 24 * 7
+	dedupWindow    = time.Minute * 2
+)
+
+// Commands lists subjects of the commands processed through CommandStream
+var Commands = []string{"task.add"}
+
+// Setup creates the streams or updates configuration of the existing ones
+func Setup(js nats.JetStreamContext) error {
+	streams := []*nats.StreamConfig{
+		{Name: CommandStream, Subjects: Commands, Retention: nats.WorkQueuePolicy, Storage: nats.FileStorage, Duplicates: dedupWindow},
+		{Name: EventStream, Subjects: []string{"events.>"}, Storage: nats.FileStorage, MaxAge: eventRetention, Duplicates: dedupWindow},
+		{Name: DeadLetterStream, Subjects: []string{DeadLetterPrefix + ">"}, Storage: nats.FileStorage},
+	}
+	for _, cfg := range streams {
+		_, err := js.StreamInfo(cfg.Name)
+		switch err {
+		case nil:
+			_, err = js.UpdateStream(cfg)
+		case nats.ErrStreamNotFound:
+			_, err = js.AddStream(cfg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler processes message data, returned error causes redelivery of the message
+// unless it is permanent, see Permanent
+type Handler func(data []byte) error
+
+// Permanent reports whether handling of the message failed for good and would fail again
+// when redelivered: the message is invalid, refers to missing entity or is not allowed
+func Permanent(err error) bool {
+	switch rpc.CodeOf(err) {
+	case rpc.CodeInvalid, rpc.CodeNotFound, rpc.CodePermission:
+		return true
+	}
+	return false
+}
+
+// Consume subscribes durable consumer to the subject, the consumer is named after
+// the queue and shared by all subscribers of the queue.
+// Message is acked when handler succeeds and redelivered after transient failure,
+// after maxDeliver attempts or permanent failure it is moved to DeadLetterStream
+func Consume(js nats.JetStreamContext, subject, queue string, maxDeliver int, h Handler) (*nats.Subscription, error) {
+	return js.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+		err := handle(h, msg.Data)
+		if err == nil {
+			if err := msg.Ack(); err != nil {
+				logrus.WithField("subject", msg.Subject).Error(err)
+			}
+			return
+		}
+		meta, mErr := msg.Metadata()
+		if mErr != nil || (meta.NumDelivered < uint64(maxDeliver) && !Permanent(err)) {
+			logrus.WithField("subject", msg.Subject).Warn(err)
+			msg.Nak()
+			return
+		}
+		if dErr := deadLetter(js, msg, err, meta); dErr != nil {
+			logrus.WithField("subject", msg.Subject).Error(dErr)
+			msg.Nak()
+			return
+		}
+		logrus.WithField("subject", msg.Subject).WithField("deliveries", meta.NumDelivered).Error(err)
+		msg.Term()
+	}, nats.ManualAck(), nats.AckExplicit(), nats.MaxDeliver(maxDeliver), nats.DeliverAll())
+}
+
+// handle invokes h converting panic to error, so the message is redelivered
+// and eventually dead lettered instead of crashing the service
+func handle(h Handler, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(data)
+}
+
+// deadLetter publishes message that could not be processed to DeadLetterStream
+func deadLetter(js nats.JetStreamContext, msg *nats.Msg, cause error, meta *nats.MsgMetadata) error {
+	dl := nats.NewMsg(DeadLetterPrefix + msg.Subject)
+	dl.Data = msg.Data
+	dl.Header.Set("Error", cause.Error())
+	dl.Header.Set("Stream", meta.Stream)
+	dl.Header.Set("Consumer", meta.Consumer)
+	dl.Header.Set("Stream-Sequence", strconv.FormatUint(meta.Sequence.Stream, 10))
+	dl.Header.Set("Deliveries", strconv.FormatUint(meta.NumDelivered, 10))
+	_, err := js.PublishMsg(dl)
+	return err
+}
+
+// Publisher publishes domain events to EventStream and waits for acknowledgement,
+// event ID is used as message ID so the stream drops duplicates published by the outbox relay
+type Publisher struct {
+	js nats.JetStreamContext
+}
+
+// NewPublisher returns new instance of Publisher
+func NewPublisher(js nats.JetStreamContext) *Publisher {
+	return &Publisher{js: js}
+}
+
+// Publish publishes event on the subject
+func (p *Publisher) Publish(id, subject string, data []byte) error {
+	_, err := p.js.Publish(subject, data, nats.MsgId(id))
+	return err
+}
+
+// Flush does nothing since every event is acknowledged by the stream
+func (p *Publisher) Flush() error {
+	return nil
+}
@@ -0,0 +1,225 @@
+package jetstream
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/rpc"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+func startServer(t *testing.T) *server.Server {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	return natstest.RunServer(&opts)
+}
+
+func setUp(t *testing.T) (nats.JetStreamContext, func()) {
+	srv := startServer(t)
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Setup(js); err != nil {
+		t.Fatal(err)
+	}
+	return js, func() {
+		conn.Close()
+		srv.Shutdown()
+	}
+}
+
+func TestSetup(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	// existing streams are updated
+	if err := Setup(js); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{CommandStream, EventStream, DeadLetterStream} {
+		if _, err := js.StreamInfo(name); err != nil {
+			t.Errorf("stream %s: %v", name, err)
+		}
+	}
+}
+
+func TestConsume_Ack(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	handled := make(chan string, 1)
+	if _, err := Consume(js, "task.add", "task-add", DefaultMaxDeliver, func(data []byte) error {
+		handled <- string(data)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Publish("task.add", []byte(`{"id":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-handled:
+		if d != `{"id":"1"}` {
+			t.Errorf("unexpected data: %s", d)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("command was not delivered")
+	}
+	// acked command is removed from work queue
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		info, err := js.StreamInfo(CommandStream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State.Msgs == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected=%d messages, got=%d", 0, info.State.Msgs)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestConsume_DeadLetter(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	dead, err := js.SubscribeSync(DeadLetterPrefix+"task.add", nats.DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attempts int32
+	if _, err := Consume(js, "task.add", "task-add", 3, func(data []byte) error {
+		atomic.AddInt32(&attempts, 1)
+		return errors.New("insufficient funds")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Publish("task.add", []byte(`{"id":"2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dead.NextMsg(time.Second * 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Data) != `{"id":"2"}` {
+		t.Errorf("unexpected data: %s", msg.Data)
+	}
+	if got := msg.Header.Get("Error"); got != "insufficient funds" {
+		t.Errorf("expected=%s got=%s", "insufficient funds", got)
+	}
+	if got := msg.Header.Get("Deliveries"); got != "3" {
+		t.Errorf("expected=%s got=%s", "3", got)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("expected=%d attempts, got=%d", 3, got)
+	}
+}
+
+func TestConsume_Permanent(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	dead, err := js.SubscribeSync(DeadLetterPrefix+"task.add", nats.DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attempts int32
+	if _, err := Consume(js, "task.add", "task-add", 3, func(data []byte) error {
+		atomic.AddInt32(&attempts, 1)
+		return rpc.Errorf(rpc.CodeInvalid, "invalid currency")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Publish("task.add", []byte(`{"id":"3"}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dead.NextMsg(time.Second * 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// invalid command is dead lettered after the first attempt
+	if got := msg.Header.Get("Deliveries"); got != "1" {
+		t.Errorf("expected=%s got=%s", "1", got)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected=%d attempts, got=%d", 1, got)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{rpc.Errorf(rpc.CodeInvalid, "invalid"), true},
+		{rpc.Errorf(rpc.CodeNotFound, "not found"), true},
+		{rpc.Errorf(rpc.CodePermission, "denied"), true},
+		{rpc.Errorf(rpc.CodeTimeout, "timeout"), false},
+		{rpc.Errorf(rpc.CodeInternal, "panic"), false},
+		{errors.New("connection refused"), false},
+	}
+	for _, c := range cases {
+		if got := Permanent(c.err); got != c.want {
+			t.Errorf("%v: expected=%v got=%v", c.err, c.want, got)
+		}
+	}
+}
+
+func TestPublisher_Dedup(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	pub := NewPublisher(js)
+	for i := 0; i < 2; i++ {
+		if err := pub.Publish("event-1", "events.task.created", []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := js.StreamInfo(EventStream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 1 {
+		t.Errorf("expected=%d messages, got=%d", 1, info.State.Msgs)
+	}
+}
+
+func TestConsume_Panic(t *testing.T) {
+	js, destroy := setUp(t)
+	defer destroy()
+
+	dead, err := js.SubscribeSync(DeadLetterPrefix+"task.add", nats.DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Consume(js, "task.add", "task-add", 2, func(data []byte) error {
+		panic("nil task")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.Publish("task.add", []byte(`{"id":"3"}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dead.NextMsg(time.Second * 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Error"); got != "panic: nil task" {
+		t.Errorf("expected=%s got=%s", "panic: nil task", got)
+	}
+}
@@ -0,0 +1,95 @@
+// Package migrate applies versioned changes of the database schema.
+//
+// Applied versions are recorded in schema_migrations table, every migration runs
+// in its own transaction once. Processes starting at the same time are serialized
+// by advisory lock, so each migration is applied by one of them
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockKey represents key of the advisory lock held while migrating
+const lockKey = 0x6d64_6d69_6772
+
+var migrationsSchema = `CREATE TABLE IF NOT EXISTS SCHEMA_MIGRATIONS (
+	VERSION int PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	APPLIED_AT timestamp NOT NULL
+)`
+
+// Migration represents change of the schema
+type Migration struct {
+	Version int    // Version orders migrations, applied versions are never applied again
+	Name    string // Name describes the change
+	Up      string // Up represents SQL statements applying the change
+}
+
+// Up applies migrations that were not applied yet in order of their versions
+// and returns versions applied by the call
+func Up(ctx context.Context, db *sqlx.DB, migrations []Migration) ([]int, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", sorted[i].Version)
+		}
+	}
+
+	// advisory lock belongs to the session, so every statement uses the same connection
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	if _, err := conn.ExecContext(ctx, migrationsSchema); err != nil {
+		return nil, err
+	}
+	var applied []int
+	if err := conn.SelectContext(ctx, &applied, "SELECT version FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, v := range applied {
+		done[v] = true
+	}
+
+	var versions []int
+	for _, m := range sorted {
+		if done[m.Version] {
+			continue
+		}
+		if err := apply(ctx, conn, m); err != nil {
+			return versions, fmt.Errorf("migrate: %d %s: %w", m.Version, m.Name, err)
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+func apply(ctx context.Context, conn *sqlx.Conn, m Migration) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+		tx.Rollback()
+		return err
+	}
+	insertS := "INSERT INTO schema_migrations (version, name, applied_at) VALUES($1, $2, $3)"
+	if _, err := tx.ExecContext(ctx, insertS, m.Version, m.Name, time.Now().UTC()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
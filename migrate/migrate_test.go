@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+func setUp(t *testing.T) (*sqlx.DB, func()) {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() { db.Close() }
+}
+
+func TestUp(t *testing.T) {
+	db, destroy := setUp(t)
+	defer destroy()
+
+	// schema_migrations is shared by runs, versions and table of the run are unique
+	base := int(time.Now().Unix() % 1_000_000 * 1000)
+	table := fmt.Sprintf("migrate_test_%d", base)
+	defer func() {
+		db.Exec("DROP TABLE IF EXISTS " + table)
+		db.Exec("DELETE FROM schema_migrations WHERE version >= $1 AND version < $2", base, base+1000)
+	}()
+	migrations := []Migration{
+		{Version: base + 2, Name: "insert", Up: "INSERT INTO " + table + " (id) VALUES(1)"},
+		{Version: base + 1, Name: "create", Up: "CREATE TABLE " + table + " (id int PRIMARY KEY)"},
+	}
+
+	versions, err := Up(context.Background(), db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0] != base+1 || versions[1] != base+2 {
+		t.Errorf("unexpected versions %v", versions)
+	}
+	if versions, err := Up(context.Background(), db, migrations); err != nil || len(versions) != 0 {
+		t.Errorf("expected no versions applied again, got %v %v", versions, err)
+	}
+
+	failing := append(migrations, Migration{Version: base + 3, Name: "duplicate", Up: "INSERT INTO " + table + " (id) VALUES(2); INSERT INTO " + table + " (id) VALUES(1)"})
+	if _, err := Up(context.Background(), db, failing); err == nil {
+		t.Fatal("expected error of failing migration")
+	}
+	var count int
+	if err := db.Get(&count, "SELECT count(*) FROM "+table); err != nil || count != 1 {
+		t.Errorf("expected failing migration rolled back, got %d %v", count, err)
+	}
+	if err := db.Get(&count, "SELECT count(*) FROM schema_migrations WHERE version = $1", base+3); err != nil || count != 0 {
+		t.Errorf("expected failing migration not recorded, got %d %v", count, err)
+	}
+}
+
+func TestUp_DuplicateVersion(t *testing.T) {
+	// versions are checked before connecting
+	if _, err := Up(context.Background(), nil, []Migration{{Version: 1}, {Version: 2}, {Version: 1}}); err == nil {
+		t.Error("expected error of duplicate version")
+	}
+}
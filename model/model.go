@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
@@ -21,6 +22,33 @@ type TaskStatus string
 // PaymentStatus represents current status of the Payment
 type PaymentStatus string
 
+// DisputeStatus represents current status of the Dispute
+type DisputeStatus string
+
+// ContractType represents how Freelancer is paid for the Task
+type ContractType string
+
+// TimeEntryStatus represents current status of the TimeEntry
+type TimeEntryStatus string
+
+// ReservationStatus represents current status of the Reservation
+type ReservationStatus string
+
+// DeliveryStatus represents current status of the webhook Delivery
+type DeliveryStatus string
+
+// AttachmentKind represents purpose of the file attached to the Task
+type AttachmentKind string
+
+// SkillLevel represents proficiency of Freelancer in the Skill, higher is better
+type SkillLevel int
+
+// NotificationKind represents lifecycle change of the Task or Payment users are notified about
+type NotificationKind string
+
+// AuditAction represents kind of the change recorded in the audit log
+type AuditAction string
+
 const (
 	// Open status means that Task was successfully created and open for applications
 	Open = TaskStatus("open")
@@ -32,6 +60,8 @@ const (
 	Closed = TaskStatus("closed")
 	// Abandoned status means that Task was abandoned by freelancer
 	Abandoned = TaskStatus("abondoned")
+	// Disputed status means that Client or Freelancer opened a Dispute on the Task
+	Disputed = TaskStatus("disputed")
 )
 
 const (
@@ -45,67 +75,508 @@ const (
 	Loaded = PaymentStatus("loaded")
 	// Paid
 	Paid = PaymentStatus("paid")
+	// Frozen status represents that locked funds are held in escrow until the Dispute is resolved
+	Frozen = PaymentStatus("frozen")
+	// Refunded status represents that funds were returned to Client's account
+	Refunded = PaymentStatus("refunded")
+)
+
+const (
+	// DisputeOpen status means that Dispute is waiting for statements and resolution
+	DisputeOpen = DisputeStatus("open")
+	// DisputeResolved status means that admin resolved the Dispute and funds were paid out
+	DisputeResolved = DisputeStatus("resolved")
+)
+
+const (
+	// FixedContract means that Freelancer is paid fixed Fee upon completion of the Task
+	FixedContract = ContractType("fixed")
+	// HourlyContract means that Freelancer is paid weekly for approved hours
+	HourlyContract = ContractType("hourly")
+)
+
+const (
+	// EntryPending status means that TimeEntry waits for Client's approval
+	EntryPending = TimeEntryStatus("pending")
+	// EntryApproved status means that Client approved TimeEntry and it will be paid in the next billing run
+	EntryApproved = TimeEntryStatus("approved")
+	// EntryRejected status means that Client rejected TimeEntry
+	EntryRejected = TimeEntryStatus("rejected")
+	// EntryBilled status means that TimeEntry was paid
+	EntryBilled = TimeEntryStatus("billed")
+)
+
+const (
+	// Reserved status means that funds were withdrawn from Client's account and wait for confirmation
+	Reserved = ReservationStatus("reserved")
+	// Confirmed status means that reserved funds were locked for the Task
+	Confirmed = ReservationStatus("confirmed")
+	// Released status means that reserved funds were returned to Client's account
+	Released = ReservationStatus("released")
+)
+
+const (
+	// DeliveryPending status means that event waits for the next attempt to be delivered
+	DeliveryPending = DeliveryStatus("pending")
+	// DeliveryDelivered status means that Client's endpoint accepted the event
+	DeliveryDelivered = DeliveryStatus("delivered")
+	// DeliveryFailed status means that all attempts failed, the Delivery can be replayed
+	DeliveryFailed = DeliveryStatus("failed")
+)
+
+const (
+	// Brief represents file describing the Task, uploaded by Client
+	Brief = AttachmentKind("brief")
+	// Deliverable represents result of the work, uploaded by Freelancer
+	Deliverable = AttachmentKind("deliverable")
+)
+
+const (
+	// Beginner level means that Freelancer has basic knowledge of the Skill
+	Beginner = SkillLevel(1)
+	// Intermediate level means that Freelancer works with the Skill independently
+	Intermediate = SkillLevel(2)
+	// Expert level means that Freelancer has deep experience with the Skill
+	Expert = SkillLevel(3)
+)
+
+const (
+	// TaskAssigned notification is sent to Freelancer who started the Task
+	TaskAssigned = NotificationKind("task_assigned")
+	// TaskCompleted notification is sent to Client when Freelancer completed the Task
+	TaskCompleted = NotificationKind("task_completed")
+	// TaskClosed notification is sent to both parties when the Task was closed
+	TaskClosed = NotificationKind("task_closed")
+	// PaymentReceived notification is sent to Freelancer when funds were transferred
+	PaymentReceived = NotificationKind("payment_received")
+	// DeadlineApproaching notification is sent to Freelancer before deadline of the started Task
+	DeadlineApproaching = NotificationKind("deadline_approaching")
+	// ReviewReminder notification is sent to Client before the completed Task is approved automatically
+	ReviewReminder = NotificationKind("review_reminder")
+)
+
+// NotificationKinds lists every NotificationKind
+var NotificationKinds = []NotificationKind{TaskAssigned, TaskCompleted, TaskClosed, PaymentReceived, DeadlineApproaching, ReviewReminder}
+
+const (
+	// AuditCreate action means that the entity was created
+	AuditCreate = AuditAction("create")
+	// AuditUpdate action means that fields of the entity were changed
+	AuditUpdate = AuditAction("update")
+	// AuditDelete action means that the entity was deleted(soft delete)
+	AuditDelete = AuditAction("delete")
+	// AuditBalance action means that funds of Client or Freelancer were credited or withdrawn
+	AuditBalance = AuditAction("balance")
 )
 
 type (
 	// Task represents a job that can be performed on job-exchange
 	Task struct {
-		ID           string        `db:"id"`                                 // ID represents task unique identifier(UUID)
-		ClientID     string        `db:"client_id" json:"client_id"`         // ClientID represents owner ID
-		FreelancerID string        `db:"freelancer_id" json:"freelancer_id"` // FreelancerID represents Freelancer's ID
-		Description  string        `db:"description"`                        // Description represents description of the Task
-		Fee          int64         `db:"fee"`                                // Fee represents amount to be paid upon completion of the Task
-		Deadline     time.Duration `db:"deadline"`                           // Deadline represents duration of the Task
-		Status       TaskStatus    `db:"status"`                             // TaskStatus represents current status of the Task
-		StartedAt    pq.NullTime   `db:"started_at"`                         // StartedAt represents datetime when Freelancer started the Task
-		DeletedAt    pq.NullTime   `db:"deleted_at"`                         // DeletedAt represents datetime when the Task was deleted(soft delete)
-		UpdatedAt    pq.NullTime   `db:"updated_at"`                         // UpdatedAt represents last updated datetime of the Task
-		CreatedAt    time.Time     `db:"created_at"`                         // CreatedAt represents datetime when Client created the Task
+		ID             string         `db:"id"`                                     // ID represents task unique identifier(UUID)
+		ClientID       string         `db:"client_id" json:"client_id"`             // ClientID represents owner ID
+		FreelancerID   string         `db:"freelancer_id" json:"freelancer_id"`     // FreelancerID represents Freelancer's ID
+		Description    string         `db:"description"`                            // Description represents description of the Task
+		Fee            Money          `db:"fee"`                                    // Fee represents amount to be paid upon completion of the Task
+		Deadline       time.Duration  `db:"deadline"`                               // Deadline represents duration of the Task
+		Status         TaskStatus     `db:"status"`                                 // TaskStatus represents current status of the Task
+		StartedAt      pq.NullTime    `db:"started_at"`                             // StartedAt represents datetime when Freelancer started the Task
+		DeletedAt      pq.NullTime    `db:"deleted_at"`                             // DeletedAt represents datetime when the Task was deleted(soft delete)
+		UpdatedAt      pq.NullTime    `db:"updated_at"`                             // UpdatedAt represents last updated datetime of the Task
+		CreatedAt      time.Time      `db:"created_at"`                             // CreatedAt represents datetime when Client created the Task
+		CompletedAt    pq.NullTime    `db:"completed_at" json:"completed_at"`       // CompletedAt represents datetime when Freelancer completed the Task
+		ReviewDeadline pq.NullTime    `db:"review_deadline" json:"review_deadline"` // ReviewDeadline represents datetime after which completed Task is closed automatically
+		RemindedAt     pq.NullTime    `db:"reminded_at" json:"reminded_at"`         // RemindedAt represents datetime when Client was reminded about review deadline
+		Contract       ContractType   `db:"contract"`                               // Contract represents how Freelancer is paid for the Task
+		HourlyRate     Money          `db:"hourly_rate" json:"hourly_rate"`         // HourlyRate represents amount paid per hour of hourly contract
+		WeeklyCap      time.Duration  `db:"weekly_cap" json:"weekly_cap"`           // WeeklyCap represents maximum duration paid per week of hourly contract
+		Tags           pq.StringArray `db:"tags" json:"tags"`                       // Tags represents skills or topics of the Task, lowercase
+		Category       string         `db:"category" json:"category"`               // Category represents ID of the Category the Task belongs to
+		Skills         pq.StringArray `db:"skills" json:"skills"`                   // Skills represents IDs of Skills required by the Task
+	}
+
+	// TaskQuery represents search of open Tasks
+	TaskQuery struct {
+		Text        string        `json:"text"`             // Text represents words searched in description, web search syntax: "phrase", or, -word
+		Tags        []string      `json:"tags,omitempty"`   // Tags represents tags every found Task must have
+		Category    string        `json:"category"`         // Category represents ID of the Category of found Tasks, empty means any
+		Skills      []string      `json:"skills,omitempty"` // Skills represents IDs of Skills every found Task must require
+		MinFee      Money         `json:"min_fee"`          // MinFee represents minimum fee of fixed contract, zero amount means no minimum
+		MaxFee      Money         `json:"max_fee"`          // MaxFee represents maximum fee of fixed contract, zero amount means no maximum
+		MaxDeadline time.Duration `json:"max_deadline"`     // MaxDeadline represents longest deadline of found Tasks, zero means any
+		Limit       int           `json:"limit"`            // Limit represents maximum number of returned Tasks
+		Offset      int           `json:"offset"`           // Offset represents number of skipped Tasks
+	}
+
+	// TaskMatch represents Task found by TaskQuery
+	TaskMatch struct {
+		Task
+		Rank    float64 `db:"rank" json:"rank"`       // Rank represents relevance of the Task to the searched text
+		Snippet string  `db:"snippet" json:"snippet"` // Snippet represents fragments of description with matching words in <mark> elements, HTML escaped
+	}
+
+	// TaskSearchResult represents page of Tasks found by TaskQuery, most relevant first
+	TaskSearchResult struct {
+		Total int         `json:"total"` // Total represents number of found Tasks on every page
+		Tasks []TaskMatch `json:"tasks"` // Tasks represents found Tasks of the page
 	}
 
 	// Freelancer represents a freelancer(obviously)
 	Freelancer struct {
-		ID          string         `db:"id"`          // ID represents Freelnacer's unique identifier
-		Description sql.NullString `db:"description"` // Description of the Freelancer's relative work experience
-		Details     sql.NullString `db:"details"`     // Details of the Freelancer
-		Email       string         `db:"email"`       // Email of the Freelancer
-		Balance     sql.NullInt64  `db:"balance"`     // Balance is amount of money freelancer possess
-		DeletedAt   pq.NullTime    `db:"deleted_at"`  // DeletedAt represents datetime when the Task was deleted(soft delete)
+		ID          string            `db:"id"`                        // ID represents Freelnacer's unique identifier
+		Description sql.NullString    `db:"description"`               // Description of the Freelancer's relative work experience
+		Details     sql.NullString    `db:"details"`                   // Details of the Freelancer
+		Email       string            `db:"email"`                     // Email of the Freelancer
+		Balance     Money             `db:"balance"`                   // Balance is amount of money freelancer possess in Freelancer's primary currency
+		DeletedAt   pq.NullTime       `db:"deleted_at"`                // DeletedAt represents datetime when the Task was deleted(soft delete)
+		Skills      []FreelancerSkill `db:"-" json:"skills,omitempty"` // Skills represents Skills declared by the Freelancer, kept in FREELANCER_SKILL table
+	}
+
+	// FreelancerSkill represents Skill declared by Freelancer along with proficiency
+	FreelancerSkill struct {
+		SkillID string     `db:"skill_id" json:"skill_id"` // SkillID represents ID of the Skill
+		Level   SkillLevel `db:"level" json:"level"`       // Level represents proficiency of the Freelancer, 1-3
+	}
+
+	// FreelancerQuery represents search of Freelancers by declared Skills
+	FreelancerQuery struct {
+		Skills   []string   `json:"skills,omitempty"` // Skills represents IDs of Skills every found Freelancer must have
+		MinLevel SkillLevel `json:"min_level"`        // MinLevel represents minimum proficiency in the Skills, zero means any
+		Category string     `json:"category"`         // Category represents ID of the Category found Freelancers must have a Skill in
+		Limit    int        `json:"limit"`            // Limit represents maximum number of returned Freelancers
+		Offset   int        `json:"offset"`           // Offset represents number of skipped Freelancers
+	}
+
+	// Category represents group of related Skills, e.g. development or design
+	Category struct {
+		ID   string `db:"id" json:"id"`     // ID represents Category's unique identifier, lowercase slug
+		Name string `db:"name" json:"name"` // Name represents human readable name of the Category
+	}
+
+	// Skill represents entry of the skills taxonomy required by Tasks and declared by Freelancers
+	Skill struct {
+		ID         string `db:"id" json:"id"`                   // ID represents Skill's unique identifier, lowercase slug, e.g. postgresql
+		Name       string `db:"name" json:"name"`               // Name represents human readable name of the Skill
+		CategoryID string `db:"category_id" json:"category_id"` // CategoryID represents Category the Skill belongs to
+	}
+
+	// Rating represents score given by Client to Freelancer for the closed Task
+	Rating struct {
+		TaskID       string    `db:"task_id" json:"task_id"`             // TaskID represents rated Task, every Task is rated once
+		ClientID     string    `db:"client_id" json:"client_id"`         // ClientID represents Client giving the Rating
+		FreelancerID string    `db:"freelancer_id" json:"freelancer_id"` // FreelancerID represents rated Freelancer
+		Score        int       `db:"score" json:"score"`                 // Score represents satisfaction of the Client, 1-5
+		CreatedAt    time.Time `db:"created_at" json:"created_at"`       // CreatedAt represents datetime when the Task was rated
+	}
+
+	// MatchQuery represents request of recommendations for the Task or Freelancer by ID
+	MatchQuery struct {
+		ID    string `json:"id"`    // ID represents Task or Freelancer recommendations are made for
+		Limit int    `json:"limit"` // Limit represents maximum number of recommendations
+	}
+
+	// Match represents how well the Freelancer fits the Task, every factor is between 0 and 1
+	Match struct {
+		TaskID       string  `json:"task_id"`       // TaskID represents matched Task
+		FreelancerID string  `json:"freelancer_id"` // FreelancerID represents matched Freelancer
+		Score        float64 `json:"score"`         // Score represents weighted sum of the factors, higher is better
+		Skills       float64 `json:"skills"`        // Skills represents proficiency of the Freelancer in Skills required by the Task
+		Rating       float64 `json:"rating"`        // Rating represents Ratings given to the Freelancer
+		Completion   float64 `json:"completion"`    // Completion represents share of assigned Tasks the Freelancer completed
+		Availability float64 `json:"availability"`  // Availability represents how few Tasks the Freelancer works on at the moment
+		Price        float64 `json:"price"`         // Price represents how the Task pays compared to Tasks the Freelancer completed
+	}
+
+	// EmailPreferences represents notifications the user by ID does not want to receive by email
+	EmailPreferences struct {
+		UserID string         `db:"user_id" json:"user_id"` // UserID represents Client or Freelancer
+		OptOut pq.StringArray `db:"opt_out" json:"opt_out"` // OptOut represents NotificationKinds not emailed to the user, "*" opts out of every email
+	}
+
+	// Notification represents entry of the user's in-app inbox
+	Notification struct {
+		ID        string           `db:"id" json:"id"`                 // ID represents unique identifier of the Notification
+		UserID    string           `db:"user_id" json:"user_id"`       // UserID represents notified Client or Freelancer
+		Kind      NotificationKind `db:"kind" json:"kind"`             // Kind represents lifecycle change the user is notified about
+		Ref       string           `db:"ref" json:"-"`                 // Ref represents event or Task the Notification is about, the user gets one Notification of a kind per Ref
+		TaskID    string           `db:"task_id" json:"task_id"`       // TaskID represents Task the Notification is about
+		Title     string           `db:"title" json:"title"`           // Title represents short summary of the Notification
+		Text      string           `db:"text" json:"text"`             // Text represents the Notification
+		CreatedAt time.Time        `db:"created_at" json:"created_at"` // CreatedAt represents datetime when the user was notified
+		ReadAt    pq.NullTime      `db:"read_at" json:"read_at"`       // ReadAt represents datetime when the user read the Notification
+	}
+
+	// NotificationQuery represents page of the user's Notifications
+	NotificationQuery struct {
+		UserID string `json:"user_id"` // UserID represents owner of the inbox
+		Unread bool   `json:"unread"`  // Unread requests unread Notifications only
+		Limit  int    `json:"limit"`   // Limit represents maximum number of returned Notifications
+		Offset int    `json:"offset"`  // Offset represents number of skipped Notifications
+	}
+
+	// NotificationPage represents page of the user's Notifications, the latest first
+	NotificationPage struct {
+		Total         int            `json:"total"`         // Total represents number of Notifications matching the query
+		Unread        int            `json:"unread"`        // Unread represents number of unread Notifications in the inbox
+		Notifications []Notification `json:"notifications"` // Notifications represents Notifications of the page
+	}
+
+	// NotificationRead represents Notifications the user by ID has read
+	NotificationRead struct {
+		UserID string   `json:"user_id"` // UserID represents owner of the inbox
+		IDs    []string `json:"ids"`     // IDs represents read Notifications
+		All    bool     `json:"all"`     // All marks every Notification of the user as read
 	}
 
 	// Payment reprents payment for the Task performed by Freelancer
 	Payment struct {
-		ID           string        `db:"id"`            // Payment transaction indentifier
-		ClientID     string        `db:"client_id"`     // ClientID represents Client's ID
-		FreelancerID string        `db:"freelancer_id"` // FreelancerID represents Freelancer's ID
-		TaskID       string        `db:"task_id"`       // TaskID represents Task's ID
-		Amount       int64         `db:"amount"`        // Amount represents amount to be paid in cents
-		PaidDate     time.Time     `db:"paid_date"`     // PaidDate  represents datetime when payment was processed
-		Status       PaymentStatus `db:"status"`        // PaymentStatus represents current status of the payment
+		ID           string         `db:"id"`            // Payment transaction indentifier
+		ClientID     string         `db:"client_id"`     // ClientID represents Client's ID
+		FreelancerID string         `db:"freelancer_id"` // FreelancerID represents Freelancer's ID
+		TaskID       string         `db:"task_id"`       // TaskID represents Task's ID
+		Amount       Money          `db:"amount"`        // Amount represents amount to be paid
+		PaidDate     time.Time      `db:"paid_date"`     // PaidDate  represents datetime when payment was processed
+		Status       PaymentStatus  `db:"status"`        // PaymentStatus represents current status of the payment
+		Reference    sql.NullString `db:"reference"`     // Reference represents unique key of the periodic payment, e.g. weekly timesheet
 	}
 
 	// Client represents individual client or organization
 	Client struct {
 		ID        string      `db:"id"`         // ID represents Client's unique identifier
 		Email     string      `db:"email"`      // Email represents Client's email
-		Balance   int64       `db:"balance"`    // Balance represents amount of money left on the account in cents
+		Balance   Money       `db:"balance"`    // Balance represents amount of money left on the account in Client's primary currency
 		DeletedAt pq.NullTime `db:"deleted_at"` // DeletedAt represents datetime when the Client was deleted(soft delete)
 	}
 
+	// Invoice represents accounting document issued for the closed and paid Task
+	Invoice struct {
+		ID              string    `db:"id"`                                       // ID represents Invoice's unique identifier
+		Number          int64     `db:"number"`                                   // Number represents sequential invoice number
+		TaskID          string    `db:"task_id" json:"task_id"`                   // TaskID represents invoiced Task's ID
+		PaymentID       string    `db:"payment_id" json:"payment_id"`             // PaymentID represents Payment's ID the Invoice was issued for
+		ClientID        string    `db:"client_id" json:"client_id"`               // ClientID represents billed Client's ID
+		ClientEmail     string    `db:"client_email" json:"client_email"`         // ClientEmail represents billed Client's email
+		FreelancerID    string    `db:"freelancer_id" json:"freelancer_id"`       // FreelancerID represents paid Freelancer's ID
+		FreelancerEmail string    `db:"freelancer_email" json:"freelancer_email"` // FreelancerEmail represents paid Freelancer's email
+		Description     string    `db:"description"`                              // Description represents description of the invoiced Task
+		Amount          Money     `db:"amount"`                                   // Amount represents invoiced amount
+		PaidDate        time.Time `db:"paid_date" json:"paid_date"`               // PaidDate represents datetime when payment was processed
+		IssuedAt        time.Time `db:"issued_at" json:"issued_at"`               // IssuedAt represents datetime when the Invoice was issued
+	}
+
+	// Dispute represents disagreement between Client and Freelancer on the Task
+	Dispute struct {
+		ID               string             `db:"id"`                                         // ID represents Dispute's unique identifier
+		TaskID           string             `db:"task_id" json:"task_id"`                     // TaskID represents disputed Task's ID
+		OpenedBy         string             `db:"opened_by" json:"opened_by"`                 // OpenedBy represents ID of the party(Client or Freelancer) that opened the Dispute
+		Reason           string             `db:"reason"`                                     // Reason represents why the Dispute was opened
+		Status           DisputeStatus      `db:"status"`                                     // Status represents current status of the Dispute
+		TaskStatus       TaskStatus         `db:"task_status" json:"task_status"`             // TaskStatus represents status of the Task before the Dispute was opened
+		FreelancerAmount Money              `db:"freelancer_amount" json:"freelancer_amount"` // FreelancerAmount represents amount paid out to Freelancer upon resolution
+		ClientAmount     Money              `db:"client_amount" json:"client_amount"`         // ClientAmount represents amount refunded to Client upon resolution
+		Resolution       sql.NullString     `db:"resolution"`                                 // Resolution represents admin's decision
+		ResolvedBy       sql.NullString     `db:"resolved_by" json:"resolved_by"`             // ResolvedBy represents operator who resolved the Dispute
+		CreatedAt        time.Time          `db:"created_at" json:"created_at"`               // CreatedAt represents datetime when the Dispute was opened
+		ResolvedAt       pq.NullTime        `db:"resolved_at" json:"resolved_at"`             // ResolvedAt represents datetime when the Dispute was resolved
+		Statements       []DisputeStatement `db:"-" json:"statements,omitempty"`              // Statements represents statements submitted by both parties
+	}
+
+	// DisputeStatement represents statement and evidence submitted by a party of the Dispute
+	DisputeStatement struct {
+		ID        string         `db:"id"`                           // ID represents Statement's unique identifier
+		DisputeID string         `db:"dispute_id" json:"dispute_id"` // DisputeID represents Dispute's ID
+		AuthorID  string         `db:"author_id" json:"author_id"`   // AuthorID represents ID of the party that submitted the Statement
+		Statement string         `db:"statement"`                    // Statement represents party's explanation
+		Evidence  pq.StringArray `db:"evidence"`                     // Evidence represents links to supporting materials
+		CreatedAt time.Time      `db:"created_at" json:"created_at"` // CreatedAt represents datetime when the Statement was submitted
+	}
+
+	// TimeEntry represents time logged by Freelancer against hourly contract
+	TimeEntry struct {
+		ID           string          `db:"id"`                                 // ID represents TimeEntry's unique identifier
+		TaskID       string          `db:"task_id" json:"task_id"`             // TaskID represents hourly Task's ID
+		FreelancerID string          `db:"freelancer_id" json:"freelancer_id"` // FreelancerID represents Freelancer's ID
+		Date         time.Time       `db:"date"`                               // Date represents the day the work was performed
+		Duration     time.Duration   `db:"duration"`                           // Duration represents time spent
+		Note         string          `db:"note"`                               // Note represents description of the work performed
+		Status       TimeEntryStatus `db:"status"`                             // Status represents current status of the TimeEntry
+		PaymentID    sql.NullString  `db:"payment_id" json:"payment_id"`       // PaymentID represents Payment the TimeEntry was billed with
+		CreatedAt    time.Time       `db:"created_at" json:"created_at"`       // CreatedAt represents datetime when the TimeEntry was logged
+	}
+
+	// Timesheet represents TimeEntries of the hourly Task logged during a week
+	Timesheet struct {
+		TaskID   string        `json:"task_id"`             // TaskID represents hourly Task's ID
+		ClientID string        `json:"client_id,omitempty"` // ClientID represents Client approving the Timesheet
+		Week     time.Time     `json:"week"`                // Week represents start of the week(Monday 00:00 UTC)
+		Total    time.Duration `json:"total"`               // Total represents time logged during the week
+		Entries  []TimeEntry   `json:"entries"`             // Entries represents TimeEntries logged during the week
+	}
+
+	// Charge represents request to lock and pay amount for the Task through escrow
+	Charge struct {
+		TaskID    string `json:"task_id"`   // TaskID represents charged Task's ID
+		Amount    Money  `json:"amount"`    // Amount represents amount to be paid to Freelancer
+		Reference string `json:"reference"` // Reference represents unique key that makes Charge idempotent
+	}
+
+	// Reservation represents Client's funds withdrawn for the Task being created,
+	// it is confirmed once the Task is created and released otherwise
+	Reservation struct {
+		ID        string            `db:"id"`                           // ID represents Reservation's unique identifier, repeated requests with the same ID are ignored
+		ClientID  string            `db:"client_id" json:"client_id"`   // ClientID represents Client whose funds are reserved
+		TaskID    string            `db:"task_id" json:"task_id"`       // TaskID represents Task the funds are reserved for
+		Amount    Money             `db:"amount"`                       // Amount represents requested amount
+		Withdrawn Money             `db:"withdrawn"`                    // Withdrawn represents amount taken from the account, differs from Amount when converted
+		Status    ReservationStatus `db:"status"`                       // Status represents current status of the Reservation
+		CreatedAt time.Time         `db:"created_at" json:"created_at"` // CreatedAt represents datetime when funds were reserved
+		UpdatedAt pq.NullTime       `db:"updated_at" json:"updated_at"` // UpdatedAt represents datetime when Reservation was confirmed or released
+	}
+
+	// Wallet represents funds of Client or Freelancer held in currency
+	// other than the primary currency of the account
+	Wallet struct {
+		ID      string `db:"id"`                       // ID represents Wallet's unique identifier
+		OwnerID string `db:"owner_id" json:"owner_id"` // OwnerID represents Client's or Freelancer's ID
+		Balance Money  `db:"balance"`                  // Balance represents amount of money in the Wallet
+	}
+
+	// Adjustment represents manual change of Client's or Freelancer's funds made by an operator,
+	// adjustments are kept as the audit trail of the balances
+	Adjustment struct {
+		ID        string    `db:"id" json:"id"`                 // ID represents Adjustment's unique identifier
+		Owner     string    `db:"owner" json:"owner"`           // Owner represents type of the account, client or freelancer
+		OwnerID   string    `db:"owner_id" json:"owner_id"`     // OwnerID represents Client's or Freelancer's ID
+		Amount    Money     `db:"amount" json:"amount"`         // Amount represents credited amount, negative amount is withdrawn
+		Reason    string    `db:"reason" json:"reason"`         // Reason represents why the funds were adjusted, it is mandatory
+		Actor     string    `db:"actor" json:"actor"`           // Actor represents operator who adjusted the funds
+		CreatedAt time.Time `db:"created_at" json:"created_at"` // CreatedAt represents datetime of the Adjustment
+	}
+
+	// TaskAction represents operator's intervention into the Task, e.g. force-close or refund
+	TaskAction struct {
+		TaskID string `json:"task_id"` // TaskID represents the Task
+		Reason string `json:"reason"`  // Reason represents why the operator intervened, it is mandatory
+		Actor  string `json:"actor"`   // Actor represents the operator
+	}
+
+	// AuditEntry represents change of the entity recorded in the audit log, entries are never updated or deleted
+	AuditEntry struct {
+		ID        string          `db:"id" json:"id"`                 // ID represents AuditEntry's unique identifier
+		Actor     string          `db:"actor" json:"actor"`           // Actor represents user or operator the change was made on behalf of, system for the services' own jobs
+		Action    AuditAction     `db:"action" json:"action"`         // Action represents kind of the change
+		Entity    string          `db:"entity" json:"entity"`         // Entity represents type of the changed entity, e.g. client, freelancer or task
+		EntityID  string          `db:"entity_id" json:"entity_id"`   // EntityID represents ID of the changed entity
+		Before    json.RawMessage `db:"before" json:"before"`         // Before represents changed fields before the change as JSON object, empty for created entity
+		After     json.RawMessage `db:"after" json:"after"`           // After represents changed fields after the change as JSON object
+		CreatedAt time.Time       `db:"created_at" json:"created_at"` // CreatedAt represents datetime of the change
+	}
+
+	// AuditQuery represents page of the audit log, entries match every field set
+	AuditQuery struct {
+		Entity   string `json:"entity"`    // Entity represents type of the changed entities
+		EntityID string `json:"entity_id"` // EntityID represents ID of the changed entity
+		Actor    string `json:"actor"`     // Actor represents user or operator who made the changes
+		Limit    int    `json:"limit"`     // Limit represents maximum number of returned entries
+		Offset   int    `json:"offset"`    // Offset represents number of skipped entries
+	}
+
+	// Webhook represents Client's endpoint notified about events of its Tasks and Payments
+	Webhook struct {
+		ID        string         `db:"id"`                             // ID represents Webhook's unique identifier
+		ClientID  string         `db:"client_id" json:"client_id"`     // ClientID represents Client that registered the Webhook
+		URL       string         `db:"url"`                            // URL represents endpoint events are POSTed to
+		Secret    string         `db:"secret" json:"secret,omitempty"` // Secret represents key deliveries are signed with, it is returned on registration only
+		Events    pq.StringArray `db:"events"`                         // Events represents event types the Webhook is subscribed to, e.g. task.*, empty means all
+		CreatedAt time.Time      `db:"created_at" json:"created_at"`   // CreatedAt represents datetime when the Webhook was registered
+		DeletedAt pq.NullTime    `db:"deleted_at" json:"deleted_at"`   // DeletedAt represents datetime when the Webhook was deleted(soft delete)
+	}
+
+	// WebhookAccess represents request of the Client to its Webhook or Delivery by ID
+	WebhookAccess struct {
+		ID       string `json:"id"`        // ID represents requested Webhook or Delivery
+		ClientID string `json:"client_id"` // ClientID represents Client making the request
+	}
+
+	// Delivery represents event sent to the Webhook
+	Delivery struct {
+		ID            string            `db:"id"`                                     // ID represents Delivery's unique identifier
+		WebhookID     string            `db:"webhook_id" json:"webhook_id"`           // WebhookID represents Webhook the event is sent to
+		EventID       string            `db:"event_id" json:"event_id"`               // EventID represents ID of the delivered event
+		EventType     string            `db:"event_type" json:"event_type"`           // EventType represents type of the delivered event
+		Payload       json.RawMessage   `db:"payload"`                                // Payload represents request body, the event envelope
+		Status        DeliveryStatus    `db:"status"`                                 // Status represents current status of the Delivery
+		Attempts      int               `db:"attempts"`                               // Attempts represents number of attempts made since the Delivery was created or replayed
+		NextAttemptAt time.Time         `db:"next_attempt_at" json:"next_attempt_at"` // NextAttemptAt represents datetime of the next attempt of pending Delivery
+		LastError     sql.NullString    `db:"last_error" json:"last_error"`           // LastError represents reason the last attempt failed
+		CreatedAt     time.Time         `db:"created_at" json:"created_at"`           // CreatedAt represents datetime when the event was received
+		DeliveredAt   pq.NullTime       `db:"delivered_at" json:"delivered_at"`       // DeliveredAt represents datetime when the endpoint accepted the event
+		Log           []DeliveryAttempt `db:"-" json:"log,omitempty"`                 // Log represents every attempt made, oldest first
+	}
+
+	// DeliveryAttempt represents single attempt to deliver event to the Webhook
+	DeliveryAttempt struct {
+		ID         string         `db:"id"`                             // ID represents DeliveryAttempt's unique identifier
+		DeliveryID string         `db:"delivery_id" json:"delivery_id"` // DeliveryID represents Delivery the attempt was made for
+		StatusCode int            `db:"status_code" json:"status_code"` // StatusCode represents HTTP status returned by the endpoint, zero when request failed
+		Error      sql.NullString `db:"error"`                          // Error represents reason the attempt failed
+		Duration   time.Duration  `db:"duration"`                       // Duration represents time the request took
+		CreatedAt  time.Time      `db:"created_at" json:"created_at"`   // CreatedAt represents datetime when the attempt was made
+	}
+
+	// Message represents message sent by Client or Freelancer to the other party of the Task
+	Message struct {
+		ID        string      `db:"id"`                           // ID represents Message's unique identifier
+		TaskID    string      `db:"task_id" json:"task_id"`       // TaskID represents Task the Message thread belongs to
+		SenderID  string      `db:"sender_id" json:"sender_id"`   // SenderID represents Client's or Freelancer's ID
+		Body      string      `db:"body"`                         // Body represents text of the Message
+		CreatedAt time.Time   `db:"created_at" json:"created_at"` // CreatedAt represents datetime when the Message was sent
+		ReadAt    pq.NullTime `db:"read_at" json:"read_at"`       // ReadAt represents datetime when the recipient read the Message
+	}
+
+	// Thread represents Messages of the Task as seen by one of its parties
+	Thread struct {
+		TaskID   string    `json:"task_id"`            // TaskID represents Task the Messages belong to
+		UserID   string    `json:"user_id"`            // UserID represents Client or Freelancer reading the Thread
+		Unread   int       `json:"unread"`             // Unread represents number of Messages sent to the user and not read yet
+		Messages []Message `json:"messages,omitempty"` // Messages represents Messages of the Thread, oldest first
+	}
+
+	// Attachment represents file attached to the Task, content is kept in blob storage
+	Attachment struct {
+		ID          string         `db:"id"`                               // ID represents Attachment's unique identifier
+		TaskID      string         `db:"task_id" json:"task_id"`           // TaskID represents Task the file is attached to
+		UploaderID  string         `db:"uploader_id" json:"uploader_id"`   // UploaderID represents Client's or Freelancer's ID
+		Kind        AttachmentKind `db:"kind" json:"kind"`                 // Kind represents whether the file is brief or deliverable
+		Name        string         `db:"name" json:"name"`                 // Name represents original file name
+		ContentType string         `db:"content_type" json:"content_type"` // ContentType represents media type detected from the content
+		Size        int64          `db:"size" json:"size"`                 // Size represents length of the file in bytes
+		Checksum    string         `db:"checksum" json:"checksum"`         // Checksum represents hex encoded SHA-256 of the content
+		CreatedAt   time.Time      `db:"created_at" json:"created_at"`     // CreatedAt represents datetime when the file was uploaded
+	}
+
+	// AttachmentAccess represents request of the user to Attachment by ID or to Attachments of the Task
+	AttachmentAccess struct {
+		ID     string `json:"id,omitempty"`      // ID represents requested Attachment
+		TaskID string `json:"task_id,omitempty"` // TaskID represents Task whose Attachments are requested
+		UserID string `json:"user_id"`           // UserID represents Client or Freelancer making the request
+	}
+
 	// NATSMsg represents message used for request/response via NATS
 	NATSMsg struct {
 		Success bool            `json:"success"`
+		Code    string          `json:"code,omitempty"`
 		Message string          `json:"message,omitempty"`
 		Data    json.RawMessage `json:"data,omitempty"`
 	}
 )
 
 // NewTask is a helper func to create new Task struct
-func NewTask(deadline time.Duration, fee int64, clientID, description string) Task {
+func NewTask(deadline time.Duration, fee Money, clientID, description string) Task {
 	return Task{
 		ID:          NewID(),
 		CreatedAt:   time.Now(),
 		Status:      Open,
+		Contract:    FixedContract,
 		Fee:         fee,
 		ClientID:    clientID,
 		Description: description,
@@ -113,8 +584,51 @@ func NewTask(deadline time.Duration, fee int64, clientID, description string) Ta
 	}
 }
 
+// NewHourlyTask is a helper func to create new Task struct with hourly contract
+func NewHourlyTask(deadline time.Duration, rate Money, weeklyCap time.Duration, clientID, description string) Task {
+	t := NewTask(deadline, NewMoney(0, rate.Currency), clientID, description)
+	t.Contract = HourlyContract
+	t.HourlyRate = rate
+	t.WeeklyCap = weeklyCap
+	return t
+}
+
+// NewTimeEntry is a helper func to create new TimeEntry struct
+func NewTimeEntry(taskID, freelancerID string, date time.Time, duration time.Duration, note string) TimeEntry {
+	return TimeEntry{
+		ID:           NewID(),
+		TaskID:       taskID,
+		FreelancerID: freelancerID,
+		Date:         date,
+		Duration:     duration,
+		Note:         note,
+		Status:       EntryPending,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// WeekStart returns start of the week(Monday 00:00 UTC) the given time belongs to
+func WeekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// NewDispute is a helper func to create new Dispute struct
+func NewDispute(taskID, openedBy, reason string) Dispute {
+	return Dispute{
+		ID:        NewID(),
+		TaskID:    taskID,
+		OpenedBy:  openedBy,
+		Reason:    reason,
+		Status:    DisputeOpen,
+		CreatedAt: time.Now(),
+	}
+}
+
 // NewClient is a helper func to create new Client struct
-func NewClient(email string, balance int64) Client {
+func NewClient(email string, balance Money) Client {
 	return Client{
 		ID:      NewID(),
 		Email:   email,
@@ -132,6 +646,21 @@ func NewFreelancer(email, description, details string) Freelancer {
 	}
 }
 
+// Valid reports whether the level is one of Beginner, Intermediate or Expert
+func (l SkillLevel) Valid() bool {
+	return l >= Beginner && l <= Expert
+}
+
+// Code returns human readable invoice number, e.g. INV-000042
+func (i Invoice) Code() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// Key returns key the Attachment's content is stored under
+func (a Attachment) Key() string {
+	return "tasks/" + a.TaskID + "/" + a.ID
+}
+
 // NewID is a wrapper around go.uuid.NewV4() func to supress possible error
 func NewID() string {
 	if i, err := uuid.NewV4(); err == nil {
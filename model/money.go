@@ -0,0 +1,238 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrCurrencyMismatch represents error returned on operation with amounts in different currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrInvalidCurrency represents error returned on unknown ISO 4217 currency code
+	ErrInvalidCurrency = errors.New("invalid currency")
+)
+
+// Currency represents ISO 4217 currency code
+type Currency string
+
+const (
+	// USD represents US Dollar
+	USD = Currency("USD")
+	// EUR represents Euro
+	EUR = Currency("EUR")
+	// GBP represents Pound Sterling
+	GBP = Currency("GBP")
+	// CHF represents Swiss Franc
+	CHF = Currency("CHF")
+	// PLN represents Polish Zloty
+	PLN = Currency("PLN")
+	// SEK represents Swedish Krona
+	SEK = Currency("SEK")
+	// JPY represents Yen
+	JPY = Currency("JPY")
+
+	// DefaultCurrency is used for amounts sent without currency
+	DefaultCurrency = USD
+)
+
+// minorUnits represents number of digits after the decimal separator of supported currencies
+var minorUnits = map[Currency]int{
+	USD: 2,
+	EUR: 2,
+	GBP: 2,
+	CHF: 2,
+	PLN: 2,
+	SEK: 2,
+	JPY: 0,
+}
+
+// Valid reports whether c is supported ISO 4217 currency code
+func (c Currency) Valid() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// MinorUnits returns number of digits after the decimal separator
+func (c Currency) MinorUnits() int {
+	return minorUnits[c]
+}
+
+// Money represents amount in minor units(e.g. cents) of the given currency
+type Money struct {
+	Amount   int64    `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+// NewMoney is a helper func to create new Money struct
+func NewMoney(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// IsZero reports whether m has zero amount
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether m has negative amount
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// SameCurrency reports whether m and o can be used in the same operation.
+// Zero amount without currency is compatible with any currency
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == o.Currency || (m.Currency == "" && m.IsZero()) || (o.Currency == "" && o.IsZero())
+}
+
+// Add returns m+o or ErrCurrencyMismatch if currencies differ
+func (m Money) Add(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return m, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency(o)}, nil
+}
+
+// Sub returns m-o or ErrCurrencyMismatch if currencies differ
+func (m Money) Sub(o Money) (Money, error) {
+	if !m.SameCurrency(o) {
+		return m, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currency(o)}, nil
+}
+
+// Cmp compares m and o and returns -1, 0 or +1,
+// or ErrCurrencyMismatch if currencies differ
+func (m Money) Cmp(o Money) (int, error) {
+	if !m.SameCurrency(o) {
+		return 0, ErrCurrencyMismatch
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m Money) currency(o Money) Currency {
+	if m.Currency != "" {
+		return m.Currency
+	}
+	return o.Currency
+}
+
+// String formats m with currency's minor units, e.g. 2000.50 EUR
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	units := m.Currency.MinorUnits()
+	if units == 0 {
+		return strings.TrimSpace(fmt.Sprintf("%s%d %s", sign, amount, m.Currency))
+	}
+	div := int64(1)
+	for i := 0; i < units; i++ {
+		div *= 10
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s%d.%0*d %s", sign, amount/div, units, amount%div, m.Currency))
+}
+
+// ParseMoney parses amount formatted by String, e.g. 2000.50 EUR or -5 USD,
+// the amount may have up to currency's minor units digits after the decimal separator
+func ParseMoney(s string) (Money, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Money{}, fmt.Errorf("can not parse %q as Money", s)
+	}
+	c := Currency(strings.ToUpper(fields[1]))
+	if !c.Valid() {
+		return Money{}, ErrInvalidCurrency
+	}
+	amount, sign := fields[0], int64(1)
+	if strings.HasPrefix(amount, "-") {
+		amount, sign = amount[1:], -1
+	}
+	whole, frac, _ := strings.Cut(amount, ".")
+	units := c.MinorUnits()
+	nonDigit := func(r rune) bool { return r < '0' || r > '9' }
+	if whole == "" || len(frac) > units || strings.IndexFunc(whole+frac, nonDigit) >= 0 {
+		return Money{}, fmt.Errorf("can not parse %q as Money", s)
+	}
+	frac += strings.Repeat("0", units-len(frac))
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("can not parse %q as Money", s)
+	}
+	return NewMoney(sign*n, c), nil
+}
+
+// UnmarshalJSON accepts either {"amount":2000,"currency":"EUR"} object
+// or bare amount in minor units of DefaultCurrency for backward compatibility
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		*m = Money{Amount: n, Currency: DefaultCurrency}
+		return nil
+	}
+	var v struct {
+		Amount   int64    `json:"amount"`
+		Currency Currency `json:"currency"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Currency != "" && !v.Currency.Valid() {
+		return ErrInvalidCurrency
+	}
+	*m = Money{Amount: v.Amount, Currency: v.Currency}
+	return nil
+}
+
+// Value implements driver.Valuer and stores Money as MONEY_AMOUNT composite,
+// zero amount without currency is stored as NULL
+func (m Money) Value() (driver.Value, error) {
+	if m.Currency == "" {
+		if m.IsZero() {
+			return nil, nil
+		}
+		return nil, ErrInvalidCurrency
+	}
+	return fmt.Sprintf("(%d,%s)", m.Amount, m.Currency), nil
+}
+
+// Scan implements sql.Scanner and reads MONEY_AMOUNT composite, e.g. (2000,EUR)
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("can not scan %T into Money", src)
+	}
+	parts := strings.Split(strings.Trim(s, "()"), ",")
+	if len(parts) != 2 {
+		return fmt.Errorf("can not scan %q into Money", s)
+	}
+	var amount int64
+	if parts[0] != "" {
+		n, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		amount = n
+	}
+	*m = Money{Amount: amount, Currency: Currency(strings.TrimSpace(parts[1]))}
+	return nil
+}
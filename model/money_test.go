@@ -0,0 +1,128 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoney_Arithmetic(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		sum     Money
+		wantErr error
+	}{
+		{name: "same-currency", a: NewMoney(150, EUR), b: NewMoney(250, EUR), sum: NewMoney(400, EUR)},
+		{name: "zero-without-currency", a: Money{}, b: NewMoney(250, GBP), sum: NewMoney(250, GBP)},
+		{name: "mixed-currency", a: NewMoney(150, EUR), b: NewMoney(250, USD), wantErr: ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, err := tt.a.Add(tt.b)
+			if err != tt.wantErr {
+				t.Errorf("Money.Add() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && sum != tt.sum {
+				t.Errorf("Money.Add() expected=%s got=%s", tt.sum, sum)
+			}
+			if _, err := tt.a.Sub(tt.b); err != tt.wantErr {
+				t.Errorf("Money.Sub() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := tt.a.Cmp(tt.b); err != tt.wantErr {
+				t.Errorf("Money.Cmp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := map[Money]string{
+		NewMoney(200050, EUR): "2000.50 EUR",
+		NewMoney(-5, USD):     "-0.05 USD",
+		NewMoney(1500, JPY):   "1500 JPY",
+	}
+	for m, want := range tests {
+		if got := m.String(); got != want {
+			t.Errorf("expected=%s got=%s", want, got)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Money
+		wantErr bool
+	}{
+		{s: "2000.50 EUR", want: NewMoney(200050, EUR)},
+		{s: "-0.05 usd", want: NewMoney(-5, USD)},
+		{s: "25.5 GBP", want: NewMoney(2550, GBP)},
+		{s: "30 CHF", want: NewMoney(3000, CHF)},
+		{s: "1500 JPY", want: NewMoney(1500, JPY)},
+		{s: "1500.5 JPY", wantErr: true},
+		{s: "0.001 EUR", wantErr: true},
+		{s: "10 XYZ", wantErr: true},
+		{s: "10", wantErr: true},
+		{s: "1.-5 EUR", wantErr: true},
+		{s: "--5 EUR", wantErr: true},
+		{s: "abc EUR", wantErr: true},
+	}
+	for _, tt := range tests {
+		m, err := ParseMoney(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && m != tt.want {
+			t.Errorf("%q: expected=%s got=%s", tt.s, tt.want, m)
+		}
+	}
+}
+
+func TestMoney_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Money
+		wantErr bool
+	}{
+		{name: "object", data: `{"amount":2000,"currency":"EUR"}`, want: NewMoney(2000, EUR)},
+		{name: "bare-amount", data: `2000`, want: NewMoney(2000, DefaultCurrency)},
+		{name: "unknown-currency", data: `{"amount":2000,"currency":"XYZ"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			err := json.Unmarshal([]byte(tt.data), &m)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Money.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && m != tt.want {
+				t.Errorf("expected=%s got=%s", tt.want, m)
+			}
+		})
+	}
+}
+
+func TestMoney_ScanValue(t *testing.T) {
+	m := NewMoney(-2000, CHF)
+	v, err := m.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Money
+	if err := got.Scan([]byte(v.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if got != m {
+		t.Errorf("expected=%s got=%s", m, got)
+	}
+	if err := got.Scan(nil); err != nil || got != (Money{}) {
+		t.Errorf("expected zero Money, got=%s err=%v", got, err)
+	}
+	if v, err := (Money{}).Value(); v != nil || err != nil {
+		t.Errorf("expected NULL, got=%v err=%v", v, err)
+	}
+}
@@ -0,0 +1,801 @@
+package pb
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/lib/pq"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func toMoney(m model.Money) *Money {
+	return &Money{Amount: m.Amount, Currency: string(m.Currency)}
+}
+
+func fromMoney(m *Money) model.Money {
+	return model.NewMoney(m.GetAmount(), model.Currency(m.GetCurrency()))
+}
+
+// toTime returns nil for zero time
+func toTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func fromTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+func toNullTime(t pq.NullTime) *timestamppb.Timestamp {
+	if !t.Valid {
+		return nil
+	}
+	return timestamppb.New(t.Time)
+}
+
+func fromNullTime(t *timestamppb.Timestamp) pq.NullTime {
+	if t == nil {
+		return pq.NullTime{}
+	}
+	return pq.NullTime{Time: t.AsTime(), Valid: true}
+}
+
+func toNullString(s sql.NullString) *wrapperspb.StringValue {
+	if !s.Valid {
+		return nil
+	}
+	return wrapperspb.String(s.String)
+}
+
+func fromNullString(s *wrapperspb.StringValue) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: s.Value, Valid: true}
+}
+
+func toTask(t model.Task) *Task {
+	return &Task{
+		Id:             t.ID,
+		ClientId:       t.ClientID,
+		FreelancerId:   t.FreelancerID,
+		Description:    t.Description,
+		Fee:            toMoney(t.Fee),
+		Deadline:       int64(t.Deadline),
+		Status:         string(t.Status),
+		StartedAt:      toNullTime(t.StartedAt),
+		DeletedAt:      toNullTime(t.DeletedAt),
+		UpdatedAt:      toNullTime(t.UpdatedAt),
+		CreatedAt:      toTime(t.CreatedAt),
+		CompletedAt:    toNullTime(t.CompletedAt),
+		ReviewDeadline: toNullTime(t.ReviewDeadline),
+		RemindedAt:     toNullTime(t.RemindedAt),
+		Contract:       string(t.Contract),
+		HourlyRate:     toMoney(t.HourlyRate),
+		WeeklyCap:      int64(t.WeeklyCap),
+		Tags:           t.Tags,
+		Category:       t.Category,
+		Skills:         t.Skills,
+	}
+}
+
+func fromTask(t *Task) model.Task {
+	return model.Task{
+		ID:             t.GetId(),
+		ClientID:       t.GetClientId(),
+		FreelancerID:   t.GetFreelancerId(),
+		Description:    t.GetDescription(),
+		Fee:            fromMoney(t.GetFee()),
+		Deadline:       time.Duration(t.GetDeadline()),
+		Status:         model.TaskStatus(t.GetStatus()),
+		StartedAt:      fromNullTime(t.GetStartedAt()),
+		DeletedAt:      fromNullTime(t.GetDeletedAt()),
+		UpdatedAt:      fromNullTime(t.GetUpdatedAt()),
+		CreatedAt:      fromTime(t.GetCreatedAt()),
+		CompletedAt:    fromNullTime(t.GetCompletedAt()),
+		ReviewDeadline: fromNullTime(t.GetReviewDeadline()),
+		RemindedAt:     fromNullTime(t.GetRemindedAt()),
+		Contract:       model.ContractType(t.GetContract()),
+		HourlyRate:     fromMoney(t.GetHourlyRate()),
+		WeeklyCap:      time.Duration(t.GetWeeklyCap()),
+		Tags:           pq.StringArray(t.GetTags()),
+		Category:       t.GetCategory(),
+		Skills:         pq.StringArray(t.GetSkills()),
+	}
+}
+
+func toFreelancer(f model.Freelancer) *Freelancer {
+	return &Freelancer{
+		Id:          f.ID,
+		Description: toNullString(f.Description),
+		Details:     toNullString(f.Details),
+		Email:       f.Email,
+		Balance:     toMoney(f.Balance),
+		DeletedAt:   toNullTime(f.DeletedAt),
+		Skills:      mapList(f.Skills, toFreelancerSkill),
+	}
+}
+
+func fromFreelancer(f *Freelancer) model.Freelancer {
+	m := model.Freelancer{
+		ID:          f.GetId(),
+		Description: fromNullString(f.GetDescription()),
+		Details:     fromNullString(f.GetDetails()),
+		Email:       f.GetEmail(),
+		Balance:     fromMoney(f.GetBalance()),
+		DeletedAt:   fromNullTime(f.GetDeletedAt()),
+	}
+	for _, s := range f.GetSkills() {
+		m.Skills = append(m.Skills, fromFreelancerSkill(s))
+	}
+	return m
+}
+
+func toFreelancerSkill(s model.FreelancerSkill) *FreelancerSkill {
+	return &FreelancerSkill{SkillId: s.SkillID, Level: int64(s.Level)}
+}
+
+func fromFreelancerSkill(s *FreelancerSkill) model.FreelancerSkill {
+	return model.FreelancerSkill{SkillID: s.GetSkillId(), Level: model.SkillLevel(s.GetLevel())}
+}
+
+func toFreelancerQuery(q model.FreelancerQuery) *FreelancerQuery {
+	return &FreelancerQuery{
+		Skills:   q.Skills,
+		MinLevel: int64(q.MinLevel),
+		Category: q.Category,
+		Limit:    int64(q.Limit),
+		Offset:   int64(q.Offset),
+	}
+}
+
+func fromFreelancerQuery(q *FreelancerQuery) model.FreelancerQuery {
+	return model.FreelancerQuery{
+		Skills:   q.GetSkills(),
+		MinLevel: model.SkillLevel(q.GetMinLevel()),
+		Category: q.GetCategory(),
+		Limit:    int(q.GetLimit()),
+		Offset:   int(q.GetOffset()),
+	}
+}
+
+func toCategory(c model.Category) *Category {
+	return &Category{Id: c.ID, Name: c.Name}
+}
+
+func fromCategory(c *Category) model.Category {
+	return model.Category{ID: c.GetId(), Name: c.GetName()}
+}
+
+func toSkill(s model.Skill) *Skill {
+	return &Skill{Id: s.ID, Name: s.Name, CategoryId: s.CategoryID}
+}
+
+func fromSkill(s *Skill) model.Skill {
+	return model.Skill{ID: s.GetId(), Name: s.GetName(), CategoryID: s.GetCategoryId()}
+}
+
+func toClient(c model.Client) *Client {
+	return &Client{Id: c.ID, Email: c.Email, Balance: toMoney(c.Balance), DeletedAt: toNullTime(c.DeletedAt)}
+}
+
+func fromClient(c *Client) model.Client {
+	return model.Client{ID: c.GetId(), Email: c.GetEmail(), Balance: fromMoney(c.GetBalance()), DeletedAt: fromNullTime(c.GetDeletedAt())}
+}
+
+func toPayment(p model.Payment) *Payment {
+	return &Payment{
+		Id:           p.ID,
+		ClientId:     p.ClientID,
+		FreelancerId: p.FreelancerID,
+		TaskId:       p.TaskID,
+		Amount:       toMoney(p.Amount),
+		PaidDate:     toTime(p.PaidDate),
+		Status:       string(p.Status),
+		Reference:    toNullString(p.Reference),
+	}
+}
+
+func fromPayment(p *Payment) model.Payment {
+	return model.Payment{
+		ID:           p.GetId(),
+		ClientID:     p.GetClientId(),
+		FreelancerID: p.GetFreelancerId(),
+		TaskID:       p.GetTaskId(),
+		Amount:       fromMoney(p.GetAmount()),
+		PaidDate:     fromTime(p.GetPaidDate()),
+		Status:       model.PaymentStatus(p.GetStatus()),
+		Reference:    fromNullString(p.GetReference()),
+	}
+}
+
+func toCharge(c model.Charge) *Charge {
+	return &Charge{TaskId: c.TaskID, Amount: toMoney(c.Amount), Reference: c.Reference}
+}
+
+func fromCharge(c *Charge) model.Charge {
+	return model.Charge{TaskID: c.GetTaskId(), Amount: fromMoney(c.GetAmount()), Reference: c.GetReference()}
+}
+
+func toInvoice(i model.Invoice) *Invoice {
+	return &Invoice{
+		Id:              i.ID,
+		Number:          i.Number,
+		TaskId:          i.TaskID,
+		PaymentId:       i.PaymentID,
+		ClientId:        i.ClientID,
+		ClientEmail:     i.ClientEmail,
+		FreelancerId:    i.FreelancerID,
+		FreelancerEmail: i.FreelancerEmail,
+		Description:     i.Description,
+		Amount:          toMoney(i.Amount),
+		PaidDate:        toTime(i.PaidDate),
+		IssuedAt:        toTime(i.IssuedAt),
+	}
+}
+
+func fromInvoice(i *Invoice) model.Invoice {
+	return model.Invoice{
+		ID:              i.GetId(),
+		Number:          i.GetNumber(),
+		TaskID:          i.GetTaskId(),
+		PaymentID:       i.GetPaymentId(),
+		ClientID:        i.GetClientId(),
+		ClientEmail:     i.GetClientEmail(),
+		FreelancerID:    i.GetFreelancerId(),
+		FreelancerEmail: i.GetFreelancerEmail(),
+		Description:     i.GetDescription(),
+		Amount:          fromMoney(i.GetAmount()),
+		PaidDate:        fromTime(i.GetPaidDate()),
+		IssuedAt:        fromTime(i.GetIssuedAt()),
+	}
+}
+
+func toDispute(d model.Dispute) *Dispute {
+	m := &Dispute{
+		Id:               d.ID,
+		TaskId:           d.TaskID,
+		OpenedBy:         d.OpenedBy,
+		Reason:           d.Reason,
+		Status:           string(d.Status),
+		TaskStatus:       string(d.TaskStatus),
+		FreelancerAmount: toMoney(d.FreelancerAmount),
+		ClientAmount:     toMoney(d.ClientAmount),
+		Resolution:       toNullString(d.Resolution),
+		ResolvedBy:       toNullString(d.ResolvedBy),
+		CreatedAt:        toTime(d.CreatedAt),
+		ResolvedAt:       toNullTime(d.ResolvedAt),
+	}
+	for _, s := range d.Statements {
+		m.Statements = append(m.Statements, toDisputeStatement(s))
+	}
+	return m
+}
+
+func fromDispute(d *Dispute) model.Dispute {
+	m := model.Dispute{
+		ID:               d.GetId(),
+		TaskID:           d.GetTaskId(),
+		OpenedBy:         d.GetOpenedBy(),
+		Reason:           d.GetReason(),
+		Status:           model.DisputeStatus(d.GetStatus()),
+		TaskStatus:       model.TaskStatus(d.GetTaskStatus()),
+		FreelancerAmount: fromMoney(d.GetFreelancerAmount()),
+		ClientAmount:     fromMoney(d.GetClientAmount()),
+		Resolution:       fromNullString(d.GetResolution()),
+		ResolvedBy:       fromNullString(d.GetResolvedBy()),
+		CreatedAt:        fromTime(d.GetCreatedAt()),
+		ResolvedAt:       fromNullTime(d.GetResolvedAt()),
+	}
+	for _, s := range d.GetStatements() {
+		m.Statements = append(m.Statements, fromDisputeStatement(s))
+	}
+	return m
+}
+
+func toDisputeStatement(s model.DisputeStatement) *DisputeStatement {
+	return &DisputeStatement{
+		Id:        s.ID,
+		DisputeId: s.DisputeID,
+		AuthorId:  s.AuthorID,
+		Statement: s.Statement,
+		Evidence:  s.Evidence,
+		CreatedAt: toTime(s.CreatedAt),
+	}
+}
+
+func fromDisputeStatement(s *DisputeStatement) model.DisputeStatement {
+	return model.DisputeStatement{
+		ID:        s.GetId(),
+		DisputeID: s.GetDisputeId(),
+		AuthorID:  s.GetAuthorId(),
+		Statement: s.GetStatement(),
+		Evidence:  s.GetEvidence(),
+		CreatedAt: fromTime(s.GetCreatedAt()),
+	}
+}
+
+func toTimeEntry(e model.TimeEntry) *TimeEntry {
+	return &TimeEntry{
+		Id:           e.ID,
+		TaskId:       e.TaskID,
+		FreelancerId: e.FreelancerID,
+		Date:         toTime(e.Date),
+		Duration:     int64(e.Duration),
+		Note:         e.Note,
+		Status:       string(e.Status),
+		PaymentId:    toNullString(e.PaymentID),
+		CreatedAt:    toTime(e.CreatedAt),
+	}
+}
+
+func fromTimeEntry(e *TimeEntry) model.TimeEntry {
+	return model.TimeEntry{
+		ID:           e.GetId(),
+		TaskID:       e.GetTaskId(),
+		FreelancerID: e.GetFreelancerId(),
+		Date:         fromTime(e.GetDate()),
+		Duration:     time.Duration(e.GetDuration()),
+		Note:         e.GetNote(),
+		Status:       model.TimeEntryStatus(e.GetStatus()),
+		PaymentID:    fromNullString(e.GetPaymentId()),
+		CreatedAt:    fromTime(e.GetCreatedAt()),
+	}
+}
+
+func toTimesheet(t model.Timesheet) *Timesheet {
+	m := &Timesheet{TaskId: t.TaskID, ClientId: t.ClientID, Week: toTime(t.Week), Total: int64(t.Total)}
+	for _, e := range t.Entries {
+		m.Entries = append(m.Entries, toTimeEntry(e))
+	}
+	return m
+}
+
+func fromTimesheet(t *Timesheet) model.Timesheet {
+	m := model.Timesheet{TaskID: t.GetTaskId(), ClientID: t.GetClientId(), Week: fromTime(t.GetWeek()), Total: time.Duration(t.GetTotal())}
+	for _, e := range t.GetEntries() {
+		m.Entries = append(m.Entries, fromTimeEntry(e))
+	}
+	return m
+}
+
+func toReservation(r model.Reservation) *Reservation {
+	return &Reservation{
+		Id:        r.ID,
+		ClientId:  r.ClientID,
+		TaskId:    r.TaskID,
+		Amount:    toMoney(r.Amount),
+		Withdrawn: toMoney(r.Withdrawn),
+		Status:    string(r.Status),
+		CreatedAt: toTime(r.CreatedAt),
+		UpdatedAt: toNullTime(r.UpdatedAt),
+	}
+}
+
+func fromReservation(r *Reservation) model.Reservation {
+	return model.Reservation{
+		ID:        r.GetId(),
+		ClientID:  r.GetClientId(),
+		TaskID:    r.GetTaskId(),
+		Amount:    fromMoney(r.GetAmount()),
+		Withdrawn: fromMoney(r.GetWithdrawn()),
+		Status:    model.ReservationStatus(r.GetStatus()),
+		CreatedAt: fromTime(r.GetCreatedAt()),
+		UpdatedAt: fromNullTime(r.GetUpdatedAt()),
+	}
+}
+
+func toWallet(w model.Wallet) *Wallet {
+	return &Wallet{Id: w.ID, OwnerId: w.OwnerID, Balance: toMoney(w.Balance)}
+}
+
+func fromWallet(w *Wallet) model.Wallet {
+	return model.Wallet{ID: w.GetId(), OwnerID: w.GetOwnerId(), Balance: fromMoney(w.GetBalance())}
+}
+
+func toReply(m model.NATSMsg) *Reply {
+	return &Reply{Success: m.Success, Code: m.Code, Message: m.Message, Data: m.Data}
+}
+
+func fromReply(r *Reply) model.NATSMsg {
+	return model.NATSMsg{Success: r.GetSuccess(), Code: r.GetCode(), Message: r.GetMessage(), Data: r.GetData()}
+}
+
+func toWebhook(w model.Webhook) *Webhook {
+	return &Webhook{
+		Id:        w.ID,
+		ClientId:  w.ClientID,
+		Url:       w.URL,
+		Secret:    w.Secret,
+		Events:    w.Events,
+		CreatedAt: toTime(w.CreatedAt),
+		DeletedAt: toNullTime(w.DeletedAt),
+	}
+}
+
+func fromWebhook(w *Webhook) model.Webhook {
+	return model.Webhook{
+		ID:        w.GetId(),
+		ClientID:  w.GetClientId(),
+		URL:       w.GetUrl(),
+		Secret:    w.GetSecret(),
+		Events:    w.GetEvents(),
+		CreatedAt: fromTime(w.GetCreatedAt()),
+		DeletedAt: fromNullTime(w.GetDeletedAt()),
+	}
+}
+
+func toWebhookAccess(a model.WebhookAccess) *WebhookAccess {
+	return &WebhookAccess{Id: a.ID, ClientId: a.ClientID}
+}
+
+func fromWebhookAccess(a *WebhookAccess) model.WebhookAccess {
+	return model.WebhookAccess{ID: a.GetId(), ClientID: a.GetClientId()}
+}
+
+func toDelivery(d model.Delivery) *Delivery {
+	m := &Delivery{
+		Id:            d.ID,
+		WebhookId:     d.WebhookID,
+		EventId:       d.EventID,
+		EventType:     d.EventType,
+		Payload:       d.Payload,
+		Status:        string(d.Status),
+		Attempts:      int64(d.Attempts),
+		NextAttemptAt: toTime(d.NextAttemptAt),
+		LastError:     toNullString(d.LastError),
+		CreatedAt:     toTime(d.CreatedAt),
+		DeliveredAt:   toNullTime(d.DeliveredAt),
+	}
+	for _, a := range d.Log {
+		m.Log = append(m.Log, toDeliveryAttempt(a))
+	}
+	return m
+}
+
+func fromDelivery(d *Delivery) model.Delivery {
+	m := model.Delivery{
+		ID:            d.GetId(),
+		WebhookID:     d.GetWebhookId(),
+		EventID:       d.GetEventId(),
+		EventType:     d.GetEventType(),
+		Payload:       d.GetPayload(),
+		Status:        model.DeliveryStatus(d.GetStatus()),
+		Attempts:      int(d.GetAttempts()),
+		NextAttemptAt: fromTime(d.GetNextAttemptAt()),
+		LastError:     fromNullString(d.GetLastError()),
+		CreatedAt:     fromTime(d.GetCreatedAt()),
+		DeliveredAt:   fromNullTime(d.GetDeliveredAt()),
+	}
+	for _, a := range d.GetLog() {
+		m.Log = append(m.Log, fromDeliveryAttempt(a))
+	}
+	return m
+}
+
+func toDeliveryAttempt(a model.DeliveryAttempt) *DeliveryAttempt {
+	return &DeliveryAttempt{
+		Id:         a.ID,
+		DeliveryId: a.DeliveryID,
+		StatusCode: int64(a.StatusCode),
+		Error:      toNullString(a.Error),
+		Duration:   int64(a.Duration),
+		CreatedAt:  toTime(a.CreatedAt),
+	}
+}
+
+func fromDeliveryAttempt(a *DeliveryAttempt) model.DeliveryAttempt {
+	return model.DeliveryAttempt{
+		ID:         a.GetId(),
+		DeliveryID: a.GetDeliveryId(),
+		StatusCode: int(a.GetStatusCode()),
+		Error:      fromNullString(a.GetError()),
+		Duration:   time.Duration(a.GetDuration()),
+		CreatedAt:  fromTime(a.GetCreatedAt()),
+	}
+}
+
+func toMessage(m model.Message) *Message {
+	return &Message{
+		Id:        m.ID,
+		TaskId:    m.TaskID,
+		SenderId:  m.SenderID,
+		Body:      m.Body,
+		CreatedAt: toTime(m.CreatedAt),
+		ReadAt:    toNullTime(m.ReadAt),
+	}
+}
+
+func fromMessage(m *Message) model.Message {
+	return model.Message{
+		ID:        m.GetId(),
+		TaskID:    m.GetTaskId(),
+		SenderID:  m.GetSenderId(),
+		Body:      m.GetBody(),
+		CreatedAt: fromTime(m.GetCreatedAt()),
+		ReadAt:    fromNullTime(m.GetReadAt()),
+	}
+}
+
+func toThread(t model.Thread) *Thread {
+	m := &Thread{TaskId: t.TaskID, UserId: t.UserID, Unread: int64(t.Unread)}
+	for _, msg := range t.Messages {
+		m.Messages = append(m.Messages, toMessage(msg))
+	}
+	return m
+}
+
+func fromThread(t *Thread) model.Thread {
+	m := model.Thread{TaskID: t.GetTaskId(), UserID: t.GetUserId(), Unread: int(t.GetUnread())}
+	for _, msg := range t.GetMessages() {
+		m.Messages = append(m.Messages, fromMessage(msg))
+	}
+	return m
+}
+
+func toAttachment(a model.Attachment) *Attachment {
+	return &Attachment{
+		Id:          a.ID,
+		TaskId:      a.TaskID,
+		UploaderId:  a.UploaderID,
+		Kind:        string(a.Kind),
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Checksum:    a.Checksum,
+		CreatedAt:   toTime(a.CreatedAt),
+	}
+}
+
+func fromAttachment(a *Attachment) model.Attachment {
+	return model.Attachment{
+		ID:          a.GetId(),
+		TaskID:      a.GetTaskId(),
+		UploaderID:  a.GetUploaderId(),
+		Kind:        model.AttachmentKind(a.GetKind()),
+		Name:        a.GetName(),
+		ContentType: a.GetContentType(),
+		Size:        a.GetSize(),
+		Checksum:    a.GetChecksum(),
+		CreatedAt:   fromTime(a.GetCreatedAt()),
+	}
+}
+
+func toAttachmentAccess(a model.AttachmentAccess) *AttachmentAccess {
+	return &AttachmentAccess{Id: a.ID, TaskId: a.TaskID, UserId: a.UserID}
+}
+
+func fromAttachmentAccess(a *AttachmentAccess) model.AttachmentAccess {
+	return model.AttachmentAccess{ID: a.GetId(), TaskID: a.GetTaskId(), UserID: a.GetUserId()}
+}
+
+func toTaskQuery(q model.TaskQuery) *TaskQuery {
+	return &TaskQuery{
+		Text:        q.Text,
+		Tags:        q.Tags,
+		Category:    q.Category,
+		Skills:      q.Skills,
+		MinFee:      toMoney(q.MinFee),
+		MaxFee:      toMoney(q.MaxFee),
+		MaxDeadline: int64(q.MaxDeadline),
+		Limit:       int64(q.Limit),
+		Offset:      int64(q.Offset),
+	}
+}
+
+func fromTaskQuery(q *TaskQuery) model.TaskQuery {
+	return model.TaskQuery{
+		Text:        q.GetText(),
+		Tags:        q.GetTags(),
+		Category:    q.GetCategory(),
+		Skills:      q.GetSkills(),
+		MinFee:      fromMoney(q.GetMinFee()),
+		MaxFee:      fromMoney(q.GetMaxFee()),
+		MaxDeadline: time.Duration(q.GetMaxDeadline()),
+		Limit:       int(q.GetLimit()),
+		Offset:      int(q.GetOffset()),
+	}
+}
+
+func toTaskSearchResult(r model.TaskSearchResult) *TaskSearchResult {
+	m := &TaskSearchResult{Total: int64(r.Total)}
+	for _, t := range r.Tasks {
+		m.Tasks = append(m.Tasks, &TaskMatch{Task: toTask(t.Task), Rank: t.Rank, Snippet: t.Snippet})
+	}
+	return m
+}
+
+func fromTaskSearchResult(m *TaskSearchResult) model.TaskSearchResult {
+	r := model.TaskSearchResult{Total: int(m.GetTotal())}
+	for _, t := range m.GetTasks() {
+		r.Tasks = append(r.Tasks, model.TaskMatch{Task: fromTask(t.GetTask()), Rank: t.GetRank(), Snippet: t.GetSnippet()})
+	}
+	return r
+}
+
+func toRating(r model.Rating) *Rating {
+	return &Rating{
+		TaskId:       r.TaskID,
+		ClientId:     r.ClientID,
+		FreelancerId: r.FreelancerID,
+		Score:        int64(r.Score),
+		CreatedAt:    toTime(r.CreatedAt),
+	}
+}
+
+func fromRating(m *Rating) model.Rating {
+	return model.Rating{
+		TaskID:       m.GetTaskId(),
+		ClientID:     m.GetClientId(),
+		FreelancerID: m.GetFreelancerId(),
+		Score:        int(m.GetScore()),
+		CreatedAt:    fromTime(m.GetCreatedAt()),
+	}
+}
+
+func toMatchQuery(q model.MatchQuery) *MatchQuery {
+	return &MatchQuery{Id: q.ID, Limit: int64(q.Limit)}
+}
+
+func fromMatchQuery(q *MatchQuery) model.MatchQuery {
+	return model.MatchQuery{ID: q.GetId(), Limit: int(q.GetLimit())}
+}
+
+func toMatch(m model.Match) *Match {
+	return &Match{
+		TaskId:       m.TaskID,
+		FreelancerId: m.FreelancerID,
+		Score:        m.Score,
+		Skills:       m.Skills,
+		Rating:       m.Rating,
+		Completion:   m.Completion,
+		Availability: m.Availability,
+		Price:        m.Price,
+	}
+}
+
+func fromMatch(m *Match) model.Match {
+	return model.Match{
+		TaskID:       m.GetTaskId(),
+		FreelancerID: m.GetFreelancerId(),
+		Score:        m.GetScore(),
+		Skills:       m.GetSkills(),
+		Rating:       m.GetRating(),
+		Completion:   m.GetCompletion(),
+		Availability: m.GetAvailability(),
+		Price:        m.GetPrice(),
+	}
+}
+
+func toEmailPreferences(p model.EmailPreferences) *EmailPreferences {
+	return &EmailPreferences{UserId: p.UserID, OptOut: p.OptOut}
+}
+
+func fromEmailPreferences(m *EmailPreferences) model.EmailPreferences {
+	return model.EmailPreferences{UserID: m.GetUserId(), OptOut: m.GetOptOut()}
+}
+
+func toNotification(n model.Notification) *Notification {
+	return &Notification{
+		Id:        n.ID,
+		UserId:    n.UserID,
+		Kind:      string(n.Kind),
+		Ref:       n.Ref,
+		TaskId:    n.TaskID,
+		Title:     n.Title,
+		Text:      n.Text,
+		CreatedAt: toTime(n.CreatedAt),
+		ReadAt:    toNullTime(n.ReadAt),
+	}
+}
+
+func fromNotification(m *Notification) model.Notification {
+	return model.Notification{
+		ID:        m.GetId(),
+		UserID:    m.GetUserId(),
+		Kind:      model.NotificationKind(m.GetKind()),
+		Ref:       m.GetRef(),
+		TaskID:    m.GetTaskId(),
+		Title:     m.GetTitle(),
+		Text:      m.GetText(),
+		CreatedAt: fromTime(m.GetCreatedAt()),
+		ReadAt:    fromNullTime(m.GetReadAt()),
+	}
+}
+
+func toNotificationQuery(q model.NotificationQuery) *NotificationQuery {
+	return &NotificationQuery{UserId: q.UserID, Unread: q.Unread, Limit: int64(q.Limit), Offset: int64(q.Offset)}
+}
+
+func fromNotificationQuery(m *NotificationQuery) model.NotificationQuery {
+	return model.NotificationQuery{UserID: m.GetUserId(), Unread: m.GetUnread(), Limit: int(m.GetLimit()), Offset: int(m.GetOffset())}
+}
+
+func toNotificationPage(p model.NotificationPage) *NotificationPage {
+	return &NotificationPage{Total: int64(p.Total), Unread: int64(p.Unread), Notifications: mapList(p.Notifications, toNotification)}
+}
+
+func fromNotificationPage(m *NotificationPage) model.NotificationPage {
+	return model.NotificationPage{Total: int(m.GetTotal()), Unread: int(m.GetUnread()), Notifications: mapList(m.GetNotifications(), fromNotification)}
+}
+
+func toNotificationRead(r model.NotificationRead) *NotificationRead {
+	return &NotificationRead{UserId: r.UserID, Ids: r.IDs, All: r.All}
+}
+
+func fromNotificationRead(m *NotificationRead) model.NotificationRead {
+	return model.NotificationRead{UserID: m.GetUserId(), IDs: m.GetIds(), All: m.GetAll()}
+}
+
+func toAdjustment(a model.Adjustment) *Adjustment {
+	return &Adjustment{
+		Id:        a.ID,
+		Owner:     a.Owner,
+		OwnerId:   a.OwnerID,
+		Amount:    toMoney(a.Amount),
+		Reason:    a.Reason,
+		Actor:     a.Actor,
+		CreatedAt: toTime(a.CreatedAt),
+	}
+}
+
+func fromAdjustment(m *Adjustment) model.Adjustment {
+	return model.Adjustment{
+		ID:        m.GetId(),
+		Owner:     m.GetOwner(),
+		OwnerID:   m.GetOwnerId(),
+		Amount:    fromMoney(m.GetAmount()),
+		Reason:    m.GetReason(),
+		Actor:     m.GetActor(),
+		CreatedAt: fromTime(m.GetCreatedAt()),
+	}
+}
+
+func toTaskAction(a model.TaskAction) *TaskAction {
+	return &TaskAction{TaskId: a.TaskID, Reason: a.Reason, Actor: a.Actor}
+}
+
+func fromTaskAction(m *TaskAction) model.TaskAction {
+	return model.TaskAction{TaskID: m.GetTaskId(), Reason: m.GetReason(), Actor: m.GetActor()}
+}
+
+func toAuditEntry(e model.AuditEntry) *AuditEntry {
+	return &AuditEntry{
+		Id:        e.ID,
+		Actor:     e.Actor,
+		Action:    string(e.Action),
+		Entity:    e.Entity,
+		EntityId:  e.EntityID,
+		Before:    e.Before,
+		After:     e.After,
+		CreatedAt: toTime(e.CreatedAt),
+	}
+}
+
+func fromAuditEntry(m *AuditEntry) model.AuditEntry {
+	return model.AuditEntry{
+		ID:        m.GetId(),
+		Actor:     m.GetActor(),
+		Action:    model.AuditAction(m.GetAction()),
+		Entity:    m.GetEntity(),
+		EntityID:  m.GetEntityId(),
+		Before:    m.GetBefore(),
+		After:     m.GetAfter(),
+		CreatedAt: fromTime(m.GetCreatedAt()),
+	}
+}
+
+func toAuditQuery(q model.AuditQuery) *AuditQuery {
+	return &AuditQuery{Entity: q.Entity, EntityId: q.EntityID, Actor: q.Actor, Limit: int64(q.Limit), Offset: int64(q.Offset)}
+}
+
+func fromAuditQuery(m *AuditQuery) model.AuditQuery {
+	return model.AuditQuery{Entity: m.GetEntity(), EntityID: m.GetEntityId(), Actor: m.GetActor(), Limit: int(m.GetLimit()), Offset: int(m.GetOffset())}
+}
@@ -0,0 +1,217 @@
+// Package pb provides protobuf encoding of the messages exchanged over NATS.
+//
+// Messages are defined in md.proto, md.pb.go is generated from it.
+// Codec converts model types to the generated messages and back,
+// it is registered as rpc Codec and as NATS encoder named EncoderName
+//
+//go:generate protoc --go_out=. --go_opt=paths=source_relative md.proto
+package pb
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// EncoderName represents name of the protobuf encoder registered in NATS
+const EncoderName = "protobuf"
+
+// Codec encodes model types as protobuf messages, generated messages are encoded as is
+type Codec struct{}
+
+// ContentType returns content type of protobuf messages
+func (Codec) ContentType() string { return "application/protobuf" }
+
+// Marshal encodes v as protobuf message
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("pb: nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	c, ok := converters[rv.Type()]
+	if !ok {
+		return nil, fmt.Errorf("pb: unsupported type %T", v)
+	}
+	return proto.Marshal(c.to(rv.Interface()))
+}
+
+// Unmarshal decodes protobuf message into v, v must be a pointer
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("pb: non-pointer %T", v)
+	}
+	c, ok := converters[rv.Type().Elem()]
+	if !ok {
+		return fmt.Errorf("pb: unsupported type %T", v)
+	}
+	m := c.newMsg()
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	c.from(m, v)
+	return nil
+}
+
+// Encode implements nats.Encoder
+func (c Codec) Encode(subject string, v interface{}) ([]byte, error) {
+	return c.Marshal(v)
+}
+
+// Decode implements nats.Encoder
+func (c Codec) Decode(subject string, data []byte, vPtr interface{}) error {
+	return c.Unmarshal(data, vPtr)
+}
+
+// Supports reports whether values of v's type can be encoded
+func Supports(v interface{}) bool {
+	if _, ok := v.(proto.Message); ok {
+		return true
+	}
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	_, ok := converters[t]
+	return ok
+}
+
+// converter converts Go value to protobuf message and back
+type converter struct {
+	newMsg func() proto.Message
+	to     func(v interface{}) proto.Message
+	from   func(m proto.Message, v interface{})
+}
+
+var converters = map[reflect.Type]converter{}
+
+func register[T any, M proto.Message](newMsg func() M, to func(T) M, from func(M) T) {
+	converters[reflect.TypeOf((*T)(nil)).Elem()] = converter{
+		newMsg: func() proto.Message { return newMsg() },
+		to:     func(v interface{}) proto.Message { return to(v.(T)) },
+		from:   func(m proto.Message, v interface{}) { *(v.(*T)) = from(m.(M)) },
+	}
+}
+
+// mapList converts list items, decoded lists are never nil
+func mapList[T, M any](items []T, f func(T) M) []M {
+	out := make([]M, 0, len(items))
+	for _, item := range items {
+		out = append(out, f(item))
+	}
+	return out
+}
+
+func init() {
+	register(func() *emptypb.Empty { return &emptypb.Empty{} },
+		func(rpc.Empty) *emptypb.Empty { return &emptypb.Empty{} },
+		func(*emptypb.Empty) rpc.Empty { return rpc.Empty{} })
+	register(func() *wrapperspb.StringValue { return &wrapperspb.StringValue{} },
+		wrapperspb.String,
+		func(m *wrapperspb.StringValue) string { return m.GetValue() })
+	register(func() *timestamppb.Timestamp { return &timestamppb.Timestamp{} },
+		timestamppb.New,
+		func(m *timestamppb.Timestamp) time.Time { return m.AsTime() })
+	register(func() *Reply { return &Reply{} }, toReply, fromReply)
+
+	register(func() *Task { return &Task{} }, toTask, fromTask)
+	register(func() *TaskList { return &TaskList{} },
+		func(l []model.Task) *TaskList { return &TaskList{Items: mapList(l, toTask)} },
+		func(m *TaskList) []model.Task { return mapList(m.GetItems(), fromTask) })
+	register(func() *Freelancer { return &Freelancer{} }, toFreelancer, fromFreelancer)
+	register(func() *FreelancerList { return &FreelancerList{} },
+		func(l []model.Freelancer) *FreelancerList { return &FreelancerList{Items: mapList(l, toFreelancer)} },
+		func(m *FreelancerList) []model.Freelancer { return mapList(m.GetItems(), fromFreelancer) })
+	register(func() *FreelancerQuery { return &FreelancerQuery{} }, toFreelancerQuery, fromFreelancerQuery)
+	register(func() *Category { return &Category{} }, toCategory, fromCategory)
+	register(func() *CategoryList { return &CategoryList{} },
+		func(l []model.Category) *CategoryList { return &CategoryList{Items: mapList(l, toCategory)} },
+		func(m *CategoryList) []model.Category { return mapList(m.GetItems(), fromCategory) })
+	register(func() *Skill { return &Skill{} }, toSkill, fromSkill)
+	register(func() *SkillList { return &SkillList{} },
+		func(l []model.Skill) *SkillList { return &SkillList{Items: mapList(l, toSkill)} },
+		func(m *SkillList) []model.Skill { return mapList(m.GetItems(), fromSkill) })
+	register(func() *Client { return &Client{} }, toClient, fromClient)
+	register(func() *ClientList { return &ClientList{} },
+		func(l []model.Client) *ClientList { return &ClientList{Items: mapList(l, toClient)} },
+		func(m *ClientList) []model.Client { return mapList(m.GetItems(), fromClient) })
+	register(func() *Payment { return &Payment{} }, toPayment, fromPayment)
+	register(func() *Charge { return &Charge{} }, toCharge, fromCharge)
+	register(func() *Invoice { return &Invoice{} }, toInvoice, fromInvoice)
+	register(func() *InvoiceList { return &InvoiceList{} },
+		func(l []model.Invoice) *InvoiceList { return &InvoiceList{Items: mapList(l, toInvoice)} },
+		func(m *InvoiceList) []model.Invoice { return mapList(m.GetItems(), fromInvoice) })
+	register(func() *Dispute { return &Dispute{} }, toDispute, fromDispute)
+	register(func() *DisputeList { return &DisputeList{} },
+		func(l []model.Dispute) *DisputeList { return &DisputeList{Items: mapList(l, toDispute)} },
+		func(m *DisputeList) []model.Dispute { return mapList(m.GetItems(), fromDispute) })
+	register(func() *DisputeStatement { return &DisputeStatement{} }, toDisputeStatement, fromDisputeStatement)
+	register(func() *TimeEntry { return &TimeEntry{} }, toTimeEntry, fromTimeEntry)
+	register(func() *Timesheet { return &Timesheet{} }, toTimesheet, fromTimesheet)
+	register(func() *Reservation { return &Reservation{} }, toReservation, fromReservation)
+	register(func() *Wallet { return &Wallet{} }, toWallet, fromWallet)
+	register(func() *WalletList { return &WalletList{} },
+		func(l []model.Wallet) *WalletList { return &WalletList{Items: mapList(l, toWallet)} },
+		func(m *WalletList) []model.Wallet { return mapList(m.GetItems(), fromWallet) })
+	register(func() *Webhook { return &Webhook{} }, toWebhook, fromWebhook)
+	register(func() *WebhookList { return &WebhookList{} },
+		func(l []model.Webhook) *WebhookList { return &WebhookList{Items: mapList(l, toWebhook)} },
+		func(m *WebhookList) []model.Webhook { return mapList(m.GetItems(), fromWebhook) })
+	register(func() *WebhookAccess { return &WebhookAccess{} }, toWebhookAccess, fromWebhookAccess)
+	register(func() *Delivery { return &Delivery{} }, toDelivery, fromDelivery)
+	register(func() *DeliveryList { return &DeliveryList{} },
+		func(l []model.Delivery) *DeliveryList { return &DeliveryList{Items: mapList(l, toDelivery)} },
+		func(m *DeliveryList) []model.Delivery { return mapList(m.GetItems(), fromDelivery) })
+	register(func() *Message { return &Message{} }, toMessage, fromMessage)
+	register(func() *Thread { return &Thread{} }, toThread, fromThread)
+	register(func() *ThreadList { return &ThreadList{} },
+		func(l []model.Thread) *ThreadList { return &ThreadList{Items: mapList(l, toThread)} },
+		func(m *ThreadList) []model.Thread { return mapList(m.GetItems(), fromThread) })
+	register(func() *Attachment { return &Attachment{} }, toAttachment, fromAttachment)
+	register(func() *AttachmentList { return &AttachmentList{} },
+		func(l []model.Attachment) *AttachmentList { return &AttachmentList{Items: mapList(l, toAttachment)} },
+		func(m *AttachmentList) []model.Attachment { return mapList(m.GetItems(), fromAttachment) })
+	register(func() *AttachmentAccess { return &AttachmentAccess{} }, toAttachmentAccess, fromAttachmentAccess)
+	register(func() *TaskQuery { return &TaskQuery{} }, toTaskQuery, fromTaskQuery)
+	register(func() *TaskSearchResult { return &TaskSearchResult{} }, toTaskSearchResult, fromTaskSearchResult)
+	register(func() *Rating { return &Rating{} }, toRating, fromRating)
+	register(func() *MatchQuery { return &MatchQuery{} }, toMatchQuery, fromMatchQuery)
+	register(func() *MatchList { return &MatchList{} },
+		func(l []model.Match) *MatchList { return &MatchList{Items: mapList(l, toMatch)} },
+		func(m *MatchList) []model.Match { return mapList(m.GetItems(), fromMatch) })
+	register(func() *EmailPreferences { return &EmailPreferences{} }, toEmailPreferences, fromEmailPreferences)
+	register(func() *Notification { return &Notification{} }, toNotification, fromNotification)
+	register(func() *NotificationQuery { return &NotificationQuery{} }, toNotificationQuery, fromNotificationQuery)
+	register(func() *NotificationPage { return &NotificationPage{} }, toNotificationPage, fromNotificationPage)
+	register(func() *NotificationRead { return &NotificationRead{} }, toNotificationRead, fromNotificationRead)
+	register(func() *Adjustment { return &Adjustment{} }, toAdjustment, fromAdjustment)
+	register(func() *AdjustmentList { return &AdjustmentList{} },
+		func(l []model.Adjustment) *AdjustmentList { return &AdjustmentList{Items: mapList(l, toAdjustment)} },
+		func(m *AdjustmentList) []model.Adjustment { return mapList(m.GetItems(), fromAdjustment) })
+	register(func() *TaskAction { return &TaskAction{} }, toTaskAction, fromTaskAction)
+	register(func() *AuditEntry { return &AuditEntry{} }, toAuditEntry, fromAuditEntry)
+	register(func() *AuditEntryList { return &AuditEntryList{} },
+		func(l []model.AuditEntry) *AuditEntryList { return &AuditEntryList{Items: mapList(l, toAuditEntry)} },
+		func(m *AuditEntryList) []model.AuditEntry { return mapList(m.GetItems(), fromAuditEntry) })
+	register(func() *AuditQuery { return &AuditQuery{} }, toAuditQuery, fromAuditQuery)
+
+	rpc.RegisterCodec(Codec{})
+	nats.RegisterEncoder(EncoderName, Codec{})
+}
@@ -0,0 +1,329 @@
+package pb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var (
+	now     = time.Date(2018, 10, 1, 12, 30, 15, 500, time.UTC)
+	nowNull = pq.NullTime{Time: now, Valid: true}
+	usd     = model.NewMoney(133227, model.USD)
+)
+
+func task() model.Task {
+	return model.Task{
+		ID: model.NewID(), ClientID: model.NewID(), FreelancerID: model.NewID(), Description: "golang app",
+		Fee: usd, Deadline: time.Hour * 48, Status: model.Started, StartedAt: nowNull, UpdatedAt: nowNull, CreatedAt: now,
+		CompletedAt: nowNull, ReviewDeadline: nowNull, RemindedAt: nowNull,
+		Contract: model.HourlyContract, HourlyRate: model.NewMoney(5000, model.EUR), WeeklyCap: time.Hour * 40,
+		Tags: pq.StringArray{"go", "postgres"}, Category: "development", Skills: pq.StringArray{"go", "postgresql"},
+	}
+}
+
+func entry() model.TimeEntry {
+	return model.TimeEntry{
+		ID: model.NewID(), TaskID: model.NewID(), FreelancerID: model.NewID(), Date: now, Duration: time.Minute * 90,
+		Note: "api", Status: model.EntryBilled, PaymentID: sql.NullString{String: model.NewID(), Valid: true}, CreatedAt: now,
+	}
+}
+
+func dispute() model.Dispute {
+	return model.Dispute{
+		ID: model.NewID(), TaskID: model.NewID(), OpenedBy: model.NewID(), Reason: "not done", Status: model.DisputeResolved,
+		TaskStatus: model.Completed, FreelancerAmount: usd, ClientAmount: model.NewMoney(1, model.USD),
+		Resolution: sql.NullString{String: "split", Valid: true}, ResolvedBy: sql.NullString{String: model.NewID(), Valid: true},
+		CreatedAt: now, ResolvedAt: nowNull,
+		Statements: []model.DisputeStatement{{ID: model.NewID(), DisputeID: model.NewID(), AuthorID: model.NewID(), Statement: "done",
+			Evidence: pq.StringArray{"https://example.com/1"}, CreatedAt: now}},
+	}
+}
+
+// messages returns sample of every type sent over NATS
+func messages() []interface{} {
+	client := model.Client{ID: model.NewID(), Email: "client@email.com", Balance: usd, DeletedAt: nowNull}
+	freelancer := model.Freelancer{ID: model.NewID(), Description: sql.NullString{String: "dev", Valid: true}, Email: "freelancer@email.com", Balance: usd,
+		Skills: []model.FreelancerSkill{{SkillID: "go", Level: model.Expert}, {SkillID: "sql", Level: model.Beginner}}}
+	skill := model.Skill{ID: "go", Name: "Go", CategoryID: "development"}
+	invoice := model.Invoice{ID: model.NewID(), Number: 42, TaskID: model.NewID(), PaymentID: model.NewID(), ClientID: model.NewID(),
+		ClientEmail: "client@email.com", FreelancerID: model.NewID(), FreelancerEmail: "freelancer@email.com",
+		Description: "golang app", Amount: usd, PaidDate: now, IssuedAt: now}
+	wallet := model.Wallet{ID: model.NewID(), OwnerID: model.NewID(), Balance: model.NewMoney(100, model.EUR)}
+	webhook := model.Webhook{ID: model.NewID(), ClientID: model.NewID(), URL: "https://example.com/hook", Secret: "whsec_1",
+		Events: pq.StringArray{"task.*", "payment.paid"}, CreatedAt: now, DeletedAt: nowNull}
+	delivery := model.Delivery{ID: model.NewID(), WebhookID: model.NewID(), EventID: model.NewID(), EventType: "task.created",
+		Payload: json.RawMessage(`{"id":"1"}`), Status: model.DeliveryDelivered, Attempts: 2, NextAttemptAt: now,
+		LastError: sql.NullString{String: "unexpected status 500", Valid: true}, CreatedAt: now, DeliveredAt: nowNull,
+		Log: []model.DeliveryAttempt{{ID: model.NewID(), DeliveryID: model.NewID(), StatusCode: 500,
+			Error: sql.NullString{String: "unexpected status 500", Valid: true}, Duration: time.Millisecond * 15, CreatedAt: now}}}
+	msg := model.Message{ID: model.NewID(), TaskID: model.NewID(), SenderID: model.NewID(), Body: "hi", CreatedAt: now, ReadAt: nowNull}
+	notification := model.Notification{ID: model.NewID(), UserID: model.NewID(), Kind: model.TaskClosed, TaskID: model.NewID(), Title: "Task is closed",
+		Text: "Task \"Build REST API\" is closed", CreatedAt: now, ReadAt: nowNull}
+	adjustment := model.Adjustment{ID: model.NewID(), Owner: "client", OwnerID: model.NewID(), Amount: model.NewMoney(-2500, model.EUR),
+		Reason: "chargeback", Actor: "ops", CreatedAt: now}
+	attachment := model.Attachment{ID: model.NewID(), TaskID: model.NewID(), UploaderID: model.NewID(), Kind: model.Deliverable,
+		Name: "app.zip", ContentType: "application/zip", Size: 1024, Checksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", CreatedAt: now}
+	auditEntry := model.AuditEntry{ID: model.NewID(), Actor: "ops", Action: model.AuditUpdate, Entity: "task", EntityID: model.NewID(),
+		Before: json.RawMessage(`{"status":"open"}`), After: json.RawMessage(`{"status":"closed"}`), CreatedAt: now}
+	return []interface{}{
+		rpc.Empty{},
+		"d6f1b8a0-4f5e-4a43-9d0e-3c1c5a1f4e21",
+		now,
+		model.NATSMsg{Success: false, Code: "not_found", Message: "sql: no rows in result set", Data: json.RawMessage(`{"id":"1"}`)},
+		task(),
+		[]model.Task{task(), task()},
+		client,
+		[]model.Client{client},
+		freelancer,
+		[]model.Freelancer{freelancer},
+		model.FreelancerQuery{Skills: []string{"go", "sql"}, MinLevel: model.Intermediate, Category: "development", Limit: 20, Offset: 40},
+		model.Category{ID: "development", Name: "Development"},
+		[]model.Category{{ID: "development", Name: "Development"}, {ID: "design", Name: "Design"}},
+		skill,
+		[]model.Skill{skill},
+		model.Payment{ID: model.NewID(), ClientID: model.NewID(), FreelancerID: model.NewID(), TaskID: model.NewID(), Amount: usd,
+			PaidDate: now, Status: model.Paid, Reference: sql.NullString{String: "2018-W40", Valid: true}},
+		model.Charge{TaskID: model.NewID(), Amount: usd, Reference: "2018-W40"},
+		invoice,
+		[]model.Invoice{invoice},
+		dispute(),
+		[]model.Dispute{dispute()},
+		dispute().Statements[0],
+		entry(),
+		model.Timesheet{TaskID: model.NewID(), ClientID: model.NewID(), Week: now, Total: time.Minute * 180, Entries: []model.TimeEntry{entry(), entry()}},
+		model.Reservation{ID: model.NewID(), ClientID: model.NewID(), TaskID: model.NewID(), Amount: usd,
+			Withdrawn: model.NewMoney(120000, model.EUR), Status: model.Confirmed, CreatedAt: now, UpdatedAt: nowNull},
+		wallet,
+		[]model.Wallet{wallet},
+		webhook,
+		[]model.Webhook{webhook},
+		model.WebhookAccess{ID: model.NewID(), ClientID: model.NewID()},
+		delivery,
+		[]model.Delivery{delivery},
+		msg,
+		model.Thread{TaskID: model.NewID(), UserID: model.NewID(), Unread: 1, Messages: []model.Message{msg, msg}},
+		[]model.Thread{{TaskID: model.NewID(), UserID: model.NewID(), Unread: 3}},
+		attachment,
+		[]model.Attachment{attachment},
+		model.AttachmentAccess{ID: model.NewID(), TaskID: model.NewID(), UserID: model.NewID()},
+		model.TaskQuery{Text: "golang -php", Tags: []string{"go"}, Category: "development", Skills: []string{"go"}, MinFee: model.NewMoney(1000, model.EUR), MaxFee: model.NewMoney(5000, model.EUR),
+			MaxDeadline: time.Hour * 72, Limit: 20, Offset: 40},
+		model.TaskSearchResult{Total: 41, Tasks: []model.TaskMatch{{Task: task(), Rank: 0.0607927, Snippet: "<mark>golang</mark> app"}}},
+		model.Rating{TaskID: model.NewID(), ClientID: model.NewID(), FreelancerID: model.NewID(), Score: 5, CreatedAt: now},
+		model.MatchQuery{ID: model.NewID(), Limit: 20},
+		[]model.Match{{TaskID: model.NewID(), FreelancerID: model.NewID(), Score: 0.8375, Skills: 1, Rating: 0.75, Completion: 0.75, Availability: 0.5, Price: 1}},
+		model.EmailPreferences{UserID: model.NewID(), OptOut: pq.StringArray{"task_closed", "payment_received"}},
+		notification,
+		model.NotificationQuery{UserID: model.NewID(), Unread: true, Limit: 20, Offset: 40},
+		model.NotificationPage{Total: 41, Unread: 3, Notifications: []model.Notification{notification}},
+		model.NotificationRead{UserID: model.NewID(), IDs: []string{model.NewID(), model.NewID()}},
+		adjustment,
+		[]model.Adjustment{adjustment},
+		model.TaskAction{TaskID: model.NewID(), Reason: "duplicate task", Actor: "ops"},
+		auditEntry,
+		[]model.AuditEntry{auditEntry},
+		model.AuditQuery{Entity: "task", EntityID: model.NewID(), Actor: "ops", Limit: 20, Offset: 40},
+		[]model.Client{},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range []rpc.Codec{rpc.JSON, Codec{}} {
+		for _, want := range messages() {
+			t.Run(c.ContentType()+"/"+reflect.TypeOf(want).String(), func(t *testing.T) {
+				d, err := c.Marshal(want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := reflect.New(reflect.TypeOf(want))
+				if err := c.Unmarshal(d, got.Interface()); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.Elem().Interface(), want) {
+					t.Errorf("expected=%+v got=%+v", want, got.Elem().Interface())
+				}
+			})
+		}
+	}
+}
+
+// TestEncodings checks that JSON and protobuf decode to the same value
+func TestEncodings(t *testing.T) {
+	for _, v := range messages() {
+		var decoded []interface{}
+		for _, c := range []rpc.Codec{rpc.JSON, Codec{}} {
+			d, err := c.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.New(reflect.TypeOf(v))
+			if err := c.Unmarshal(d, got.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, got.Elem().Interface())
+		}
+		if !reflect.DeepEqual(decoded[0], decoded[1]) {
+			t.Errorf("%T: json=%+v protobuf=%+v", v, decoded[0], decoded[1])
+		}
+	}
+}
+
+func supported[Req, Resp any](t *testing.T, e rpc.Endpoint[Req, Resp]) {
+	var req Req
+	var resp Resp
+	if !Supports(req) || !Supports(resp) {
+		t.Errorf("%s: %T -> %T is not supported", e.Subject, req, resp)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	supported(t, api.ClientAdd)
+	supported(t, api.ClientGet)
+	supported(t, api.ClientUpdate)
+	supported(t, api.ClientList)
+	supported(t, api.ClientDelete)
+	supported(t, api.ClientReserve)
+	supported(t, api.ClientConfirm)
+	supported(t, api.ClientRelease)
+	supported(t, api.FreelancerAdd)
+	supported(t, api.FreelancerGet)
+	supported(t, api.FreelancerUpdate)
+	supported(t, api.FreelancerList)
+	supported(t, api.FreelancerDelete)
+	supported(t, api.FreelancerSkills)
+	supported(t, api.FreelancerSearch)
+	supported(t, api.CategoryList)
+	supported(t, api.CategoryAdd)
+	supported(t, api.SkillList)
+	supported(t, api.SkillAdd)
+	supported(t, api.TaskAdd)
+	supported(t, api.TaskGet)
+	supported(t, api.TaskUpdate)
+	supported(t, api.TaskList)
+	supported(t, api.TaskDelete)
+	supported(t, api.TaskCharge)
+	supported(t, api.InvoiceGet)
+	supported(t, api.InvoiceList)
+	supported(t, api.DisputeOpen)
+	supported(t, api.DisputeGet)
+	supported(t, api.DisputeList)
+	supported(t, api.DisputeStatement)
+	supported(t, api.DisputeResolve)
+	supported(t, api.WalletList)
+	supported(t, api.TimesheetLog)
+	supported(t, api.TimesheetGet)
+	supported(t, api.TimesheetApprove)
+	supported(t, api.TimesheetReject)
+	supported(t, api.TimesheetBill)
+	supported(t, api.WebhookAdd)
+	supported(t, api.WebhookList)
+	supported(t, api.WebhookDelete)
+	supported(t, api.WebhookDeliveries)
+	supported(t, api.WebhookReplay)
+	supported(t, api.MessageSend)
+	supported(t, api.MessageList)
+	supported(t, api.MessageRead)
+	supported(t, api.MessageUnread)
+	supported(t, api.AttachmentAuthorize)
+	supported(t, api.AttachmentAdd)
+	supported(t, api.AttachmentList)
+	supported(t, api.AttachmentGet)
+	supported(t, api.TaskSearch)
+	supported(t, api.MatchFreelancers)
+	supported(t, api.MatchTasks)
+	supported(t, api.RatingAdd)
+	supported(t, api.EmailPreferencesGet)
+	supported(t, api.EmailPreferencesSet)
+	supported(t, api.NotificationList)
+	supported(t, api.NotificationRead)
+	supported(t, api.WalletAdjust)
+	supported(t, api.WalletAdjustments)
+	supported(t, api.TaskForceClose)
+	supported(t, api.TaskRefund)
+	supported(t, api.AuditList)
+}
+
+func setUp(t *testing.T) (*nats.Conn, func()) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	srv := natstest.RunServer(&opts)
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		srv.Shutdown()
+	}
+}
+
+func TestCall(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	if err := rpc.Register(rpc.NewServer(conn, rpc.Defaults()...), api.TaskGet, func(_ context.Context, id string) (model.Task, error) {
+		if id == "" {
+			return model.Task{}, sql.ErrNoRows
+		}
+		tsk := task()
+		tsk.ID = id
+		return tsk, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer rpc.SetCodec(rpc.JSON)
+	// servers reply with encoding of the request, so callers may switch independently
+	for _, c := range []rpc.Codec{Codec{}, rpc.JSON} {
+		rpc.SetCodec(c)
+		id := model.NewID()
+		got, err := rpc.Call(context.Background(), conn, api.TaskGet, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != id || got.Fee != usd || !got.CreatedAt.Equal(now) {
+			t.Errorf("%s: unexpected task %+v", c.ContentType(), got)
+		}
+		if _, err := rpc.Call(context.Background(), conn, api.TaskGet, ""); rpc.CodeOf(err) != rpc.CodeNotFound {
+			t.Errorf("%s: expected=%s got=%v", c.ContentType(), rpc.CodeNotFound, err)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	encConn, err := nats.NewEncodedConn(conn, EncoderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *model.Task, 1)
+	if _, err := encConn.Subscribe("task.review.reminder", func(t *model.Task) {
+		received <- t
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := task()
+	if err := encConn.Publish("task.review.reminder", &want); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("expected=%+v got=%+v", want, *got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("message was not received")
+	}
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// contentTypeHeader carries content type of the request and the reply
+const contentTypeHeader = "Content-Type"
+
+// Codec encodes requests and replies. Caller chooses the Codec and sends its
+// content type with the request, the server replies using the same Codec
+type Codec interface {
+	// ContentType returns value of Content-Type header of encoded messages
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSON represents default Codec encoding messages as JSON
+var JSON Codec = jsonCodec{}
+
+var (
+	mu     sync.RWMutex
+	codecs = map[string]Codec{JSON.ContentType(): JSON}
+	// codec represents Codec used by Call
+	codec = JSON
+)
+
+// RegisterCodec makes the Codec available to servers, so they accept requests encoded with it
+func RegisterCodec(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// SetCodec registers the Codec and makes Call encode requests with it
+func SetCodec(c Codec) {
+	RegisterCodec(c)
+	mu.Lock()
+	defer mu.Unlock()
+	codec = c
+}
+
+// defaultCodec returns Codec used by Call
+func defaultCodec() Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+	return codec
+}
+
+// codecFor returns Codec of the message by its Content-Type header,
+// messages without the header are JSON
+func codecFor(h nats.Header) (Codec, bool) {
+	ct := h.Get(contentTypeHeader)
+	if ct == "" {
+		return JSON, true
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[ct]
+	return c, ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal accepts string requests unquoted as well
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	if s, ok := v.(*string); ok && !strings.HasPrefix(string(data), `"`) {
+		*s = string(data)
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
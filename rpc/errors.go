@@ -0,0 +1,44 @@
+package rpc
+
+import "fmt"
+
+// Code represents category of the error returned by the handler
+type Code string
+
+const (
+	// CodeUnknown represents error without specific category
+	CodeUnknown Code = "unknown"
+	// CodeInvalid represents malformed or invalid request
+	CodeInvalid Code = "invalid_argument"
+	// CodeNotFound represents request for the entity that does not exist
+	CodeNotFound Code = "not_found"
+	// CodeInternal represents failure of the handler, e.g. panic
+	CodeInternal Code = "internal"
+	// CodeTimeout represents request that was not answered in time
+	CodeTimeout Code = "timeout"
+	// CodePermission represents request the caller is not allowed to make
+	CodePermission Code = "permission_denied"
+)
+
+// Error represents error returned by the remote handler
+type Error struct {
+	Code    Code
+	Message string
+}
+
+// Errorf returns new Error with the code and formatted message
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeOf returns Code of the err, CodeUnknown if err is not *Error
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	return toError(err).Code
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metrics holds request counters per subject, published on /debug/vars
+var metrics = expvar.NewMap("rpc")
+
+// Defaults returns middlewares every service uses: logging, metrics and panic recovery
+func Defaults() []Middleware {
+	return []Middleware{Logging(), Metrics(), Recovery()}
+}
+
+// Logging logs every request with its duration at debug level,
+// failed requests are always logged by the Server
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+			logrus.WithField("subject", req.Subject).
+				WithField("success", err == nil).
+				WithField("duration", time.Since(start)).
+				Debug("request handled")
+			return resp, err
+		}
+	}
+}
+
+// Metrics counts requests and errors per subject
+func Metrics() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			resp, err := next(ctx, req)
+			metrics.Add(req.Subject+".requests", 1)
+			if err != nil {
+				metrics.Add(req.Subject+".errors", 1)
+			}
+			return resp, err
+		}
+	}
+}
+
+// Recovery converts panic of the handler to CodeInternal error so outer middlewares
+// see it as failed request. Server recovers panics without it as well
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			return call(ctx, next, req)
+		}
+	}
+}
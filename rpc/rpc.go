@@ -0,0 +1,302 @@
+// Package rpc provides typed request/reply over NATS.
+//
+// Request/response pair is defined once as Endpoint, services register typed
+// Handlers on a Server and callers use Call with the same Endpoint.
+// Responses are encoded as model.NATSMsg so untyped callers keep working.
+// Messages are JSON unless the caller chooses another Codec, requests and responses
+// carry schema version of the payload and previous version is upgraded on receipt
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/schema"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout represents timeout of the Call when context has no deadline
+const DefaultTimeout = time.Second * 10
+
+const (
+	// deadlineHeader carries caller's deadline in unix nanoseconds
+	deadlineHeader = "Rpc-Deadline"
+	// versionHeader carries schema version of the payload
+	versionHeader = "Schema-Version"
+	// actorHeader carries user or operator the request is made on behalf of
+	actorHeader = "Rpc-Actor"
+)
+
+// MaxActor represents maximum length of the actor, it is recorded in the audit log
+const MaxActor = 128
+
+// ErrInvalidActor represents error returned for request made on behalf of actor longer than MaxActor
+var ErrInvalidActor = Errorf(CodeInvalid, "actor is longer than %d characters", MaxActor)
+
+// actorKey represents context key of the actor
+type actorKey struct{}
+
+// WithActor returns context carrying the user or operator requests are made on behalf of.
+// Call sends the actor along with the request and the handler's context carries it,
+// so the actor is passed on by requests the handler makes with its context
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorOf returns actor carried by the context, empty if it has none
+func ActorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// Empty represents empty request or response
+type Empty struct{}
+
+// Endpoint represents request/response pair served on the subject
+type Endpoint[Req, Resp any] struct {
+	Subject string // Subject represents NATS subject of the Endpoint
+	Queue   string // Queue represents queue group the handlers subscribe with
+}
+
+// NewEndpoint returns new Endpoint served on the subject by the queue group
+func NewEndpoint[Req, Resp any](subject, queue string) Endpoint[Req, Resp] {
+	return Endpoint[Req, Resp]{Subject: subject, Queue: queue}
+}
+
+// Handler handles typed request
+type Handler[Req, Resp any] func(ctx context.Context, req Req) (Resp, error)
+
+// Request represents raw request passed through middlewares
+type Request struct {
+	Subject string
+	Data    []byte
+	Header  nats.Header
+}
+
+// HandlerFunc represents untyped handler middlewares wrap
+type HandlerFunc func(ctx context.Context, req *Request) (interface{}, error)
+
+// Middleware wraps HandlerFunc, e.g. to log or recover requests
+type Middleware func(HandlerFunc) HandlerFunc
+
+// Server dispatches requests received on NATS to the registered handlers
+type Server struct {
+	conn       *nats.Conn
+	middleware []Middleware
+}
+
+// NewServer returns new instance of Server, middlewares are applied in given order,
+// i.e. the first one is the outermost
+func NewServer(conn *nats.Conn, mw ...Middleware) *Server {
+	return &Server{conn: conn, middleware: mw}
+}
+
+// Register subscribes typed handler to the Endpoint
+func Register[Req, Resp any](s *Server, e Endpoint[Req, Resp], h Handler[Req, Resp]) error {
+	next := func(ctx context.Context, r *Request) (interface{}, error) {
+		c, ok := codecFor(r.Header)
+		if !ok {
+			return nil, Errorf(CodeInvalid, "unsupported content type %q", r.Header.Get(contentTypeHeader))
+		}
+		if len(ActorOf(ctx)) > MaxActor {
+			return nil, ErrInvalidActor
+		}
+		var req Req
+		data, err := upgrade(c, r.Header, reflect.TypeOf(req), r.Data)
+		if err != nil {
+			return nil, Errorf(CodeInvalid, "invalid request: %v", err)
+		}
+		if err := decode(c, data, &req); err != nil {
+			return nil, Errorf(CodeInvalid, "invalid request: %v", err)
+		}
+		return h(ctx, req)
+	}
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		next = s.middleware[i](next)
+	}
+	_, err := s.conn.QueueSubscribe(e.Subject, e.Queue, func(msg *nats.Msg) {
+		serve(msg, next)
+	})
+	return err
+}
+
+// serve handles the request and sends exactly one reply: handler's response,
+// its error or internal error if the handler panicked. Failures are logged with the subject.
+// Reply is encoded with the Codec of the request, JSON if the Codec is unknown
+func serve(msg *nats.Msg, h HandlerFunc) {
+	start := time.Now()
+	ctx, cancel := requestContext(msg)
+	defer cancel()
+	resp, err := call(ctx, h, &Request{Subject: msg.Subject, Data: msg.Data, Header: msg.Header})
+	if err != nil {
+		logrus.WithField("subject", msg.Subject).
+			WithField("code", CodeOf(err)).
+			WithField("duration", time.Since(start)).
+			Error(err)
+	}
+	if msg.Reply == "" {
+		return
+	}
+	c, ok := codecFor(msg.Header)
+	if !ok {
+		c = JSON
+	}
+	reply := &nats.Msg{Data: encode(c, resp, err), Header: nats.Header{}}
+	reply.Header.Set(contentTypeHeader, c.ContentType())
+	if err == nil && resp != nil {
+		reply.Header.Set(versionHeader, strconv.Itoa(schema.VersionOf(reflect.TypeOf(resp))))
+	}
+	if rErr := msg.RespondMsg(reply); rErr != nil {
+		logrus.WithField("subject", msg.Subject).Error(rErr)
+	}
+}
+
+// call invokes h converting panic to CodeInternal error
+func call(ctx context.Context, h HandlerFunc, req *Request) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("subject", req.Subject).Errorf("panic: %v\n%s", r, debug.Stack())
+			resp, err = nil, Errorf(CodeInternal, "internal error: %v", r)
+		}
+	}()
+	return h(ctx, req)
+}
+
+// Call sends typed request to the Endpoint and waits for the response
+// until context is done or DefaultTimeout if context has no deadline
+func Call[Req, Resp any](ctx context.Context, conn *nats.Conn, e Endpoint[Req, Resp], req Req) (Resp, error) {
+	var resp Resp
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	c := defaultCodec()
+	d, err := c.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	msg := nats.NewMsg(e.Subject)
+	msg.Data = d
+	msg.Header.Set(contentTypeHeader, c.ContentType())
+	msg.Header.Set(versionHeader, strconv.Itoa(schema.VersionOf(reflect.TypeOf((*Req)(nil)).Elem())))
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Header.Set(deadlineHeader, strconv.FormatInt(deadline.UnixNano(), 10))
+	}
+	if actor := ActorOf(ctx); actor != "" {
+		msg.Header.Set(actorHeader, actor)
+	}
+	reply, err := conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		if err == context.DeadlineExceeded || err == nats.ErrTimeout {
+			return resp, Errorf(CodeTimeout, "%s: %v", e.Subject, err)
+		}
+		return resp, err
+	}
+	rc, ok := codecFor(reply.Header)
+	if !ok {
+		return resp, fmt.Errorf("%s: unsupported content type %q", e.Subject, reply.Header.Get(contentTypeHeader))
+	}
+	m := model.NATSMsg{}
+	if err := rc.Unmarshal(reply.Data, &m); err != nil {
+		return resp, err
+	}
+	if !m.Success {
+		code := Code(m.Code)
+		if code == "" {
+			code = CodeUnknown
+		}
+		return resp, &Error{Code: code, Message: m.Message}
+	}
+	if len(m.Data) == 0 {
+		return resp, nil
+	}
+	data, err := upgrade(rc, reply.Header, reflect.TypeOf((*Resp)(nil)).Elem(), m.Data)
+	if err != nil {
+		return resp, fmt.Errorf("%s: %v", e.Subject, err)
+	}
+	return resp, rc.Unmarshal(data, &resp)
+}
+
+// requestContext returns context with caller's deadline and actor if they were sent
+func requestContext(msg *nats.Msg) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if actor := msg.Header.Get(actorHeader); actor != "" {
+		ctx = WithActor(ctx, actor)
+	}
+	if v := msg.Header.Get(deadlineHeader); v != "" {
+		if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return context.WithDeadline(ctx, time.Unix(0, ns))
+		}
+	}
+	return context.WithCancel(ctx)
+}
+
+// upgrade converts payload of the version sent in the header to the current version of t,
+// payload without version is assumed current. Protobuf payloads of the previous version
+// are decoded as is since fields are never renumbered
+func upgrade(c Codec, h nats.Header, t reflect.Type, data []byte) ([]byte, error) {
+	v := h.Get(versionHeader)
+	if v == "" {
+		return data, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema version %q", v)
+	}
+	if c.ContentType() != JSON.ContentType() {
+		if !schema.Supported(t, n) {
+			return nil, fmt.Errorf("%w %d of %s", schema.ErrUnsupportedVersion, n, t)
+		}
+		return data, nil
+	}
+	return schema.UpgradeJSON(t, n, data)
+}
+
+// decode decodes request, empty data is decoded as zero value and Empty request ignores the data
+func decode(c Codec, data []byte, v interface{}) error {
+	if _, empty := v.(*Empty); empty || len(data) == 0 {
+		return nil
+	}
+	return c.Unmarshal(data, v)
+}
+
+// encode encodes handler's result as model.NATSMsg
+func encode(c Codec, resp interface{}, err error) []byte {
+	m := model.NATSMsg{Success: err == nil}
+	if err != nil {
+		e := toError(err)
+		m.Code, m.Message = string(e.Code), e.Message
+	} else if _, empty := resp.(Empty); !empty && resp != nil {
+		d, mErr := c.Marshal(resp)
+		if mErr != nil {
+			m = model.NATSMsg{Success: false, Code: string(CodeInternal), Message: mErr.Error()}
+		} else {
+			m.Data = d
+		}
+	}
+	d, _ := c.Marshal(m)
+	return d
+}
+
+// toError converts err to *Error keeping its message
+func toError(err error) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, sql.ErrNoRows):
+		return &Error{Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, model.ErrInvalidID):
+		return &Error{Code: CodeInvalid, Message: err.Error()}
+	}
+	return &Error{Code: CodeUnknown, Message: err.Error()}
+}
@@ -0,0 +1,343 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/schema"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+type echo struct {
+	Text string `json:"text"`
+}
+
+var echoEndpoint = NewEndpoint[echo, echo]("test.echo", "test-queue")
+
+func setUp(t *testing.T) (*nats.Conn, func()) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = -1
+	srv := natstest.RunServer(&opts)
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		srv.Shutdown()
+	}
+}
+
+func TestCall(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	if err := Register(NewServer(conn, Defaults()...), echoEndpoint, func(ctx context.Context, req echo) (echo, error) {
+		return echo{Text: req.Text + "!"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Call(context.Background(), conn, echoEndpoint, echo{Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != "hi!" {
+		t.Errorf("expected=%s got=%s", "hi!", got.Text)
+	}
+}
+
+func TestCall_Errors(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	srv := NewServer(conn, Defaults()...)
+	tests := []struct {
+		name    string
+		handler Handler[echo, echo]
+		code    Code
+		message string
+	}{
+		{name: "not-found", handler: func(context.Context, echo) (echo, error) { return echo{}, sql.ErrNoRows }, code: CodeNotFound, message: sql.ErrNoRows.Error()},
+		{name: "invalid-id", handler: func(context.Context, echo) (echo, error) { return echo{}, model.ErrInvalidID }, code: CodeInvalid, message: model.ErrInvalidID.Error()},
+		{name: "coded", handler: func(context.Context, echo) (echo, error) { return echo{}, Errorf(CodeNotFound, "no echo") }, code: CodeNotFound, message: "no echo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEndpoint[echo, echo]("test."+tt.name, "test-queue")
+			if err := Register(srv, e, tt.handler); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Call(context.Background(), conn, e, echo{})
+			if got := CodeOf(err); got != tt.code {
+				t.Errorf("expected=%s got=%s", tt.code, got)
+			}
+			if err.Error() != tt.message {
+				t.Errorf("expected=%s got=%s", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestCall_Deadline(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	deadlines := make(chan time.Time, 1)
+	if err := Register(NewServer(conn), echoEndpoint, func(ctx context.Context, req echo) (echo, error) {
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		<-ctx.Done()
+		return req, ctx.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	_, err := Call(ctx, conn, echoEndpoint, echo{})
+	if got := CodeOf(err); got != CodeTimeout {
+		t.Errorf("expected=%s got=%s (%v)", CodeTimeout, got, err)
+	}
+	// handler sees caller's deadline
+	if got := <-deadlines; !got.Equal(want) {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
+
+func TestCall_Actor(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	if err := Register(NewServer(conn), echoEndpoint, func(ctx context.Context, req echo) (echo, error) {
+		return echo{Text: ActorOf(ctx)}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, actor := range []string{"ops", ""} {
+		got, err := Call(WithActor(context.Background(), actor), conn, echoEndpoint, echo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Text != actor {
+			t.Errorf("expected=%q got=%q", actor, got.Text)
+		}
+	}
+	// actor too long to be audited is rejected before the handler runs
+	long := WithActor(context.Background(), strings.Repeat("a", MaxActor+1))
+	if _, err := Call(long, conn, echoEndpoint, echo{}); CodeOf(err) != CodeInvalid {
+		t.Errorf("expected=%s got=%v", CodeInvalid, err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var id string
+	if err := decode(JSON, []byte(`"abc"`), &id); err != nil || id != "abc" {
+		t.Errorf("quoted: expected=%s got=%s (%v)", "abc", id, err)
+	}
+	if err := decode(JSON, []byte(`abc`), &id); err != nil || id != "abc" {
+		t.Errorf("raw: expected=%s got=%s (%v)", "abc", id, err)
+	}
+	var e Empty
+	if err := decode(JSON, []byte(`""`), &e); err != nil {
+		t.Errorf("empty: %v", err)
+	}
+}
+
+// request sends raw request and returns the only reply,
+// it fails if the handler replies more than once or not at all
+func request(t *testing.T, conn *nats.Conn, subject string, data []byte) model.NATSMsg {
+	inbox := nats.NewInbox()
+	sub, err := conn.SubscribeSync(inbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	if err := conn.PublishRequest(subject, inbox, data); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sub.NextMsg(time.Second * 5)
+	if err != nil {
+		t.Fatalf("no reply: %v", err)
+	}
+	if extra, err := sub.NextMsg(time.Millisecond * 200); err == nil {
+		t.Errorf("unexpected second reply: %s", extra.Data)
+	}
+	m := model.NATSMsg{}
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestServer_Reply(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	// no middlewares, server alone guarantees the reply
+	srv := NewServer(conn)
+	tests := []struct {
+		name    string
+		handler Handler[echo, echo]
+		data    []byte
+		want    model.NATSMsg
+	}{
+		{
+			name:    "success",
+			handler: func(_ context.Context, req echo) (echo, error) { return req, nil },
+			data:    []byte(`{"text":"hi"}`),
+			want:    model.NATSMsg{Success: true, Data: json.RawMessage(`{"text":"hi"}`)},
+		},
+		{
+			name:    "error",
+			handler: func(context.Context, echo) (echo, error) { return echo{}, errors.New("Insufficient funds") },
+			data:    []byte(`{}`),
+			want:    model.NATSMsg{Code: string(CodeUnknown), Message: "Insufficient funds"},
+		},
+		{
+			name:    "panic",
+			handler: func(context.Context, echo) (echo, error) { panic("boom") },
+			data:    []byte(`{}`),
+			want:    model.NATSMsg{Code: string(CodeInternal), Message: "internal error: boom"},
+		},
+		{
+			name:    "decode",
+			handler: func(context.Context, echo) (echo, error) { t.Error("handler called"); return echo{}, nil },
+			data:    []byte(`{"text":`),
+			want:    model.NATSMsg{Code: string(CodeInvalid)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEndpoint[echo, echo]("reply."+tt.name, "test-queue")
+			if err := Register(srv, e, tt.handler); err != nil {
+				t.Fatal(err)
+			}
+			got := request(t, conn, e.Subject, tt.data)
+			if got.Success != tt.want.Success || got.Code != tt.want.Code || string(got.Data) != string(tt.want.Data) {
+				t.Errorf("expected=%+v got=%+v", tt.want, got)
+			}
+			if tt.want.Message != "" && got.Message != tt.want.Message {
+				t.Errorf("expected=%s got=%s", tt.want.Message, got.Message)
+			}
+		})
+	}
+}
+
+func TestServer_PanicInMiddleware(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	broken := func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) (interface{}, error) {
+			panic("middleware")
+		}
+	}
+	if err := Register(NewServer(conn, broken), echoEndpoint, func(_ context.Context, req echo) (echo, error) {
+		return req, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got := request(t, conn, echoEndpoint.Subject, []byte(`{}`))
+	if got.Success || got.Code != string(CodeInternal) {
+		t.Errorf("expected=%s got=%+v", CodeInternal, got)
+	}
+}
+
+func TestServer_UnsupportedContentType(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	if err := Register(NewServer(conn), echoEndpoint, func(_ context.Context, req echo) (echo, error) {
+		t.Error("handler called")
+		return req, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msg := nats.NewMsg(echoEndpoint.Subject)
+	msg.Data = []byte(`{}`)
+	msg.Header.Set(contentTypeHeader, "application/xml")
+	reply, err := conn.RequestMsg(msg, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unknown codec is answered in JSON
+	if got := reply.Header.Get(contentTypeHeader); got != JSON.ContentType() {
+		t.Errorf("expected=%s got=%s", JSON.ContentType(), got)
+	}
+	m := model.NATSMsg{}
+	if err := json.Unmarshal(reply.Data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Success || m.Code != string(CodeInvalid) {
+		t.Errorf("expected=%s got=%+v", CodeInvalid, m)
+	}
+}
+
+// echoV2 is version 2 of echo, text was renamed to message
+type echoV2 struct {
+	Message string `json:"message"`
+}
+
+func TestServer_SchemaVersion(t *testing.T) {
+	conn, destroy := setUp(t)
+	defer destroy()
+
+	schema.Register[echoV2](2, func(doc map[string]interface{}) error {
+		doc["message"] = doc["text"]
+		delete(doc, "text")
+		return nil
+	})
+	e := NewEndpoint[echoV2, echoV2]("test.echo.v2", "test-queue")
+	if err := Register(NewServer(conn), e, func(_ context.Context, req echoV2) (echoV2, error) {
+		return req, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		version string
+		data    string
+		want    model.NATSMsg
+	}{
+		{name: "current", version: "2", data: `{"message":"hi"}`, want: model.NATSMsg{Success: true, Data: json.RawMessage(`{"message":"hi"}`)}},
+		{name: "previous", version: "1", data: `{"text":"hi"}`, want: model.NATSMsg{Success: true, Data: json.RawMessage(`{"message":"hi"}`)}},
+		{name: "unversioned", data: `{"message":"hi"}`, want: model.NATSMsg{Success: true, Data: json.RawMessage(`{"message":"hi"}`)}},
+		{name: "unsupported", version: "3", data: `{"message":"hi"}`, want: model.NATSMsg{Code: string(CodeInvalid)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := nats.NewMsg(e.Subject)
+			msg.Data = []byte(tt.data)
+			if tt.version != "" {
+				msg.Header.Set(versionHeader, tt.version)
+			}
+			reply, err := conn.RequestMsg(msg, time.Second*5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := model.NATSMsg{}
+			if err := json.Unmarshal(reply.Data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Success != tt.want.Success || got.Code != tt.want.Code || string(got.Data) != string(tt.want.Data) {
+				t.Errorf("expected=%+v got=%+v", tt.want, got)
+			}
+			if got.Success && reply.Header.Get(versionHeader) != "2" {
+				t.Errorf("expected=%s got=%s", "2", reply.Header.Get(versionHeader))
+			}
+		})
+	}
+	// typed caller sends current version
+	got, err := Call(context.Background(), conn, e, echoV2{Message: "hi"})
+	if err != nil || got.Message != "hi" {
+		t.Errorf("expected=%s got=%s (%v)", "hi", got.Message, err)
+	}
+}
@@ -0,0 +1,54 @@
+package schema
+
+import (
+	"reflect"
+
+	"github.com/kylycht/md/model"
+)
+
+// Payloads lists types sent over NATS, lists share snapshot and version of the element.
+// Register new version with upgrade from the previous one before changing JSON shape of the type, e.g.
+//
+//	Register[model.Task](2, func(doc map[string]interface{}) error {
+//		doc["contract"] = "fixed"
+//		return nil
+//	})
+var Payloads = []reflect.Type{
+	reflect.TypeOf(model.Task{}),
+	reflect.TypeOf(model.TaskQuery{}),
+	reflect.TypeOf(model.TaskSearchResult{}),
+	reflect.TypeOf(model.TaskAction{}),
+	reflect.TypeOf(model.Client{}),
+	reflect.TypeOf(model.Freelancer{}),
+	reflect.TypeOf(model.FreelancerQuery{}),
+	reflect.TypeOf(model.Category{}),
+	reflect.TypeOf(model.Skill{}),
+	reflect.TypeOf(model.Rating{}),
+	reflect.TypeOf(model.MatchQuery{}),
+	reflect.TypeOf(model.Match{}),
+	reflect.TypeOf(model.EmailPreferences{}),
+	reflect.TypeOf(model.Notification{}),
+	reflect.TypeOf(model.NotificationQuery{}),
+	reflect.TypeOf(model.NotificationPage{}),
+	reflect.TypeOf(model.NotificationRead{}),
+	reflect.TypeOf(model.Payment{}),
+	reflect.TypeOf(model.Charge{}),
+	reflect.TypeOf(model.Invoice{}),
+	reflect.TypeOf(model.Dispute{}),
+	reflect.TypeOf(model.DisputeStatement{}),
+	reflect.TypeOf(model.TimeEntry{}),
+	reflect.TypeOf(model.Timesheet{}),
+	reflect.TypeOf(model.Reservation{}),
+	reflect.TypeOf(model.Wallet{}),
+	reflect.TypeOf(model.Adjustment{}),
+	reflect.TypeOf(model.Webhook{}),
+	reflect.TypeOf(model.WebhookAccess{}),
+	reflect.TypeOf(model.Delivery{}),
+	reflect.TypeOf(model.Message{}),
+	reflect.TypeOf(model.Thread{}),
+	reflect.TypeOf(model.Attachment{}),
+	reflect.TypeOf(model.AuditEntry{}),
+	reflect.TypeOf(model.AuditQuery{}),
+	reflect.TypeOf(model.AttachmentAccess{}),
+	reflect.TypeOf(model.NATSMsg{}),
+}
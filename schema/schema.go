@@ -0,0 +1,145 @@
+// Package schema versions payloads sent over NATS.
+//
+// Every payload type has a version, 1 unless registered with Register.
+// Version is sent in Schema-Version header, receivers accept the current and
+// the previous version, JSON of the previous version is upgraded with the registered Upgrade.
+// JSON shape of the payload types is stored in snapshots, Check compares
+// the Go types with the snapshots and reports changes requiring a version bump
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema describes JSON shape of a Go type
+type Schema struct {
+	Type       string             `json:"type"`                 // Type represents JSON type: object, array, string, integer, number, boolean or any
+	Format     string             `json:"format,omitempty"`     // Format represents format of the string, e.g. date-time
+	Properties map[string]*Schema `json:"properties,omitempty"` // Properties represents fields of the object
+	Items      *Schema            `json:"items,omitempty"`      // Items represents elements of the array
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Describe returns JSON shape of the type as encoded by encoding/json
+func Describe(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{Type: "any"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return Describe(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: Describe(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		describeFields(t, s.Properties)
+		return s
+	}
+	return &Schema{Type: "any"}
+}
+
+// describeFields adds exported fields of the struct, fields of embedded structs are promoted
+func describeFields(t reflect.Type, props map[string]*Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			describeFields(f.Type, props)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = Describe(f.Type)
+	}
+}
+
+// Change represents difference between two schemas
+type Change struct {
+	Path     string `json:"path"`     // Path represents changed property, e.g. fee.amount
+	Kind     string `json:"kind"`     // Kind represents the change: added, removed or changed
+	Breaking bool   `json:"breaking"` // Breaking reports whether receivers of the old shape can not decode the new one
+}
+
+func (c Change) String() string {
+	s := c.Kind + " " + c.Path
+	if c.Breaking {
+		s += " (breaking)"
+	}
+	return s
+}
+
+// Diff returns changes from old to new schema, added properties are compatible,
+// removed properties and changed types are breaking
+func Diff(old, new *Schema) []Change {
+	changes := diff("", old, new)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diff(path string, old, new *Schema) []Change {
+	if old.Type != new.Type || old.Format != new.Format {
+		return []Change{{Path: root(path), Kind: "changed", Breaking: true}}
+	}
+	var changes []Change
+	if old.Items != nil && new.Items != nil {
+		changes = append(changes, diff(path+"[]", old.Items, new.Items)...)
+	}
+	for name, o := range old.Properties {
+		n, ok := new.Properties[name]
+		if !ok {
+			changes = append(changes, Change{Path: join(path, name), Kind: "removed", Breaking: true})
+			continue
+		}
+		changes = append(changes, diff(join(path, name), o, n)...)
+	}
+	for name := range new.Properties {
+		if _, ok := old.Properties[name]; !ok {
+			changes = append(changes, Change{Path: join(path, name), Kind: "added"})
+		}
+	}
+	return changes
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func root(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
@@ -0,0 +1,157 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSnapshots fails when payload type differs from its snapshot,
+// run `go run ./cmd/schema-check -update` after bumping the version
+func TestSnapshots(t *testing.T) {
+	results, err := Check("snapshots", Payloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if err := r.Err(); err != nil {
+			t.Errorf("%s: %v %v", r.Name, err, r.Changes)
+		}
+	}
+}
+
+type money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type taskV1 struct {
+	ID       string `db:"id"`
+	Fee      int64  `json:"fee"`
+	Internal string `json:"-"`
+	secret   string
+}
+
+type taskV2 struct {
+	ID      string    `db:"id"`
+	Fee     money     `json:"fee"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+func TestDescribe(t *testing.T) {
+	got := Describe(reflect.TypeOf(taskV2{}))
+	want := &Schema{Type: "object", Properties: map[string]*Schema{
+		"ID":      {Type: "string"},
+		"fee":     {Type: "object", Properties: map[string]*Schema{"amount": {Type: "integer"}, "currency": {Type: "string"}}},
+		"tags":    {Type: "array", Items: &Schema{Type: "string"}},
+		"created": {Type: "string", Format: "date-time"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		g, _ := json.Marshal(got)
+		t.Errorf("unexpected schema %s", g)
+	}
+	// ignored and unexported fields are not encoded
+	if got := Describe(reflect.TypeOf(taskV1{})).Properties; len(got) != 2 {
+		t.Errorf("expected=%d got=%d", 2, len(got))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := Diff(Describe(reflect.TypeOf(taskV1{})), Describe(reflect.TypeOf(taskV2{})))
+	want := []Change{
+		{Path: "created", Kind: "added"},
+		{Path: "fee", Kind: "changed", Breaking: true},
+		{Path: "tags", Kind: "added"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected=%v got=%v", want, got)
+	}
+	if got := Diff(Describe(reflect.TypeOf(taskV2{})), Describe(reflect.TypeOf(taskV2{}))); len(got) != 0 {
+		t.Errorf("unexpected changes %v", got)
+	}
+}
+
+type versioned struct {
+	ID  string `json:"id"`
+	Fee money  `json:"fee"`
+}
+
+func TestUpgradeJSON(t *testing.T) {
+	Register[versioned](2, func(doc map[string]interface{}) error {
+		// version 1 had fee in USD cents
+		doc["fee"] = map[string]interface{}{"amount": doc["fee"], "currency": "USD"}
+		return nil
+	})
+	typ := reflect.TypeOf(versioned{})
+	if got := VersionOf(reflect.TypeOf([]versioned{})); got != 2 {
+		t.Errorf("expected=%d got=%d", 2, got)
+	}
+
+	d, err := UpgradeJSON(typ, 1, []byte(`{"id":"1","fee":100}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v versioned
+	if err := json.Unmarshal(d, &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := (versioned{ID: "1", Fee: money{Amount: 100, Currency: "USD"}}); v != want {
+		t.Errorf("expected=%+v got=%+v", want, v)
+	}
+
+	d, err = UpgradeJSON(reflect.TypeOf([]versioned{}), 1, []byte(`[{"id":"1","fee":100},{"id":"2","fee":5}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []versioned
+	if err := json.Unmarshal(d, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].Fee.Amount != 5 || list[1].Fee.Currency != "USD" {
+		t.Errorf("unexpected list %+v", list)
+	}
+
+	current := []byte(`{"id":"1","fee":{"amount":100,"currency":"EUR"}}`)
+	if d, err := UpgradeJSON(typ, 2, current); err != nil || string(d) != string(current) {
+		t.Errorf("expected=%s got=%s (%v)", current, d, err)
+	}
+	for _, v := range []int{0, 3} {
+		if _, err := UpgradeJSON(typ, v, current); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Errorf("version %d: expected=%v got=%v", v, ErrUnsupportedVersion, err)
+		}
+	}
+}
+
+func TestResult_Err(t *testing.T) {
+	v1 := Snapshot{Name: "task", Version: 1, Schema: Describe(reflect.TypeOf(taskV1{}))}
+	v2 := Snapshot{Name: "task", Version: 1, Schema: Describe(reflect.TypeOf(taskV2{}))}
+	tests := []struct {
+		name    string
+		current Snapshot
+		prev    *Snapshot
+		want    error
+	}{
+		{name: "no-snapshot", current: v1, want: ErrNoSnapshot},
+		{name: "unchanged", current: v1, prev: &v1},
+		{name: "breaking", current: v2, prev: &v1, want: ErrBreaking},
+		{name: "bumped", current: Snapshot{Name: "task", Version: 2, Schema: v2.Schema}, prev: &v1, want: ErrOutdated},
+		{name: "skipped", current: Snapshot{Name: "task", Version: 3, Schema: v2.Schema}, prev: &v1, want: ErrVersion},
+		{name: "compatible", current: Snapshot{Name: "task", Version: 1, Schema: &Schema{Type: "object", Properties: map[string]*Schema{
+			"ID": {Type: "string"}, "fee": {Type: "integer"}, "tags": {Type: "array", Items: &Schema{Type: "string"}},
+		}}}, prev: &v1, want: ErrOutdated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Result{Snapshot: tt.current, Previous: tt.prev}
+			if tt.prev != nil {
+				r.Changes = Diff(tt.prev.Schema, tt.current.Schema)
+			}
+			if err := r.Err(); !errors.Is(err, tt.want) {
+				t.Errorf("expected=%v got=%v", tt.want, err)
+			}
+		})
+	}
+}
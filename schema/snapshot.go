@@ -0,0 +1,104 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+var (
+	// ErrNoSnapshot represents error reported for payload type without snapshot
+	ErrNoSnapshot = errors.New("no snapshot")
+	// ErrOutdated represents error reported when JSON shape or version changed compatibly and the snapshot must be updated
+	ErrOutdated = errors.New("snapshot is outdated")
+	// ErrBreaking represents error reported for breaking change without version bump
+	ErrBreaking = errors.New("breaking change without version bump")
+	// ErrVersion represents error reported when version was decreased or bumped more than once
+	ErrVersion = errors.New("invalid version")
+)
+
+// Snapshot represents stored JSON shape of the payload type
+type Snapshot struct {
+	Name    string  `json:"name"`    // Name represents Go type, e.g. model.Task
+	Version int     `json:"version"` // Version represents version of the type
+	Schema  *Schema `json:"schema"`  // Schema represents JSON shape of the type
+}
+
+// Result represents comparison of the payload type with its snapshot
+type Result struct {
+	Snapshot
+	Previous *Snapshot // Previous represents stored snapshot, nil if there is none
+	Changes  []Change  // Changes represents changes since the stored snapshot
+}
+
+// Err returns nil if the type matches its snapshot
+func (r Result) Err() error {
+	switch {
+	case r.Previous == nil:
+		return ErrNoSnapshot
+	case r.Version < r.Previous.Version || r.Version > r.Previous.Version+1:
+		return fmt.Errorf("%w %d, snapshot is version %d", ErrVersion, r.Version, r.Previous.Version)
+	case r.Version == r.Previous.Version:
+		for _, c := range r.Changes {
+			if c.Breaking {
+				return ErrBreaking
+			}
+		}
+		if len(r.Changes) > 0 {
+			return ErrOutdated
+		}
+		return nil
+	}
+	return ErrOutdated
+}
+
+// Take returns current snapshot of the type
+func Take(t reflect.Type) Snapshot {
+	return Snapshot{Name: t.String(), Version: VersionOf(t), Schema: Describe(t)}
+}
+
+// Check compares payload types with snapshots stored in dir
+func Check(dir string, types []reflect.Type) ([]Result, error) {
+	var results []Result
+	for _, t := range types {
+		r := Result{Snapshot: Take(t)}
+		prev, err := load(path(dir, r.Name))
+		switch {
+		case err == nil:
+			r.Previous = prev
+			r.Changes = Diff(prev.Schema, r.Schema)
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Update stores snapshot of the result, breaking changes and invalid versions are never stored
+func Update(dir string, r Result) error {
+	if err := r.Err(); err != nil && err != ErrNoSnapshot && err != ErrOutdated {
+		return err
+	}
+	d, err := json.MarshalIndent(r.Snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path(dir, r.Name), append(d, '\n'), 0644)
+}
+
+func path(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+func load(path string) (*Snapshot, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	return s, json.Unmarshal(d, s)
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnsupportedVersion represents error returned when payload is neither current nor previous version
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// Upgrade converts JSON object of the previous version to the current one in place
+type Upgrade func(doc map[string]interface{}) error
+
+type version struct {
+	current int
+	upgrade Upgrade
+}
+
+var (
+	mu       sync.RWMutex
+	versions = map[reflect.Type]version{}
+)
+
+// Register sets current version of T, upgrade converts JSON of the previous version.
+// Types that were never registered are version 1
+func Register[T any](current int, upgrade Upgrade) {
+	if current > 1 && upgrade == nil {
+		panic(fmt.Sprintf("schema: no upgrade to version %d of %T", current, *new(T)))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	versions[reflect.TypeOf((*T)(nil)).Elem()] = version{current: current, upgrade: upgrade}
+}
+
+// payloadType returns type versions are registered for, slices share version of the element
+func payloadType(t reflect.Type) reflect.Type {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	return t
+}
+
+func lookup(t reflect.Type) version {
+	mu.RLock()
+	defer mu.RUnlock()
+	if v, ok := versions[payloadType(t)]; ok {
+		return v
+	}
+	return version{current: 1}
+}
+
+// VersionOf returns current version of the type
+func VersionOf(t reflect.Type) int {
+	return lookup(t).current
+}
+
+// Supported reports whether payload of the version can be decoded into the type
+func Supported(t reflect.Type, v int) bool {
+	current := VersionOf(t)
+	return v == current || v == current-1
+}
+
+// UpgradeJSON converts JSON payload of given version to the current version of the type,
+// payload of the current version is returned as is
+func UpgradeJSON(t reflect.Type, from int, data []byte) ([]byte, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v := lookup(t)
+	switch {
+	case from == v.current:
+		return data, nil
+	case from != v.current-1:
+		return nil, fmt.Errorf("%w %d of %s, accepted %d and %d", ErrUnsupportedVersion, from, payloadType(t), v.current-1, v.current)
+	}
+	if t.Kind() == reflect.Slice {
+		var docs []map[string]interface{}
+		if err := json.Unmarshal(data, &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if doc == nil {
+				continue
+			}
+			if err := v.upgrade(doc); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(docs)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return data, nil
+	}
+	if err := v.upgrade(doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,166 @@
+// Package attachment keeps records of the files attached to the Tasks. Content of the files
+// is stored in blob storage by the gateway, the service decides who may attach and read them
+package attachment
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+)
+
+// source represents the service in domain events
+const source = "attachment"
+
+// maxName represents maximum length of the file name in characters
+const maxName = 255
+
+var (
+	// ErrNotParticipant represents error returned when Attachment is accessed by someone other than Client or Freelancer of the Task
+	ErrNotParticipant = rpc.Errorf(rpc.CodePermission, "not a participant of the task")
+	// ErrUploader represents error returned when brief is not uploaded by Client or deliverable is not uploaded by Freelancer
+	ErrUploader = rpc.Errorf(rpc.CodePermission, "brief is attached by client, deliverable by freelancer")
+	// ErrInvalidKind represents error returned for unknown kind of Attachment
+	ErrInvalidKind = rpc.Errorf(rpc.CodeInvalid, "kind must be %s or %s", model.Brief, model.Deliverable)
+	// ErrInvalidFile represents error returned when name, size, content type or checksum of the file is missing or malformed
+	ErrInvalidFile = rpc.Errorf(rpc.CodeInvalid, "invalid file")
+)
+
+// Service represents Attachment service
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Attachment service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.AttachmentAuthorize, s.Authorize); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.AttachmentAdd, s.Add); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.AttachmentList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.AttachmentGet, s.Get); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Authorize will check that the uploader may attach the Attachment of its kind to the Task,
+// the gateway stores content of the file only after the check
+func (s *Service) Authorize(ctx context.Context, a model.Attachment) (rpc.Empty, error) {
+	return rpc.Empty{}, s.authorize(ctx, a)
+}
+
+// Add will record the Attachment, brief is attached by Client and deliverable by assigned Freelancer of the Task
+func (s *Service) Add(ctx context.Context, a model.Attachment) (model.Attachment, error) {
+	if len(a.ID) != 36 {
+		return a, model.ErrInvalidID
+	}
+	if err := validFile(a); err != nil {
+		return a, err
+	}
+	if err := s.authorize(ctx, a); err != nil {
+		return a, err
+	}
+	a.CreatedAt = time.Now().UTC()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return a, err
+	}
+	insertS := "INSERT INTO attachment (id, task_id, uploader_id, kind, name, content_type, size, checksum, created_at) " +
+		"VALUES(:id, :task_id, :uploader_id, :kind, :name, :content_type, :size, :checksum, :created_at)"
+	if _, err := tx.NamedExec(insertS, a); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	if err := events.Record(tx, source, events.AttachmentAdded, a.TaskID, a); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// List will retrieve Attachments of the Task, oldest first
+func (s *Service) List(ctx context.Context, req model.AttachmentAccess) ([]model.Attachment, error) {
+	attachments := []model.Attachment{}
+	if _, err := s.task(ctx, req.TaskID, req.UserID); err != nil {
+		return attachments, err
+	}
+	err := s.db.SelectContext(ctx, &attachments, "SELECT * FROM attachment WHERE task_id=$1 ORDER BY created_at, id", req.TaskID)
+	return attachments, err
+}
+
+// Get will retrieve Attachment by ID for the party of its Task
+func (s *Service) Get(ctx context.Context, req model.AttachmentAccess) (model.Attachment, error) {
+	var a model.Attachment
+	if len(req.ID) != 36 {
+		return a, model.ErrInvalidID
+	}
+	if err := s.db.GetContext(ctx, &a, "SELECT * FROM attachment WHERE id=$1", req.ID); err != nil {
+		return a, err
+	}
+	if _, err := s.task(ctx, a.TaskID, req.UserID); err != nil {
+		return model.Attachment{}, err
+	}
+	return a, nil
+}
+
+// authorize returns ErrUploader unless brief is attached by Client and deliverable by assigned Freelancer of the Task
+func (s *Service) authorize(ctx context.Context, a model.Attachment) error {
+	if a.Kind != model.Brief && a.Kind != model.Deliverable {
+		return ErrInvalidKind
+	}
+	task, err := s.task(ctx, a.TaskID, a.UploaderID)
+	if err != nil {
+		return err
+	}
+	if (a.Kind == model.Brief && a.UploaderID != task.ClientID) || (a.Kind == model.Deliverable && a.UploaderID != task.FreelancerID) {
+		return ErrUploader
+	}
+	return nil
+}
+
+// task returns parties of the Task, ErrNotParticipant unless user is Client or assigned Freelancer of the Task
+func (s *Service) task(ctx context.Context, taskID, userID string) (model.Task, error) {
+	task := model.Task{}
+	if len(taskID) != 36 || len(userID) != 36 {
+		return task, model.ErrInvalidID
+	}
+	query := "SELECT client_id, COALESCE(freelancer_id, '') AS freelancer_id FROM task WHERE id=$1 AND deleted_at IS NULL"
+	if err := s.db.GetContext(ctx, &task, query, taskID); err != nil {
+		return task, err
+	}
+	if userID != task.ClientID && userID != task.FreelancerID {
+		return task, ErrNotParticipant
+	}
+	return task, nil
+}
+
+func validFile(a model.Attachment) error {
+	if l := utf8.RuneCountInString(a.Name); l == 0 || l > maxName || a.Size <= 0 || a.ContentType == "" {
+		return ErrInvalidFile
+	}
+	if sum, err := hex.DecodeString(a.Checksum); err != nil || len(sum) != 32 {
+		return ErrInvalidFile
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package attachment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var attachmentSchema = `CREATE TABLE ATTACHMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	UPLOADER_ID varchar(36) NOT NULL,
+	KIND varchar(16) NOT NULL,
+	NAME varchar(255) NOT NULL,
+	CONTENT_TYPE varchar(255) NOT NULL,
+	SIZE bigint NOT NULL,
+	CHECKSUM char(64) NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+func setUp(t *testing.T) func() {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(moneyType)
+	db.Exec(taskSchema)
+	db.Exec(attachmentSchema)
+	db.Exec(outboxSchema)
+
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewService(db, natsEncConn); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		natsConn.Close()
+		natsServer.Shutdown()
+		db.Close()
+	}
+}
+
+// populateDB inserts started Task with assigned Freelancer
+func populateDB(t *testing.T) model.Task {
+	task := model.NewTask(time.Hour*24, model.NewMoney(2000, model.EUR), model.NewID(), "golang app")
+	task.FreelancerID = model.NewID()
+	task.Status = model.Started
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.Status); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func attachment(task model.Task, uploaderID string, kind model.AttachmentKind) model.Attachment {
+	return model.Attachment{ID: model.NewID(), TaskID: task.ID, UploaderID: uploaderID, Kind: kind, Name: "app.zip",
+		ContentType: "application/zip", Size: 1024, Checksum: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+}
+
+func add(t *testing.T, a model.Attachment) model.Attachment {
+	a, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentAdd, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestService_Add(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t)
+	brief := add(t, attachment(task, task.ClientID, model.Brief))
+	deliverable := add(t, attachment(task, task.FreelancerID, model.Deliverable))
+	if brief.CreatedAt.IsZero() {
+		t.Errorf("unexpected attachment: %+v", brief)
+	}
+
+	list, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentList, model.AttachmentAccess{TaskID: task.ID, UserID: task.ClientID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != brief.ID || list[1].ID != deliverable.ID {
+		t.Errorf("unexpected attachments: %+v", list)
+	}
+	got, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentGet, model.AttachmentAccess{ID: deliverable.ID, UserID: task.ClientID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Checksum != deliverable.Checksum || got.Size != deliverable.Size || got.Kind != model.Deliverable {
+		t.Errorf("expected=%+v got=%+v", deliverable, got)
+	}
+
+	var count int
+	query := "SELECT count(*) FROM outbox WHERE subject=$1 AND convert_from(payload, 'UTF8') LIKE '%' || $2 || '%'"
+	if err := s.db.Get(&count, query, events.AttachmentAdded.Subject(), task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected=2 got=%d events", count)
+	}
+}
+
+func TestService_Access(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t)
+	stranger := model.NewID()
+	deliverable := add(t, attachment(task, task.FreelancerID, model.Deliverable))
+
+	for _, c := range []struct {
+		a    model.Attachment
+		code rpc.Code
+	}{
+		{attachment(task, stranger, model.Brief), rpc.CodePermission},
+		{attachment(task, task.FreelancerID, model.Brief), rpc.CodePermission},
+		{attachment(task, task.ClientID, model.Deliverable), rpc.CodePermission},
+		{attachment(task, task.ClientID, "invoice"), rpc.CodeInvalid},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentAdd, c.a); rpc.CodeOf(err) != c.code {
+			t.Errorf("%s by %s: expected=%s got=%v", c.a.Kind, c.a.UploaderID, c.code, err)
+		}
+		// the gateway checks the uploader before the file is uploaded
+		upload := model.Attachment{TaskID: c.a.TaskID, UploaderID: c.a.UploaderID, Kind: c.a.Kind}
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentAuthorize, upload); rpc.CodeOf(err) != c.code {
+			t.Errorf("authorize %s by %s: expected=%s got=%v", c.a.Kind, c.a.UploaderID, c.code, err)
+		}
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentAuthorize,
+		model.Attachment{TaskID: task.ID, UploaderID: task.ClientID, Kind: model.Brief}); err != nil {
+		t.Errorf("expected brief of the client authorized, got %v", err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentGet,
+		model.AttachmentAccess{ID: deliverable.ID, UserID: stranger}); rpc.CodeOf(err) != rpc.CodePermission {
+		t.Errorf("expected=%s got=%v", rpc.CodePermission, err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentList,
+		model.AttachmentAccess{TaskID: task.ID, UserID: stranger}); rpc.CodeOf(err) != rpc.CodePermission {
+		t.Errorf("expected=%s got=%v", rpc.CodePermission, err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.AttachmentGet,
+		model.AttachmentAccess{ID: model.NewID(), UserID: task.ClientID}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestValidFile(t *testing.T) {
+	valid := attachment(model.Task{ID: model.NewID()}, model.NewID(), model.Brief)
+	if err := validFile(valid); err != nil {
+		t.Fatal(err)
+	}
+	for i, modify := range []func(a *model.Attachment){
+		func(a *model.Attachment) { a.Name = "" },
+		func(a *model.Attachment) { a.Size = 0 },
+		func(a *model.Attachment) { a.ContentType = "" },
+		func(a *model.Attachment) { a.Checksum = "abc" },
+		func(a *model.Attachment) {
+			a.Checksum = "zz86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		},
+	} {
+		a := valid
+		modify(&a)
+		if err := validFile(a); err != ErrInvalidFile {
+			t.Errorf("%d: expected=%v got=%v", i, ErrInvalidFile, err)
+		}
+	}
+}
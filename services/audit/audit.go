@@ -0,0 +1,134 @@
+// Package audit keeps append-only log of changes made to Clients, Freelancers, Tasks and their balances.
+//
+// Services write an entry within the transaction of the change, so the entry is kept
+// if and only if the change is committed. The entry records the actor the request was made
+// on behalf of and fields of the entity that differ before and after the change.
+// Entries are never updated or deleted, the database rejects such statements
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+)
+
+// System represents actor of the changes made by the services on their own, e.g. automatic approval of Tasks
+const System = "system"
+
+const (
+	// defaultLimit and maxLimit represent number of entries returned per page
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// ErrInvalidQuery represents error returned for negative limit or offset
+var ErrInvalidQuery = rpc.Errorf(rpc.CodeInvalid, "invalid query")
+
+// Service represents Audit service that exposes the audit log to operators
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Audit service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	return rpc.Register(srv, api.AuditList, s.List)
+}
+
+// List returns page of the entries matching the query, newest first
+func (s *Service) List(ctx context.Context, q model.AuditQuery) ([]model.AuditEntry, error) {
+	entries := []model.AuditEntry{}
+	if q.Limit < 0 || q.Offset < 0 {
+		return entries, ErrInvalidQuery
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultLimit
+	} else if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	query := "SELECT id, actor, action, entity, entity_id, before, after, created_at FROM audit_log " +
+		"WHERE ($1 = '' OR entity = $1) AND ($2 = '' OR entity_id = $2) AND ($3 = '' OR actor = $3) ORDER BY seq DESC LIMIT $4 OFFSET $5"
+	err := s.db.SelectContext(ctx, &entries, query, q.Entity, q.EntityID, q.Actor, q.Limit, q.Offset)
+	return entries, err
+}
+
+// Record writes change of the entity to the audit log within given transaction.
+// Before and after represent the entity as it was and as it is, before is nil for created entity.
+// Only fields that differ are recorded and change that did not alter any field is not recorded.
+// Actor is taken from the context, System if it has none
+func Record(ctx context.Context, tx *sqlx.Tx, action model.AuditAction, entity, entityID string, before, after interface{}) error {
+	b, a, err := Diff(before, after)
+	if err != nil || b == nil {
+		return err
+	}
+	actor := rpc.ActorOf(ctx)
+	if actor == "" {
+		actor = System
+	}
+	// JSON is passed as text, lib/pq sends []byte in binary format jsonb does not accept
+	insertS := "INSERT INTO audit_log (id, actor, action, entity, entity_id, before, after, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)"
+	_, err = tx.Exec(insertS, model.NewID(), actor, action, entity, entityID, string(b), string(a), time.Now().UTC())
+	return err
+}
+
+// Diff returns JSON objects with fields of before and after that differ, field missing on one side is
+// omitted from its object. Nil value has no fields. Both objects are nil when nothing differs
+func Diff(before, after interface{}) (json.RawMessage, json.RawMessage, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	changedB, changedA := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	for k, v := range b {
+		if w, ok := a[k]; !ok || !bytes.Equal(v, w) {
+			changedB[k] = v
+		}
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			changedA[k] = v
+		}
+	}
+	if len(changedB) == 0 && len(changedA) == 0 {
+		return nil, nil, nil
+	}
+	dB, err := json.Marshal(changedB)
+	if err != nil {
+		return nil, nil, err
+	}
+	dA, err := json.Marshal(changedA)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dB, dA, nil
+}
+
+// fields returns top-level fields of v encoded as JSON object
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	m := map[string]json.RawMessage{}
+	if v == nil {
+		return m, nil
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(d, &m)
+}
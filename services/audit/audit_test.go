@@ -0,0 +1,174 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+var appendOnly = `CREATE OR REPLACE FUNCTION AUDIT_LOG_APPEND_ONLY() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit log is append-only';
+END
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS AUDIT_LOG_APPEND_ONLY ON AUDIT_LOG;
+CREATE TRIGGER AUDIT_LOG_APPEND_ONLY BEFORE UPDATE OR DELETE OR TRUNCATE ON AUDIT_LOG
+	FOR EACH STATEMENT EXECUTE PROCEDURE AUDIT_LOG_APPEND_ONLY()`
+
+func setUp(t *testing.T) func() {
+	s = &Service{}
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.db = db
+	s.db.Exec(auditSchema)
+	s.db.Exec(appendOnly)
+
+	natsServer := natstest.RunDefaultServer()
+
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jsonConn = natsEncConn
+
+	// subscribe to topics
+	s.init()
+	return func() {
+		s.db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+// record writes the change within its own transaction
+func record(t *testing.T, ctx context.Context, action model.AuditAction, id string, before, after interface{}) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(ctx, tx, action, "client", id, before, after); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_List(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	id := model.NewID()
+	created := model.Client{ID: id, Email: "client@email.com"}
+	updated := model.Client{ID: id, Email: "new@email.com"}
+	record(t, rpc.WithActor(ctx, id), model.AuditCreate, id, nil, created)
+	// unchanged entity is not recorded
+	record(t, rpc.WithActor(ctx, id), model.AuditUpdate, id, updated, updated)
+	record(t, rpc.WithActor(ctx, "ops"), model.AuditUpdate, id, created, updated)
+	record(t, ctx, model.AuditDelete, id, updated, nil)
+
+	entries, err := rpc.Call(ctx, s.jsonConn.Conn, api.AuditList, model.AuditQuery{Entity: "client", EntityID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	// newest first
+	for i, want := range []struct {
+		action model.AuditAction
+		actor  string
+	}{{model.AuditDelete, System}, {model.AuditUpdate, "ops"}, {model.AuditCreate, id}} {
+		if entries[i].Action != want.action || entries[i].Actor != want.actor {
+			t.Errorf("%d: expected=%s %s got=%s %s", i, want.action, want.actor, entries[i].Action, entries[i].Actor)
+		}
+	}
+	if before, after := string(entries[1].Before), string(entries[1].After); before != `{"Email": "client@email.com"}` || after != `{"Email": "new@email.com"}` {
+		t.Errorf("unexpected update diff: %s -> %s", before, after)
+	}
+
+	page, err := s.List(ctx, model.AuditQuery{EntityID: id, Actor: "ops"})
+	if err != nil || len(page) != 1 {
+		t.Errorf("expected one entry by ops, got %d %v", len(page), err)
+	}
+	page, err = s.List(ctx, model.AuditQuery{EntityID: id, Limit: 1, Offset: 2})
+	if err != nil || len(page) != 1 || page[0].Action != model.AuditCreate {
+		t.Errorf("expected created entry on the last page, got %+v %v", page, err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.AuditList, model.AuditQuery{Limit: -1}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+
+	// entries can not be changed
+	if _, err := s.db.Exec("UPDATE audit_log SET actor='nobody' WHERE entity_id=$1", id); err == nil {
+		t.Error("expected update of the log rejected")
+	}
+	if _, err := s.db.Exec("DELETE FROM audit_log WHERE entity_id=$1", id); err == nil {
+		t.Error("expected delete from the log rejected")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type entity struct {
+		Name   string      `json:"name"`
+		Amount model.Money `json:"amount"`
+		Tags   []string    `json:"tags,omitempty"`
+	}
+	tests := []struct {
+		name          string
+		before, after interface{}
+		wantB, wantA  string
+	}{
+		{name: "create", after: entity{Name: "a"}, wantB: `{}`, wantA: `{"amount":{"amount":0,"currency":""},"name":"a"}`},
+		{name: "delete", before: entity{Name: "a"}, wantB: `{"amount":{"amount":0,"currency":""},"name":"a"}`, wantA: `{}`},
+		{name: "update", before: entity{Name: "a", Amount: model.NewMoney(100, model.USD)}, after: entity{Name: "b", Amount: model.NewMoney(100, model.USD)},
+			wantB: `{"name":"a"}`, wantA: `{"name":"b"}`},
+		{name: "added field", before: entity{Name: "a"}, after: entity{Name: "a", Tags: []string{"go"}}, wantB: `{}`, wantA: `{"tags":["go"]}`},
+		{name: "unchanged", before: entity{Name: "a"}, after: entity{Name: "a"}},
+		{name: "nothing", before: nil, after: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, a, err := Diff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.wantB || string(a) != tt.wantA {
+				t.Errorf("expected=%s -> %s got=%s -> %s", tt.wantB, tt.wantA, b, a)
+			}
+		})
+	}
+}
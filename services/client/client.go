@@ -1,43 +1,63 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/fx"
 	"github.com/kylycht/md/model"
-	"github.com/nats-io/go-nats"
-	"github.com/sirupsen/logrus"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/audit"
+	nats "github.com/nats-io/nats.go"
 )
 
 // Service represents Client service
 type Service struct {
 	db       *sqlx.DB
 	jsonConn *nats.EncodedConn
+
+	rates fx.Source
 }
 
 // NewService returns new instance of Client service
-func NewService(db *sqlx.DB, natsClient *nats.EncodedConn) (*Service, error) {
+func NewService(db *sqlx.DB, natsClient *nats.EncodedConn, opts ...Option) (*Service, error) {
 	srv := &Service{db: db, jsonConn: natsClient}
+	for _, opt := range opts {
+		opt(srv)
+	}
 	return srv, srv.init()
 }
 
 func (s *Service) init() error {
-	if _, err := s.jsonConn.QueueSubscribe("client.add", "client-queue", s.New); err != nil {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.ClientAdd, s.New); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.ClientGet, s.Get); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.ClientUpdate, s.Update); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.ClientList, s.List); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("client.get", "client-queue", s.Get); err != nil {
+	if err := rpc.Register(srv, api.ClientDelete, s.Delete); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("client.update", "client-queue", s.Update); err != nil {
+	if err := rpc.Register(srv, api.ClientReserve, s.Reserve); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("client.list", "client-queue", s.List); err != nil {
+	if err := rpc.Register(srv, api.ClientConfirm, s.Confirm); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("client.delete", "client-queue", s.Delete); err != nil {
+	if err := rpc.Register(srv, api.ClientRelease, s.Release); err != nil {
 		return err
 	}
 
@@ -45,117 +65,122 @@ func (s *Service) init() error {
 }
 
 // New will perform DB insert operation for the given Client
-func (s *Service) New(subject, reply string, t *model.Client) error {
+func (s *Service) New(ctx context.Context, t model.Client) (rpc.Empty, error) {
 	insertS := "INSERT INTO client (id, email, balance) VALUES($1, $2, $3)"
 
-	if res, err := s.db.Exec(insertS, t.ID, t.Email, t.Balance); err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-	} else if c, err := res.RowsAffected(); c == 0 || err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+	res, err := s.execWithEvent(ctx, model.AuditCreate, events.ClientCreated, t.ID, t, insertS, t.ID, t.Email, t.Balance)
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return rpc.Empty{}, err
+	} else if c == 0 {
+		return rpc.Empty{}, sql.ErrNoRows
 	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
+	return rpc.Empty{}, nil
+}
+
+// execWithEvent executes query changing the Client by ID, records the change in the audit log
+// and writes domain event to the outbox within single transaction
+func (s *Service) execWithEvent(ctx context.Context, action model.AuditAction, t events.Type, id string, payload interface{}, query string, args ...interface{}) (sql.Result, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	var before interface{}
+	if action != model.AuditCreate {
+		current := model.Client{}
+		if err := tx.Get(&current, "SELECT * FROM client WHERE id=$1 FOR UPDATE", id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		before = current
+	}
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	after := model.Client{}
+	if err := tx.Get(&after, "SELECT * FROM client WHERE id=$1", id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := audit.Record(ctx, tx, action, "client", id, before, after); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := events.Record(tx, "client", t, id, payload); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return res, tx.Commit()
 }
 
 // Update will perform DB update operation for the given Client
 // TODO: Write better query builder using reflect package
-func (s *Service) Update(subject, reply string, t *model.Client) {
+func (s *Service) Update(ctx context.Context, t model.Client) (rpc.Empty, error) {
 
 	var (
 		updateS  strings.Builder
 		position = 1
 		args     []interface{}
 		update   = "UPDATE client SET "
-		err      error
 	)
 
-	defer func() {
-		if err != nil {
-			s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-			return
-		}
-		s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
-	}()
-
-	if _, err = updateS.WriteString(update); err != nil {
-		return
-	}
+	updateS.WriteString(update)
 
 	if len(t.Email) > 0 {
-		if _, err = updateS.WriteString(fmt.Sprintf("email=$%d ", position)); err != nil {
-			return
-		}
+		updateS.WriteString(fmt.Sprintf("email=$%d ", position))
 		position++
 		args = append(args, t.Email)
 	}
 
-	if t.Balance > 0 {
+	if t.Balance.Amount > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(","); err != nil {
-				return
-			}
-		}
-		if _, err = updateS.WriteString(fmt.Sprintf("balance=$%d ", position)); err != nil {
-			return
+			updateS.WriteString(",")
 		}
+		updateS.WriteString(fmt.Sprintf("balance=$%d ", position))
 		position++
 		args = append(args, t.Balance)
 	}
 
 	if update == updateS.String() {
-		return
+		return rpc.Empty{}, nil
 	}
 
-	if _, err = updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position)); err != nil {
-		return
-	}
+	updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position))
 	args = append(args, t.ID)
 
-	if _, err = s.db.Exec(updateS.String(), args...); err != nil {
-		return
-	}
+	_, err := s.execWithEvent(ctx, model.AuditUpdate, events.ClientUpdated, t.ID, t, updateS.String(), args...)
+	return rpc.Empty{}, err
 }
 
 // Delete will peform soft delete and set deleted_at datetime
-func (s *Service) Delete(subject, reply, id string) error {
+func (s *Service) Delete(ctx context.Context, id string) (rpc.Empty, error) {
 	if len(id) != 36 {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return rpc.Empty{}, model.ErrInvalidID
 	}
 	delS := "UPDATE Client SET deleted_at=$1 WHERE id=$2"
-	if _, err := s.db.Exec(delS, time.Now(), id); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(reply, &model.NATSMsg{Success: true})
+	_, err := s.execWithEvent(ctx, model.AuditDelete, events.ClientDeleted, id, model.Client{ID: id}, delS, time.Now(), id)
+	return rpc.Empty{}, err
 }
 
 // Get will perform DB select operation and retrieve Client by given ID
-func (s *Service) Get(subject, reply, id string) error {
+func (s *Service) Get(ctx context.Context, id string) (model.Client, error) {
+	client := model.Client{}
 	if len(id) != 36 {
-		logrus.WithField("id", id).Error("invalid id")
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return client, model.ErrInvalidID
 	}
-	client := model.Client{}
 	query := "SELECT * FROM client WHERE id = $1"
-
-	if err := s.db.Get(&client, query, id); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	d, err := json.Marshal(&client)
-	if err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true, Data: d})
+	err := s.db.GetContext(ctx, &client, query, id)
+	return client, err
 }
 
 // List will perform DB select operation and retrieve all Clients by give owner(client)
-func (s *Service) List(msg *nats.Msg) error {
+func (s *Service) List(ctx context.Context, _ rpc.Empty) ([]model.Client, error) {
 	query := "SELECT * FROM client WHERE deleted_at IS NULL"
 	clients := []model.Client{}
-	if err := s.db.Select(&clients, query); err != nil {
-		return s.jsonConn.Publish(msg.Reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	d, err := json.Marshal(&clients)
-	if err != nil {
-		return s.jsonConn.Publish(msg.Reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(msg.Reply, model.NATSMsg{Success: true, Data: d})
+	err := s.db.SelectContext(ctx, &clients, query)
+	return clients, err
 }
@@ -1,15 +1,18 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
 	"github.com/kylycht/md/model"
-	"github.com/nats-io/gnatsd/server"
-	gnatsd "github.com/nats-io/gnatsd/test"
-	nats "github.com/nats-io/go-nats"
+	"github.com/kylycht/md/rpc"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
 )
 
 var s *Service
@@ -18,15 +21,60 @@ var s *Service
 
  */
 
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
 var clientSchema = `CREATE TABLE CLIENT (
 	ID varchar(36) PRIMARY KEY NOT NULL,
-	BALANCE bigint,
+	BALANCE MONEY_AMOUNT,
 	EMAIL varchar(128),
     DELETED_AT timestamp
 )`
 
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+var walletSchema = `CREATE TABLE WALLET (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	BALANCE MONEY_AMOUNT NOT NULL
+)`
+
+var reservationSchema = `CREATE TABLE RESERVATION (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	AMOUNT MONEY_AMOUNT,
+	WITHDRAWN MONEY_AMOUNT,
+	STATUS varchar NOT NULL,
+	CREATED_AT timestamp,
+	UPDATED_AT timestamp
+)`
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
 func startServer() *server.Server {
-	return gnatsd.RunDefaultServer()
+	return natstest.RunDefaultServer()
 }
 
 func setUp(t *testing.T) func() {
@@ -41,7 +89,13 @@ func setUp(t *testing.T) func() {
 	}
 
 	s.db = db
+	s.db.Exec(moneyType)
 	s.db.Exec(clientSchema)
+	s.db.Exec(outboxSchema)
+	s.db.Exec(outboxIndex)
+	s.db.Exec(walletSchema)
+	s.db.Exec(reservationSchema)
+	s.db.Exec(auditSchema)
 
 	natsServer := startServer()
 
@@ -81,7 +135,7 @@ func populateDB(t *testing.T) string {
 }
 
 func NewClient() model.Client {
-	return model.NewClient("imail@email.com", 123456789)
+	return model.NewClient("imail@email.com", model.NewMoney(123456789, model.USD))
 }
 
 func TestService_Create(t *testing.T) {
@@ -167,7 +221,7 @@ func TestService_List(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	client2 := model.NewClient("aaa@email.com", 99999999)
+	client2 := model.NewClient("aaa@email.com", model.NewMoney(99999999, model.USD))
 
 	if err := s.jsonConn.Request("client.add", client2, reply, time.Second*10); err != nil {
 		t.Error(err)
@@ -229,7 +283,7 @@ func TestService_Update(t *testing.T) {
 		},
 		{
 			name:    "update-balance",
-			args:    args{t: model.Client{ID: clientID, Balance: 33333}},
+			args:    args{t: model.Client{ID: clientID, Balance: model.NewMoney(33333, model.USD)}},
 			wantErr: false,
 		},
 		{
@@ -238,7 +292,7 @@ func TestService_Update(t *testing.T) {
 				t: model.Client{
 					ID:      clientID,
 					Email:   "noemail@email.com",
-					Balance: 121313121,
+					Balance: model.NewMoney(121313121, model.USD),
 				},
 			},
 			wantErr: false,
@@ -257,3 +311,143 @@ func TestService_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestService_Audit(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := rpc.WithActor(context.Background(), "user-1")
+	client := NewClient()
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientAdd, client); err != nil {
+		t.Fatal(err)
+	}
+	// the second update changes nothing and is not recorded
+	for i := 0; i < 2; i++ {
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientUpdate, model.Client{ID: client.ID, Email: "new@email.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientDelete, client.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientUpdate, model.Client{ID: model.NewID(), Email: "new@email.com"}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+
+	entries := []model.AuditEntry{}
+	query := "SELECT id, actor, action, entity, entity_id, before, after, created_at FROM audit_log WHERE entity_id=$1 ORDER BY seq"
+	if err := s.db.Select(&entries, query, client.ID); err != nil {
+		t.Fatal(err)
+	}
+	actions := []model.AuditAction{model.AuditCreate, model.AuditUpdate, model.AuditDelete}
+	if len(entries) != len(actions) {
+		t.Fatalf("expected %d entries, got %+v", len(actions), entries)
+	}
+	for i, e := range entries {
+		if e.Action != actions[i] || e.Actor != "user-1" || e.Entity != "client" {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(entries[1].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entries[1].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 || before["Email"] != client.Email || len(after) != 1 || after["Email"] != "new@email.com" {
+		t.Errorf("expected email change only, got before=%v after=%v", before, after)
+	}
+}
+
+func balanceOf(t *testing.T, id string) model.Money {
+	c, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Balance
+}
+
+func TestService_Reservation(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	clientID := populateDB(t)
+	ctx := context.Background()
+	r := model.Reservation{ID: model.NewID(), ClientID: clientID, TaskID: model.NewID(), Amount: model.NewMoney(1000, model.USD)}
+
+	reserved, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved.Status != model.Reserved || reserved.Withdrawn != r.Amount {
+		t.Errorf("unexpected reservation: %+v", reserved)
+	}
+	// repeated request does not withdraw funds twice
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := balanceOf(t, clientID), model.NewMoney(123456789-1000, model.USD); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+
+	for i := 0; i < 2; i++ {
+		released, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientRelease, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if released.Status != model.Released {
+			t.Errorf("expected=%s got=%s", model.Released, released.Status)
+		}
+	}
+	if got, want := balanceOf(t, clientID), model.NewMoney(123456789, model.USD); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientConfirm, r.ID); err == nil || err.Error() != ErrReservationReleased.Error() {
+		t.Errorf("expected=%v got=%v", ErrReservationReleased, err)
+	}
+}
+
+func TestService_ReservationConfirm(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	clientID := populateDB(t)
+	ctx := context.Background()
+	r := model.Reservation{ID: model.NewID(), ClientID: clientID, TaskID: model.NewID(), Amount: model.NewMoney(1000, model.USD)}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, r); err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientConfirm, r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.Status != model.Confirmed {
+		t.Errorf("expected=%s got=%s", model.Confirmed, confirmed.Status)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientRelease, r); err == nil || err.Error() != ErrReservationConfirmed.Error() {
+		t.Errorf("expected=%v got=%v", ErrReservationConfirmed, err)
+	}
+	if got, want := balanceOf(t, clientID), model.NewMoney(123456789-1000, model.USD); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
+
+func TestService_ReleaseBeforeReserve(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	clientID := populateDB(t)
+	ctx := context.Background()
+	r := model.Reservation{ID: model.NewID(), ClientID: clientID, TaskID: model.NewID(), Amount: model.NewMoney(1000, model.USD)}
+	// compensation may arrive before the late reservation request
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientRelease, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, r); err == nil || err.Error() != ErrReservationReleased.Error() {
+		t.Errorf("expected=%v got=%v", ErrReservationReleased, err)
+	}
+	if got, want := balanceOf(t, clientID), model.NewMoney(123456789, model.USD); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
@@ -0,0 +1,14 @@
+package client
+
+import "github.com/kylycht/md/fx"
+
+// Option represents optional configuration of Client service
+type Option func(*Service)
+
+// WithRates sets exchange rates source used to convert reserved amount
+// when Client has no funds in its currency
+func WithRates(src fx.Source) Option {
+	return func(s *Service) {
+		s.rates = src
+	}
+}
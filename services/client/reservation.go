@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/wallet"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrReservationReleased represents error returned when released Reservation is reserved again or confirmed
+	ErrReservationReleased = errors.New("reservation is released")
+	// ErrReservationConfirmed represents error returned when confirmed Reservation is released
+	ErrReservationConfirmed = errors.New("reservation is confirmed")
+)
+
+// Reserve will withdraw funds from Client's account and hold them until the Reservation
+// is confirmed or released. Repeated request with the same ID returns existing Reservation
+func (s *Service) Reserve(ctx context.Context, r model.Reservation) (model.Reservation, error) {
+	if r.Amount.IsNegative() || r.Amount.IsZero() || !r.Amount.Currency.Valid() {
+		return r, rpc.Errorf(rpc.CodeInvalid, "invalid amount")
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return r, err
+	}
+	existing, err := getReservation(tx, r.ID)
+	switch {
+	case err == nil:
+		tx.Rollback()
+		if existing.Status == model.Released {
+			return existing, ErrReservationReleased
+		}
+		return existing, nil
+	case err != sql.ErrNoRows:
+		tx.Rollback()
+		return r, err
+	}
+	if r.Withdrawn, err = wallet.Debit(ctx, tx, wallet.ClientOwner, r.ClientID, r.Amount, s.rates); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	r.Status = model.Reserved
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = pq.NullTime{}
+	if err := insertReservation(tx, r); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// Confirm will confirm Reservation by given ID, confirmed funds can not be released
+func (s *Service) Confirm(ctx context.Context, id string) (model.Reservation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return model.Reservation{}, err
+	}
+	r, err := getReservation(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	switch r.Status {
+	case model.Confirmed:
+		tx.Rollback()
+		return r, nil
+	case model.Released:
+		tx.Rollback()
+		return r, ErrReservationReleased
+	}
+	if err := setReservationStatus(tx, &r, model.Confirmed); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// Release will return reserved funds to Client's account.
+// Releasing unknown Reservation records it as released,
+// so reservation request delivered after the release does not withdraw funds
+func (s *Service) Release(ctx context.Context, req model.Reservation) (model.Reservation, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return req, err
+	}
+	r, err := getReservation(tx, req.ID)
+	if err == sql.ErrNoRows {
+		req.Status = model.Released
+		req.CreatedAt = time.Now()
+		req.UpdatedAt = pq.NullTime{Time: req.CreatedAt, Valid: true}
+		if err := insertReservation(tx, req); err != nil {
+			tx.Rollback()
+			return req, err
+		}
+		return req, tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	switch r.Status {
+	case model.Released:
+		tx.Rollback()
+		return r, nil
+	case model.Confirmed:
+		tx.Rollback()
+		return r, ErrReservationConfirmed
+	}
+	if err := wallet.Credit(ctx, tx, wallet.ClientOwner, r.ClientID, r.Withdrawn); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	if err := setReservationStatus(tx, &r, model.Released); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+func getReservation(tx *sqlx.Tx, id string) (model.Reservation, error) {
+	r := model.Reservation{}
+	err := tx.Get(&r, "SELECT * FROM reservation WHERE id = $1 FOR UPDATE", id)
+	return r, err
+}
+
+func insertReservation(tx *sqlx.Tx, r model.Reservation) error {
+	_, err := tx.Exec("INSERT INTO reservation (id, client_id, task_id, amount, withdrawn, status, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)",
+		r.ID, r.ClientID, r.TaskID, r.Amount, r.Withdrawn, r.Status, r.CreatedAt, r.UpdatedAt)
+	return err
+}
+
+func setReservationStatus(tx *sqlx.Tx, r *model.Reservation, status model.ReservationStatus) error {
+	r.Status = status
+	r.UpdatedAt = pq.NullTime{Time: time.Now(), Valid: true}
+	_, err := tx.Exec("UPDATE reservation SET status=$1, updated_at=$2 WHERE id=$3", r.Status, r.UpdatedAt, r.ID)
+	return err
+}
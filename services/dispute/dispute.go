@@ -0,0 +1,334 @@
+package dispute
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/audit"
+	"github.com/kylycht/md/services/wallet"
+	"github.com/lib/pq"
+	nats "github.com/nats-io/nats.go"
+)
+
+// source represents the service in domain events
+const source = "dispute"
+
+var (
+	// ErrNotDisputable represents error returned when Dispute is opened on the Task that is neither started nor completed
+	ErrNotDisputable = rpc.Errorf(rpc.CodeInvalid, "task can not be disputed")
+	// ErrNotParty represents error returned when Dispute is opened or statement is submitted by someone other than Client or Freelancer of the Task
+	ErrNotParty = rpc.Errorf(rpc.CodePermission, "not a party of the task")
+	// ErrNotOpen represents error returned when Dispute is already resolved
+	ErrNotOpen = rpc.Errorf(rpc.CodeInvalid, "dispute is not open")
+	// ErrInvalidSplit represents error returned when payout amounts do not sum up to the amount held in escrow
+	ErrInvalidSplit = rpc.Errorf(rpc.CodeInvalid, "payout does not match amount held in escrow")
+	// ErrNoLockedFunds represents error returned when Dispute is opened on the Task without funds held in escrow
+	ErrNoLockedFunds = rpc.Errorf(rpc.CodeInvalid, "no locked funds for the task")
+	// ErrNoFreelancer represents error returned when payout to Freelancer is requested for the Task without Freelancer
+	ErrNoFreelancer = rpc.Errorf(rpc.CodeInvalid, "task has no freelancer assigned")
+)
+
+// Service represents Dispute service that will handle
+// Dispute related DB operations and escrow payouts
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Dispute service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.DisputeOpen, s.Open); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.DisputeGet, s.Get); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.DisputeList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.DisputeStatement, s.AddStatement); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.DisputeResolve, s.Resolve); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Open will open Dispute on the Task and freeze funds held in escrow
+func (s *Service) Open(ctx context.Context, d model.Dispute) (rpc.Empty, error) {
+	return rpc.Empty{}, s.open(ctx, &d)
+}
+
+func (s *Service) open(ctx context.Context, d *model.Dispute) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	task := model.Task{}
+	if err := tx.Get(&task, "SELECT * FROM task WHERE id = $1 FOR UPDATE", d.TaskID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if task.Status != model.Started && task.Status != model.Completed {
+		tx.Rollback()
+		return ErrNotDisputable
+	}
+	if d.OpenedBy == "" || (d.OpenedBy != task.ClientID && d.OpenedBy != task.FreelancerID) {
+		tx.Rollback()
+		return ErrNotParty
+	}
+	// freeze escrow
+	if res, err := tx.Exec("UPDATE billing SET status=$1 WHERE task_id=$2 AND status=$3", model.Frozen, task.ID, model.Locked); err != nil {
+		tx.Rollback()
+		return err
+	} else if c, err := res.RowsAffected(); c == 0 || err != nil {
+		tx.Rollback()
+		return ErrNoLockedFunds
+	}
+	if _, err := tx.Exec("UPDATE task SET status=$1, updated_at=$2 WHERE id=$3", model.Disputed, time.Now(), task.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := auditTask(ctx, tx, task); err != nil {
+		tx.Rollback()
+		return err
+	}
+	d.Status = model.DisputeOpen
+	d.TaskStatus = task.Status
+	insertS := "INSERT INTO dispute (id, task_id, opened_by, reason, status, task_status, created_at) VALUES($1, $2, $3, $4, $5, $6, $7)"
+	if _, err := tx.Exec(insertS, d.ID, d.TaskID, d.OpenedBy, d.Reason, d.Status, d.TaskStatus, d.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := events.Record(tx, source, events.DisputeOpened, d.ID, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := events.Record(tx, source, events.TaskStatusChanged, task.ID, events.StatusChange{TaskID: task.ID, From: task.Status, To: model.Disputed, ClientID: task.ClientID, FreelancerID: task.FreelancerID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddStatement will add statement and evidence of a party to the open Dispute
+func (s *Service) AddStatement(ctx context.Context, st model.DisputeStatement) (rpc.Empty, error) {
+	return rpc.Empty{}, s.addStatement(&st)
+}
+
+func (s *Service) addStatement(st *model.DisputeStatement) error {
+	d, task, err := s.getDisputeWithTask(st.DisputeID)
+	if err != nil {
+		return err
+	}
+	if d.Status != model.DisputeOpen {
+		return ErrNotOpen
+	}
+	if st.AuthorID == "" || (st.AuthorID != task.ClientID && st.AuthorID != task.FreelancerID) {
+		return ErrNotParty
+	}
+	if st.ID == "" {
+		st.ID = model.NewID()
+	}
+	st.CreatedAt = time.Now()
+	insertS := "INSERT INTO dispute_statement (id, dispute_id, author_id, statement, evidence, created_at) VALUES($1, $2, $3, $4, $5, $6)"
+	_, err = s.db.Exec(insertS, st.ID, st.DisputeID, st.AuthorID, st.Statement, st.Evidence, st.CreatedAt)
+	return err
+}
+
+// Resolve will resolve the Dispute and pay out funds held in escrow
+// to Freelancer and Client according to the given split.
+// All balance, billing, task and dispute changes are applied in a single transaction.
+// The Dispute is resolved by the actor of the request when it has one
+func (s *Service) Resolve(ctx context.Context, r model.Dispute) (rpc.Empty, error) {
+	return rpc.Empty{}, s.resolve(ctx, &r)
+}
+
+func (s *Service) resolve(ctx context.Context, r *model.Dispute) error {
+	if r.FreelancerAmount.IsNegative() || r.ClientAmount.IsNegative() {
+		return ErrInvalidSplit
+	}
+	if actor := rpc.ActorOf(ctx); actor != "" {
+		r.ResolvedBy = sql.NullString{String: actor, Valid: true}
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	d := model.Dispute{}
+	if err = tx.Get(&d, "SELECT * FROM dispute WHERE id = $1 FOR UPDATE", r.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if d.Status != model.DisputeOpen {
+		tx.Rollback()
+		return ErrNotOpen
+	}
+	task := model.Task{}
+	if err := tx.Get(&task, "SELECT * FROM task WHERE id = $1 FOR UPDATE", d.TaskID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	payment := model.Payment{}
+	if err := tx.Get(&payment, "SELECT id, client_id, task_id, amount, status FROM billing WHERE task_id = $1 AND status = $2 FOR UPDATE",
+		d.TaskID, model.Frozen); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// zero part of the payout takes escrow currency
+	if r.FreelancerAmount.IsZero() {
+		r.FreelancerAmount = model.NewMoney(0, payment.Amount.Currency)
+	}
+	if r.ClientAmount.IsZero() {
+		r.ClientAmount = model.NewMoney(0, payment.Amount.Currency)
+	}
+	total, err := r.FreelancerAmount.Add(r.ClientAmount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if total != payment.Amount {
+		tx.Rollback()
+		return ErrInvalidSplit
+	}
+	now := time.Now()
+
+	if !r.FreelancerAmount.IsZero() {
+		if task.FreelancerID == "" {
+			tx.Rollback()
+			return ErrNoFreelancer
+		}
+		if err := wallet.Credit(ctx, tx, wallet.FreelancerOwner, task.FreelancerID, r.FreelancerAmount); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := execOne(tx, "UPDATE billing SET status=$1, amount=$2, paid_date=$3, freelancer_id=$4 WHERE id=$5",
+			model.Paid, r.FreelancerAmount, now, task.FreelancerID, payment.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		paid := model.Payment{ID: payment.ID, ClientID: payment.ClientID, FreelancerID: task.FreelancerID, TaskID: task.ID,
+			Amount: r.FreelancerAmount, PaidDate: now, Status: model.Paid}
+		if err := events.Record(tx, source, events.PaymentPaid, paid.ID, paid); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		if err := execOne(tx, "UPDATE billing SET status=$1, paid_date=$2 WHERE id=$3", model.Refunded, now, payment.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if !r.ClientAmount.IsZero() {
+		if err := wallet.Credit(ctx, tx, wallet.ClientOwner, task.ClientID, r.ClientAmount); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// split payout keeps separate billing record for the refunded part
+		if !r.FreelancerAmount.IsZero() {
+			if err := execOne(tx, "INSERT INTO billing(id, client_id, task_id, amount, status, paid_date) VALUES($1,$2,$3,$4,$5,$6)",
+				model.NewID(), task.ClientID, task.ID, r.ClientAmount, model.Refunded, now); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if err := execOne(tx, "UPDATE task SET status=$1, updated_at=$2 WHERE id=$3", model.Closed, now, task.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := auditTask(ctx, tx, task); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := execOne(tx, "UPDATE dispute SET status=$1, freelancer_amount=$2, client_amount=$3, resolution=$4, resolved_by=$5, resolved_at=$6 WHERE id=$7",
+		model.DisputeResolved, r.FreelancerAmount, r.ClientAmount, r.Resolution, r.ResolvedBy, now, d.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	d.Status = model.DisputeResolved
+	d.FreelancerAmount, d.ClientAmount = r.FreelancerAmount, r.ClientAmount
+	d.Resolution, d.ResolvedBy = r.Resolution, r.ResolvedBy
+	d.ResolvedAt = pq.NullTime{Time: now, Valid: true}
+	if err := events.Record(tx, source, events.DisputeResolved, d.ID, d); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := events.Record(tx, source, events.TaskStatusChanged, task.ID, events.StatusChange{TaskID: task.ID, From: model.Disputed, To: model.Closed, ClientID: task.ClientID, FreelancerID: task.FreelancerID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get will perform DB select operation and retrieve Dispute with statements by given ID
+func (s *Service) Get(ctx context.Context, id string) (model.Dispute, error) {
+	d := model.Dispute{}
+	if len(id) != 36 {
+		return d, model.ErrInvalidID
+	}
+	if err := s.db.GetContext(ctx, &d, "SELECT * FROM dispute WHERE id = $1", id); err != nil {
+		return d, err
+	}
+	err := s.db.SelectContext(ctx, &d.Statements, "SELECT * FROM dispute_statement WHERE dispute_id = $1 ORDER BY created_at ASC", id)
+	return d, err
+}
+
+// List will perform DB select operation and retrieve all Disputes by given Task ID
+func (s *Service) List(ctx context.Context, taskID string) ([]model.Dispute, error) {
+	disputes := []model.Dispute{}
+	err := s.db.SelectContext(ctx, &disputes, "SELECT * FROM dispute WHERE task_id = $1 ORDER BY created_at ASC", taskID)
+	return disputes, err
+}
+
+func (s *Service) getDisputeWithTask(id string) (model.Dispute, model.Task, error) {
+	d := model.Dispute{}
+	task := model.Task{}
+	if err := s.db.Get(&d, "SELECT * FROM dispute WHERE id = $1", id); err != nil {
+		return d, task, err
+	}
+	if err := s.db.Get(&task, "SELECT * FROM task WHERE id = $1", d.TaskID); err != nil {
+		return d, task, err
+	}
+	return d, task, nil
+}
+
+// auditTask records change of the Task by the Dispute in the audit log within given transaction,
+// before represents the Task as it was
+func auditTask(ctx context.Context, tx *sqlx.Tx, before model.Task) error {
+	after := model.Task{}
+	if err := tx.Get(&after, "SELECT * FROM task WHERE id = $1", before.ID); err != nil {
+		return err
+	}
+	return audit.Record(ctx, tx, model.AuditUpdate, "task", before.ID, before, after)
+}
+
+// execOne executes query and expects exactly one affected row
+func execOne(tx *sqlx.Tx, query string, args ...interface{}) error {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package dispute
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var billingSchema = `CREATE TABLE BILLING (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36),
+	PAID_DATE timestamp,
+	STATUS varchar,
+	AMOUNT MONEY_AMOUNT,
+	TASK_ID varchar(36),
+	REFERENCE varchar(128) UNIQUE
+)`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var disputeSchema = `CREATE TABLE DISPUTE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	OPENED_BY varchar(36) NOT NULL,
+	REASON text,
+	STATUS varchar,
+	TASK_STATUS varchar,
+	FREELANCER_AMOUNT MONEY_AMOUNT,
+	CLIENT_AMOUNT MONEY_AMOUNT,
+	RESOLUTION text,
+	RESOLVED_BY varchar(36),
+	CREATED_AT timestamp,
+	RESOLVED_AT timestamp
+)`
+
+var disputeStatementSchema = `CREATE TABLE DISPUTE_STATEMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	DISPUTE_ID varchar(36) NOT NULL,
+	AUTHOR_ID varchar(36) NOT NULL,
+	STATEMENT text,
+	EVIDENCE text[],
+	CREATED_AT timestamp
+)`
+
+var walletSchema = `CREATE TABLE WALLET (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	BALANCE MONEY_AMOUNT NOT NULL
+)`
+
+var walletIndex = `CREATE UNIQUE INDEX WALLET_OWNER_CURRENCY ON WALLET (OWNER_ID, ((BALANCE).CURRENCY))`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+func startServer() *server.Server {
+	return natstest.RunDefaultServer()
+}
+
+func setUp(t *testing.T) func() {
+	s = &Service{}
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.db = db
+	s.db.Exec(moneyType)
+	s.db.Exec(taskSchema)
+	s.db.Exec(billingSchema)
+	s.db.Exec(clientSchema)
+	s.db.Exec(freelancerSchema)
+	s.db.Exec(disputeSchema)
+	s.db.Exec(disputeStatementSchema)
+	s.db.Exec(walletSchema)
+	s.db.Exec(walletIndex)
+	s.db.Exec(outboxSchema)
+	s.db.Exec(outboxIndex)
+	s.db.Exec(auditSchema)
+
+	natsServer := startServer()
+
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !natsConn.IsConnected() {
+		t.Fatal("no nats connection")
+	}
+
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jsonConn = natsEncConn
+
+	// subscribe to topics
+	s.init()
+	return func() {
+		s.db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+// populateDB inserts client, freelancer and task with the given status and funds locked in escrow
+func populateDB(t *testing.T, status model.TaskStatus) model.Task {
+	client := model.NewClient("client@email.com", model.NewMoney(0, model.EUR))
+	freelancer := model.NewFreelancer("freelancer@email.com", "dev", "golang")
+	task := model.NewTask(time.Hour*24, model.NewMoney(2000, model.EUR), client.ID, "golang app")
+	task.FreelancerID = freelancer.ID
+	task.Status = status
+
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3)", client.ID, client.Email, client.Balance); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, email, description, details, balance) VALUES($1, $2, $3, $4, $5)",
+		freelancer.ID, freelancer.Email, freelancer.Description, freelancer.Details, model.NewMoney(0, model.EUR)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.Status); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO billing (id, client_id, task_id, amount, status) VALUES($1, $2, $3, $4, $5)",
+		model.NewID(), client.ID, task.ID, task.Fee, model.Locked); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestFlow(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t, model.Completed)
+	dispute := model.NewDispute(task.ID, task.ClientID, "work was not delivered")
+	reply := &model.NATSMsg{}
+	// Open dispute
+	if err := s.jsonConn.Request("dispute.open", dispute, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	var status model.PaymentStatus
+	if err := s.db.Get(&status, "SELECT status FROM billing WHERE task_id = $1", task.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if status != model.Frozen {
+		t.Errorf("expected=%s got=%s", model.Frozen, status)
+	}
+	// Submit statement
+	statement := model.DisputeStatement{DisputeID: dispute.ID, AuthorID: task.FreelancerID, Statement: "work was delivered", Evidence: []string{"https://example.com"}}
+	if err := s.jsonConn.Request("dispute.statement", statement, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	// Resolve with split payout
+	// resolver in the request is replaced by the actor
+	resolution := model.Dispute{ID: dispute.ID, FreelancerAmount: model.NewMoney(1500, model.EUR), ClientAmount: model.NewMoney(500, model.EUR), Resolution: sql.NullString{String: "partially delivered", Valid: true},
+		ResolvedBy: sql.NullString{String: task.ClientID, Valid: true}}
+	if _, err := rpc.Call(rpc.WithActor(context.Background(), "ops"), s.jsonConn.Conn, api.DisputeResolve, resolution); err != nil {
+		t.Error(err)
+		return
+	}
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM freelancer WHERE id = $1", task.FreelancerID); err != nil {
+		t.Error(err)
+		return
+	}
+	if balance != model.NewMoney(1500, model.EUR) {
+		t.Errorf("freelancer balance mismatch, expected=%d got=%s", 1500, balance)
+	}
+	if err := s.db.Get(&balance, "SELECT balance FROM client WHERE id = $1", task.ClientID); err != nil {
+		t.Error(err)
+		return
+	}
+	if balance != model.NewMoney(500, model.EUR) {
+		t.Errorf("client balance mismatch, expected=%d got=%s", 500, balance)
+	}
+
+	if err := s.jsonConn.Request("dispute.get", dispute.ID, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	resolved := model.Dispute{}
+	if err := json.Unmarshal(reply.Data, &resolved); err != nil {
+		t.Error(err, string(reply.Data))
+		return
+	}
+	if resolved.Status != model.DisputeResolved {
+		t.Errorf("expected=%s got=%s", model.DisputeResolved, resolved.Status)
+	}
+	if resolved.ResolvedBy.String != "ops" {
+		t.Errorf("expected=%s got=%s", "ops", resolved.ResolvedBy.String)
+	}
+	if len(resolved.Statements) != 1 {
+		t.Errorf("expected=%d statements, got=%d", 1, len(resolved.Statements))
+	}
+}
+
+func TestService_OpenErrors(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	open := populateDB(t, model.Open)
+	started := populateDB(t, model.Started)
+	tests := []struct {
+		name    string
+		dispute model.Dispute
+		want    error
+	}{
+		{name: "not-started", dispute: model.NewDispute(open.ID, open.ClientID, "foo"), want: ErrNotDisputable},
+		{name: "not-party", dispute: model.NewDispute(started.ID, model.NewID(), "foo"), want: ErrNotParty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := &model.NATSMsg{}
+			if err := s.jsonConn.Request("dispute.open", tt.dispute, reply, time.Second*10); err != nil {
+				t.Error(err)
+				return
+			}
+			if reply.Success || reply.Message != tt.want.Error() {
+				t.Errorf("expected=%s got=%s", tt.want, reply.Message)
+			}
+			if reply.Code != string(rpc.CodeOf(tt.want)) {
+				t.Errorf("expected=%s got=%s", rpc.CodeOf(tt.want), reply.Code)
+			}
+		})
+	}
+}
+
+func TestService_ResolveInvalidSplit(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t, model.Started)
+	dispute := model.NewDispute(task.ID, task.FreelancerID, "client does not respond")
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("dispute.open", dispute, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	resolution := model.Dispute{ID: dispute.ID, FreelancerAmount: model.NewMoney(1500, model.EUR), ClientAmount: model.NewMoney(1500, model.EUR)}
+	if err := s.jsonConn.Request("dispute.resolve", resolution, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if reply.Success || reply.Message != ErrInvalidSplit.Error() {
+		t.Errorf("expected=%s got=%s", ErrInvalidSplit, reply.Message)
+	}
+	if reply.Code != string(rpc.CodeInvalid) {
+		t.Errorf("expected=%s got=%s", rpc.CodeInvalid, reply.Code)
+	}
+}
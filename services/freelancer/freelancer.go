@@ -1,14 +1,19 @@
 package freelancer
 
 import (
-	"encoding/json"
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
 	"github.com/kylycht/md/model"
-	"github.com/nats-io/go-nats"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/audit"
+	nats "github.com/nats-io/nats.go"
 )
 
 // Service represents Freelancer service
@@ -24,149 +29,192 @@ func NewService(db *sqlx.DB, natsClient *nats.EncodedConn) (*Service, error) {
 }
 
 func (s *Service) init() error {
-
-	if _, err := s.jsonConn.QueueSubscribe("freelancer.add", "freelancer-queue", s.New); err != nil {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.FreelancerAdd, s.New); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.FreelancerGet, s.Get); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("freelancer.get", "freelancer-queue", s.Get); err != nil {
+	if err := rpc.Register(srv, api.FreelancerUpdate, s.Update); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("freelancer.update", "freelancer-queue", s.Update); err != nil {
+	if err := rpc.Register(srv, api.FreelancerList, s.List); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("freelancer.list", "freelancer-queue", s.List); err != nil {
+	if err := rpc.Register(srv, api.FreelancerDelete, s.Delete); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("freelancer.delete", "freelancer-queue", s.Delete); err != nil {
+	if err := rpc.Register(srv, api.FreelancerSkills, s.SetSkills); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.FreelancerSearch, s.Search); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// New will perform DB insert operation for the given Freelancer
-func (s *Service) New(subject, reply string, t *model.Freelancer) error {
+// New will perform DB insert operation for the given Freelancer along with declared Skills
+func (s *Service) New(ctx context.Context, t model.Freelancer) (rpc.Empty, error) {
+	skills, err := s.checkSkills(ctx, t.Skills)
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	t.Skills = skills
 	insertS := "INSERT INTO freelancer (id, description,details, email) VALUES($1, $2, $3, $4)"
 
-	if res, err := s.db.Exec(insertS, t.ID, t.Description, t.Details, t.Email); err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-	} else if c, err := res.RowsAffected(); c == 0 || err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+	err = s.withEvent(ctx, model.AuditCreate, events.FreelancerCreated, t.ID, t, func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(insertS, t.ID, t.Description, t.Details, t.Email)
+		if err != nil {
+			return err
+		}
+		if c, err := res.RowsAffected(); err != nil {
+			return err
+		} else if c == 0 {
+			return sql.ErrNoRows
+		}
+		return insertSkills(tx, t.ID, skills)
+	})
+	return rpc.Empty{}, err
+}
+
+// execWithEvent executes query changing the Freelancer by ID, records the change in the audit log
+// and writes domain event to the outbox within single transaction
+func (s *Service) execWithEvent(ctx context.Context, action model.AuditAction, t events.Type, id string, payload interface{}, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := s.withEvent(ctx, action, t, id, payload, func(tx *sqlx.Tx) (err error) {
+		res, err = tx.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+// withEvent runs fn changing the Freelancer by ID, records the change in the audit log
+// and writes domain event to the outbox within single transaction
+func (s *Service) withEvent(ctx context.Context, action model.AuditAction, t events.Type, id string, payload interface{}, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	var before interface{}
+	if action != model.AuditCreate {
+		current, err := getFreelancer(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		before = current
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	after, err := getFreelancer(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := audit.Record(ctx, tx, action, "freelancer", id, before, after); err != nil {
+		tx.Rollback()
+		return err
 	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
+	if err := events.Record(tx, "freelancer", t, id, payload); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 // Update will perform DB update operation for the given Freelancer
 // TODO: Write better query builder using reflect package
-func (s *Service) Update(subject, reply string, t *model.Freelancer) {
+func (s *Service) Update(ctx context.Context, t model.Freelancer) (rpc.Empty, error) {
 
 	var (
 		updateS  strings.Builder
 		position = 1
 		args     []interface{}
 		update   = "UPDATE freelancer SET "
-		err      error
 	)
 
-	defer func() {
-		if err != nil {
-			s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-			return
-		}
-		s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
-	}()
-
-	if _, err = updateS.WriteString(update); err != nil {
-		return
-	}
+	updateS.WriteString(update)
 
 	if t.Details.Valid {
-		if _, err = updateS.WriteString(fmt.Sprintf("description=$%d ", position)); err != nil {
-			return
-		}
+		updateS.WriteString(fmt.Sprintf("description=$%d ", position))
 		position++
 		args = append(args, t.Description)
 	}
 
 	if len(t.Email) > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
-		}
-		if _, err = updateS.WriteString(fmt.Sprintf("email=$%d ", position)); err != nil {
-			return
+			updateS.WriteString(", ")
 		}
+		updateS.WriteString(fmt.Sprintf("email=$%d ", position))
 		position++
 		args = append(args, t.Email)
 	}
 
 	if t.Details.Valid {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
-		}
-		if _, err = updateS.WriteString(fmt.Sprintf("details=$%d ", position)); err != nil {
-			return
+			updateS.WriteString(", ")
 		}
+		updateS.WriteString(fmt.Sprintf("details=$%d ", position))
 		position++
 		args = append(args, t.Details)
 	}
 
 	if update == updateS.String() {
-		return
+		return rpc.Empty{}, nil
 	}
 
-	if _, err = updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position)); err != nil {
-		return
-	}
+	updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position))
 	args = append(args, t.ID)
-	if _, err = s.db.Exec(updateS.String(), args...); err != nil {
-		return
-	}
+
+	_, err := s.execWithEvent(ctx, model.AuditUpdate, events.FreelancerUpdated, t.ID, t, updateS.String(), args...)
+	return rpc.Empty{}, err
 }
 
 // Delete will peform soft delete and set deleted_at datetime
-func (s *Service) Delete(subject, reply, id string) error {
+func (s *Service) Delete(ctx context.Context, id string) (rpc.Empty, error) {
 	if len(id) != 36 {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return rpc.Empty{}, model.ErrInvalidID
 	}
 	delS := "UPDATE Freelancer SET deleted_at=$1 WHERE id=$2"
-	if _, err := s.db.Exec(delS, time.Now(), id); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(reply, &model.NATSMsg{Success: true})
+	_, err := s.execWithEvent(ctx, model.AuditDelete, events.FreelancerDeleted, id, model.Freelancer{ID: id}, delS, time.Now(), id)
+	return rpc.Empty{}, err
 }
 
 // Get will perform DB select operation and retrieve Freelancer by given ID
-func (s *Service) Get(subject, reply, id string) error {
+func (s *Service) Get(ctx context.Context, id string) (model.Freelancer, error) {
+	freelancer := model.Freelancer{}
 	if len(id) != 36 {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return freelancer, model.ErrInvalidID
 	}
-	freelancer := model.Freelancer{}
 	query := "SELECT * FROM freelancer WHERE id = $1"
-
-	if err := s.db.Get(&freelancer, query, id); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	d, err := json.Marshal(&freelancer)
-	if err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
+	if err := s.db.GetContext(ctx, &freelancer, query, id); err != nil {
+		return freelancer, err
 	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true, Data: d})
+	list := []model.Freelancer{freelancer}
+	err := s.loadSkills(ctx, list)
+	return list[0], err
 }
 
 // List will perform DB select operation and retrieve all Freelancers by give owner(client)
-func (s *Service) List(msg *nats.Msg) error {
+func (s *Service) List(ctx context.Context, _ rpc.Empty) ([]model.Freelancer, error) {
 	query := "SELECT * FROM freelancer WHERE deleted_at IS NULL"
 	freelancers := []model.Freelancer{}
-	if err := s.db.Select(&freelancers, query); err != nil {
-		return s.jsonConn.Publish(msg.Reply, &model.NATSMsg{Success: false, Message: err.Error()})
+	if err := s.db.SelectContext(ctx, &freelancers, query); err != nil {
+		return freelancers, err
 	}
-	d, err := json.Marshal(&freelancers)
-	if err != nil {
-		return s.jsonConn.Publish(msg.Reply, &model.NATSMsg{Success: false, Message: err.Error()})
+	return freelancers, s.loadSkills(ctx, freelancers)
+}
+
+// getFreelancer returns the Freelancer by ID along with Skills within given transaction, the row is locked for update
+func getFreelancer(tx *sqlx.Tx, id string) (model.Freelancer, error) {
+	f := model.Freelancer{}
+	if err := tx.Get(&f, "SELECT * FROM freelancer WHERE id = $1 FOR UPDATE", id); err != nil {
+		return f, err
 	}
-	return s.jsonConn.Publish(msg.Reply, model.NATSMsg{Success: true, Data: d})
+	err := tx.Select(&f.Skills, "SELECT skill_id, level FROM freelancer_skill WHERE freelancer_id = $1 ORDER BY level DESC, skill_id", id)
+	return f, err
 }
@@ -9,9 +9,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/kylycht/md/model"
 	_ "github.com/lib/pq"
-	"github.com/nats-io/gnatsd/server"
-	gnatsd "github.com/nats-io/gnatsd/test"
-	nats "github.com/nats-io/go-nats"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
 )
 
 var s *Service
@@ -28,8 +28,52 @@ var freelancerSchema = `CREATE TABLE FREELANCER (
     DELETED_AT timestamp
 )`
 
+var categorySchema = `CREATE TABLE CATEGORY (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL
+)`
+
+var skillSchema = `CREATE TABLE SKILL (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	CATEGORY_ID varchar(64) NOT NULL REFERENCES CATEGORY (ID)
+)`
+
+var freelancerSkillSchema = `CREATE TABLE FREELANCER_SKILL (
+	FREELANCER_ID varchar(36) NOT NULL,
+	SKILL_ID varchar(64) NOT NULL REFERENCES SKILL (ID),
+	LEVEL int NOT NULL CHECK (LEVEL BETWEEN 1 AND 3),
+	PRIMARY KEY (FREELANCER_ID, SKILL_ID)
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
 func startServer() *server.Server {
-	return gnatsd.RunDefaultServer()
+	return natstest.RunDefaultServer()
 }
 
 func setUp(t *testing.T) func() {
@@ -45,6 +89,12 @@ func setUp(t *testing.T) func() {
 
 	s.db = db
 	s.db.Exec(freelancerSchema)
+	s.db.Exec(categorySchema)
+	s.db.Exec(skillSchema)
+	s.db.Exec(freelancerSkillSchema)
+	s.db.Exec(outboxSchema)
+	s.db.Exec(outboxIndex)
+	s.db.Exec(auditSchema)
 
 	natsServer := startServer()
 
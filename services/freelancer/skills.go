@@ -0,0 +1,132 @@
+package freelancer
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/skill"
+	"github.com/lib/pq"
+)
+
+const (
+	// defaultLimit and maxLimit represent number of Freelancers returned by search
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// ErrInvalidQuery represents error returned for negative paging of the search
+var ErrInvalidQuery = rpc.Errorf(rpc.CodeInvalid, "invalid query")
+
+// SetSkills will replace Skills declared by the Freelancer, empty list removes every Skill
+func (s *Service) SetSkills(ctx context.Context, f model.Freelancer) (rpc.Empty, error) {
+	if len(f.ID) != 36 {
+		return rpc.Empty{}, model.ErrInvalidID
+	}
+	skills, err := s.checkSkills(ctx, f.Skills)
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	f.Skills = skills
+	err = s.withEvent(ctx, model.AuditUpdate, events.FreelancerUpdated, f.ID, model.Freelancer{ID: f.ID, Skills: skills}, func(tx *sqlx.Tx) error {
+		var id string
+		if err := tx.Get(&id, "SELECT id FROM freelancer WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", f.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM freelancer_skill WHERE freelancer_id = $1", f.ID); err != nil {
+			return err
+		}
+		return insertSkills(tx, f.ID, skills)
+	})
+	return rpc.Empty{}, err
+}
+
+// Search will find Freelancers having every Skill of the query at least at MinLevel,
+// best proficiency in the Skills first. Category narrows the search to Freelancers with a Skill in it
+func (s *Service) Search(ctx context.Context, q model.FreelancerQuery) ([]model.Freelancer, error) {
+	freelancers := []model.Freelancer{}
+	skills, err := skill.Normalize(q.Skills)
+	if err != nil {
+		return freelancers, err
+	}
+	if q.MinLevel != 0 && !q.MinLevel.Valid() {
+		return freelancers, skill.ErrInvalidLevel
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return freelancers, ErrInvalidQuery
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	category := strings.ToLower(strings.TrimSpace(q.Category))
+
+	query := `SELECT f.* FROM freelancer f
+	WHERE f.deleted_at IS NULL
+	AND (SELECT count(*) FROM freelancer_skill fs WHERE fs.freelancer_id = f.id AND fs.skill_id = ANY($1) AND fs.level >= $2) = $3
+	AND ($4 = '' OR EXISTS (SELECT 1 FROM freelancer_skill fs JOIN skill sk ON sk.id = fs.skill_id
+		WHERE fs.freelancer_id = f.id AND sk.category_id = $4 AND fs.level >= $2))
+	ORDER BY (SELECT COALESCE(sum(fs.level), 0) FROM freelancer_skill fs WHERE fs.freelancer_id = f.id AND fs.skill_id = ANY($1)) DESC, f.id
+	LIMIT $5 OFFSET $6`
+	if err := s.db.SelectContext(ctx, &freelancers, query, skills, q.MinLevel, len(skills), category, q.Limit, q.Offset); err != nil {
+		return freelancers, err
+	}
+	return freelancers, s.loadSkills(ctx, freelancers)
+}
+
+// checkSkills normalizes Skills declared by the Freelancer and verifies they exist in the taxonomy
+func (s *Service) checkSkills(ctx context.Context, skills []model.FreelancerSkill) ([]model.FreelancerSkill, error) {
+	skills, err := skill.NormalizeLevels(skills)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(skills))
+	for _, fs := range skills {
+		ids = append(ids, fs.SkillID)
+	}
+	return skills, skill.Check(ctx, s.db, "", ids)
+}
+
+// insertSkills inserts Skills of the Freelancer within given transaction
+func insertSkills(tx *sqlx.Tx, freelancerID string, skills []model.FreelancerSkill) error {
+	for _, fs := range skills {
+		if _, err := tx.Exec("INSERT INTO freelancer_skill (freelancer_id, skill_id, level) VALUES($1, $2, $3)",
+			freelancerID, fs.SkillID, fs.Level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSkills fills Skills of the Freelancers, the most proficient first
+func (s *Service) loadSkills(ctx context.Context, freelancers []model.Freelancer) error {
+	if len(freelancers) == 0 {
+		return nil
+	}
+	ids := make(pq.StringArray, 0, len(freelancers))
+	for _, f := range freelancers {
+		ids = append(ids, f.ID)
+	}
+	rows := []struct {
+		FreelancerID string `db:"freelancer_id"`
+		model.FreelancerSkill
+	}{}
+	query := "SELECT freelancer_id, skill_id, level FROM freelancer_skill WHERE freelancer_id = ANY($1) ORDER BY level DESC, skill_id"
+	if err := s.db.SelectContext(ctx, &rows, query, ids); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	skills := map[string][]model.FreelancerSkill{}
+	for _, r := range rows {
+		skills[r.FreelancerID] = append(skills[r.FreelancerID], r.FreelancerSkill)
+	}
+	for i := range freelancers {
+		freelancers[i].Skills = skills[freelancers[i].ID]
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package freelancer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+// populateSkills inserts Category and Skills unique to the run, the taxonomy is shared by runs
+func populateSkills(t *testing.T) (category string, skills []string) {
+	suffix := model.NewID()[:8]
+	category = "dev-" + suffix
+	skills = []string{"go-" + suffix, "sql-" + suffix, "css-" + suffix}
+	if _, err := s.db.Exec("INSERT INTO category (id, name) VALUES($1, 'Development')", category); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range skills {
+		if _, err := s.db.Exec("INSERT INTO skill (id, name, category_id) VALUES($1, $1, $2)", id, category); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return category, skills
+}
+
+func addFreelancer(t *testing.T, skills ...model.FreelancerSkill) model.Freelancer {
+	f := NewFreelancer()
+	f.Skills = skills
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerAdd, f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestService_Skills(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	_, skills := populateSkills(t)
+	goSkill, sqlSkill := skills[0], skills[1]
+	f := addFreelancer(t, model.FreelancerSkill{SkillID: strings.ToUpper(goSkill), Level: model.Intermediate},
+		model.FreelancerSkill{SkillID: sqlSkill, Level: model.Expert})
+
+	got, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerGet, f.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.FreelancerSkill{{SkillID: sqlSkill, Level: model.Expert}, {SkillID: goSkill, Level: model.Intermediate}}
+	if len(got.Skills) != 2 || got.Skills[0] != expected[0] || got.Skills[1] != expected[1] {
+		t.Errorf("expected=%v got=%v", expected, got.Skills)
+	}
+
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerSkills,
+		model.Freelancer{ID: f.ID, Skills: []model.FreelancerSkill{{SkillID: goSkill, Level: model.Expert}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerGet, f.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Skills) != 1 || got.Skills[0] != (model.FreelancerSkill{SkillID: goSkill, Level: model.Expert}) {
+		t.Errorf("unexpected skills %v", got.Skills)
+	}
+	// replaced Skills are audited
+	var entry model.AuditEntry
+	query := "SELECT id, actor, action, entity, entity_id, before, after, created_at FROM audit_log WHERE entity_id=$1 ORDER BY seq DESC LIMIT 1"
+	if err := s.db.Get(&entry, query, f.ID); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Action != model.AuditUpdate || !strings.Contains(string(entry.Before), sqlSkill) || strings.Contains(string(entry.After), sqlSkill) {
+		t.Errorf("expected change of skills, got %s before=%s after=%s", entry.Action, entry.Before, entry.After)
+	}
+
+	for _, invalid := range []model.Freelancer{
+		{ID: f.ID, Skills: []model.FreelancerSkill{{SkillID: "cobol-" + model.NewID()[:8], Level: model.Expert}}},
+		{ID: f.ID, Skills: []model.FreelancerSkill{{SkillID: goSkill, Level: 5}}},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerSkills, invalid); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", invalid.Skills, rpc.CodeInvalid, err)
+		}
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerSkills, model.Freelancer{ID: model.NewID()}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestService_Search(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	category, skills := populateSkills(t)
+	goSkill, sqlSkill, cssSkill := skills[0], skills[1], skills[2]
+	backend := addFreelancer(t, model.FreelancerSkill{SkillID: goSkill, Level: model.Expert}, model.FreelancerSkill{SkillID: sqlSkill, Level: model.Expert})
+	junior := addFreelancer(t, model.FreelancerSkill{SkillID: goSkill, Level: model.Beginner}, model.FreelancerSkill{SkillID: sqlSkill, Level: model.Intermediate})
+	frontend := addFreelancer(t, model.FreelancerSkill{SkillID: cssSkill, Level: model.Intermediate})
+	deleted := addFreelancer(t, model.FreelancerSkill{SkillID: goSkill, Level: model.Expert})
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerDelete, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		query    model.FreelancerQuery
+		expected []string
+	}{
+		{"best proficiency first", model.FreelancerQuery{Skills: []string{goSkill, sqlSkill}}, []string{backend.ID, junior.ID}},
+		{"min level", model.FreelancerQuery{Skills: []string{goSkill}, MinLevel: model.Intermediate}, []string{backend.ID}},
+		{"every skill", model.FreelancerQuery{Skills: []string{goSkill, cssSkill}}, nil},
+		{"category", model.FreelancerQuery{Category: category, MinLevel: model.Intermediate, Skills: []string{cssSkill}}, []string{frontend.ID}},
+		{"paging", model.FreelancerQuery{Skills: []string{sqlSkill}, Limit: 1, Offset: 1}, []string{junior.ID}},
+	} {
+		found, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerSearch, c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, f := range found {
+			ids = append(ids, f.ID)
+			if len(f.Skills) == 0 {
+				t.Errorf("%s: skills of %s are not loaded", c.name, f.ID)
+			}
+		}
+		if strings.Join(ids, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected=%v got=%v", c.name, c.expected, ids)
+		}
+	}
+
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.FreelancerSearch, model.FreelancerQuery{MinLevel: 4}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+}
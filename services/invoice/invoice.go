@@ -0,0 +1,126 @@
+package invoice
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+)
+
+var (
+	// ErrTaskNotClosed represents error returned when invoice is requested for the Task that is not closed yet
+	ErrTaskNotClosed = rpc.Errorf(rpc.CodeInvalid, "task is not closed")
+	// ErrNotPaid represents error returned when invoice is requested for the Task that is not paid yet
+	ErrNotPaid = rpc.Errorf(rpc.CodeInvalid, "task is not paid")
+)
+
+// Service represents Invoice service that issues invoices
+// for closed and paid Tasks
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Invoice service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.InvoiceGet, s.Get); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.InvoiceList, s.List); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get will retrieve Invoice for the Task by given ID,
+// invoice is issued on the first request
+func (s *Service) Get(ctx context.Context, taskID string) (model.Invoice, error) {
+	if len(taskID) != 36 {
+		return model.Invoice{}, model.ErrInvalidID
+	}
+	return s.issue(taskID)
+}
+
+// List will perform DB select operation and retrieve all Invoices issued to given client
+func (s *Service) List(ctx context.Context, clientID string) ([]model.Invoice, error) {
+	query := "SELECT * FROM invoice WHERE client_id = $1 ORDER BY number ASC"
+	invoices := []model.Invoice{}
+	err := s.db.SelectContext(ctx, &invoices, query, clientID)
+	return invoices, err
+}
+
+// issue returns already issued Invoice for the Task or issues a new one
+// with the next number from invoice_number_seq
+func (s *Service) issue(taskID string) (model.Invoice, error) {
+	invoice := model.Invoice{}
+	err := s.db.Get(&invoice, "SELECT * FROM invoice WHERE task_id = $1", taskID)
+	if err == nil {
+		return invoice, nil
+	}
+	if err != sql.ErrNoRows {
+		return invoice, err
+	}
+
+	task := model.Task{}
+	if err := s.db.Get(&task, "SELECT * FROM task WHERE id = $1", taskID); err != nil {
+		return invoice, err
+	}
+	if task.Status != model.Closed {
+		return invoice, ErrTaskNotClosed
+	}
+
+	payment := model.Payment{}
+	if err := s.db.Get(&payment, "SELECT * FROM billing WHERE task_id = $1 AND status = $2", taskID, model.Paid); err != nil {
+		if err == sql.ErrNoRows {
+			return invoice, ErrNotPaid
+		}
+		return invoice, err
+	}
+
+	client := model.Client{}
+	if err := s.db.Get(&client, "SELECT * FROM client WHERE id = $1", task.ClientID); err != nil {
+		return invoice, err
+	}
+	freelancer := model.Freelancer{}
+	if err := s.db.Get(&freelancer, "SELECT * FROM freelancer WHERE id = $1", payment.FreelancerID); err != nil {
+		return invoice, err
+	}
+
+	invoice = model.Invoice{
+		ID:              model.NewID(),
+		TaskID:          task.ID,
+		PaymentID:       payment.ID,
+		ClientID:        client.ID,
+		ClientEmail:     client.Email,
+		FreelancerID:    freelancer.ID,
+		FreelancerEmail: freelancer.Email,
+		Description:     task.Description,
+		Amount:          payment.Amount,
+		PaidDate:        payment.PaidDate,
+		IssuedAt:        time.Now(),
+	}
+	// concurrent requests for the same task are resolved by the unique task_id constraint
+	insertS := "INSERT INTO invoice (id, number, task_id, payment_id, client_id, client_email, freelancer_id, " +
+		"freelancer_email, description, amount, paid_date, issued_at) " +
+		"VALUES($1, nextval('invoice_number_seq'), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) " +
+		"ON CONFLICT (task_id) DO NOTHING"
+	if _, err := s.db.Exec(insertS, invoice.ID, invoice.TaskID, invoice.PaymentID, invoice.ClientID, invoice.ClientEmail,
+		invoice.FreelancerID, invoice.FreelancerEmail, invoice.Description, invoice.Amount, invoice.PaidDate, invoice.IssuedAt); err != nil {
+		return invoice, err
+	}
+
+	err = s.db.Get(&invoice, "SELECT * FROM invoice WHERE task_id = $1", taskID)
+	return invoice, err
+}
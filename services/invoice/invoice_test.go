@@ -0,0 +1,259 @@
+package invoice
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var billingSchema = `CREATE TABLE BILLING (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36),
+	PAID_DATE timestamp,
+	STATUS varchar,
+	AMOUNT MONEY_AMOUNT,
+	TASK_ID varchar(36),
+	REFERENCE varchar(128) UNIQUE
+)`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var invoiceSeq = `CREATE SEQUENCE INVOICE_NUMBER_SEQ`
+
+var invoiceSchema = `CREATE TABLE INVOICE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	NUMBER bigint UNIQUE NOT NULL,
+	TASK_ID varchar(36) UNIQUE NOT NULL,
+	PAYMENT_ID varchar(36) NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	CLIENT_EMAIL varchar(128),
+	FREELANCER_ID varchar(36) NOT NULL,
+	FREELANCER_EMAIL varchar(128),
+	DESCRIPTION text,
+	AMOUNT MONEY_AMOUNT,
+	PAID_DATE timestamp,
+	ISSUED_AT timestamp
+)`
+
+func startServer() *server.Server {
+	return natstest.RunDefaultServer()
+}
+
+func setUp(t *testing.T) func() {
+	s = &Service{}
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.db = db
+	s.db.Exec(moneyType)
+	s.db.Exec(taskSchema)
+	s.db.Exec(billingSchema)
+	s.db.Exec(clientSchema)
+	s.db.Exec(freelancerSchema)
+	s.db.Exec(invoiceSeq)
+	s.db.Exec(invoiceSchema)
+
+	natsServer := startServer()
+
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !natsConn.IsConnected() {
+		t.Fatal("no nats connection")
+	}
+
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jsonConn = natsEncConn
+
+	// subscribe to topics
+	s.init()
+	return func() {
+		s.db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+// populateDB inserts client, freelancer and task with the given status and payment status
+func populateDB(t *testing.T, status model.TaskStatus, paymentStatus model.PaymentStatus) model.Task {
+	client := model.NewClient("client@email.com", model.NewMoney(100000, model.USD))
+	freelancer := model.NewFreelancer("freelancer@email.com", "dev", "golang")
+	task := model.NewTask(time.Hour*24, model.NewMoney(5050, model.USD), client.ID, "golang app")
+	task.FreelancerID = freelancer.ID
+	task.Status = status
+
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3)", client.ID, client.Email, client.Balance); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, email, description, details, balance) VALUES($1, $2, $3, $4, $5)",
+		freelancer.ID, freelancer.Email, freelancer.Description, freelancer.Details, model.NewMoney(0, model.USD)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.Status); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO billing (id, client_id, freelancer_id, task_id, amount, status, paid_date) VALUES($1, $2, $3, $4, $5, $6, $7)",
+		model.NewID(), client.ID, freelancer.ID, task.ID, task.Fee, paymentStatus, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestService_Get(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t, model.Closed, model.Paid)
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("invoice.get", task.ID, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	invoice := model.Invoice{}
+	if err := json.Unmarshal(reply.Data, &invoice); err != nil {
+		t.Error(err, string(reply.Data))
+		return
+	}
+	if invoice.TaskID != task.ID {
+		t.Errorf("task ID mismatch, expected=%s got=%s", task.ID, invoice.TaskID)
+	}
+	if invoice.Amount != task.Fee {
+		t.Errorf("amount mismatch, expected=%s got=%s", task.Fee, invoice.Amount)
+	}
+
+	// invoice is issued only once
+	if err := s.jsonConn.Request("invoice.get", task.ID, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	again := model.Invoice{}
+	if err := json.Unmarshal(reply.Data, &again); err != nil {
+		t.Error(err, string(reply.Data))
+		return
+	}
+	if again.Number != invoice.Number {
+		t.Errorf("number mismatch, expected=%d got=%d", invoice.Number, again.Number)
+	}
+
+	// next invoice gets next number
+	task2 := populateDB(t, model.Closed, model.Paid)
+	if err := s.jsonConn.Request("invoice.get", task2.ID, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	next := model.Invoice{}
+	if err := json.Unmarshal(reply.Data, &next); err != nil {
+		t.Error(err, string(reply.Data))
+		return
+	}
+	if next.Number <= invoice.Number {
+		t.Errorf("expected number greater than %d, got=%d", invoice.Number, next.Number)
+	}
+}
+
+func TestService_GetNotInvoiceable(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	tests := []struct {
+		name    string
+		status  model.TaskStatus
+		payment model.PaymentStatus
+		want    error
+	}{
+		{name: "not-closed", status: model.Completed, payment: model.Locked, want: ErrTaskNotClosed},
+		{name: "not-paid", status: model.Closed, payment: model.Locked, want: ErrNotPaid},
+	}
+	// unknown task is not found
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("invoice.get", model.NewID(), reply, time.Second*10); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != string(rpc.CodeNotFound) {
+		t.Errorf("expected=%s got=%s", rpc.CodeNotFound, reply.Code)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := populateDB(t, tt.status, tt.payment)
+			reply := &model.NATSMsg{}
+			if err := s.jsonConn.Request("invoice.get", task.ID, reply, time.Second*10); err != nil {
+				t.Error(err)
+				return
+			}
+			if reply.Success {
+				t.Error("expected failure")
+				return
+			}
+			if reply.Message != tt.want.Error() {
+				t.Errorf("expected=%s got=%s", tt.want, reply.Message)
+			}
+			if reply.Code != string(rpc.CodeInvalid) {
+				t.Errorf("expected=%s got=%s", rpc.CodeInvalid, reply.Code)
+			}
+		})
+	}
+}
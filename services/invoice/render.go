@@ -0,0 +1,131 @@
+package invoice
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+const dateLayout = "2006-01-02"
+
+// ErrUnsupportedText represents error returned when Invoice contains characters
+// the PDF font can not show, such Invoice is available as CSV
+var ErrUnsupportedText = rpc.Errorf(rpc.CodeInvalid, "invoice contains characters not supported in PDF, use CSV format")
+
+// winAnsi maps characters of Windows-1252 code page that differ from ISO-8859-1 to their codes
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// RenderCSV writes Invoice to w as CSV with header row
+func RenderCSV(w io.Writer, i model.Invoice) error {
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"invoice", "issued_at", "task_id", "description", "client_id", "client_email",
+			"freelancer_id", "freelancer_email", "payment_id", "paid_date", "amount", "currency"},
+		{i.Code(), i.IssuedAt.Format(dateLayout), i.TaskID, i.Description, i.ClientID, i.ClientEmail,
+			i.FreelancerID, i.FreelancerEmail, i.PaymentID, i.PaidDate.Format(dateLayout), formatAmount(i.Amount), string(i.Amount.Currency)},
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// RenderPDF writes Invoice to w as single page PDF document, ErrUnsupportedText is returned
+// and nothing is written when the Invoice has characters outside of Windows-1252
+func RenderPDF(w io.Writer, i model.Invoice) error {
+	lines := []string{
+		"Invoice " + i.Code(),
+		"",
+		"Issued: " + i.IssuedAt.Format(dateLayout),
+		"Paid: " + i.PaidDate.Format(dateLayout),
+		"Payment: " + i.PaymentID,
+		"",
+		"Bill to: " + i.ClientEmail + " (" + i.ClientID + ")",
+		"Freelancer: " + i.FreelancerEmail + " (" + i.FreelancerID + ")",
+		"",
+		"Task: " + i.TaskID,
+		"Description: " + i.Description,
+		"",
+		"Total: " + i.Amount.String(),
+	}
+	return writePDF(w, lines, i.IssuedAt)
+}
+
+// formatAmount formats amount without currency code, e.g. 200050 USD -> 2000.50
+func formatAmount(m model.Money) string {
+	return strings.TrimSuffix(m.String(), " "+string(m.Currency))
+}
+
+// writePDF writes minimal PDF 1.4 document with one A4 page
+// containing given lines of text set in Helvetica
+func writePDF(w io.Writer, lines []string, created time.Time) error {
+	var content bytes.Buffer
+	content.WriteString("BT\n/F1 12 Tf\n16 TL\n50 790 Td\n")
+	for _, l := range lines {
+		text, err := encodeWinAnsi(l)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDF(text))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		fmt.Sprintf("<< /Producer (md) /CreationDate (D:%s) >>", created.UTC().Format("20060102150405Z")),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for n, o := range objects {
+		offsets[n] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", n+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, len(objects), xref)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// encodeWinAnsi encodes UTF-8 text in Windows-1252 the font is declared with,
+// ErrUnsupportedText is returned for characters the code page does not have
+func encodeWinAnsi(s string) (string, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if c, ok := winAnsi[r]; ok {
+			b = append(b, c)
+			continue
+		}
+		if r >= 0x80 && (r < 0xA0 || r > 0xFF) {
+			return "", ErrUnsupportedText
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// escapePDF escapes characters that have special meaning in PDF string literals
+func escapePDF(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\r", " ", "\n", " ").Replace(s)
+}
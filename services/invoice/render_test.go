@@ -0,0 +1,112 @@
+package invoice
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/model"
+)
+
+func testInvoice() model.Invoice {
+	return model.Invoice{
+		ID:              model.NewID(),
+		Number:          42,
+		TaskID:          model.NewID(),
+		PaymentID:       model.NewID(),
+		ClientID:        model.NewID(),
+		ClientEmail:     "client@email.com",
+		FreelancerID:    model.NewID(),
+		FreelancerEmail: "freelancer@email.com",
+		Description:     "golang app (backend)",
+		Amount:          model.NewMoney(200050, model.EUR),
+		PaidDate:        time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC),
+		IssuedAt:        time.Date(2018, 10, 2, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderCSV(&buf, testInvoice()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected=%d rows, got=%d", 2, len(records))
+	}
+	row := records[1]
+	if row[0] != "INV-000042" {
+		t.Errorf("invoice code mismatch, expected=%s got=%s", "INV-000042", row[0])
+	}
+	if row[len(row)-2] != "2000.50" {
+		t.Errorf("amount mismatch, expected=%s got=%s", "2000.50", row[len(row)-2])
+	}
+	if row[len(row)-1] != "EUR" {
+		t.Errorf("currency mismatch, expected=%s got=%s", "EUR", row[len(row)-1])
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := RenderPDF(&buf, testInvoice()); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+	if !strings.HasPrefix(doc, "%PDF-1.4") {
+		t.Error("missing PDF header")
+	}
+	if !strings.HasSuffix(doc, "%%EOF\n") {
+		t.Error("missing PDF trailer")
+	}
+	if !strings.Contains(doc, "(Total: 2000.50 EUR) Tj") {
+		t.Error("missing total")
+	}
+	if !strings.Contains(doc, "(Invoice INV-000042) Tj") {
+		t.Error("missing invoice code")
+	}
+	if !strings.Contains(doc, `golang app \(backend\)`) {
+		t.Error("description is not escaped")
+	}
+}
+
+func TestRenderPDF_WinAnsi(t *testing.T) {
+	inv := testInvoice()
+	inv.Description = "Café – “déjà vu” €5"
+	var buf bytes.Buffer
+	if err := RenderPDF(&buf, inv); err != nil {
+		t.Fatal(err)
+	}
+	want := "(Description: Caf\xe9 \x96 \x93d\xe9j\xe0 vu\x94 \x805) Tj"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("description is not encoded in Windows-1252: %q", buf.String())
+	}
+
+	inv.Description = "移动应用"
+	buf.Reset()
+	if err := RenderPDF(&buf, inv); err != ErrUnsupportedText {
+		t.Errorf("expected=%v got=%v", ErrUnsupportedText, err)
+	}
+	if buf.Len() != 0 {
+		t.Error("invoice is partially written")
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := map[model.Money]string{
+		model.NewMoney(0, model.USD):      "0.00",
+		model.NewMoney(5, model.USD):      "0.05",
+		model.NewMoney(200050, model.EUR): "2000.50",
+		model.NewMoney(-150, model.GBP):   "-1.50",
+		model.NewMoney(1500, model.JPY):   "1500",
+	}
+	for m, want := range tests {
+		if got := formatAmount(m); got != want {
+			t.Errorf("formatAmount(%v) expected=%s got=%s", m, want, got)
+		}
+	}
+}
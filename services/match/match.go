@@ -0,0 +1,297 @@
+package match
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	nats "github.com/nats-io/nats.go"
+)
+
+// source represents the service in domain events
+const source = "match"
+
+const (
+	// defaultLimit and maxLimit represent number of returned recommendations
+	defaultLimit = 10
+	maxLimit     = 50
+	// maxCandidates represents maximum number of Freelancers or Tasks scored per request
+	maxCandidates = 1000
+)
+
+var (
+	// ErrNotOpen represents error returned when Freelancers are recommended for Task that is not open
+	ErrNotOpen = rpc.Errorf(rpc.CodeInvalid, "freelancers are recommended for open tasks only")
+	// ErrInvalidQuery represents error returned for negative limit
+	ErrInvalidQuery = rpc.Errorf(rpc.CodeInvalid, "invalid query")
+	// ErrInvalidScore represents error returned when Rating's score is out of range
+	ErrInvalidScore = rpc.Errorf(rpc.CodeInvalid, "score must be 1 to 5")
+	// ErrNotClosed represents error returned when Task is rated before it is closed
+	ErrNotClosed = rpc.Errorf(rpc.CodeInvalid, "only closed tasks are rated")
+	// ErrNotClient represents error returned when Task is rated by someone other than its Client
+	ErrNotClient = rpc.Errorf(rpc.CodePermission, "only client of the task rates it")
+	// ErrRated represents error returned when the Task is rated again
+	ErrRated = rpc.Errorf(rpc.CodeInvalid, "task is already rated")
+)
+
+var (
+	// done represents statuses of Tasks the Freelancer completed
+	done = pq.StringArray{string(model.Completed), string(model.Closed)}
+	// finished represents statuses of Tasks the Freelancer no longer works on
+	finished = pq.StringArray{string(model.Completed), string(model.Closed), string(model.Abandoned), string(model.Disputed)}
+)
+
+// Service represents Match service that recommends Freelancers for Tasks and Tasks to Freelancers
+// by Skills, Ratings and history of the Freelancer, it also records Ratings
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Match service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.MatchFreelancers, s.Freelancers); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.MatchTasks, s.Tasks); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.RatingAdd, s.Rate); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Freelancers will recommend Freelancers for the open Task, best Match first.
+// Candidates have one of Skills required by the Task or, when none is required, a Skill in its Category
+func (s *Service) Freelancers(ctx context.Context, q model.MatchQuery) ([]model.Match, error) {
+	matches := []model.Match{}
+	if len(q.ID) != 36 {
+		return matches, model.ErrInvalidID
+	}
+	limit, err := limit(q.Limit)
+	if err != nil {
+		return matches, err
+	}
+	task := model.Task{}
+	if err := s.db.GetContext(ctx, &task, "SELECT * FROM task WHERE id = $1 AND deleted_at IS NULL", q.ID); err != nil {
+		return matches, err
+	}
+	if task.Status != model.Open {
+		return matches, ErrNotOpen
+	}
+
+	var ids []string
+	query := `SELECT DISTINCT fs.freelancer_id FROM freelancer_skill fs
+	JOIN skill sk ON sk.id = fs.skill_id
+	JOIN freelancer f ON f.id = fs.freelancer_id
+	WHERE f.deleted_at IS NULL AND (fs.skill_id = ANY($1::text[]) OR (cardinality($1::text[]) = 0 AND sk.category_id = $2))
+	ORDER BY fs.freelancer_id LIMIT $3`
+	if err := s.db.SelectContext(ctx, &ids, query, pq.StringArray(task.Skills), task.Category, maxCandidates); err != nil {
+		return matches, err
+	}
+	stats, err := s.stats(ctx, ids)
+	if err != nil {
+		return matches, err
+	}
+	for _, id := range ids {
+		matches = append(matches, Score(task, *stats[id]))
+	}
+	return top(matches, limit), nil
+}
+
+// Tasks will recommend open Tasks to the Freelancer, best Match first.
+// Candidates require one of Freelancer's Skills or, requiring none, belong to Category of one of them
+func (s *Service) Tasks(ctx context.Context, q model.MatchQuery) ([]model.Match, error) {
+	matches := []model.Match{}
+	if len(q.ID) != 36 {
+		return matches, model.ErrInvalidID
+	}
+	limit, err := limit(q.Limit)
+	if err != nil {
+		return matches, err
+	}
+	var id string
+	if err := s.db.GetContext(ctx, &id, "SELECT id FROM freelancer WHERE id = $1 AND deleted_at IS NULL", q.ID); err != nil {
+		return matches, err
+	}
+	stats, err := s.stats(ctx, []string{q.ID})
+	if err != nil {
+		return matches, err
+	}
+	st := stats[q.ID]
+	skills, categories := pq.StringArray{}, pq.StringArray{}
+	for skill := range st.Skills {
+		skills = append(skills, skill)
+	}
+	for category := range st.Categories {
+		categories = append(categories, category)
+	}
+
+	tasks := []model.Task{}
+	query := `SELECT * FROM task
+	WHERE status = $1 AND deleted_at IS NULL AND (skills && $2 OR (cardinality(skills) = 0 AND category = ANY($3)))
+	ORDER BY created_at DESC, id LIMIT $4`
+	if err := s.db.SelectContext(ctx, &tasks, query, model.Open, skills, categories, maxCandidates); err != nil {
+		return matches, err
+	}
+	for _, t := range tasks {
+		matches = append(matches, Score(t, *st))
+	}
+	return top(matches, limit), nil
+}
+
+// Rate will record Rating given by Client to Freelancer of the closed Task, every Task is rated once
+func (s *Service) Rate(ctx context.Context, r model.Rating) (model.Rating, error) {
+	if r.Score < 1 || r.Score > 5 {
+		return r, ErrInvalidScore
+	}
+	task := model.Task{}
+	if err := s.db.GetContext(ctx, &task, "SELECT * FROM task WHERE id = $1 AND deleted_at IS NULL", r.TaskID); err != nil {
+		return r, err
+	}
+	if task.ClientID != r.ClientID {
+		return r, ErrNotClient
+	}
+	if task.Status != model.Closed || task.FreelancerID == "" {
+		return r, ErrNotClosed
+	}
+	r.FreelancerID = task.FreelancerID
+	r.CreatedAt = time.Now().UTC()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return r, err
+	}
+	insertS := "INSERT INTO rating (task_id, client_id, freelancer_id, score, created_at) VALUES($1, $2, $3, $4, $5) ON CONFLICT (task_id) DO NOTHING"
+	res, err := tx.Exec(insertS, r.TaskID, r.ClientID, r.FreelancerID, r.Score, r.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	if c, err := res.RowsAffected(); err != nil || c == 0 {
+		tx.Rollback()
+		if err != nil {
+			return r, err
+		}
+		return r, ErrRated
+	}
+	if err := events.Record(tx, source, events.RatingAdded, r.TaskID, r); err != nil {
+		tx.Rollback()
+		return r, err
+	}
+	return r, tx.Commit()
+}
+
+// stats loads Skills, Ratings and history of the Freelancers by ID
+func (s *Service) stats(ctx context.Context, ids []string) (map[string]*Stats, error) {
+	stats := map[string]*Stats{}
+	for _, id := range ids {
+		stats[id] = &Stats{FreelancerID: id, Skills: map[string]model.SkillLevel{}, Categories: map[string]bool{}, Prices: map[price]float64{}}
+	}
+	if len(ids) == 0 {
+		return stats, nil
+	}
+	args := pq.StringArray(ids)
+
+	skills := []struct {
+		FreelancerID string           `db:"freelancer_id"`
+		SkillID      string           `db:"skill_id"`
+		Level        model.SkillLevel `db:"level"`
+		CategoryID   string           `db:"category_id"`
+	}{}
+	query := `SELECT fs.freelancer_id, fs.skill_id, fs.level, sk.category_id FROM freelancer_skill fs
+	JOIN skill sk ON sk.id = fs.skill_id WHERE fs.freelancer_id = ANY($1)`
+	if err := s.db.SelectContext(ctx, &skills, query, args); err != nil {
+		return nil, err
+	}
+	for _, r := range skills {
+		stats[r.FreelancerID].Skills[r.SkillID] = r.Level
+		stats[r.FreelancerID].Categories[r.CategoryID] = true
+	}
+
+	ratings := []struct {
+		FreelancerID string `db:"freelancer_id"`
+		Sum          int    `db:"sum"`
+		Count        int    `db:"count"`
+	}{}
+	query = "SELECT freelancer_id, sum(score) AS sum, count(*) AS count FROM rating WHERE freelancer_id = ANY($1) GROUP BY freelancer_id"
+	if err := s.db.SelectContext(ctx, &ratings, query, args); err != nil {
+		return nil, err
+	}
+	for _, r := range ratings {
+		stats[r.FreelancerID].RatingSum, stats[r.FreelancerID].Ratings = r.Sum, r.Count
+	}
+
+	history := []struct {
+		FreelancerID string `db:"freelancer_id"`
+		Done         int    `db:"done"`
+		Finished     int    `db:"finished"`
+		Active       int    `db:"active"`
+	}{}
+	query = `SELECT freelancer_id,
+	count(*) FILTER (WHERE status = ANY($2)) AS done,
+	count(*) FILTER (WHERE status = ANY($3)) AS finished,
+	count(*) FILTER (WHERE status = $4) AS active
+	FROM task WHERE freelancer_id = ANY($1) AND deleted_at IS NULL GROUP BY freelancer_id`
+	if err := s.db.SelectContext(ctx, &history, query, args, done, finished, model.Started); err != nil {
+		return nil, err
+	}
+	for _, r := range history {
+		st := stats[r.FreelancerID]
+		st.Done, st.Finished, st.Active = r.Done, r.Finished, r.Active
+	}
+
+	prices := []struct {
+		FreelancerID string             `db:"freelancer_id"`
+		Contract     model.ContractType `db:"contract"`
+		Currency     model.Currency     `db:"currency"`
+		Amount       float64            `db:"amount"`
+	}{}
+	// hourly contracts are compared by hourly rate, fixed ones by fee
+	query = `SELECT freelancer_id, contract,
+	CASE WHEN contract = $3 THEN (hourly_rate).currency ELSE (fee).currency END AS currency,
+	avg(CASE WHEN contract = $3 THEN (hourly_rate).amount ELSE (fee).amount END) AS amount
+	FROM task WHERE freelancer_id = ANY($1) AND status = ANY($2) AND deleted_at IS NULL GROUP BY 1, 2, 3`
+	if err := s.db.SelectContext(ctx, &prices, query, args, done, model.HourlyContract); err != nil {
+		return nil, err
+	}
+	for _, r := range prices {
+		stats[r.FreelancerID].Prices[price{r.Contract, r.Currency}] = r.Amount
+	}
+	return stats, nil
+}
+
+// limit returns number of recommendations for requested limit
+func limit(requested int) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, ErrInvalidQuery
+	case requested == 0:
+		return defaultLimit, nil
+	case requested > maxLimit:
+		return maxLimit, nil
+	}
+	return requested, nil
+}
+
+// top ranks the Matches and returns the best ones
+func top(matches []model.Match, limit int) []model.Match {
+	rank(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
@@ -0,0 +1,315 @@
+package match
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var categorySchema = `CREATE TABLE CATEGORY (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL
+)`
+
+var skillSchema = `CREATE TABLE SKILL (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	CATEGORY_ID varchar(64) NOT NULL REFERENCES CATEGORY (ID)
+)`
+
+var freelancerSkillSchema = `CREATE TABLE FREELANCER_SKILL (
+	FREELANCER_ID varchar(36) NOT NULL,
+	SKILL_ID varchar(64) NOT NULL REFERENCES SKILL (ID),
+	LEVEL int NOT NULL CHECK (LEVEL BETWEEN 1 AND 3),
+	PRIMARY KEY (FREELANCER_ID, SKILL_ID)
+)`
+
+var ratingSchema = `CREATE TABLE RATING (
+	TASK_ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36) NOT NULL,
+	SCORE smallint NOT NULL CHECK (SCORE BETWEEN 1 AND 5),
+	CREATED_AT timestamp NOT NULL
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+func setUp(t *testing.T) func() {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	for _, schema := range []string{moneyType, taskSchema, freelancerSchema, categorySchema, skillSchema, freelancerSkillSchema, ratingSchema, outboxSchema} {
+		db.Exec(schema)
+	}
+
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewService(db, natsEncConn); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		natsConn.Close()
+		natsServer.Shutdown()
+		db.Close()
+	}
+}
+
+// populateSkills inserts Category and Skills unique to the run, the taxonomy is shared by runs
+func populateSkills(t *testing.T) (category string, skills []string) {
+	suffix := model.NewID()[:8]
+	category = "dev-" + suffix
+	skills = []string{"go-" + suffix, "sql-" + suffix}
+	if _, err := s.db.Exec("INSERT INTO category (id, name) VALUES($1, 'Development')", category); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range skills {
+		if _, err := s.db.Exec("INSERT INTO skill (id, name, category_id) VALUES($1, $1, $2)", id, category); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return category, skills
+}
+
+func addFreelancer(t *testing.T, skills map[string]model.SkillLevel) string {
+	id := model.NewID()
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, description, details, email) VALUES($1, '', '', '')", id); err != nil {
+		t.Fatal(err)
+	}
+	for skill, level := range skills {
+		if _, err := s.db.Exec("INSERT INTO freelancer_skill (freelancer_id, skill_id, level) VALUES($1, $2, $3)", id, skill, level); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func addTask(t *testing.T, freelancerID string, status model.TaskStatus, category string, skills ...string) model.Task {
+	task := model.Task{ID: model.NewID(), ClientID: model.NewID(), FreelancerID: freelancerID, Description: "task", Status: status,
+		Fee: model.NewMoney(10000, model.USD), CreatedAt: time.Now().UTC(), Contract: model.FixedContract, Tags: pq.StringArray{},
+		Category: category, Skills: pq.StringArray(skills)}
+	if task.Skills == nil {
+		task.Skills = pq.StringArray{}
+	}
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status, contract, hourly_rate, weekly_cap, tags, category, skills) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline,
+		task.CreatedAt, task.Status, task.Contract, task.HourlyRate, task.WeeklyCap, task.Tags, task.Category, task.Skills); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func ids(matches []model.Match, freelancers bool) string {
+	var ids []string
+	for _, m := range matches {
+		if freelancers {
+			ids = append(ids, m.FreelancerID)
+		} else {
+			ids = append(ids, m.TaskID)
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestService_Freelancers(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	category, skills := populateSkills(t)
+	goSkill, sqlSkill := skills[0], skills[1]
+	expert := addFreelancer(t, map[string]model.SkillLevel{goSkill: model.Expert, sqlSkill: model.Expert})
+	junior := addFreelancer(t, map[string]model.SkillLevel{goSkill: model.Beginner})
+	// busy Freelancer with the same Skills ranks below the free one
+	busy := addFreelancer(t, map[string]model.SkillLevel{goSkill: model.Expert, sqlSkill: model.Expert})
+	addTask(t, busy, model.Started, category, goSkill)
+	addTask(t, busy, model.Started, category, goSkill)
+
+	task := addTask(t, "", model.Open, category, goSkill, sqlSkill)
+	matches, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: task.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := strings.Join([]string{expert, busy, junior}, ","); ids(matches, true) != expected {
+		t.Errorf("expected=%s got=%s", expected, ids(matches, true))
+	}
+	for _, m := range matches {
+		if m.TaskID != task.ID || m.Score <= 0 || m.Score > 1 {
+			t.Errorf("unexpected match %+v", m)
+		}
+	}
+	// the same data gives the same recommendations
+	again, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: task.ID, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 2 || again[0] != matches[0] || again[1] != matches[1] {
+		t.Errorf("expected=%+v got=%+v", matches[:2], again)
+	}
+
+	// Task without required Skills is matched by Category
+	general := addTask(t, "", model.Open, category)
+	if matches, err = rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: general.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 3 {
+		t.Errorf("expected 3 matches, got %+v", matches)
+	}
+
+	started := addTask(t, junior, model.Started, category, goSkill)
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: started.ID}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: model.NewID()}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestService_Tasks(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	category, skills := populateSkills(t)
+	goSkill, sqlSkill := skills[0], skills[1]
+	f := addFreelancer(t, map[string]model.SkillLevel{goSkill: model.Expert})
+
+	both := addTask(t, "", model.Open, category, goSkill, sqlSkill)
+	def := addTask(t, "", model.Open, category, goSkill)
+	general := addTask(t, "", model.Open, category)
+	addTask(t, "", model.Open, category, sqlSkill)
+	addTask(t, model.NewID(), model.Started, category, goSkill)
+
+	matches, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchTasks, model.MatchQuery{ID: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Task requiring one of two Skills and Task matched by Category score the same, ties are ordered by ID
+	tied := []string{both.ID, general.ID}
+	sort.Strings(tied)
+	if expected := strings.Join(append([]string{def.ID}, tied...), ","); ids(matches, false) != expected {
+		t.Errorf("expected=%s got=%s", expected, ids(matches, false))
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchTasks, model.MatchQuery{ID: f, Limit: -1}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+}
+
+func TestService_Rate(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	category, skills := populateSkills(t)
+	rated := addFreelancer(t, map[string]model.SkillLevel{skills[0]: model.Intermediate})
+	unrated := addFreelancer(t, map[string]model.SkillLevel{skills[0]: model.Intermediate})
+	closed := addTask(t, rated, model.Closed, category, skills[0])
+	addTask(t, unrated, model.Closed, category, skills[0])
+
+	for _, c := range []struct {
+		name   string
+		rating model.Rating
+		code   rpc.Code
+	}{
+		{"score", model.Rating{TaskID: closed.ID, ClientID: closed.ClientID, Score: 6}, rpc.CodeInvalid},
+		{"client", model.Rating{TaskID: closed.ID, ClientID: model.NewID(), Score: 5}, rpc.CodePermission},
+		{"task", model.Rating{TaskID: model.NewID(), ClientID: closed.ClientID, Score: 5}, rpc.CodeNotFound},
+	} {
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.RatingAdd, c.rating); rpc.CodeOf(err) != c.code {
+			t.Errorf("%s: expected=%s got=%v", c.name, c.code, err)
+		}
+	}
+
+	rating, err := rpc.Call(ctx, s.jsonConn.Conn, api.RatingAdd, model.Rating{TaskID: closed.ID, ClientID: closed.ClientID, Score: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rating.FreelancerID != rated {
+		t.Errorf("expected=%s got=%s", rated, rating.FreelancerID)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.RatingAdd, model.Rating{TaskID: closed.ID, ClientID: closed.ClientID, Score: 1}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%v got=%v", ErrRated, err)
+	}
+	var events int
+	if err := s.db.Get(&events, "SELECT count(*) FROM outbox WHERE subject = 'events.rating.added' AND convert_from(payload, 'UTF8') LIKE '%' || $1 || '%'", closed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("expected 1 event, got %d", events)
+	}
+
+	// well rated Freelancer ranks first among otherwise equal ones
+	task := addTask(t, "", model.Open, category, skills[0])
+	matches, err := rpc.Call(ctx, s.jsonConn.Conn, api.MatchFreelancers, model.MatchQuery{ID: task.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 || matches[0].FreelancerID != rated || matches[0].Rating <= matches[1].Rating {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+}
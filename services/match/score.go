@@ -0,0 +1,110 @@
+package match
+
+import (
+	"sort"
+
+	"github.com/kylycht/md/model"
+)
+
+// Weights of the factors in the Score, they add up to 1
+const (
+	skillsWeight       = 0.4
+	ratingWeight       = 0.2
+	completionWeight   = 0.15
+	availabilityWeight = 0.15
+	priceWeight        = 0.1
+)
+
+const (
+	// priorRating and priorRatings pull average of few Ratings towards the middle of the scale
+	priorRating  = 3
+	priorRatings = 2
+	// neutral represents factor of the Freelancer without history
+	neutral = 0.5
+	// categoryMatch represents skills factor of the Freelancer with a Skill in Category of the Task
+	// that does not require specific Skills
+	categoryMatch = 0.5
+)
+
+// price represents contract and currency prices are compared in
+type price struct {
+	contract model.ContractType
+	currency model.Currency
+}
+
+// Stats represents Freelancer's Skills and history of Tasks used in scoring
+type Stats struct {
+	FreelancerID string
+	Skills       map[string]model.SkillLevel // Skills represents declared proficiency by Skill ID
+	Categories   map[string]bool             // Categories represents Categories of declared Skills
+	RatingSum    int                         // RatingSum represents sum of Ratings' scores
+	Ratings      int                         // Ratings represents number of Ratings
+	Done         int                         // Done represents number of completed or closed Tasks
+	Finished     int                         // Finished represents number of Tasks completed, closed, abandoned or disputed
+	Active       int                         // Active represents number of started Tasks
+	Prices       map[price]float64           // Prices represents average fee or hourly rate of done Tasks
+}
+
+// Score returns Match of the Freelancer with given Stats and the Task
+func Score(t model.Task, st Stats) model.Match {
+	m := model.Match{
+		TaskID:       t.ID,
+		FreelancerID: st.FreelancerID,
+		Skills:       skillsFactor(t, st),
+		Rating:       (float64(st.RatingSum+priorRating*priorRatings)/float64(st.Ratings+priorRatings) - 1) / 4,
+		Completion:   float64(st.Done+1) / float64(st.Finished+2),
+		Availability: 1 / float64(1+st.Active),
+		Price:        priceFactor(t, st),
+	}
+	m.Score = skillsWeight*m.Skills + ratingWeight*m.Rating + completionWeight*m.Completion +
+		availabilityWeight*m.Availability + priceWeight*m.Price
+	return m
+}
+
+// skillsFactor returns average proficiency in Skills required by the Task relative to Expert,
+// Tasks without required Skills are matched by Category
+func skillsFactor(t model.Task, st Stats) float64 {
+	if len(t.Skills) == 0 {
+		if t.Category != "" && st.Categories[t.Category] {
+			return categoryMatch
+		}
+		return 0
+	}
+	var sum float64
+	for _, id := range t.Skills {
+		sum += float64(st.Skills[id]) / float64(model.Expert)
+	}
+	return sum / float64(len(t.Skills))
+}
+
+// priceFactor returns 1 when the Task pays at least as much as Tasks the Freelancer completed
+// under the same contract in the same currency, otherwise the ratio of the two
+func priceFactor(t model.Task, st Stats) float64 {
+	offered := t.Fee
+	if t.Contract == model.HourlyContract {
+		offered = t.HourlyRate
+	}
+	typical, ok := st.Prices[price{t.Contract, offered.Currency}]
+	if !ok || typical <= 0 {
+		return neutral
+	}
+	if ratio := float64(offered.Amount) / typical; ratio < 1 {
+		return ratio
+	}
+	return 1
+}
+
+// rank orders Matches by Score, ties are ordered by Freelancer's and Task's ID
+// so the same data always gives the same recommendations
+func rank(matches []model.Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.FreelancerID != b.FreelancerID {
+			return a.FreelancerID < b.FreelancerID
+		}
+		return a.TaskID < b.TaskID
+	})
+}
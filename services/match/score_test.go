@@ -0,0 +1,117 @@
+package match
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kylycht/md/model"
+	"github.com/lib/pq"
+)
+
+func newStats(id string) Stats {
+	return Stats{FreelancerID: id, Skills: map[string]model.SkillLevel{}, Categories: map[string]bool{}, Prices: map[price]float64{}}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScore(t *testing.T) {
+	task := model.Task{ID: "task", Contract: model.FixedContract, Fee: model.NewMoney(1000, model.USD),
+		Category: "development", Skills: pq.StringArray{"go", "sql"}}
+
+	newcomer := newStats("newcomer")
+	m := Score(task, newcomer)
+	// no Skills, prior rating, no history, free, no price history
+	expected := model.Match{TaskID: "task", FreelancerID: "newcomer", Skills: 0, Rating: 0.5, Completion: 0.5, Availability: 1, Price: neutral}
+	expected.Score = ratingWeight*0.5 + completionWeight*0.5 + availabilityWeight + priceWeight*neutral
+	if !near(m.Score, expected.Score) || m.Rating != expected.Rating || m.Completion != expected.Completion ||
+		m.Availability != expected.Availability || m.Price != expected.Price || m.Skills != expected.Skills {
+		t.Errorf("expected=%+v got=%+v", expected, m)
+	}
+
+	veteran := newStats("veteran")
+	veteran.Skills["go"], veteran.Skills["sql"] = model.Expert, model.Intermediate
+	veteran.RatingSum, veteran.Ratings = 38, 8
+	veteran.Done, veteran.Finished, veteran.Active = 8, 8, 1
+	veteran.Prices[price{model.FixedContract, model.USD}] = 2000
+	m = Score(task, veteran)
+	if !near(m.Skills, (1+2.0/3)/2) {
+		t.Errorf("unexpected skills factor %f", m.Skills)
+	}
+	if !near(m.Rating, (44.0/10-1)/4) {
+		t.Errorf("unexpected rating factor %f", m.Rating)
+	}
+	if !near(m.Completion, 0.9) || m.Availability != 0.5 || m.Price != 0.5 {
+		t.Errorf("unexpected factors %+v", m)
+	}
+	if m.Score <= Score(task, newcomer).Score {
+		t.Errorf("expected veteran to outscore newcomer: %+v", m)
+	}
+}
+
+func TestScore_Category(t *testing.T) {
+	st := newStats("designer")
+	st.Skills["figma"] = model.Expert
+	st.Categories["design"] = true
+
+	if m := Score(model.Task{Category: "design"}, st); m.Skills != categoryMatch {
+		t.Errorf("expected=%f got=%f", categoryMatch, m.Skills)
+	}
+	if m := Score(model.Task{Category: "development"}, st); m.Skills != 0 {
+		t.Errorf("expected=0 got=%f", m.Skills)
+	}
+}
+
+func TestScore_Price(t *testing.T) {
+	st := newStats("f")
+	st.Prices[price{model.HourlyContract, model.EUR}] = 5000
+	st.Prices[price{model.FixedContract, model.EUR}] = 100000
+
+	for _, c := range []struct {
+		name     string
+		task     model.Task
+		expected float64
+	}{
+		{"hourly below typical", model.Task{Contract: model.HourlyContract, HourlyRate: model.NewMoney(4000, model.EUR)}, 0.8},
+		{"hourly above typical", model.Task{Contract: model.HourlyContract, HourlyRate: model.NewMoney(9000, model.EUR)}, 1},
+		{"fixed", model.Task{Contract: model.FixedContract, Fee: model.NewMoney(25000, model.EUR)}, 0.25},
+		{"other currency", model.Task{Contract: model.FixedContract, Fee: model.NewMoney(25000, model.USD)}, neutral},
+	} {
+		if got := Score(c.task, st).Price; !near(got, c.expected) {
+			t.Errorf("%s: expected=%f got=%f", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	matches := []model.Match{
+		{TaskID: "b", FreelancerID: "2", Score: 0.5},
+		{TaskID: "a", FreelancerID: "3", Score: 0.9},
+		{TaskID: "a", FreelancerID: "2", Score: 0.5},
+		{TaskID: "a", FreelancerID: "1", Score: 0.5},
+	}
+	rank(matches)
+	expected := []model.Match{
+		{TaskID: "a", FreelancerID: "3", Score: 0.9},
+		{TaskID: "a", FreelancerID: "1", Score: 0.5},
+		{TaskID: "a", FreelancerID: "2", Score: 0.5},
+		{TaskID: "b", FreelancerID: "2", Score: 0.5},
+	}
+	for i := range expected {
+		if matches[i] != expected[i] {
+			t.Errorf("%d: expected=%+v got=%+v", i, expected[i], matches[i])
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	for requested, expected := range map[int]int{0: defaultLimit, 5: 5, maxLimit + 1: maxLimit} {
+		if got, err := limit(requested); err != nil || got != expected {
+			t.Errorf("%d: expected=%d got=%d %v", requested, expected, got, err)
+		}
+	}
+	if _, err := limit(-1); err != ErrInvalidQuery {
+		t.Errorf("expected=%v got=%v", ErrInvalidQuery, err)
+	}
+}
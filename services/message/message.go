@@ -0,0 +1,155 @@
+package message
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	nats "github.com/nats-io/nats.go"
+)
+
+// source represents the service in domain events
+const source = "message"
+
+// maxBody represents maximum length of the Message in characters
+const maxBody = 10000
+
+var (
+	// ErrNotParticipant represents error returned when Thread is accessed by someone other than Client or Freelancer of the Task
+	ErrNotParticipant = rpc.Errorf(rpc.CodePermission, "not a participant of the task")
+	// ErrInvalidBody represents error returned when Message is empty or too long
+	ErrInvalidBody = rpc.Errorf(rpc.CodeInvalid, "message must be 1 to %d characters long", maxBody)
+)
+
+// Service represents Message service that stores per-Task Threads
+// between Client and Freelancer of the Task
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Message service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.MessageSend, s.Send); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.MessageList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.MessageRead, s.Read); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.MessageUnread, s.Unread); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Send will store the Message in the Task's Thread, sender must be Client or assigned Freelancer of the Task
+func (s *Service) Send(ctx context.Context, m model.Message) (model.Message, error) {
+	if l := utf8.RuneCountInString(m.Body); l == 0 || l > maxBody {
+		return m, ErrInvalidBody
+	}
+	if err := s.checkParticipant(ctx, m.TaskID, m.SenderID); err != nil {
+		return m, err
+	}
+	if m.ID == "" {
+		m.ID = model.NewID()
+	}
+	m.CreatedAt = time.Now().UTC()
+	m.ReadAt = pq.NullTime{}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return m, err
+	}
+	insertS := "INSERT INTO message (id, task_id, sender_id, body, created_at) VALUES($1, $2, $3, $4, $5)"
+	if _, err := tx.Exec(insertS, m.ID, m.TaskID, m.SenderID, m.Body, m.CreatedAt); err != nil {
+		tx.Rollback()
+		return m, err
+	}
+	if err := events.Record(tx, source, events.MessageSent, m.TaskID, m); err != nil {
+		tx.Rollback()
+		return m, err
+	}
+	return m, tx.Commit()
+}
+
+// List will retrieve Messages of the Task's Thread along with number of Messages the user has not read
+func (s *Service) List(ctx context.Context, th model.Thread) (model.Thread, error) {
+	if err := s.checkParticipant(ctx, th.TaskID, th.UserID); err != nil {
+		return th, err
+	}
+	th.Messages = []model.Message{}
+	if err := s.db.SelectContext(ctx, &th.Messages, "SELECT * FROM message WHERE task_id=$1 ORDER BY created_at, id", th.TaskID); err != nil {
+		return th, err
+	}
+	th.Unread = 0
+	for _, m := range th.Messages {
+		if m.SenderID != th.UserID && !m.ReadAt.Valid {
+			th.Unread++
+		}
+	}
+	return th, nil
+}
+
+// Read will mark Messages of the Thread sent to the user as read
+func (s *Service) Read(ctx context.Context, th model.Thread) (rpc.Empty, error) {
+	if err := s.checkParticipant(ctx, th.TaskID, th.UserID); err != nil {
+		return rpc.Empty{}, err
+	}
+	updateS := "UPDATE message SET read_at=$1 WHERE task_id=$2 AND sender_id<>$3 AND read_at IS NULL"
+	_, err := s.db.ExecContext(ctx, updateS, time.Now().UTC(), th.TaskID, th.UserID)
+	return rpc.Empty{}, err
+}
+
+// Unread will retrieve Threads of the Tasks the user participates in that have unread Messages
+func (s *Service) Unread(ctx context.Context, userID string) ([]model.Thread, error) {
+	threads := []model.Thread{}
+	if len(userID) != 36 {
+		return threads, model.ErrInvalidID
+	}
+	rows := []struct {
+		TaskID string `db:"task_id"`
+		Unread int    `db:"unread"`
+	}{}
+	query := "SELECT m.task_id, count(*) AS unread FROM message m JOIN task t ON t.id = m.task_id " +
+		"WHERE (t.client_id=$1 OR t.freelancer_id=$1) AND m.sender_id<>$1 AND m.read_at IS NULL " +
+		"GROUP BY m.task_id ORDER BY m.task_id"
+	if err := s.db.SelectContext(ctx, &rows, query, userID); err != nil {
+		return threads, err
+	}
+	for _, r := range rows {
+		threads = append(threads, model.Thread{TaskID: r.TaskID, UserID: userID, Unread: r.Unread})
+	}
+	return threads, nil
+}
+
+// checkParticipant returns ErrNotParticipant unless user is Client or assigned Freelancer of the Task
+func (s *Service) checkParticipant(ctx context.Context, taskID, userID string) error {
+	if len(taskID) != 36 || len(userID) != 36 {
+		return model.ErrInvalidID
+	}
+	task := model.Task{}
+	query := "SELECT client_id, COALESCE(freelancer_id, '') AS freelancer_id FROM task WHERE id=$1 AND deleted_at IS NULL"
+	if err := s.db.GetContext(ctx, &task, query, taskID); err != nil {
+		return err
+	}
+	if userID != task.ClientID && userID != task.FreelancerID {
+		return ErrNotParticipant
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package message
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var messageSchema = `CREATE TABLE MESSAGE (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	SENDER_ID varchar(36) NOT NULL,
+	BODY text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	READ_AT timestamp
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+func setUp(t *testing.T) func() {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(moneyType)
+	db.Exec(taskSchema)
+	db.Exec(messageSchema)
+	db.Exec(outboxSchema)
+
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewService(db, natsEncConn); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		natsConn.Close()
+		natsServer.Shutdown()
+		db.Close()
+	}
+}
+
+// populateDB inserts started Task with assigned Freelancer
+func populateDB(t *testing.T) model.Task {
+	task := model.NewTask(time.Hour*24, model.NewMoney(2000, model.EUR), model.NewID(), "golang app")
+	task.FreelancerID = model.NewID()
+	task.Status = model.Started
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.Status); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func send(t *testing.T, task model.Task, senderID, body string) model.Message {
+	m, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageSend, model.Message{TaskID: task.ID, SenderID: senderID, Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func thread(t *testing.T, task model.Task, userID string) model.Thread {
+	th, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageList, model.Thread{TaskID: task.ID, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return th
+}
+
+func TestService_Thread(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t)
+	first := send(t, task, task.ClientID, "hi, when can you start?")
+	send(t, task, task.ClientID, "the deadline is tight")
+	send(t, task, task.FreelancerID, "today")
+
+	th := thread(t, task, task.FreelancerID)
+	if len(th.Messages) != 3 || th.Messages[0].ID != first.ID || th.Messages[2].SenderID != task.FreelancerID {
+		t.Fatalf("unexpected thread: %+v", th)
+	}
+	if th.Unread != 2 {
+		t.Errorf("expected=2 got=%d unread", th.Unread)
+	}
+	if th := thread(t, task, task.ClientID); th.Unread != 1 {
+		t.Errorf("expected=1 got=%d unread", th.Unread)
+	}
+
+	var count int
+	query := "SELECT count(*) FROM outbox WHERE subject=$1 AND convert_from(payload, 'UTF8') LIKE '%' || $2 || '%'"
+	if err := s.db.Get(&count, query, events.MessageSent.Subject(), task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected=3 got=%d events", count)
+	}
+}
+
+func TestService_Read(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t)
+	other := populateDB(t)
+	send(t, task, task.ClientID, "hi")
+	send(t, task, task.ClientID, "are you there?")
+	send(t, other, other.ClientID, "hi")
+
+	unread, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageUnread, task.FreelancerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unread) != 1 || unread[0].TaskID != task.ID || unread[0].Unread != 2 {
+		t.Errorf("unexpected unread threads: %+v", unread)
+	}
+
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageRead, model.Thread{TaskID: task.ID, UserID: task.FreelancerID}); err != nil {
+		t.Fatal(err)
+	}
+	th := thread(t, task, task.FreelancerID)
+	if th.Unread != 0 || !th.Messages[0].ReadAt.Valid || !th.Messages[1].ReadAt.Valid {
+		t.Errorf("unexpected thread: %+v", th)
+	}
+	unread, err = rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageUnread, task.FreelancerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unread) != 0 {
+		t.Errorf("unexpected unread threads: %+v", unread)
+	}
+}
+
+func TestService_NotParticipant(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t)
+	stranger := model.NewID()
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageSend,
+		model.Message{TaskID: task.ID, SenderID: stranger, Body: "hi"}); rpc.CodeOf(err) != rpc.CodePermission {
+		t.Errorf("expected=%s got=%v", rpc.CodePermission, err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageList,
+		model.Thread{TaskID: task.ID, UserID: stranger}); rpc.CodeOf(err) != rpc.CodePermission {
+		t.Errorf("expected=%s got=%v", rpc.CodePermission, err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageSend,
+		model.Message{TaskID: model.NewID(), SenderID: stranger, Body: "hi"}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+	for _, body := range []string{"", strings.Repeat("a", maxBody+1)} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.MessageSend,
+			model.Message{TaskID: task.ID, SenderID: task.ClientID, Body: body}); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+		}
+	}
+}
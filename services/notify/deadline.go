@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if err := s.RemindDeadlines(now); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+}
+
+// RemindDeadlines notifies Freelancers of started Tasks which deadline is within reminder period from now.
+// Every Task is reminded once, the email log keeps concurrent instances from reminding twice
+func (s *Service) RemindDeadlines(now time.Time) error {
+	if s.reminderBefore <= 0 {
+		return nil
+	}
+	now = now.UTC()
+	// deadline is stored in nanoseconds
+	query := "SELECT * FROM task WHERE status = $1 AND deleted_at IS NULL AND started_at IS NOT NULL AND deadline > 0 " +
+		"AND started_at + deadline / 1000 * interval '1 microsecond' BETWEEN $2 AND $3"
+	tasks := []model.Task{}
+	if err := s.db.Select(&tasks, query, model.Started, now, now.Add(s.reminderBefore)); err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		n := notice{Kind: model.DeadlineApproaching, Ref: t.ID, UserID: t.FreelancerID, Task: t, Due: due(t)}
+		if err := s.notify(n); err != nil {
+			logrus.WithField("task_id", t.ID).Error(err)
+		}
+	}
+	return nil
+}
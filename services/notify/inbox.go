@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultLimit and maxLimit represent number of Notifications returned per page
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// ErrInvalidQuery represents error returned for negative limit or offset
+var ErrInvalidQuery = rpc.Errorf(rpc.CodeInvalid, "invalid query")
+
+// List returns page of the user's Notifications, the latest first
+func (s *Service) List(ctx context.Context, q model.NotificationQuery) (model.NotificationPage, error) {
+	page := model.NotificationPage{Notifications: []model.Notification{}}
+	if len(q.UserID) != 36 {
+		return page, model.ErrInvalidID
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return page, ErrInvalidQuery
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultLimit
+	} else if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+
+	counts := struct {
+		Total  int `db:"total"`
+		Unread int `db:"unread"`
+	}{}
+	query := "SELECT count(*) AS total, count(*) FILTER (WHERE read_at IS NULL) AS unread FROM notification WHERE user_id=$1"
+	if err := s.db.GetContext(ctx, &counts, query, q.UserID); err != nil {
+		return page, err
+	}
+	page.Total, page.Unread = counts.Total, counts.Unread
+	if q.Unread {
+		page.Total = counts.Unread
+	}
+	query = "SELECT * FROM notification WHERE user_id=$1 AND (NOT $2 OR read_at IS NULL) ORDER BY created_at DESC, id LIMIT $3 OFFSET $4"
+	err := s.db.SelectContext(ctx, &page.Notifications, query, q.UserID, q.Unread, q.Limit, q.Offset)
+	return page, err
+}
+
+// Read marks Notifications of the user by ID as read, or every Notification of the user when All is set.
+// Notifications of other users and already read ones are left as they are
+func (s *Service) Read(ctx context.Context, r model.NotificationRead) (rpc.Empty, error) {
+	if len(r.UserID) != 36 {
+		return rpc.Empty{}, model.ErrInvalidID
+	}
+	for _, id := range r.IDs {
+		if len(id) != 36 {
+			return rpc.Empty{}, model.ErrInvalidID
+		}
+	}
+	if !r.All && len(r.IDs) == 0 {
+		return rpc.Empty{}, nil
+	}
+	updateS := "UPDATE notification SET read_at=$1 WHERE user_id=$2 AND read_at IS NULL AND ($3 OR id = ANY($4))"
+	_, err := s.db.ExecContext(ctx, updateS, time.Now().UTC(), r.UserID, r.All, pq.StringArray(r.IDs))
+	return rpc.Empty{}, err
+}
+
+// store adds Notification of the notice to the user's inbox and publishes it to the user's live streams,
+// the notice already in the inbox is skipped
+func (s *Service) store(n notice) error {
+	subject, _, err := render(n)
+	if err != nil {
+		return err
+	}
+	text, err := summary(n)
+	if err != nil {
+		return err
+	}
+	taskID := n.Task.ID
+	if taskID == "" {
+		taskID = n.Payment.TaskID
+	}
+	notification := model.Notification{
+		ID:        model.NewID(),
+		UserID:    n.UserID,
+		Kind:      n.Kind,
+		Ref:       n.Ref,
+		TaskID:    taskID,
+		Title:     subject,
+		Text:      text,
+		CreatedAt: time.Now().UTC(),
+	}
+	insertS := "INSERT INTO notification (id, user_id, kind, ref, task_id, title, text, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8) " +
+		"ON CONFLICT (kind, ref, user_id) DO NOTHING"
+	res, err := s.db.Exec(insertS, notification.ID, notification.UserID, notification.Kind, notification.Ref,
+		notification.TaskID, notification.Title, notification.Text, notification.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil || c == 0 {
+		return err
+	}
+	// live streams forward the Notification as JSON whatever encoder the connection uses,
+	// the inbox is fetched again by reconnected streams, so a Notification missing from the stream is not lost
+	d, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	if err := s.jsonConn.Conn.Publish(api.NotificationSubject(n.UserID), d); err != nil {
+		logrus.WithField("user_id", n.UserID).Error(err)
+	}
+	return nil
+}
+
+// summary returns text of the notice shown in the inbox
+func summary(n notice) (string, error) {
+	var b bytes.Buffer
+	if err := templates[n.Kind].ExecuteTemplate(&b, "inbox", n); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+)
+
+func TestService_Inbox(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	clientID, freelancerID := addUsers(t)
+	task := addTask(t, clientID, freelancerID, model.Started, time.Now().UTC(), time.Hour*72)
+
+	live, err := s.jsonConn.Conn.SubscribeSync(api.NotificationSubject(freelancerID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Unsubscribe()
+
+	// redelivered event is added to the inbox once
+	assigned := statusChanged(t, task, model.Open, model.Started)
+	publish(t, assigned)
+	publish(t, assigned)
+	smtpServer.receive(t)
+	publish(t, statusChanged(t, task, model.Completed, model.Closed))
+	smtpServer.receive(t)
+	smtpServer.receive(t)
+
+	var pushed []model.Notification
+	for i := 0; i < 2; i++ {
+		msg, err := live.NextMsg(time.Second * 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n model.Notification
+		if err := json.Unmarshal(msg.Data, &n); err != nil {
+			t.Fatal(err)
+		}
+		pushed = append(pushed, n)
+	}
+	if _, err := live.NextMsg(time.Millisecond * 300); err != nats.ErrTimeout {
+		t.Errorf("expected no more notifications, got %v", err)
+	}
+	if pushed[0].Kind != model.TaskAssigned || pushed[1].Kind != model.TaskClosed || pushed[1].TaskID != task.ID {
+		t.Errorf("unexpected notifications %+v", pushed)
+	}
+
+	page, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationList, model.NotificationQuery{UserID: freelancerID, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.Unread != 2 || len(page.Notifications) != 1 || page.Notifications[0].ID != pushed[1].ID {
+		t.Errorf("unexpected page %+v", page)
+	}
+	if n := page.Notifications[0]; n.Title != "Task "+task.ID+" is closed" || n.Text != `"Build REST API" is closed` || n.ReadAt.Valid {
+		t.Errorf("unexpected notification %+v", n)
+	}
+
+	// other users cannot read the Notification
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationRead, model.NotificationRead{UserID: clientID, IDs: []string{pushed[0].ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationRead, model.NotificationRead{UserID: freelancerID, IDs: []string{pushed[0].ID}}); err != nil {
+		t.Fatal(err)
+	}
+	page, err = rpc.Call(ctx, s.jsonConn.Conn, api.NotificationList, model.NotificationQuery{UserID: freelancerID, Unread: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Unread != 1 || len(page.Notifications) != 1 || page.Notifications[0].ID != pushed[1].ID {
+		t.Errorf("unexpected unread page %+v", page)
+	}
+
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationRead, model.NotificationRead{UserID: freelancerID, All: true}); err != nil {
+		t.Fatal(err)
+	}
+	page, err = rpc.Call(ctx, s.jsonConn.Conn, api.NotificationList, model.NotificationQuery{UserID: freelancerID, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || page.Unread != 0 || len(page.Notifications) != 1 || page.Notifications[0].ID != pushed[0].ID || !page.Notifications[0].ReadAt.Valid {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	for _, c := range []struct {
+		query model.NotificationQuery
+		code  rpc.Code
+	}{
+		{model.NotificationQuery{UserID: "1"}, rpc.CodeInvalid},
+		{model.NotificationQuery{UserID: freelancerID, Limit: -1}, rpc.CodeInvalid},
+	} {
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationList, c.query); rpc.CodeOf(err) != c.code {
+			t.Errorf("%+v: expected=%s got=%v", c.query, c.code, err)
+		}
+	}
+}
+
+func TestService_InboxOptOut(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	clientID, freelancerID := addUsers(t)
+	task := addTask(t, clientID, freelancerID, model.Started, time.Now().UTC(), 0)
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesSet, model.EmailPreferences{UserID: freelancerID, OptOut: []string{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// users opted out of emails are still notified in the inbox
+	if err := s.notify(notice{Kind: model.TaskAssigned, Ref: model.NewID(), UserID: freelancerID, Task: task}); err != nil {
+		t.Fatal(err)
+	}
+	smtpServer.none(t)
+	page, err := rpc.Call(ctx, s.jsonConn.Conn, api.NotificationList, model.NotificationQuery{UserID: freelancerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Notifications[0].Kind != model.TaskAssigned {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// templates holds "subject" and "body" templates of every NotificationKind,
+// they are defined in templates/<kind>.tmpl
+var templates = parseTemplates()
+
+func parseTemplates() map[model.NotificationKind]*template.Template {
+	m := map[model.NotificationKind]*template.Template{}
+	for _, kind := range model.NotificationKinds {
+		m[kind] = template.Must(template.ParseFS(templateFiles, "templates/"+string(kind)+".tmpl"))
+	}
+	return m
+}
+
+// Sender represents transport emails are sent with
+type Sender interface {
+	// Send sends plain text email with the subject to the address
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends emails through SMTP server, STARTTLS is used when the server supports it
+type SMTPSender struct {
+	Addr string    // Addr represents host:port of the server
+	From string    // From represents sender's address
+	Auth smtp.Auth // Auth authenticates the sender, nil means no authentication
+}
+
+// NewSMTPSender returns SMTPSender authenticated with PLAIN mechanism when user is set
+func NewSMTPSender(addr, from, user, password string) *SMTPSender {
+	s := &SMTPSender{Addr: addr, From: from}
+	if user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		s.Auth = smtp.PlainAuth("", user, password, host)
+	}
+	return s
+}
+
+// Send sends plain text email with the subject to the address
+func (s *SMTPSender) Send(to, subject, body string) error {
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to}, compose(s.From, to, subject, body, time.Now()))
+}
+
+// LogSender logs emails instead of sending them, it is used when no SMTP server is configured
+type LogSender struct{}
+
+// Send logs recipient and subject of the email
+func (LogSender) Send(to, subject, body string) error {
+	logrus.WithFields(logrus.Fields{"to": to, "subject": subject}).Info("email is not sent, no SMTP server")
+	return nil
+}
+
+// compose returns RFC 5322 message, the subject is encoded so it may contain any characters
+func compose(from, to, subject, body string, date time.Time) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes()
+}
+
+// notice represents notification of the user about the Task or Payment
+type notice struct {
+	Kind    model.NotificationKind
+	Ref     string // Ref represents event or Task the notice is about, the user gets one notice of a kind per Ref
+	UserID  string
+	Task    model.Task
+	Payment model.Payment
+	Due     time.Time // Due represents deadline of the Task
+}
+
+// render returns subject and body of the email for the notice
+func render(n notice) (subject, body string, err error) {
+	t, ok := templates[n.Kind]
+	if !ok {
+		return "", "", fmt.Errorf("no template of %s notification", n.Kind)
+	}
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, "subject", n); err != nil {
+		return "", "", err
+	}
+	subject = strings.TrimSpace(b.String())
+	b.Reset()
+	if err := t.ExecuteTemplate(&b, "body", n); err != nil {
+		return "", "", err
+	}
+	return subject, strings.TrimSpace(b.String()) + "\n", nil
+}
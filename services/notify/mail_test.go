@@ -0,0 +1,206 @@
+package notify
+
+import (
+	"database/sql"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/lib/pq"
+)
+
+// email represents message received by fakeSMTP
+type email struct {
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP represents local SMTP server collecting received emails,
+// recipients containing "reject" are refused
+type fakeSMTP struct {
+	ln     net.Listener
+	emails chan email
+}
+
+func startSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeSMTP{ln: ln, emails: make(chan email, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.session(conn)
+		}
+	}()
+	return srv
+}
+
+func (s *fakeSMTP) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeSMTP) Close() {
+	s.ln.Close()
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	tp := textproto.NewConn(conn)
+	defer tp.Close()
+	tp.PrintfLine("220 localhost fake SMTP")
+	var e email
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			tp.PrintfLine("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			e = email{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			tp.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			to := strings.Trim(line[len("RCPT TO:"):], "<> ")
+			if strings.Contains(to, "reject") {
+				tp.PrintfLine("550 no such user")
+				continue
+			}
+			e.to = append(e.to, to)
+			tp.PrintfLine("250 OK")
+		case cmd == "DATA":
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			e.data = strings.Join(lines, "\n")
+			s.emails <- e
+			tp.PrintfLine("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			tp.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// receive returns the next email or fails the test after a while
+func (s *fakeSMTP) receive(t *testing.T) email {
+	t.Helper()
+	select {
+	case e := <-s.emails:
+		return e
+	case <-time.After(time.Second * 5):
+		t.Fatal("no email received")
+	}
+	return email{}
+}
+
+// none fails the test when email is received in a while
+func (s *fakeSMTP) none(t *testing.T) {
+	t.Helper()
+	select {
+	case e := <-s.emails:
+		t.Errorf("unexpected email to %v: %s", e.to, e.data)
+	case <-time.After(time.Millisecond * 300):
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	srv := startSMTP(t)
+	defer srv.Close()
+
+	sender := NewSMTPSender(srv.Addr(), "noreply@example.com", "", "")
+	if err := sender.Send("client@example.com", "Task is completed – review", "Hello,\n.\nbye\n"); err != nil {
+		t.Fatal(err)
+	}
+	e := srv.receive(t)
+	if e.from != "noreply@example.com" || len(e.to) != 1 || e.to[0] != "client@example.com" {
+		t.Errorf("unexpected envelope %+v", e)
+	}
+	for _, expected := range []string{
+		"To: client@example.com",
+		"Subject: =?utf-8?q?Task_is_completed_=E2=80=93_review?=",
+		"Content-Type: text/plain; charset=utf-8",
+		"\n\nHello,\n.\nbye",
+	} {
+		if !strings.Contains(e.data, expected) {
+			t.Errorf("%q not found in %s", expected, e.data)
+		}
+	}
+
+	if err := sender.Send("reject@example.com", "subject", "body"); err == nil {
+		t.Error("expected rejected recipient to fail")
+	}
+}
+
+func TestRender(t *testing.T) {
+	started := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	task := model.Task{ID: model.NewID(), Description: "Build REST API", Fee: model.NewMoney(150000, model.USD), Contract: model.FixedContract,
+		StartedAt: pq.NullTime{Time: started, Valid: true}, Deadline: time.Hour * 48, Tags: pq.StringArray{}}
+	payment := model.Payment{ID: model.NewID(), TaskID: task.ID, Amount: model.NewMoney(150000, model.USD), Reference: sql.NullString{}}
+	completed := task
+	completed.ReviewDeadline = pq.NullTime{Time: started.Add(time.Hour * 72), Valid: true}
+
+	for _, c := range []struct {
+		notice  notice
+		subject string
+		body    []string
+	}{
+		{notice{Kind: model.TaskAssigned, Task: task, Due: due(task)}, "You are assigned to task " + task.ID, []string{"Build REST API", "Fee: 1500.00 USD", "Deadline: 2018-10-03 12:00 UTC"}},
+		{notice{Kind: model.TaskCompleted, Task: task}, "Task " + task.ID + " is completed", []string{"Build REST API", "Please review the result."}},
+		{notice{Kind: model.TaskClosed, Task: task}, "Task " + task.ID + " is closed", []string{"Build REST API"}},
+		{notice{Kind: model.PaymentReceived, Task: task, Payment: payment}, "Payment of 1500.00 USD received", []string{"for the task " + task.ID + ":\n\nBuild REST API", "Payment: " + payment.ID}},
+		{notice{Kind: model.PaymentReceived, Payment: payment}, "Payment of 1500.00 USD received", []string{"for the task " + task.ID + ".\n"}},
+		{notice{Kind: model.DeadlineApproaching, Task: task, Due: due(task)}, "Deadline of task " + task.ID + " is approaching", []string{"due 2018-10-03 12:00 UTC"}},
+		{notice{Kind: model.ReviewReminder, Task: completed}, "Review of task " + task.ID + " ends soon", []string{"Build REST API", "paid on 2018-10-04 12:00 UTC"}},
+	} {
+		subject, body, err := render(c.notice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject != c.subject {
+			t.Errorf("%s: expected=%q got=%q", c.notice.Kind, c.subject, subject)
+		}
+		for _, expected := range c.body {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: %q not found in %q", c.notice.Kind, expected, body)
+			}
+		}
+	}
+	if _, _, err := render(notice{Kind: "unknown"}); err == nil {
+		t.Error("expected unknown kind to fail")
+	}
+
+	for _, c := range []struct {
+		notice notice
+		text   string
+	}{
+		{notice{Kind: model.TaskAssigned, Task: task}, `You have started working on "Build REST API"`},
+		{notice{Kind: model.PaymentReceived, Task: task, Payment: payment}, `1500.00 USD was transferred to your wallet for "Build REST API"`},
+		{notice{Kind: model.PaymentReceived, Payment: payment}, "1500.00 USD was transferred to your wallet"},
+		{notice{Kind: model.DeadlineApproaching, Task: task, Due: due(task)}, `"Build REST API" is due 2018-10-03 12:00 UTC`},
+		{notice{Kind: model.ReviewReminder, Task: completed}, `"Build REST API" is approved automatically on 2018-10-04 12:00 UTC`},
+	} {
+		text, err := summary(c.notice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != c.text {
+			t.Errorf("%s: expected=%q got=%q", c.notice.Kind, c.text, text)
+		}
+	}
+}
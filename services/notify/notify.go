@@ -0,0 +1,322 @@
+// Package notify notifies Clients and Freelancers about lifecycle of their Tasks and Payments.
+//
+// The service consumes domain events and checks deadlines of started Tasks, every notice
+// is rendered from the template of its NotificationKind, added to the user's in-app inbox
+// and sent to Email of the user unless the user opted out of the kind. New Notifications are
+// published to the user's live streams. Notifications and sent emails are unique per notice,
+// so redelivered events and concurrent instances do not notify the user twice
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/jetstream"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// optOutAll represents opt-out of every NotificationKind
+const optOutAll = "*"
+
+// ErrInvalidKind represents error returned when user opts out of unknown NotificationKind
+var ErrInvalidKind = rpc.Errorf(rpc.CodeInvalid, "unknown notification kind")
+
+// Service represents Notification service that notifies users about their Tasks and Payments
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+	sender   Sender
+
+	js             nats.JetStreamContext
+	reminderBefore time.Duration
+	interval       time.Duration
+	done           chan struct{}
+}
+
+// NewService returns new instance of Notification service sending emails with the sender
+func NewService(db *sqlx.DB, conn *nats.EncodedConn, sender Sender, opts ...Option) (*Service, error) {
+	srv := &Service{
+		db:       db,
+		jsonConn: conn,
+		sender:   sender,
+		interval: time.Minute,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if err := srv.init(); err != nil {
+		return srv, err
+	}
+	go srv.run()
+	return srv, nil
+}
+
+// Close stops checking of deadlines
+func (s *Service) Close() {
+	close(s.done)
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.EmailPreferencesGet, s.Preferences); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.EmailPreferencesSet, s.SetPreferences); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.NotificationList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.NotificationRead, s.Read); err != nil {
+		return err
+	}
+
+	subject := events.SubjectPrefix + ">"
+	if s.js != nil {
+		_, err := jetstream.Consume(s.js, subject, "notify-events", jetstream.DefaultMaxDeliver, s.handle)
+		return err
+	}
+	_, err := s.jsonConn.Conn.QueueSubscribe(subject, "notify-queue", func(msg *nats.Msg) {
+		if err := s.handle(msg.Data); err != nil {
+			logrus.WithField("subject", msg.Subject).Error(err)
+		}
+	})
+	return err
+}
+
+// Preferences returns EmailPreferences of the user by ID, the user gets every email by default
+func (s *Service) Preferences(ctx context.Context, userID string) (model.EmailPreferences, error) {
+	p := model.EmailPreferences{UserID: userID, OptOut: pq.StringArray{}}
+	if len(userID) != 36 {
+		return p, model.ErrInvalidID
+	}
+	if _, err := s.email(ctx, userID); err != nil {
+		return p, err
+	}
+	err := s.db.GetContext(ctx, &p, "SELECT * FROM email_preference WHERE user_id=$1", userID)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	return p, err
+}
+
+// SetPreferences replaces EmailPreferences of the user
+func (s *Service) SetPreferences(ctx context.Context, p model.EmailPreferences) (model.EmailPreferences, error) {
+	if len(p.UserID) != 36 {
+		return p, model.ErrInvalidID
+	}
+	optOut := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, kind := range p.OptOut {
+		if !known(kind) {
+			return p, ErrInvalidKind
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			optOut = append(optOut, kind)
+		}
+	}
+	p.OptOut = optOut
+	if _, err := s.email(ctx, p.UserID); err != nil {
+		return p, err
+	}
+	upsertS := "INSERT INTO email_preference (user_id, opt_out) VALUES($1, $2) ON CONFLICT (user_id) DO UPDATE SET opt_out = EXCLUDED.opt_out"
+	_, err := s.db.ExecContext(ctx, upsertS, p.UserID, p.OptOut)
+	return p, err
+}
+
+// handle notifies parties of the Task or Payment the event is about
+func (s *Service) handle(data []byte) error {
+	var e events.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	notices, err := s.notices(e)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, n := range notices {
+		if err := s.notify(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notices returns notices of the event, events users are not notified about have none
+func (s *Service) notices(e events.Envelope) ([]notice, error) {
+	switch e.Type {
+	case events.TaskStatusChanged:
+		var change events.StatusChange
+		if err := e.Decode(&change); err != nil {
+			return nil, err
+		}
+		var kind model.NotificationKind
+		switch change.To {
+		case model.Started:
+			kind = model.TaskAssigned
+		case model.Completed:
+			kind = model.TaskCompleted
+		case model.Closed:
+			kind = model.TaskClosed
+		default:
+			return nil, nil
+		}
+		t, err := s.task(change.TaskID)
+		if err != nil {
+			return nil, err
+		}
+		n := notice{Kind: kind, Ref: e.ID, Task: t, Due: due(t)}
+		switch kind {
+		case model.TaskAssigned:
+			return n.to(t.FreelancerID), nil
+		case model.TaskCompleted:
+			return n.to(t.ClientID), nil
+		}
+		return n.to(t.ClientID, t.FreelancerID), nil
+	case events.PaymentPaid:
+		var p model.Payment
+		if err := e.Decode(&p); err != nil {
+			return nil, err
+		}
+		t, err := s.task(p.TaskID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		return notice{Kind: model.PaymentReceived, Ref: e.ID, Task: t, Payment: p}.to(p.FreelancerID), nil
+	case events.TaskReviewReminder:
+		t, err := s.task(e.AggregateID)
+		if err != nil {
+			return nil, err
+		}
+		// the Task was closed or disputed before the reminder was delivered
+		if t.Status != model.Completed {
+			return nil, nil
+		}
+		return notice{Kind: model.ReviewReminder, Ref: e.ID, Task: t}.to(t.ClientID), nil
+	}
+	return nil, nil
+}
+
+// to returns copies of the notice for every user
+func (n notice) to(userIDs ...string) []notice {
+	notices := []notice{}
+	for _, id := range userIDs {
+		if id == "" {
+			continue
+		}
+		n.UserID = id
+		notices = append(notices, n)
+	}
+	return notices
+}
+
+// notify adds the notice to the user's inbox and emails it, deleted users are not notified
+func (s *Service) notify(n notice) error {
+	email, err := s.email(context.Background(), n.UserID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.store(n); err != nil {
+		return err
+	}
+	return s.mail(n, email)
+}
+
+// mail emails the notice unless the user has no email, opted out of its kind or was already emailed.
+// The email is logged before it is sent, failed email is removed from the log to be sent again
+func (s *Service) mail(n notice, email string) error {
+	if email == "" {
+		return nil
+	}
+	if optedOut, err := s.optedOut(n.UserID, n.Kind); err != nil || optedOut {
+		return err
+	}
+	subject, body, err := render(n)
+	if err != nil {
+		return err
+	}
+	log := logrus.WithFields(logrus.Fields{"kind": n.Kind, "user_id": n.UserID})
+
+	id := model.NewID()
+	insertS := "INSERT INTO email_log (id, kind, ref, user_id, email, subject, created_at) VALUES($1, $2, $3, $4, $5, $6, $7) " +
+		"ON CONFLICT (kind, ref, user_id) DO NOTHING"
+	res, err := s.db.Exec(insertS, id, n.Kind, n.Ref, n.UserID, email, subject, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil || c == 0 {
+		return err
+	}
+	if err := s.sender.Send(email, subject, body); err != nil {
+		if _, dErr := s.db.Exec("DELETE FROM email_log WHERE id=$1", id); dErr != nil {
+			log.Error(dErr)
+		}
+		return err
+	}
+	if _, err := s.db.Exec("UPDATE email_log SET sent_at=$1 WHERE id=$2", time.Now().UTC(), id); err != nil {
+		log.Error(err)
+	}
+	log.Info("email sent")
+	return nil
+}
+
+// email returns Email of the Client or Freelancer by ID
+func (s *Service) email(ctx context.Context, userID string) (string, error) {
+	var email sql.NullString
+	query := "SELECT email FROM client WHERE id=$1 AND deleted_at IS NULL " +
+		"UNION ALL SELECT email FROM freelancer WHERE id=$1 AND deleted_at IS NULL LIMIT 1"
+	err := s.db.GetContext(ctx, &email, query, userID)
+	return email.String, err
+}
+
+// optedOut reports whether the user opted out of emails of the kind
+func (s *Service) optedOut(userID string, kind model.NotificationKind) (bool, error) {
+	var optedOut bool
+	query := "SELECT EXISTS(SELECT 1 FROM email_preference WHERE user_id=$1 AND opt_out && $2)"
+	err := s.db.Get(&optedOut, query, userID, pq.StringArray{string(kind), optOutAll})
+	return optedOut, err
+}
+
+func (s *Service) task(id string) (model.Task, error) {
+	t := model.Task{}
+	err := s.db.Get(&t, "SELECT * FROM task WHERE id=$1", id)
+	return t, err
+}
+
+// known reports whether kind is NotificationKind or opt-out of every kind
+func known(kind string) bool {
+	if kind == optOutAll {
+		return true
+	}
+	for _, k := range model.NotificationKinds {
+		if string(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// due returns deadline of the started Task, zero time means no deadline
+func due(t model.Task) time.Time {
+	if !t.StartedAt.Valid || t.Deadline <= 0 {
+		return time.Time{}
+	}
+	return t.StartedAt.Time.Add(t.Deadline)
+}
@@ -0,0 +1,358 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var emailLogSchema = `CREATE TABLE EMAIL_LOG (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	KIND varchar(32) NOT NULL,
+	REF varchar(36) NOT NULL,
+	USER_ID varchar(36) NOT NULL,
+	EMAIL varchar(128) NOT NULL,
+	SUBJECT text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	SENT_AT timestamp,
+	UNIQUE (KIND, REF, USER_ID)
+)`
+
+var emailPreferenceSchema = `CREATE TABLE EMAIL_PREFERENCE (
+	USER_ID varchar(36) PRIMARY KEY NOT NULL,
+	OPT_OUT text[] NOT NULL DEFAULT '{}'
+)`
+
+var notificationSchema = `CREATE TABLE NOTIFICATION (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	USER_ID varchar(36) NOT NULL,
+	KIND varchar(32) NOT NULL,
+	REF varchar(36) NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	TITLE text NOT NULL,
+	TEXT text NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	READ_AT timestamp,
+	UNIQUE (KIND, REF, USER_ID)
+)`
+
+func setUp(t *testing.T) (*fakeSMTP, func()) {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	for _, schema := range []string{moneyType, taskSchema, clientSchema, freelancerSchema, emailLogSchema, emailPreferenceSchema, notificationSchema} {
+		db.Exec(schema)
+	}
+
+	smtpServer := startSMTP(t)
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := NewSMTPSender(smtpServer.Addr(), "noreply@example.com", "", "")
+	if s, err = NewService(db, natsEncConn, sender, WithDeadlineReminder(time.Hour*24), WithInterval(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	return smtpServer, func() {
+		s.Close()
+		natsConn.Close()
+		natsServer.Shutdown()
+		smtpServer.Close()
+		db.Close()
+	}
+}
+
+// addUsers inserts Client and Freelancer with emails unique to the run
+func addUsers(t *testing.T) (clientID, freelancerID string) {
+	clientID, freelancerID = model.NewID(), model.NewID()
+	if _, err := s.db.Exec("INSERT INTO client (id, email) VALUES($1, $2)", clientID, "client-"+clientID[:8]+"@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, description, details, email) VALUES($1, '', '', $2)", freelancerID, "freelancer-"+freelancerID[:8]+"@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return clientID, freelancerID
+}
+
+func addTask(t *testing.T, clientID, freelancerID string, status model.TaskStatus, startedAt time.Time, deadline time.Duration) model.Task {
+	task := model.Task{ID: model.NewID(), ClientID: clientID, FreelancerID: freelancerID, Description: "Build REST API", Status: status,
+		Fee: model.NewMoney(150000, model.USD), CreatedAt: time.Now().UTC(), StartedAt: pq.NullTime{Time: startedAt, Valid: true}, Deadline: deadline}
+	insertS := "INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, started_at, status) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	if _, err := s.db.Exec(insertS, task.ID, task.ClientID, task.FreelancerID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.StartedAt, task.Status); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// publish publishes event the way outbox relay does
+func publish(t *testing.T, e events.Envelope) {
+	d, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.jsonConn.Conn.Publish(e.Type.Subject(), d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func statusChanged(t *testing.T, task model.Task, from, to model.TaskStatus) events.Envelope {
+	e, err := events.New("task", events.TaskStatusChanged, task.ID, events.StatusChange{TaskID: task.ID, From: from, To: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestService_Lifecycle(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	clientID, freelancerID := addUsers(t)
+	task := addTask(t, clientID, freelancerID, model.Started, time.Now().UTC(), time.Hour*72)
+	clientEmail, freelancerEmail := "client-"+clientID[:8]+"@example.com", "freelancer-"+freelancerID[:8]+"@example.com"
+
+	// redelivered event is emailed once
+	assigned := statusChanged(t, task, model.Open, model.Started)
+	publish(t, assigned)
+	publish(t, assigned)
+	e := smtpServer.receive(t)
+	if e.to[0] != freelancerEmail || !strings.Contains(e.data, "You are assigned to task "+task.ID) {
+		t.Errorf("unexpected email to %v: %s", e.to, e.data)
+	}
+	smtpServer.none(t)
+
+	publish(t, statusChanged(t, task, model.Started, model.Completed))
+	if e := smtpServer.receive(t); e.to[0] != clientEmail || !strings.Contains(e.data, "is completed") {
+		t.Errorf("unexpected email to %v: %s", e.to, e.data)
+	}
+
+	publish(t, statusChanged(t, task, model.Completed, model.Closed))
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := smtpServer.receive(t)
+		received[e.to[0]] = strings.Contains(e.data, "is closed")
+	}
+	if !received[clientEmail] || !received[freelancerEmail] {
+		t.Errorf("expected closed task emailed to both parties, got %v", received)
+	}
+
+	payment := model.Payment{ID: model.NewID(), ClientID: clientID, FreelancerID: freelancerID, TaskID: task.ID, Amount: task.Fee, Status: model.Paid}
+	paid, err := events.New("task", events.PaymentPaid, payment.ID, payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, paid)
+	if e := smtpServer.receive(t); e.to[0] != freelancerEmail || !strings.Contains(e.data, "1500.00 USD was transferred") {
+		t.Errorf("unexpected email to %v: %s", e.to, e.data)
+	}
+
+	var logged int
+	if err := s.db.Get(&logged, "SELECT count(*) FROM email_log WHERE user_id IN ($1, $2) AND sent_at IS NOT NULL", clientID, freelancerID); err != nil {
+		t.Fatal(err)
+	}
+	if logged != 5 {
+		t.Errorf("expected 5 logged emails, got %d", logged)
+	}
+}
+
+func TestService_OptOut(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	clientID, freelancerID := addUsers(t)
+	task := addTask(t, clientID, freelancerID, model.Completed, time.Now().UTC(), 0)
+
+	prefs, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesGet, freelancerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefs.UserID != freelancerID || len(prefs.OptOut) != 0 {
+		t.Errorf("expected every email by default, got %+v", prefs)
+	}
+	prefs, err = rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesSet,
+		model.EmailPreferences{UserID: freelancerID, OptOut: pq.StringArray{"task_closed", "payment_received", "task_closed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(prefs.OptOut, ",") != "task_closed,payment_received" {
+		t.Errorf("unexpected preferences %+v", prefs)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesSet, model.EmailPreferences{UserID: clientID, OptOut: pq.StringArray{"*"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, statusChanged(t, task, model.Completed, model.Closed))
+	smtpServer.none(t)
+
+	// opting in again
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesSet, model.EmailPreferences{UserID: freelancerID}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, statusChanged(t, task, model.Completed, model.Closed))
+	if e := smtpServer.receive(t); !strings.HasPrefix(e.to[0], "freelancer-") {
+		t.Errorf("unexpected email to %v", e.to)
+	}
+	smtpServer.none(t)
+
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesSet, model.EmailPreferences{UserID: clientID, OptOut: pq.StringArray{"newsletter"}}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.EmailPreferencesGet, model.NewID()); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestService_RemindDeadlines(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	now := time.Now().UTC()
+	clientID, freelancerID := addUsers(t)
+	due := addTask(t, clientID, freelancerID, model.Started, now.Add(-time.Hour*60), time.Hour*72)
+	addTask(t, clientID, freelancerID, model.Started, now, time.Hour*72)
+	addTask(t, clientID, freelancerID, model.Completed, now.Add(-time.Hour*60), time.Hour*72)
+
+	for i := 0; i < 2; i++ {
+		if err := s.RemindDeadlines(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Tasks of previous runs may be due as well, the tables are shared by runs
+	var reminded []string
+	for len(smtpServer.emails) > 0 {
+		if e := <-smtpServer.emails; e.to[0] == "freelancer-"+freelancerID[:8]+"@example.com" {
+			reminded = append(reminded, e.data)
+		}
+	}
+	if len(reminded) != 1 || !strings.Contains(reminded[0], "Deadline of task "+due.ID) {
+		t.Errorf("expected one reminder of %s, got %v", due.ID, reminded)
+	}
+}
+
+func TestService_ReviewReminder(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	clientID, freelancerID := addUsers(t)
+	task := addTask(t, clientID, freelancerID, model.Completed, time.Now().UTC(), time.Hour*72)
+	deadline := time.Date(2018, 10, 4, 12, 0, 0, 0, time.UTC)
+	if _, err := s.db.Exec("UPDATE task SET review_deadline=$1 WHERE id=$2", deadline, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	reminder, err := events.New("task", events.TaskReviewReminder, task.ID, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// redelivered reminder is emailed once
+	publish(t, reminder)
+	publish(t, reminder)
+	e := smtpServer.receive(t)
+	if e.to[0] != "client-"+clientID[:8]+"@example.com" || !strings.Contains(e.data, "paid on 2018-10-04 12:00 UTC") {
+		t.Errorf("unexpected email to %v: %s", e.to, e.data)
+	}
+	smtpServer.none(t)
+	var kind model.NotificationKind
+	if err := s.db.Get(&kind, "SELECT kind FROM notification WHERE user_id=$1", clientID); err != nil {
+		t.Fatal(err)
+	}
+	if kind != model.ReviewReminder {
+		t.Errorf("expected=%s got=%s", model.ReviewReminder, kind)
+	}
+
+	// Task approved before the reminder was delivered
+	if _, err := s.db.Exec("UPDATE task SET status=$1 WHERE id=$2", model.Closed, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	late, err := events.New("task", events.TaskReviewReminder, task.ID, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, late)
+	smtpServer.none(t)
+}
+
+func TestService_Failed(t *testing.T) {
+	smtpServer, destroy := setUp(t)
+	defer destroy()
+
+	clientID := model.NewID()
+	if _, err := s.db.Exec("INSERT INTO client (id, email) VALUES($1, $2)", clientID, "reject-"+clientID[:8]+"@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	task := addTask(t, clientID, "", model.Completed, time.Now().UTC(), 0)
+	n := notice{Kind: model.TaskCompleted, Ref: model.NewID(), UserID: clientID, Task: task}
+	if err := s.notify(n); err == nil {
+		t.Fatal("expected rejected email to fail")
+	}
+	// failed email is sent again on redelivery
+	if _, err := s.db.Exec("UPDATE client SET email=$1 WHERE id=$2", "client-"+clientID[:8]+"@example.com", clientID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.notify(n); err != nil {
+		t.Fatal(err)
+	}
+	smtpServer.receive(t)
+}
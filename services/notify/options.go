@@ -0,0 +1,35 @@
+package notify
+
+import (
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// Option represents optional configuration of Notification service
+type Option func(*Service)
+
+// WithJetStream makes the service consume events from JetStream durable consumer
+// instead of core NATS subscription, so events published while the service is down are notified too
+func WithJetStream(js nats.JetStreamContext) Option {
+	return func(s *Service) {
+		s.js = js
+	}
+}
+
+// WithDeadlineReminder sets how long before deadline of the started Task Freelancer is notified,
+// zero disables the reminder
+func WithDeadlineReminder(before time.Duration) Option {
+	return func(s *Service) {
+		s.reminderBefore = before
+	}
+}
+
+// WithInterval sets how often approaching deadlines are checked
+func WithInterval(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
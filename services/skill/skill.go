@@ -0,0 +1,204 @@
+package skill
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+	nats "github.com/nats-io/nats.go"
+)
+
+const (
+	// maxSkills represents maximum number of Skills required by the Task or declared by the Freelancer
+	maxSkills = 20
+	// maxID represents maximum length of Skill's and Category's ID
+	maxID = 64
+	// maxName represents maximum length of Skill's and Category's name
+	maxName = 128
+)
+
+var (
+	// ErrInvalidID represents error returned when ID of the Skill or Category is not a lowercase slug
+	ErrInvalidID = rpc.Errorf(rpc.CodeInvalid, "ID must be up to %d letters, digits, '+', '#', '.' and '-'", maxID)
+	// ErrInvalidName represents error returned when name of the Skill or Category is empty or too long
+	ErrInvalidName = rpc.Errorf(rpc.CodeInvalid, "name must be 1 to %d characters long", maxName)
+	// ErrTooMany represents error returned when too many Skills are given
+	ErrTooMany = rpc.Errorf(rpc.CodeInvalid, "up to %d skills are allowed", maxSkills)
+	// ErrUnknownCategory represents error returned when Category does not exist
+	ErrUnknownCategory = rpc.Errorf(rpc.CodeInvalid, "unknown category")
+	// ErrUnknownSkill represents error returned when one of the Skills does not exist
+	ErrUnknownSkill = rpc.Errorf(rpc.CodeInvalid, "unknown skill")
+	// ErrInvalidLevel represents error returned when proficiency is not between Beginner and Expert
+	ErrInvalidLevel = rpc.Errorf(rpc.CodeInvalid, "level must be %d to %d", model.Beginner, model.Expert)
+)
+
+// Service represents Skill service that owns the taxonomy of Categories and Skills,
+// Tasks and Freelancers refer to Skills by ID
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Skill service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.CategoryList, s.Categories); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.CategoryAdd, s.AddCategory); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.SkillList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.SkillAdd, s.Add); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Categories will retrieve all Categories ordered by name
+func (s *Service) Categories(ctx context.Context, _ rpc.Empty) ([]model.Category, error) {
+	categories := []model.Category{}
+	err := s.db.SelectContext(ctx, &categories, "SELECT id, name FROM category ORDER BY name, id")
+	return categories, err
+}
+
+// AddCategory will create the Category or rename existing one
+func (s *Service) AddCategory(ctx context.Context, c model.Category) (model.Category, error) {
+	c.ID = strings.ToLower(strings.TrimSpace(c.ID))
+	c.Name = strings.TrimSpace(c.Name)
+	if !validID(c.ID) {
+		return c, ErrInvalidID
+	}
+	if c.Name == "" || len(c.Name) > maxName {
+		return c, ErrInvalidName
+	}
+	upsertS := "INSERT INTO category (id, name) VALUES($1, $2) ON CONFLICT (id) DO UPDATE SET name=EXCLUDED.name"
+	_, err := s.db.ExecContext(ctx, upsertS, c.ID, c.Name)
+	return c, err
+}
+
+// List will retrieve Skills of the Category by ID ordered by name, empty ID means every Skill
+func (s *Service) List(ctx context.Context, categoryID string) ([]model.Skill, error) {
+	skills := []model.Skill{}
+	query := "SELECT id, name, category_id FROM skill WHERE $1 = '' OR category_id = $1 ORDER BY name, id"
+	err := s.db.SelectContext(ctx, &skills, query, categoryID)
+	return skills, err
+}
+
+// Add will create the Skill in existing Category or update existing one
+func (s *Service) Add(ctx context.Context, sk model.Skill) (model.Skill, error) {
+	sk.ID = strings.ToLower(strings.TrimSpace(sk.ID))
+	sk.Name = strings.TrimSpace(sk.Name)
+	if !validID(sk.ID) {
+		return sk, ErrInvalidID
+	}
+	if sk.Name == "" || len(sk.Name) > maxName {
+		return sk, ErrInvalidName
+	}
+	if sk.CategoryID == "" {
+		return sk, ErrUnknownCategory
+	}
+	if err := Check(ctx, s.db, sk.CategoryID, nil); err != nil {
+		return sk, err
+	}
+	upsertS := "INSERT INTO skill (id, name, category_id) VALUES($1, $2, $3) " +
+		"ON CONFLICT (id) DO UPDATE SET name=EXCLUDED.name, category_id=EXCLUDED.category_id"
+	_, err := s.db.ExecContext(ctx, upsertS, sk.ID, sk.Name, sk.CategoryID)
+	return sk, err
+}
+
+// Normalize trims and lowercases IDs of the Skills and removes duplicates, the result is never nil
+func Normalize(ids []string) (pq.StringArray, error) {
+	normalized := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if !validID(id) {
+			return nil, ErrInvalidID
+		}
+		if !seen[id] {
+			seen[id] = true
+			normalized = append(normalized, id)
+		}
+	}
+	if len(normalized) > maxSkills {
+		return nil, ErrTooMany
+	}
+	return normalized, nil
+}
+
+// NormalizeLevels normalizes Skills declared by the Freelancer, the last level of repeated Skill wins
+func NormalizeLevels(skills []model.FreelancerSkill) ([]model.FreelancerSkill, error) {
+	normalized := []model.FreelancerSkill{}
+	index := map[string]int{}
+	for _, fs := range skills {
+		fs.SkillID = strings.ToLower(strings.TrimSpace(fs.SkillID))
+		if !validID(fs.SkillID) {
+			return nil, ErrInvalidID
+		}
+		if !fs.Level.Valid() {
+			return nil, ErrInvalidLevel
+		}
+		if i, ok := index[fs.SkillID]; ok {
+			normalized[i] = fs
+			continue
+		}
+		index[fs.SkillID] = len(normalized)
+		normalized = append(normalized, fs)
+	}
+	if len(normalized) > maxSkills {
+		return nil, ErrTooMany
+	}
+	return normalized, nil
+}
+
+// Check verifies that the Category, unless empty, and every Skill exist in the taxonomy,
+// IDs are expected to be normalized
+func Check(ctx context.Context, q sqlx.QueryerContext, categoryID string, skillIDs []string) error {
+	if categoryID != "" {
+		var n int
+		if err := sqlx.GetContext(ctx, q, &n, "SELECT count(*) FROM category WHERE id = $1", categoryID); err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUnknownCategory
+		}
+	}
+	if len(skillIDs) == 0 {
+		return nil
+	}
+	var n int
+	if err := sqlx.GetContext(ctx, q, &n, "SELECT count(*) FROM skill WHERE id = ANY($1)", pq.StringArray(skillIDs)); err != nil {
+		return err
+	}
+	if n != len(skillIDs) {
+		return ErrUnknownSkill
+	}
+	return nil
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > maxID {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '+', r == '#', r == '.', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package skill
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var categorySchema = `CREATE TABLE CATEGORY (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL
+)`
+
+var skillSchema = `CREATE TABLE SKILL (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	CATEGORY_ID varchar(64) NOT NULL REFERENCES CATEGORY (ID)
+)`
+
+func setUp(t *testing.T) func() {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(categorySchema)
+	db.Exec(skillSchema)
+
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err = NewService(db, natsEncConn); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		natsConn.Close()
+		natsServer.Shutdown()
+		db.Close()
+	}
+}
+
+func TestService_Taxonomy(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	// IDs are unique per run, the tables are shared by runs
+	suffix := model.NewID()[:8]
+	category, err := rpc.Call(ctx, s.jsonConn.Conn, api.CategoryAdd, model.Category{ID: " Audio-" + suffix, Name: "Audio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.ID != "audio-"+suffix {
+		t.Errorf("expected normalized ID, got %q", category.ID)
+	}
+	for _, name := range []string{"Mixing", "Mastering"} {
+		sk := model.Skill{ID: strings.ToLower(name) + "-" + suffix, Name: name, CategoryID: category.ID}
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.SkillAdd, sk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// adding existing Skill renames it
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.SkillAdd, model.Skill{ID: "mixing-" + suffix, Name: "Audio Mixing", CategoryID: category.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	skills, err := rpc.Call(ctx, s.jsonConn.Conn, api.SkillList, category.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skills) != 2 || skills[0].Name != "Audio Mixing" || skills[1].ID != "mastering-"+suffix {
+		t.Errorf("unexpected skills %+v", skills)
+	}
+	categories, err := rpc.Call(ctx, s.jsonConn.Conn, api.CategoryList, rpc.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range categories {
+		found = found || c == category
+	}
+	if !found {
+		t.Errorf("%+v not found in %+v", category, categories)
+	}
+
+	if err := Check(ctx, s.db, category.ID, []string{"mixing-" + suffix, "mastering-" + suffix}); err != nil {
+		t.Error(err)
+	}
+	if err := Check(ctx, s.db, "", []string{"mixing-" + suffix, "singing-" + suffix}); err != ErrUnknownSkill {
+		t.Errorf("expected=%v got=%v", ErrUnknownSkill, err)
+	}
+	if err := Check(ctx, s.db, "video-"+suffix, nil); err != ErrUnknownCategory {
+		t.Errorf("expected=%v got=%v", ErrUnknownCategory, err)
+	}
+}
+
+func TestService_AddInvalid(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	for _, sk := range []model.Skill{
+		{ID: "two words", Name: "Two words", CategoryID: "development"},
+		{ID: "go", Name: "", CategoryID: "development"},
+		{ID: "go", Name: "Go"},
+		{ID: "go", Name: "Go", CategoryID: "unknown-" + model.NewID()[:8]},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.SkillAdd, sk); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", sk, rpc.CodeInvalid, err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	ids, err := Normalize([]string{" Go", "postgresql", "go", "C++"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ids, ",") != "go,postgresql,c++" {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+	if ids, err := Normalize(nil); err != nil || ids == nil {
+		t.Errorf("expected empty IDs, got %v %v", ids, err)
+	}
+	if _, err := Normalize([]string{"node js"}); err != ErrInvalidID {
+		t.Errorf("expected=%v got=%v", ErrInvalidID, err)
+	}
+	tooMany := make([]string, maxSkills+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i+1)
+	}
+	if _, err := Normalize(tooMany); err != ErrTooMany {
+		t.Errorf("expected=%v got=%v", ErrTooMany, err)
+	}
+}
+
+func TestNormalizeLevels(t *testing.T) {
+	skills, err := NormalizeLevels([]model.FreelancerSkill{{SkillID: "Go", Level: model.Beginner}, {SkillID: "sql", Level: model.Expert}, {SkillID: "go", Level: model.Intermediate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []model.FreelancerSkill{{SkillID: "go", Level: model.Intermediate}, {SkillID: "sql", Level: model.Expert}}
+	if len(skills) != 2 || skills[0] != expected[0] || skills[1] != expected[1] {
+		t.Errorf("expected=%v got=%v", expected, skills)
+	}
+	for _, level := range []model.SkillLevel{0, 4} {
+		if _, err := NormalizeLevels([]model.FreelancerSkill{{SkillID: "go", Level: level}}); err != ErrInvalidLevel {
+			t.Errorf("%d: expected=%v got=%v", level, ErrInvalidLevel, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package task
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/wallet"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrReasonRequired represents error returned when operator's action has no reason or actor
+	ErrReasonRequired = rpc.Errorf(rpc.CodeInvalid, "reason and actor are required")
+	// ErrClosed represents error returned on attempt to settle already closed Task
+	ErrClosed = rpc.Errorf(rpc.CodeInvalid, "task is closed")
+	// ErrNoFreelancer represents error returned when funds of the Task have nobody to be paid to
+	ErrNoFreelancer = rpc.Errorf(rpc.CodeInvalid, "task has no freelancer assigned")
+)
+
+// ForceClose closes the Task whatever its status, funds locked for the Task are paid to Freelancer
+func (s *Service) ForceClose(ctx context.Context, a model.TaskAction) (model.Task, error) {
+	return s.settle(ctx, a, false)
+}
+
+// Refund closes the Task whatever its status, funds locked for the Task are returned to Client.
+// Hourly contracts are charged weekly, so only fixed Fee is refunded
+func (s *Service) Refund(ctx context.Context, a model.TaskAction) (model.Task, error) {
+	return s.settle(ctx, a, true)
+}
+
+// settle closes the Task and pays out or refunds its locked funds within single transaction.
+// Funds of disputed Tasks are frozen and settled by dispute resolution only
+func (s *Service) settle(ctx context.Context, a model.TaskAction, refund bool) (model.Task, error) {
+	t := model.Task{}
+	a.Reason, a.Actor = strings.TrimSpace(a.Reason), strings.TrimSpace(a.Actor)
+	if len(a.TaskID) != 36 {
+		return t, model.ErrInvalidID
+	}
+	if a.Reason == "" || a.Actor == "" {
+		return t, ErrReasonRequired
+	}
+	if len(a.Actor) > rpc.MaxActor {
+		return t, rpc.ErrInvalidActor
+	}
+	ctx = rpc.WithActor(ctx, a.Actor)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return t, err
+	}
+	if err := tx.Get(&t, "SELECT * FROM task WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", a.TaskID); err != nil {
+		tx.Rollback()
+		return t, err
+	}
+	switch t.Status {
+	case model.Closed:
+		tx.Rollback()
+		return t, ErrClosed
+	case model.Disputed:
+		tx.Rollback()
+		return t, ErrDisputed
+	}
+	payments := []model.Payment{}
+	if err := tx.Select(&payments, "SELECT id, client_id, task_id, amount, status FROM billing WHERE task_id=$1 AND status=$2 FOR UPDATE",
+		t.ID, model.Locked); err != nil {
+		tx.Rollback()
+		return t, err
+	}
+	for i := range payments {
+		if refund {
+			err = s.refund(ctx, tx, &payments[i])
+		} else if t.FreelancerID == "" {
+			err = ErrNoFreelancer
+		} else {
+			err = s.pay(ctx, tx, &payments[i], t.FreelancerID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return t, err
+		}
+	}
+
+	before := t
+	t.Status = model.Closed
+	t.UpdatedAt = pq.NullTime{Time: time.Now(), Valid: true}
+	if _, err := tx.Exec("UPDATE task SET status=$1, updated_at=$2 WHERE id=$3", t.Status, t.UpdatedAt, t.ID); err != nil {
+		tx.Rollback()
+		return t, err
+	}
+	if err := auditTask(ctx, tx, model.AuditUpdate, t.ID, before); err != nil {
+		tx.Rollback()
+		return t, err
+	}
+	change := events.StatusChange{TaskID: t.ID, From: before.Status, To: t.Status, ClientID: t.ClientID, FreelancerID: t.FreelancerID, Reason: a.Reason, Actor: a.Actor}
+	if err := events.Record(tx, source, events.TaskStatusChanged, t.ID, change); err != nil {
+		tx.Rollback()
+		return t, err
+	}
+	if err := tx.Commit(); err != nil {
+		return t, err
+	}
+	logrus.WithFields(logrus.Fields{"task_id": t.ID, "actor": a.Actor, "refund": refund, "reason": a.Reason}).Info("task settled by operator")
+	return t, nil
+}
+
+// refund marks locked Payment as refunded and credits Client's account within given transaction
+func (s *Service) refund(ctx context.Context, tx *sqlx.Tx, payment *model.Payment) error {
+	payment.Status = model.Refunded
+	payment.PaidDate = time.Now()
+	if rs, err := tx.Exec("UPDATE billing SET status=$1, paid_date=$2 WHERE id=$3", payment.Status, payment.PaidDate, payment.ID); err != nil {
+		return err
+	} else if c, err := rs.RowsAffected(); c == 0 || err != nil {
+		return errNoRows(err)
+	}
+	if err := wallet.Credit(ctx, tx, wallet.ClientOwner, payment.ClientID, payment.Amount); err != nil {
+		return err
+	}
+	return events.Record(tx, source, events.PaymentRefunded, payment.ID, payment)
+}
@@ -0,0 +1,136 @@
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+func outboxCount(t *testing.T, subject, contains string) int {
+	var count int
+	query := "SELECT count(*) FROM outbox WHERE subject=$1 AND convert_from(payload, 'UTF8') LIKE '%' || $2 || '%'"
+	if err := s.db.Get(&count, query, subject, contains); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// auditCount returns number of changes of the entity made by the actor since given time,
+// the client is shared by the tests
+func auditCount(t *testing.T, entityID, actor string, since time.Time) int {
+	var count int
+	query := "SELECT count(*) FROM audit_log WHERE entity_id=$1 AND actor=$2 AND created_at >= $3"
+	if err := s.db.Get(&count, query, entityID, actor, since.UTC()); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestService_Refund(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	task := NewTask()
+	if _, err := s.New(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	if balance := clientBalance(t); balance != model.NewMoney(testBalance.Amount-task.Fee.Amount, model.USD) {
+		t.Fatalf("unexpected balance after the task was created: %s", balance)
+	}
+
+	for _, a := range []model.TaskAction{
+		{TaskID: task.ID, Actor: "ops"},
+		{TaskID: task.ID, Reason: "duplicate task", Actor: " "},
+	} {
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskRefund, a); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", a, rpc.CodeInvalid, err)
+		}
+	}
+
+	start := time.Now()
+	refunded, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskRefund, model.TaskAction{TaskID: task.ID, Reason: "duplicate task", Actor: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != model.Closed {
+		t.Errorf("expected=%s got=%s", model.Closed, refunded.Status)
+	}
+	if balance := clientBalance(t); balance != testBalance {
+		t.Errorf("expected=%s got=%s", testBalance, balance)
+	}
+	var status model.PaymentStatus
+	if err := s.db.Get(&status, "SELECT status FROM billing WHERE task_id=$1", task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status != model.Refunded {
+		t.Errorf("expected=%s got=%s", model.Refunded, status)
+	}
+	if c := outboxCount(t, "events.payment.refunded", task.ID); c != 1 {
+		t.Errorf("expected one payment.refunded event, got %d", c)
+	}
+	if c := outboxCount(t, "events.task.status_changed", `"reason":"duplicate task","actor":"ops"`); c == 0 {
+		t.Error("expected status change recorded with reason and actor")
+	}
+	// the operator is audited as the actor of both the status change and the refund
+	if c := auditCount(t, task.ID, "ops", start); c != 1 {
+		t.Errorf("expected closing of the task audited, got %d entries", c)
+	}
+	if c := auditCount(t, testClientID, "ops", start); c != 1 {
+		t.Errorf("expected refund audited, got %d entries", c)
+	}
+
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskRefund, model.TaskAction{TaskID: task.ID, Reason: "again", Actor: "ops"}); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskRefund, model.TaskAction{TaskID: model.NewID(), Reason: "missing", Actor: "ops"}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestService_ForceClose(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	task := NewTask()
+	if _, err := s.New(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	// locked funds have nobody to be paid to
+	if _, err := s.ForceClose(ctx, model.TaskAction{TaskID: task.ID, Reason: "abandoned", Actor: "ops"}); err != ErrNoFreelancer {
+		t.Errorf("expected=%v got=%v", ErrNoFreelancer, err)
+	}
+	if current, err := s.Get(ctx, task.ID); err != nil || current.Status != model.Open {
+		t.Errorf("expected open task, got %+v %v", current, err)
+	}
+
+	freelancer := model.NewFreelancer("freelancer@email.com", "dev", "go")
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, email) VALUES($1, $2)", freelancer.ID, freelancer.Email); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(ctx, model.Task{ID: task.ID, FreelancerID: freelancer.ID, Status: model.Started}); err != nil {
+		t.Fatal(err)
+	}
+	closed, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskForceClose, model.TaskAction{TaskID: task.ID, Reason: "client unreachable", Actor: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Status != model.Closed {
+		t.Errorf("expected=%s got=%s", model.Closed, closed.Status)
+	}
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM freelancer WHERE id=$1", freelancer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if balance != task.Fee {
+		t.Errorf("expected=%s got=%s", task.Fee, balance)
+	}
+	if c := outboxCount(t, "events.payment.paid", task.ID); c != 1 {
+		t.Errorf("expected one payment.paid event, got %d", c)
+	}
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"time"
+
+	"github.com/kylycht/md/fx"
+	nats "github.com/nats-io/nats.go"
+)
+
+// Option represents optional configuration of Task service
+type Option func(*Service)
+
+// WithRates sets exchange rates source used to convert Fee when Client has no funds in Fee's currency
+func WithRates(src fx.Source) Option {
+	return func(s *Service) {
+		s.rates = src
+	}
+}
+
+// WithJetStream makes the service consume commands from JetStream durable consumers
+// instead of core NATS subscriptions
+func WithJetStream(js nats.JetStreamContext) Option {
+	return func(s *Service) {
+		s.js = js
+	}
+}
+
+// WithReviewPeriod sets period after which completed Task is closed
+// automatically and funds are transfered to Freelancer.
+// Zero period disables auto-approval
+func WithReviewPeriod(d time.Duration) Option {
+	return func(s *Service) {
+		s.reviewPeriod = d
+	}
+}
+
+// WithReminder sets how long before review deadline Client is reminded to review completed Task
+func WithReminder(before time.Duration) Option {
+	return func(s *Service) {
+		s.reminderBefore = before
+	}
+}
+
+// WithCheckInterval sets how often completed Tasks are checked for review deadline
+func WithCheckInterval(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.checkInterval = d
+		}
+	}
+}
+
+// WithSagaInterval sets how often interrupted task creation sagas are resumed
+func WithSagaInterval(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.sagaInterval = d
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// errNotCompleted represents error returned when the Task was closed or disputed after it was selected for approval
+var errNotCompleted = errors.New("task is no longer completed")
+
+func (s *Service) runReview() {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.remindReview(now)
+			s.approveCompleted(now)
+		}
+	}
+}
+
+// remindReview records TaskReviewReminder event for completed Tasks
+// which review deadline is approaching.
+// Disputed Tasks are skipped since review period is paused during Dispute
+func (s *Service) remindReview(now time.Time) {
+	if s.reminderBefore <= 0 {
+		return
+	}
+	query := "SELECT * FROM task WHERE status = $1 AND deleted_at IS NULL AND reminded_at IS NULL " +
+		"AND review_deadline IS NOT NULL AND review_deadline <= $2"
+	tasks := []model.Task{}
+	if err := s.db.Select(&tasks, query, model.Completed, now.Add(s.reminderBefore)); err != nil {
+		logrus.Error(err)
+		return
+	}
+	for _, t := range tasks {
+		if err := s.remind(t, now); err != nil {
+			logrus.WithField("task_id", t.ID).Error(err)
+		}
+	}
+}
+
+// remind marks the Task reminded and records TaskReviewReminder event in the same transaction,
+// concurrent instances remind only once
+func (s *Service) remind(t model.Task, now time.Time) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE task SET reminded_at=$1 WHERE id=$2 AND reminded_at IS NULL", now, t.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if c, err := res.RowsAffected(); c == 0 || err != nil {
+		tx.Rollback()
+		return err
+	}
+	t.RemindedAt = pq.NullTime{Time: now, Valid: true}
+	if err := events.Record(tx, source, events.TaskReviewReminder, t.ID, t); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// approveCompleted closes completed Tasks which review deadline has passed
+// and transfers funds held for fixed contracts to Freelancer
+func (s *Service) approveCompleted(now time.Time) {
+	query := "SELECT * FROM task WHERE status = $1 AND deleted_at IS NULL " +
+		"AND review_deadline IS NOT NULL AND review_deadline <= $2"
+	tasks := []model.Task{}
+	if err := s.db.Select(&tasks, query, model.Completed, now); err != nil {
+		logrus.Error(err)
+		return
+	}
+	for _, t := range tasks {
+		err := s.approve(&t, now)
+		if err == errNotCompleted {
+			continue
+		}
+		if err != nil {
+			logrus.WithField("task_id", t.ID).Error(err)
+			continue
+		}
+		logrus.WithField("task_id", t.ID).Info("task approved automatically")
+	}
+}
+
+// approve closes the Task on behalf of the service, so the change is audited as made by the system
+func (s *Service) approve(t *model.Task, now time.Time) error {
+	ctx := context.Background()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	// status guard makes sure the Task is not closed twice or disputed meanwhile
+	res, err := tx.Exec("UPDATE task SET status=$1, updated_at=$2 WHERE id=$3 AND status=$4", model.Closed, now, t.ID, model.Completed)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if c == 0 {
+		tx.Rollback()
+		return errNotCompleted
+	}
+	// hourly contracts are paid by weekly billing runs
+	if t.Contract != model.HourlyContract {
+		if err := s.payFunds(ctx, tx, t); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := auditTask(ctx, tx, model.AuditUpdate, t.ID, *t); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := events.Record(tx, source, events.TaskStatusChanged, t.ID, events.StatusChange{TaskID: t.ID, From: model.Completed, To: model.Closed, ClientID: t.ClientID, FreelancerID: t.FreelancerID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,202 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// sagaState represents step of the task creation saga
+type sagaState string
+
+const (
+	// sagaStarted means that saga is persisted and Client's funds are being reserved
+	sagaStarted = sagaState("started")
+	// sagaReserved means that funds are reserved and the Task is being created
+	sagaReserved = sagaState("reserved")
+	// sagaCreated means that the Task is created and the reservation is being confirmed
+	sagaCreated = sagaState("created")
+	// sagaCompleted means that the reservation is confirmed
+	sagaCompleted = sagaState("completed")
+	// sagaCompensating means that a step failed and the reservation is being released
+	sagaCompensating = sagaState("compensating")
+	// sagaFailed means that the reservation is released and the Task was not created
+	sagaFailed = sagaState("failed")
+)
+
+// errInterrupted represents cause of the saga compensated by recovery
+var errInterrupted = errors.New("saga interrupted")
+
+// sagaStale represents how long saga may stay in the same state
+// before it is considered interrupted and resumed by recovery
+const sagaStale = timeout * 6
+
+// saga represents persisted state of the task creation,
+// its ID is used as the reservation ID so every step can be safely retried
+type saga struct {
+	ID        string         `db:"id"`
+	TaskID    string         `db:"task_id"`
+	State     sagaState      `db:"state"`
+	Payload   []byte         `db:"payload"`
+	LastError sql.NullString `db:"last_error"`
+	Attempts  int            `db:"attempts"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+}
+
+// startSaga persists new saga creating the Task
+func (s *Service) startSaga(t *model.Task) (*saga, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sg := &saga{ID: model.NewID(), TaskID: t.ID, State: sagaStarted, Payload: payload, CreatedAt: now, UpdatedAt: now}
+	_, err = s.db.Exec("INSERT INTO saga (id, task_id, state, payload, created_at, updated_at) VALUES($1, $2, $3, $4, $5, $6)",
+		sg.ID, sg.TaskID, sg.State, sg.Payload, sg.CreatedAt, sg.UpdatedAt)
+	return sg, err
+}
+
+// runSaga performs the remaining steps of the saga:
+// reserve funds, create the Task and confirm the reservation.
+// When a step before the Task is created fails, the reservation is released.
+// Returned error is the cause of the failure
+func (s *Service) runSaga(ctx context.Context, sg *saga, t *model.Task) error {
+	reservation := model.Reservation{ID: sg.ID, ClientID: t.ClientID, TaskID: t.ID, Amount: t.Fee}
+	for {
+		switch sg.State {
+		case sagaStarted:
+			r, err := call(ctx, s.jsonConn.Conn, api.ClientReserve, reservation)
+			if err != nil {
+				return s.compensate(ctx, sg, reservation, err)
+			}
+			if r.Withdrawn != t.Fee {
+				logrus.WithField("fee", t.Fee.String()).WithField("withdrawn", r.Withdrawn.String()).Info("fee converted")
+			}
+			if err := s.setSagaState(s.db, sg, sagaReserved, nil); err != nil {
+				return err
+			}
+		case sagaReserved:
+			if err := s.insertTask(ctx, t, sg); err != nil {
+				return s.compensate(ctx, sg, reservation, err)
+			}
+		case sagaCreated:
+			if _, err := call(ctx, s.jsonConn.Conn, api.ClientConfirm, sg.ID); err != nil {
+				// the Task exists, confirmation is retried by recovery
+				logrus.WithField("saga_id", sg.ID).WithField("task_id", sg.TaskID).Warn(err)
+				return s.setSagaState(s.db, sg, sagaCreated, err)
+			}
+			if err := s.setSagaState(s.db, sg, sagaCompleted, nil); err != nil {
+				return err
+			}
+		case sagaCompensating:
+			return s.release(ctx, sg, reservation)
+		default:
+			return nil
+		}
+	}
+}
+
+// call sends the request and waits for the response no longer than the service timeout
+func call[Req, Resp any](ctx context.Context, conn *nats.Conn, e rpc.Endpoint[Req, Resp], req Req) (Resp, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return rpc.Call(ctx, conn, e, req)
+}
+
+// compensate releases the reservation after failed step and returns the cause,
+// release that failed is retried by recovery
+func (s *Service) compensate(ctx context.Context, sg *saga, r model.Reservation, cause error) error {
+	if err := s.setSagaState(s.db, sg, sagaCompensating, cause); err != nil {
+		logrus.WithField("saga_id", sg.ID).Error(err)
+		return cause
+	}
+	s.release(ctx, sg, r)
+	return cause
+}
+
+// release returns reserved funds to Client and fails the saga
+func (s *Service) release(ctx context.Context, sg *saga, r model.Reservation) error {
+	if _, err := call(ctx, s.jsonConn.Conn, api.ClientRelease, r); err != nil {
+		logrus.WithField("saga_id", sg.ID).WithField("task_id", sg.TaskID).Error(err)
+		s.setSagaState(s.db, sg, sagaCompensating, err)
+		return err
+	}
+	return s.setSagaState(s.db, sg, sagaFailed, nil)
+}
+
+// setSagaState moves saga to the state, cause of the failure is recorded
+func (s *Service) setSagaState(e sqlx.Execer, sg *saga, state sagaState, cause error) error {
+	sg.State = state
+	sg.UpdatedAt = time.Now().UTC()
+	if cause != nil {
+		sg.Attempts++
+		sg.LastError = sql.NullString{String: cause.Error(), Valid: true}
+	}
+	_, err := e.Exec("UPDATE saga SET state=$1, last_error=$2, attempts=$3, updated_at=$4 WHERE id=$5",
+		sg.State, sg.LastError, sg.Attempts, sg.UpdatedAt, sg.ID)
+	return err
+}
+
+// runSagas periodically resumes interrupted sagas
+func (s *Service) runSagas() {
+	ticker := time.NewTicker(s.sagaInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.recoverSagas(context.Background()); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+}
+
+// recoverSagas resumes sagas that were not updated for sagaStale, e.g. after a crash.
+// Sagas interrupted before the Task was created are compensated,
+// created Tasks get their reservation confirmed. Returns number of resumed sagas
+func (s *Service) recoverSagas(ctx context.Context) (int, error) {
+	sagas := []saga{}
+	if err := s.db.Select(&sagas, "SELECT * FROM saga WHERE state NOT IN ($1, $2) AND updated_at < $3 ORDER BY updated_at",
+		sagaCompleted, sagaFailed, time.Now().UTC().Add(-sagaStale)); err != nil {
+		return 0, err
+	}
+	var resumed int
+	for i := range sagas {
+		sg := &sagas[i]
+		// claim the saga so concurrent recovery skips it
+		prev := sg.UpdatedAt
+		sg.UpdatedAt = time.Now().UTC()
+		res, err := s.db.Exec("UPDATE saga SET updated_at=$1 WHERE id=$2 AND updated_at=$3", sg.UpdatedAt, sg.ID, prev)
+		if err != nil {
+			return resumed, err
+		}
+		if c, err := res.RowsAffected(); err != nil || c == 0 {
+			continue
+		}
+		t := model.Task{}
+		if err := json.Unmarshal(sg.Payload, &t); err != nil {
+			logrus.WithField("saga_id", sg.ID).Error(err)
+			continue
+		}
+		resumed++
+		logrus.WithField("saga_id", sg.ID).WithField("state", sg.State).Info("resuming saga")
+		if sg.State == sagaStarted || sg.State == sagaReserved {
+			s.compensate(ctx, sg, model.Reservation{ID: sg.ID, ClientID: t.ClientID, TaskID: t.ID, Amount: t.Fee}, errInterrupted)
+			continue
+		}
+		s.runSaga(ctx, sg, &t)
+	}
+	return resumed, nil
+}
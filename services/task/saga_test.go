@@ -0,0 +1,141 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+)
+
+func getSaga(t *testing.T, taskID string) saga {
+	sg := saga{}
+	if err := s.db.Get(&sg, "SELECT * FROM saga WHERE task_id=$1 ORDER BY created_at DESC LIMIT 1", taskID); err != nil {
+		t.Fatal(err)
+	}
+	return sg
+}
+
+func clientBalance(t *testing.T) model.Money {
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM client WHERE id=$1", testClientID); err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestSaga_Completed(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := NewTask()
+	if _, err := s.New(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	if sg := getSaga(t, task.ID); sg.State != sagaCompleted {
+		t.Errorf("expected=%s got=%s", sagaCompleted, sg.State)
+	}
+	want, _ := testBalance.Sub(task.Fee)
+	if got := clientBalance(t); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
+
+func TestSaga_InsufficientFunds(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := NewTask()
+	task.Fee = model.NewMoney(testBalance.Amount+1, model.USD)
+	if _, err := s.New(context.Background(), task); err == nil {
+		t.Fatal("expected insufficient funds")
+	}
+	sg := getSaga(t, task.ID)
+	if sg.State != sagaFailed || !sg.LastError.Valid {
+		t.Errorf("unexpected saga: %+v", sg)
+	}
+	if _, err := s.getTaskByID(task.ID); err != sql.ErrNoRows {
+		t.Errorf("expected=%v got=%v", sql.ErrNoRows, err)
+	}
+	if got := clientBalance(t); got != testBalance {
+		t.Errorf("expected=%s got=%s", testBalance, got)
+	}
+}
+
+func TestSaga_CompensateCreate(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := NewTask()
+	if _, err := s.New(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	// funds are reserved again, but the Task with the same ID can not be created
+	if _, err := s.New(context.Background(), task); err == nil {
+		t.Fatal("expected duplicate task")
+	}
+	if sg := getSaga(t, task.ID); sg.State != sagaFailed {
+		t.Errorf("expected=%s got=%s", sagaFailed, sg.State)
+	}
+	want, _ := testBalance.Sub(task.Fee)
+	if got := clientBalance(t); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
+
+func TestSaga_Recover(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	// crashed after funds were reserved
+	reserved := NewTask()
+	sg, err := s.startSaga(&reserved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, model.Reservation{ID: sg.ID, ClientID: testClientID, TaskID: reserved.ID, Amount: reserved.Fee}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setSagaState(s.db, sg, sagaReserved, nil); err != nil {
+		t.Fatal(err)
+	}
+	// crashed after the Task was created
+	created := NewTask()
+	sg2, err := s.startSaga(&created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.ClientReserve, model.Reservation{ID: sg2.ID, ClientID: testClientID, TaskID: created.ID, Amount: created.Fee}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.insertTask(context.Background(), &created, sg2); err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh sagas are left alone
+	if n, err := s.recoverSagas(ctx); err != nil || n != 0 {
+		t.Errorf("expected=%d got=%d (%v)", 0, n, err)
+	}
+	if _, err := s.db.Exec("UPDATE saga SET updated_at=$1 WHERE id IN ($2, $3)", time.Now().UTC().Add(-sagaStale*2), sg.ID, sg2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.recoverSagas(ctx); err != nil || n != 2 {
+		t.Errorf("expected=%d got=%d (%v)", 2, n, err)
+	}
+
+	if got := getSaga(t, reserved.ID); got.State != sagaFailed || got.LastError.String != errInterrupted.Error() {
+		t.Errorf("unexpected saga: %+v", got)
+	}
+	if got := getSaga(t, created.ID); got.State != sagaCompleted {
+		t.Errorf("expected=%s got=%s", sagaCompleted, got.State)
+	}
+	// only the created Task keeps its funds
+	want, _ := testBalance.Sub(created.Fee)
+	if got := clientBalance(t); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
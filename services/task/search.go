@@ -0,0 +1,172 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/skill"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxTags represents maximum number of tags of the Task
+	maxTags = 10
+	// maxTagLength represents maximum length of the tag
+	maxTagLength = 32
+	// defaultLimit and maxLimit represent number of Tasks returned by search
+	defaultLimit = 20
+	maxLimit     = 100
+	// searchConfig represents text search configuration, the same as in TASK_SEARCH index
+	searchConfig = "english"
+	// startSel and stopSel mark matching words in snippets before they are escaped
+	startSel = "\x02"
+	stopSel  = "\x03"
+)
+
+// headlineOptions represents options of snippets, matching words are marked by startSel and stopSel
+const headlineOptions = "'StartSel=" + startSel + ", StopSel=" + stopSel + `, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=" … "'`
+
+// document represents indexed text of the Task, it must match expression of TASK_SEARCH index
+const document = "to_tsvector('" + searchConfig + "', COALESCE(t.description, ''))"
+
+var (
+	// ErrInvalidTags represents error returned when Task has too many tags or tag is malformed
+	ErrInvalidTags = rpc.Errorf(rpc.CodeInvalid, "up to %d tags of letters, digits, '+', '#', '.' and '-' up to %d characters are allowed", maxTags, maxTagLength)
+	// ErrInvalidQuery represents error returned for negative fee, deadline or paging of the search
+	ErrInvalidQuery = rpc.Errorf(rpc.CodeInvalid, "invalid query")
+)
+
+// Search will find open Tasks matching the query, most relevant first.
+// Found Tasks have every tag and require every Skill of the query
+// Without text the newest Tasks are returned first
+func (s *Service) Search(ctx context.Context, q model.TaskQuery) (model.TaskSearchResult, error) {
+	result := model.TaskSearchResult{Tasks: []model.TaskMatch{}}
+	tags, err := normalizeTags(q.Tags)
+	if err != nil {
+		return result, err
+	}
+	skills, err := skill.Normalize(q.Skills)
+	if err != nil {
+		return result, err
+	}
+	if q.MinFee.Amount < 0 || q.MaxFee.Amount < 0 || q.MaxDeadline < 0 || q.Limit < 0 || q.Offset < 0 {
+		return result, ErrInvalidQuery
+	}
+	if q.MinFee.Amount > 0 && q.MaxFee.Amount > 0 && q.MinFee.Currency != q.MaxFee.Currency {
+		return result, rpc.Errorf(rpc.CodeInvalid, "%v", model.ErrCurrencyMismatch)
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+
+	var (
+		conds = []string{"t.status = $1", "t.deleted_at IS NULL"}
+		args  = []interface{}{model.Open}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	rank, snippet, order := "0", "''", "t.created_at DESC, t.id"
+	if text := strings.TrimSpace(q.Text); text != "" {
+		query := "websearch_to_tsquery('" + searchConfig + "', " + arg(text) + ")"
+		conds = append(conds, document+" @@ "+query)
+		rank = "ts_rank(" + document + ", " + query + ")"
+		snippet = "ts_headline('" + searchConfig + "', COALESCE(t.description, ''), " + query + ", " + headlineOptions + ")"
+		order = "rank DESC, " + order
+	}
+	if len(tags) > 0 {
+		conds = append(conds, "t.tags @> "+arg(tags))
+	}
+	if category := strings.ToLower(strings.TrimSpace(q.Category)); category != "" {
+		conds = append(conds, "t.category = "+arg(category))
+	}
+	if len(skills) > 0 {
+		conds = append(conds, "t.skills @> "+arg(skills))
+	}
+	if q.MinFee.Amount > 0 || q.MaxFee.Amount > 0 {
+		currency := q.MinFee.Currency
+		if q.MinFee.Amount == 0 {
+			currency = q.MaxFee.Currency
+		}
+		if !currency.Valid() {
+			return result, rpc.Errorf(rpc.CodeInvalid, "%v", model.ErrInvalidCurrency)
+		}
+		conds = append(conds, "t.contract = "+arg(model.FixedContract), "(t.fee).currency = "+arg(currency))
+		if q.MinFee.Amount > 0 {
+			conds = append(conds, "(t.fee).amount >= "+arg(q.MinFee.Amount))
+		}
+		if q.MaxFee.Amount > 0 {
+			conds = append(conds, "(t.fee).amount <= "+arg(q.MaxFee.Amount))
+		}
+	}
+	if q.MaxDeadline > 0 {
+		conds = append(conds, "t.deadline <= "+arg(q.MaxDeadline))
+	}
+
+	query := "SELECT t.*, " + rank + " AS rank, " + snippet + " AS snippet, count(*) OVER () AS total " +
+		"FROM task t WHERE " + strings.Join(conds, " AND ") +
+		" ORDER BY " + order + " LIMIT " + arg(q.Limit) + " OFFSET " + arg(q.Offset)
+	rows := []struct {
+		model.TaskMatch
+		Total int `db:"total"`
+	}{}
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return result, err
+	}
+	for _, r := range rows {
+		r.Snippet = highlight(r.Snippet)
+		result.Total = r.Total
+		result.Tasks = append(result.Tasks, r.TaskMatch)
+	}
+	if len(rows) == 0 && q.Offset > 0 {
+		// total is not known past the last page
+		err = s.db.GetContext(ctx, &result.Total, "SELECT count(*) FROM task t WHERE "+strings.Join(conds, " AND "), args[:len(args)-2]...)
+	}
+	return result, err
+}
+
+// highlight escapes the snippet and wraps matching words in <mark> elements
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, startSel, "<mark>")
+	return strings.ReplaceAll(snippet, stopSel, "</mark>")
+}
+
+// normalizeTags trims and lowercases the tags and removes duplicates, the result is never nil
+func normalizeTags(tags []string) (pq.StringArray, error) {
+	normalized := pq.StringArray{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength || !validTag(tag) {
+			return nil, ErrInvalidTags
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, ErrInvalidTags
+	}
+	return normalized, nil
+}
+
+func validTag(tag string) bool {
+	for _, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '+', r == '#', r == '.', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package task
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/lib/pq"
+)
+
+// insertTask inserts the Task directly, tasks of other tests share the table so every search is narrowed by tag
+func insertTask(t *testing.T, description string, fee model.Money, deadline time.Duration, status model.TaskStatus, tags ...string) model.Task {
+	task := model.NewTask(deadline, fee, testClientID, description)
+	task.Status, task.Tags = status, pq.StringArray(tags)
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, description, fee, deadline, created_at, status, contract, tags) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		task.ID, task.ClientID, task.Description, task.Fee, task.Deadline, task.CreatedAt, task.Status, task.Contract, task.Tags); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func search(t *testing.T, q model.TaskQuery) model.TaskSearchResult {
+	result, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskSearch, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func ids(result model.TaskSearchResult) []string {
+	var ids []string
+	for _, m := range result.Tasks {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestService_Search(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	tag := "t" + model.NewID()[:8]
+	day := time.Hour * 24
+	service := insertTask(t, "Build a Go service with Postgres <b>full-text</b> search", model.NewMoney(5000, model.EUR), day*7, model.Open, tag, "go")
+	consultant := insertTask(t, "Postgres consultant: tune postgres queries and postgres replication", model.NewMoney(2000, model.EUR), day*2, model.Open, tag)
+	logo := insertTask(t, "Design a logo", model.NewMoney(800, model.USD), day, model.Open, tag)
+	insertTask(t, "Postgres backups", model.NewMoney(2000, model.EUR), day, model.Started, tag)
+
+	for _, c := range []struct {
+		name     string
+		query    model.TaskQuery
+		expected []string
+	}{
+		{"relevance", model.TaskQuery{Text: "postgres", Tags: []string{tag}}, []string{consultant.ID, service.ID}},
+		{"web search syntax", model.TaskQuery{Text: "postgres -replication", Tags: []string{tag}}, []string{service.ID}},
+		{"stemming", model.TaskQuery{Text: "designing logos", Tags: []string{tag}}, []string{logo.ID}},
+		{"tags", model.TaskQuery{Tags: []string{tag, "GO"}}, []string{service.ID}},
+		{"newest first", model.TaskQuery{Tags: []string{tag}}, []string{logo.ID, consultant.ID, service.ID}},
+		{"fee range", model.TaskQuery{Tags: []string{tag}, MinFee: model.NewMoney(1000, model.EUR), MaxFee: model.NewMoney(3000, model.EUR)}, []string{consultant.ID}},
+		{"deadline", model.TaskQuery{Tags: []string{tag}, MaxDeadline: day * 2}, []string{logo.ID, consultant.ID}},
+	} {
+		if got := ids(search(t, c.query)); strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected=%v got=%v", c.name, c.expected, got)
+		}
+	}
+
+	result := search(t, model.TaskQuery{Text: "full text search", Tags: []string{tag}})
+	if len(result.Tasks) != 1 || result.Tasks[0].Rank <= 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	// description is escaped, only marks are HTML
+	if snippet := result.Tasks[0].Snippet; !strings.Contains(snippet, "&lt;b&gt;") || !strings.Contains(snippet, "<mark>search</mark>") || strings.Contains(snippet, "<b>") {
+		t.Errorf("unexpected snippet %q", snippet)
+	}
+
+	page := search(t, model.TaskQuery{Tags: []string{tag}, Limit: 1, Offset: 1})
+	if page.Total != 3 || len(page.Tasks) != 1 || page.Tasks[0].ID != consultant.ID {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	if page := search(t, model.TaskQuery{Tags: []string{tag}, Offset: 10}); page.Total != 3 || len(page.Tasks) != 0 {
+		t.Errorf("unexpected page: %+v", page)
+	}
+}
+
+func TestService_Skills(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	for _, q := range []string{
+		"INSERT INTO category (id, name) VALUES('development', 'Development'), ('design', 'Design') ON CONFLICT (id) DO NOTHING",
+		"INSERT INTO skill (id, name, category_id) VALUES('go', 'Go', 'development'), ('postgresql', 'PostgreSQL', 'development'), " +
+			"('logo-design', 'Logo Design', 'design') ON CONFLICT (id) DO NOTHING",
+	} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag := "t" + model.NewID()[:8]
+	backend := NewTask()
+	backend.Tags, backend.Category, backend.Skills = pq.StringArray{tag}, " Development", pq.StringArray{"Go", "postgresql", "go"}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskAdd, backend); err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.getTaskByID(backend.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Category != "development" || strings.Join(created.Skills, ",") != "go,postgresql" {
+		t.Errorf("unexpected category %q and skills %v", created.Category, created.Skills)
+	}
+	logo := NewTask()
+	logo.Tags, logo.Category, logo.Skills = pq.StringArray{tag}, "design", pq.StringArray{"logo-design"}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskAdd, logo); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name     string
+		query    model.TaskQuery
+		expected []string
+	}{
+		{"category", model.TaskQuery{Tags: []string{tag}, Category: "design"}, []string{logo.ID}},
+		{"skills", model.TaskQuery{Tags: []string{tag}, Skills: []string{"postgresql"}}, []string{backend.ID}},
+		{"every skill", model.TaskQuery{Tags: []string{tag}, Skills: []string{"go", "logo-design"}}, nil},
+	} {
+		if got := ids(search(t, c.query)); strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: expected=%v got=%v", c.name, c.expected, got)
+		}
+	}
+
+	for _, invalid := range []model.Task{
+		{ID: backend.ID, Skills: pq.StringArray{"cobol"}},
+		{ID: backend.ID, Category: "cooking"},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskUpdate, invalid); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", invalid, rpc.CodeInvalid, err)
+		}
+	}
+	unknown := NewTask()
+	unknown.Skills = pq.StringArray{"cobol"}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskAdd, unknown); rpc.CodeOf(err) != rpc.CodeInvalid {
+		t.Errorf("expected=%s got=%v", rpc.CodeInvalid, err)
+	}
+	// empty list removes required skills
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskUpdate, model.Task{ID: backend.ID, Skills: pq.StringArray{}}); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := s.getTaskByID(backend.ID); err != nil || len(updated.Skills) != 0 || updated.Category != "development" {
+		t.Errorf("unexpected task %+v %v", updated, err)
+	}
+}
+
+func TestService_SearchInvalid(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	for _, q := range []model.TaskQuery{
+		{Tags: []string{"c++", "with space"}},
+		{MinFee: model.NewMoney(100, model.EUR), MaxFee: model.NewMoney(200, model.USD)},
+		{MinFee: model.NewMoney(100, "XXX")},
+		{Offset: -1},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.TaskSearch, q); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", q, rpc.CodeInvalid, err)
+		}
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" Go", "postgres", "go", "C++", "node.js"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tags, ",") != "go,postgres,c++,node.js" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if tags, err := normalizeTags(nil); err != nil || tags == nil || len(tags) != 0 {
+		t.Errorf("expected empty tags, got %v %v", tags, err)
+	}
+	for _, invalid := range [][]string{{""}, {"two words"}, {strings.Repeat("a", maxTagLength+1)},
+		{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}} {
+		if _, err := normalizeTags(invalid); err != ErrInvalidTags {
+			t.Errorf("%v: expected=%v got=%v", invalid, ErrInvalidTags, err)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	got := highlight("a <b>" + startSel + "golang" + stopSel + "</b> & " + startSel + "go" + stopSel)
+	if expected := "a &lt;b&gt;<mark>golang</mark>&lt;/b&gt; &amp; <mark>go</mark>"; got != expected {
+		t.Errorf("expected=%s got=%s", expected, got)
+	}
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -11,15 +12,32 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/lib/pq"
-	nats "github.com/nats-io/go-nats"
+	nats "github.com/nats-io/nats.go"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/fx"
+	"github.com/kylycht/md/jetstream"
 	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/audit"
+	"github.com/kylycht/md/services/skill"
+	"github.com/kylycht/md/services/wallet"
 	_ "github.com/lib/pq"
 )
 
 const (
 	timeout = time.Second * 5
+	// source represents the service in domain events
+	source = "task"
+)
+
+var (
+	// ErrDisputed represents error returned on attempt to change status of the disputed Task
+	ErrDisputed = errors.New("task is disputed")
+	// ErrInvalidContract represents error returned when hourly contract has no rate or weekly cap
+	ErrInvalidContract = rpc.Errorf(rpc.CodeInvalid, "invalid contract")
 )
 
 // Service represents Task service that will handle
@@ -27,28 +45,70 @@ const (
 type Service struct {
 	db       *sqlx.DB
 	jsonConn *nats.EncodedConn
+
+	js    nats.JetStreamContext
+	rates fx.Source
+
+	reviewPeriod   time.Duration
+	reminderBefore time.Duration
+	checkInterval  time.Duration
+	sagaInterval   time.Duration
+	done           chan struct{}
 }
 
 // NewService returns new instance of Task service
-func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
-	srv := &Service{db: db, jsonConn: conn}
-	return srv, srv.init()
+func NewService(db *sqlx.DB, conn *nats.EncodedConn, opts ...Option) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn, checkInterval: time.Minute, sagaInterval: time.Minute, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if err := srv.init(); err != nil {
+		return srv, err
+	}
+	if srv.reviewPeriod > 0 {
+		go srv.runReview()
+	}
+	go srv.runSagas()
+	return srv, nil
+}
+
+// Close stops background processing of the service
+func (s *Service) Close() {
+	close(s.done)
 }
 
 func (s *Service) init() error {
-	if _, err := s.jsonConn.QueueSubscribe("task.add", "task-queue", s.New); err != nil {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	// commands delivered by JetStream are acked once processed
+	if s.js != nil {
+		if _, err := jetstream.Consume(s.js, api.TaskAdd.Subject, "task-add", jetstream.DefaultMaxDeliver, s.add); err != nil {
+			return err
+		}
+	} else if err := rpc.Register(srv, api.TaskAdd, s.New); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("task.get", "task-queue", s.Get); err != nil {
+	if err := rpc.Register(srv, api.TaskGet, s.Get); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("task.update", "task-queue", s.Update); err != nil {
+	if err := rpc.Register(srv, api.TaskUpdate, s.Update); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("task.list", "task-queue", s.List); err != nil {
+	if err := rpc.Register(srv, api.TaskList, s.List); err != nil {
 		return err
 	}
-	if _, err := s.jsonConn.QueueSubscribe("task.delete", "task-queue", s.Delete); err != nil {
+	if err := rpc.Register(srv, api.TaskDelete, s.Delete); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TaskCharge, s.Charge); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TaskSearch, s.Search); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TaskForceClose, s.ForceClose); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TaskRefund, s.Refund); err != nil {
 		return err
 	}
 
@@ -56,153 +116,280 @@ func (s *Service) init() error {
 }
 
 // New will perform DB insert operation for the given Task
-func (s *Service) New(subject, reply string, t *model.Task) error {
-	//get client info
-	replyMsg := model.NATSMsg{}
-	if err := s.jsonConn.Request("client.get", t.ClientID, &replyMsg, timeout); err != nil {
+func (s *Service) New(ctx context.Context, t model.Task) (rpc.Empty, error) {
+	return rpc.Empty{}, s.create(ctx, &t)
+}
+
+// add handles task.add command delivered by JetStream.
+// Redelivered command of already created Task is acked without changes, invalid command
+// is dead lettered without retries, so no saga and reservation is started for it again.
+// The command carries no actor, so the Task is created on behalf of its Client
+func (s *Service) add(data []byte) error {
+	var t model.Task
+	if err := json.Unmarshal(data, &t); err != nil {
+		return rpc.Errorf(rpc.CodeInvalid, "%v", err)
+	}
+	if _, err := s.getTaskByID(t.ID); err == nil {
+		return nil
+	}
+	return s.create(rpc.WithActor(context.Background(), t.ClientID), &t)
+}
+
+// create validates the Task and creates it. Fee of fixed contract is reserved
+// from Client's account by the saga, hourly contracts are charged by weekly billing runs
+func (s *Service) create(ctx context.Context, t *model.Task) error {
+	if !t.Fee.Currency.Valid() {
+		return rpc.Errorf(rpc.CodeInvalid, "%v", model.ErrInvalidCurrency)
+	}
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return err
+	}
+	t.Tags = tags
+	t.Category = strings.ToLower(strings.TrimSpace(t.Category))
+	if t.Skills, err = skill.Normalize(t.Skills); err != nil {
 		return err
 	}
-	if !replyMsg.Success {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: replyMsg.Message})
+	if err := skill.Check(ctx, s.db, t.Category, t.Skills); err != nil {
+		return err
 	}
-	var client model.Client
-	if err := json.Unmarshal(replyMsg.Data, &client); err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+	if t.Contract == "" {
+		t.Contract = model.FixedContract
 	}
-	// client does not have enough money to create task for Fee specified
-	if client.Balance-t.Fee < 0 {
-		return errors.New("Insufficient funds")
+	if t.Contract == model.HourlyContract {
+		if !t.HourlyRate.Currency.Valid() || t.HourlyRate.Amount <= 0 || t.WeeklyCap <= 0 {
+			return ErrInvalidContract
+		}
+		//get client info
+		if _, err := call(ctx, s.jsonConn.Conn, api.ClientGet, t.ClientID); err != nil {
+			return err
+		}
+		return s.insertTask(ctx, t, nil)
 	}
+	sg, err := s.startSaga(t)
+	if err != nil {
+		return err
+	}
+	return s.runSaga(ctx, sg, t)
+}
+
+// insertTask inserts the Task and locks its Fee in billing,
+// the saga is moved to created state within the same transaction
+func (s *Service) insertTask(ctx context.Context, t *model.Task, sg *saga) error {
 	// tx begin
-	tx, err := s.db.Begin()
+	tx, err := s.db.Beginx()
 	if err != nil {
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+		return err
 	}
-	// withdraw client money
-	balance := client.Balance - t.Fee
-	withdrawFunds := "UPDATE client SET balance=$1 WHERE id=$2"
-	if res, err := tx.Exec(withdrawFunds, balance, client.ID); err != nil {
+	var locked *model.Payment
+	if t.Contract == model.FixedContract {
+		locked = &model.Payment{ID: model.NewID(), ClientID: t.ClientID, TaskID: t.ID, Amount: t.Fee, Status: model.Locked}
+		// lock funds reserved from client account
+		lockFunds := "INSERT INTO billing(id, client_id, task_id, amount, status) VALUES($1,$2,$3,$4,$5)"
+		if _, err = tx.Exec(lockFunds, locked.ID, locked.ClientID, locked.TaskID, locked.Amount, locked.Status); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// create task
+	if res, err := tx.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status, contract, hourly_rate, weekly_cap, tags, category, skills) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)", t.ID, t.ClientID, t.FreelancerID, t.Description, t.Fee, t.Deadline, t.CreatedAt, t.Status,
+		t.Contract, t.HourlyRate, t.WeeklyCap, t.Tags, t.Category, t.Skills); err != nil {
 		tx.Rollback()
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+		return err
 	} else if c, err := res.RowsAffected(); c == 0 || err != nil {
 		tx.Rollback()
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: "no rows effected"})
+		return errNoRows(err)
 	}
-
-	txID := model.NewID()
-	// lock funds from client account
-	lockFunds := "INSERT INTO billing(id, client_id, task_id, amount, status) VALUES($1,$2,$3,$4,$5)"
-	if _, err = tx.Exec(lockFunds, txID, t.ClientID, t.ID, t.Fee, model.Locked); err != nil {
+	if err := auditTask(ctx, tx, model.AuditCreate, t.ID, nil); err != nil {
 		tx.Rollback()
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+		return err
 	}
-
-	// create task
-	if res, err := tx.Exec("INSERT INTO task (id, client_id, freelancer_id, description, fee, deadline, created_at, status) "+
-		"VALUES($1, $2, $3, $4, $5, $6, $7, $8)", t.ID, t.ClientID, t.FreelancerID, t.Description, t.Fee, t.Deadline, t.CreatedAt, t.Status); err != nil {
-		tx.Rollback()
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-	} else if c, err := res.RowsAffected(); c == 0 || err != nil {
+	if err := events.Record(tx, source, events.TaskCreated, t.ID, t); err != nil {
 		tx.Rollback()
-		return s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
+		return err
 	}
-	tx.Commit()
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
+	if locked != nil {
+		if err := events.Record(tx, source, events.PaymentLocked, locked.ID, locked); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if sg != nil {
+		if err := s.setSagaState(tx, sg, sagaCreated, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *Service) completeTask(subject, reply string, t *model.Task) {
 
 }
 
-func (s *Service) transferFunds(t *model.Task) error {
+func (s *Service) transferFunds(ctx context.Context, t *model.Task) error {
 	logrus.Info("transfering funds")
-	tx, err := s.db.Begin()
+	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
-	var b sql.NullInt64
-	var txID string
-	if err := tx.QueryRow("SELECT id FROM billing WHERE task_id=$1", t.ID).Scan(&txID); err != nil {
+	if err := s.payFunds(ctx, tx, t); err != nil {
 		tx.Rollback()
 		return err
 	}
-	logrus.WithField("balance", b.Int64).WithField("fee", t.Fee).Info("updating status")
+	return tx.Commit()
+}
+
+// payFunds transfers funds locked for the Task to Freelancer's account within given transaction
+func (s *Service) payFunds(ctx context.Context, tx *sqlx.Tx, t *model.Task) error {
+	payment := model.Payment{}
+	// frozen funds are paid out by dispute resolution only
+	if err := tx.Get(&payment, "SELECT id, client_id, task_id, amount, status FROM billing WHERE task_id=$1 AND status=$2 FOR UPDATE",
+		t.ID, model.Locked); err != nil {
+		return err
+	}
+	return s.pay(ctx, tx, &payment, t.FreelancerID)
+}
+
+// pay marks locked Payment as paid and credits Freelancer's account within given transaction
+func (s *Service) pay(ctx context.Context, tx *sqlx.Tx, payment *model.Payment, freelancerID string) error {
+	logrus.WithField("amount", payment.Amount.String()).Info("updating status")
+	payment.Status = model.Paid
+	payment.PaidDate = time.Now()
+	payment.FreelancerID = freelancerID
 	if rs, err := tx.Exec("UPDATE billing SET status=$1, paid_date=$2, freelancer_id=$3 WHERE id=$4",
-		model.Paid, time.Now(), t.FreelancerID, txID); err != nil {
-		tx.Rollback()
+		payment.Status, payment.PaidDate, payment.FreelancerID, payment.ID); err != nil {
 		return err
 	} else if c, err := rs.RowsAffected(); c == 0 || err != nil {
-		tx.Rollback()
+		return errNoRows(err)
+	}
+	logrus.WithField("amount", payment.Amount.String()).Info("transfering funds")
+	if err := wallet.Credit(ctx, tx, wallet.FreelancerOwner, freelancerID, payment.Amount); err != nil {
 		return err
 	}
-	if err := tx.QueryRow("SELECT balance FROM freelancer WHERE id=$1", t.FreelancerID).Scan(&b); err != nil {
+	return events.Record(tx, source, events.PaymentPaid, payment.ID, payment)
+}
+
+// Charge will lock given amount from Client's account and pay it to Freelancer of the hourly Task.
+// Charge with the same reference is performed only once and existing Payment is returned
+func (s *Service) Charge(ctx context.Context, c model.Charge) (model.Payment, error) {
+	return s.charge(ctx, &c)
+}
+
+func (s *Service) charge(ctx context.Context, c *model.Charge) (model.Payment, error) {
+	payment := model.Payment{}
+	if c.Reference == "" || c.Amount.IsNegative() || c.Amount.IsZero() {
+		return payment, errors.New("invalid charge")
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return payment, err
+	}
+	task := model.Task{}
+	if err := tx.Get(&task, "SELECT * FROM task WHERE id=$1 FOR UPDATE", c.TaskID); err != nil {
 		tx.Rollback()
-		return err
+		return payment, err
 	}
-	logrus.WithField("balance", b.Int64).WithField("fee", t.Fee).Info("transfering funds")
-	total := b.Int64 + t.Fee
-	if rs, err := tx.Exec("UPDATE freelancer SET balance=$1 WHERE id=$2", total, t.FreelancerID); err != nil {
+	if task.Contract != model.HourlyContract || task.FreelancerID == "" {
 		tx.Rollback()
-		return err
-	} else if c, err := rs.RowsAffected(); c == 0 || err != nil {
+		return payment, ErrInvalidContract
+	}
+	err = tx.Get(&payment, "SELECT * FROM billing WHERE reference=$1", c.Reference)
+	if err == nil {
+		tx.Rollback()
+		return payment, nil
+	}
+	if err != sql.ErrNoRows {
+		tx.Rollback()
+		return payment, err
+	}
+	// lock funds from client account
+	if _, err := wallet.Debit(ctx, tx, wallet.ClientOwner, task.ClientID, c.Amount, s.rates); err != nil {
+		tx.Rollback()
+		return payment, err
+	}
+	payment = model.Payment{
+		ID:        model.NewID(),
+		ClientID:  task.ClientID,
+		TaskID:    task.ID,
+		Amount:    c.Amount,
+		Status:    model.Locked,
+		Reference: sql.NullString{String: c.Reference, Valid: true},
+	}
+	lockFunds := "INSERT INTO billing(id, client_id, task_id, amount, status, reference) VALUES($1,$2,$3,$4,$5,$6)"
+	if _, err := tx.Exec(lockFunds, payment.ID, payment.ClientID, payment.TaskID, payment.Amount, payment.Status, payment.Reference); err != nil {
 		tx.Rollback()
+		return payment, err
+	}
+	if err := s.pay(ctx, tx, &payment, task.FreelancerID); err != nil {
+		tx.Rollback()
+		return payment, err
+	}
+	return payment, tx.Commit()
+}
+
+// auditTask records change of the Task by ID in the audit log within given transaction,
+// before represents the Task as it was, nil for created Task
+func auditTask(ctx context.Context, tx *sqlx.Tx, action model.AuditAction, id string, before interface{}) error {
+	after := model.Task{}
+	if err := tx.Get(&after, "SELECT * FROM task WHERE id=$1", id); err != nil {
 		return err
 	}
+	return audit.Record(ctx, tx, action, "task", id, before, after)
+}
 
-	tx.Commit()
-	return nil
+// errNoRows returns err or sql.ErrNoRows if err is nil
+func errNoRows(err error) error {
+	if err != nil {
+		return err
+	}
+	return sql.ErrNoRows
 }
 
 // Update will perform DB update operation for the given Task
 // NOTE: Not all fields are updatable
 // TODO: Write better query builder using reflect package
-func (s *Service) Update(subject, reply string, t *model.Task) {
+func (s *Service) Update(ctx context.Context, t model.Task) (rpc.Empty, error) {
 	var (
 		updateS  strings.Builder
 		position = 1
 		args     []interface{}
 		update   = "UPDATE task SET "
-		err      error
+		from     model.TaskStatus
 	)
-	defer func() {
-		if err != nil {
-			s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-			return
-		}
-		s.jsonConn.Publish(reply, model.NATSMsg{Success: true})
-	}()
 
-	if _, err := updateS.WriteString(update); err != nil {
-		return
-	}
+	updateS.WriteString(update)
 	// check if fee is set
-	if t.Fee > 0 {
-		if _, err := updateS.WriteString(fmt.Sprintf("fee=$%d", position)); err != nil {
-			return
-		}
+	if t.Fee.Amount > 0 {
+		updateS.WriteString(fmt.Sprintf("fee=$%d", position))
 		position++
 		args = append(args, t.Fee)
 	}
 
 	if len(t.Status) > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
+			updateS.WriteString(", ")
 		}
-		if _, err := updateS.WriteString(fmt.Sprintf("status=$%d", position)); err != nil {
-			return
+		updateS.WriteString(fmt.Sprintf("status=$%d", position))
+		current, err := s.getTaskByID(t.ID)
+		if err == nil && current.Status == model.Disputed {
+			// only dispute resolution can change status of disputed task
+			return rpc.Empty{}, ErrDisputed
 		}
+		from = current.Status
 		switch t.Status {
 		//transfer funds to freelancer
 		case model.Closed:
-			task, err := s.getTaskByID(t.ID)
 			if err != nil {
-				return
+				return rpc.Empty{}, err
 			}
-			if err := s.transferFunds(&task); err != nil {
-				s.jsonConn.Publish(reply, model.NATSMsg{Success: false, Message: err.Error()})
-				return
+			// hourly contracts are paid by weekly billing runs
+			if current.Contract != model.HourlyContract {
+				if err := s.transferFunds(ctx, &current); err != nil {
+					return rpc.Empty{}, err
+				}
 			}
 			//return funds to client
 		case model.Abandoned:
@@ -210,100 +397,171 @@ func (s *Service) Update(subject, reply string, t *model.Task) {
 		}
 		position++
 		args = append(args, t.Status)
+		// start review period
+		if t.Status == model.Completed {
+			now := time.Now()
+			updateS.WriteString(fmt.Sprintf(", completed_at=$%d", position))
+			position++
+			args = append(args, now)
+			if s.reviewPeriod > 0 {
+				updateS.WriteString(fmt.Sprintf(", review_deadline=$%d", position))
+				position++
+				args = append(args, now.Add(s.reviewPeriod))
+			}
+		}
 	}
 
 	if len(t.Description) > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
-		}
-		if _, err := updateS.WriteString(fmt.Sprintf("description=$%d", position)); err != nil {
-			return
+			updateS.WriteString(", ")
 		}
+		updateS.WriteString(fmt.Sprintf("description=$%d", position))
 		position++
 		args = append(args, t.Description)
 	}
 
 	if len(t.FreelancerID) > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
-		}
-		if _, err := updateS.WriteString(fmt.Sprintf("freelancer_id=$%d", position)); err != nil {
-			return
+			updateS.WriteString(", ")
 		}
+		updateS.WriteString(fmt.Sprintf("freelancer_id=$%d", position))
 		position++
 		args = append(args, t.FreelancerID)
 	}
 
 	if t.Deadline > 0 {
 		if position > 1 {
-			if _, err = updateS.WriteString(", "); err != nil {
-				return
-			}
-		}
-		if _, err := updateS.WriteString(fmt.Sprintf("deadline=$%d", position)); err != nil {
-			return
+			updateS.WriteString(", ")
 		}
+		updateS.WriteString(fmt.Sprintf("deadline=$%d", position))
 		position++
 		args = append(args, t.Deadline)
 	}
+
+	// empty list removes the tags
+	if t.Tags != nil {
+		tags, err := normalizeTags(t.Tags)
+		if err != nil {
+			return rpc.Empty{}, err
+		}
+		if position > 1 {
+			updateS.WriteString(", ")
+		}
+		updateS.WriteString(fmt.Sprintf("tags=$%d", position))
+		position++
+		args = append(args, tags)
+	}
+
+	if len(t.Category) > 0 {
+		category := strings.ToLower(strings.TrimSpace(t.Category))
+		if err := skill.Check(ctx, s.db, category, nil); err != nil {
+			return rpc.Empty{}, err
+		}
+		if position > 1 {
+			updateS.WriteString(", ")
+		}
+		updateS.WriteString(fmt.Sprintf("category=$%d", position))
+		position++
+		args = append(args, category)
+	}
+
+	// empty list removes required skills
+	if t.Skills != nil {
+		skills, err := skill.Normalize(t.Skills)
+		if err != nil {
+			return rpc.Empty{}, err
+		}
+		if err := skill.Check(ctx, s.db, "", skills); err != nil {
+			return rpc.Empty{}, err
+		}
+		if position > 1 {
+			updateS.WriteString(", ")
+		}
+		updateS.WriteString(fmt.Sprintf("skills=$%d", position))
+		position++
+		args = append(args, skills)
+	}
 	// nothing to update
 	if update == updateS.String() {
-		return
+		return rpc.Empty{}, nil
 	}
 	if position > 1 {
-		if _, err = updateS.WriteString(", "); err != nil {
-			return
-		}
+		updateS.WriteString(", ")
 	}
 	t.UpdatedAt = pq.NullTime{Time: time.Now(), Valid: true}
-	if _, err := updateS.WriteString(fmt.Sprintf("updated_at=$%d ", position)); err != nil {
-		return
-	}
+	updateS.WriteString(fmt.Sprintf("updated_at=$%d ", position))
 	position++
 	args = append(args, t.UpdatedAt)
 
-	if _, err := updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position)); err != nil {
-		return
-	}
-	logrus.Info(updateS.String())
+	updateS.WriteString(fmt.Sprintf("WHERE ID=$%d", position))
 	args = append(args, t.ID)
-	if _, err := s.db.Exec(updateS.String(), args...); err != nil {
-		return
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	before := model.Task{}
+	if err := tx.Get(&before, "SELECT * FROM task WHERE id=$1 FOR UPDATE", t.ID); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if _, err := tx.Exec(updateS.String(), args...); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if err := auditTask(ctx, tx, model.AuditUpdate, t.ID, before); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if len(t.Status) > 0 && t.Status != from {
+		freelancerID := before.FreelancerID
+		if len(t.FreelancerID) > 0 {
+			freelancerID = t.FreelancerID
+		}
+		change := events.StatusChange{TaskID: t.ID, From: from, To: t.Status, ClientID: before.ClientID, FreelancerID: freelancerID}
+		if err := events.Record(tx, source, events.TaskStatusChanged, t.ID, change); err != nil {
+			tx.Rollback()
+			return rpc.Empty{}, err
+		}
 	}
+	return rpc.Empty{}, tx.Commit()
 }
 
 // Delete will peform soft delete and set deleted_at datetime
-func (s *Service) Delete(subject, reply, id string) error {
+func (s *Service) Delete(ctx context.Context, id string) (rpc.Empty, error) {
 	if len(id) != 36 {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return rpc.Empty{}, model.ErrInvalidID
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	before := model.Task{}
+	if err := tx.Get(&before, "SELECT * FROM task WHERE id=$1 FOR UPDATE", id); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
 	}
 	delS := "UPDATE task SET deleted_at=$1 WHERE id=$2"
-	if _, err := s.db.Exec(delS, time.Now(), id); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
+	if _, err := tx.Exec(delS, time.Now(), id); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if err := auditTask(ctx, tx, model.AuditDelete, id, before); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if err := events.Record(tx, source, events.TaskDeleted, id, model.Task{ID: id, ClientID: before.ClientID, FreelancerID: before.FreelancerID}); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
 	}
-	return s.jsonConn.Publish(reply, &model.NATSMsg{Success: true})
+	return rpc.Empty{}, tx.Commit()
 }
 
 // Get will perform DB select operation and retrieve Task by given ID
-func (s *Service) Get(subject, reply, id string) error {
+func (s *Service) Get(ctx context.Context, id string) (model.Task, error) {
 	if len(id) != 36 {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: model.ErrInvalidID.Error()})
+		return model.Task{}, model.ErrInvalidID
 	}
-
-	task, err := s.getTaskByID(id)
-	if err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-
-	d, err := json.Marshal(&task)
-	if err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true, Data: d})
+	return s.getTaskByID(id)
 }
 
 func (s *Service) getTaskByID(id string) (model.Task, error) {
@@ -316,15 +574,9 @@ func (s *Service) getTaskByID(id string) (model.Task, error) {
 }
 
 // List will perform DB select operation and retrieve all Tasks by give owner(client)
-func (s *Service) List(subject, reply, clientID string) error {
+func (s *Service) List(ctx context.Context, clientID string) ([]model.Task, error) {
 	query := "SELECT * FROM task WHERE client_id = $1 AND deleted_at IS NULL ORDER BY created_at ASC"
 	tasks := []model.Task{}
-	if err := s.db.Select(&tasks, query, clientID); err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	d, err := json.Marshal(&tasks)
-	if err != nil {
-		return s.jsonConn.Publish(reply, &model.NATSMsg{Success: false, Message: err.Error()})
-	}
-	return s.jsonConn.Publish(reply, model.NATSMsg{Success: true, Data: d})
+	err := s.db.SelectContext(ctx, &tasks, query, clientID)
+	return tasks, err
 }
@@ -1,17 +1,22 @@
 package task
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
 	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/client"
 	"github.com/kylycht/md/services/freelancer"
 	_ "github.com/lib/pq"
-	"github.com/nats-io/gnatsd/server"
-	gnatsd "github.com/nats-io/gnatsd/test"
-	nats "github.com/nats-io/go-nats"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
 )
 
 var s *Service
@@ -20,18 +25,40 @@ var s *Service
 
  */
 
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
 var taskSchema = `CREATE TABLE TASK (
     ID varchar(36) PRIMARY KEY NOT NULL,
     CLIENT_ID varchar(36) NOT NULL,
     FREELANCER_ID varchar(36),
     DESCRIPTION text,
-    FEE bigint,
+    FEE MONEY_AMOUNT,
 	STATUS varchar,
 	Deadline int8,
     CREATED_AT timestamp,
     STARTED_AT timestamp,
     DELETED_AT timestamp,
-    UPDATED_AT timestamp
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var categorySchema = `CREATE TABLE CATEGORY (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL
+)`
+
+var skillSchema = `CREATE TABLE SKILL (
+	ID varchar(64) PRIMARY KEY NOT NULL,
+	NAME varchar(128) NOT NULL,
+	CATEGORY_ID varchar(64) NOT NULL REFERENCES CATEGORY (ID)
 )`
 
 var billingSchema = `CREATE TABLE BILLING (
@@ -40,13 +67,14 @@ var billingSchema = `CREATE TABLE BILLING (
 	FREELANCER_ID varchar(36),
 	PAID_DATE timestamp,
 	STATUS varchar,
-	AMOUNT bigint,
-	TASK_ID varchar(36)
+	AMOUNT MONEY_AMOUNT,
+	TASK_ID varchar(36),
+	REFERENCE varchar(128) UNIQUE
 )`
 
 var clientSchema = `CREATE TABLE CLIENT (
 	ID varchar(36) PRIMARY KEY NOT NULL,
-	BALANCE bigint,
+	BALANCE MONEY_AMOUNT,
 	EMAIL varchar(128),
     DELETED_AT timestamp
 )`
@@ -55,13 +83,75 @@ var freelancerSchema = `CREATE TABLE FREELANCER (
     ID varchar(36) PRIMARY KEY NOT NULL,
 	DESCRIPTION text,
 	DETAILS text,
-	BALANCE bigint,
+	BALANCE MONEY_AMOUNT,
 	EMAIL varchar(128),
     DELETED_AT timestamp
 )`
 
+var walletSchema = `CREATE TABLE WALLET (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	BALANCE MONEY_AMOUNT NOT NULL
+)`
+
+var walletIndex = `CREATE UNIQUE INDEX WALLET_OWNER_CURRENCY ON WALLET (OWNER_ID, ((BALANCE).CURRENCY))`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var outboxIndex = `CREATE INDEX OUTBOX_PENDING ON OUTBOX (NEXT_ATTEMPT_AT) WHERE SENT_AT IS NULL`
+
+var reservationSchema = `CREATE TABLE RESERVATION (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	AMOUNT MONEY_AMOUNT,
+	WITHDRAWN MONEY_AMOUNT,
+	STATUS varchar NOT NULL,
+	CREATED_AT timestamp,
+	UPDATED_AT timestamp
+)`
+
+var sagaSchema = `CREATE TABLE SAGA (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	STATE varchar NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	LAST_ERROR text,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	CREATED_AT timestamp NOT NULL,
+	UPDATED_AT timestamp NOT NULL
+)`
+
+// testClientID represents Client owning created Tasks
+const testClientID = "74dcc973-50a9-4b87-9403-814a69c5359e"
+
+// testBalance represents balance of the Client at the beginning of every test
+var testBalance = model.NewMoney(123456789, model.USD)
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
 func startServer() *server.Server {
-	return gnatsd.RunDefaultServer()
+	return natstest.RunDefaultServer()
 }
 
 func setUp(t *testing.T) func() {
@@ -77,10 +167,25 @@ func setUp(t *testing.T) func() {
 
 	s.db = db
 
+	s.db.Exec(moneyType)
+
 	s.db.Exec(taskSchema)
+	s.db.Exec(categorySchema)
+	s.db.Exec(skillSchema)
 	s.db.Exec(billingSchema)
 	s.db.Exec(clientSchema)
 	s.db.Exec(freelancerSchema)
+	s.db.Exec(walletSchema)
+	s.db.Exec(walletIndex)
+	s.db.Exec(outboxSchema)
+	s.db.Exec(outboxIndex)
+	s.db.Exec(auditSchema)
+	s.db.Exec(reservationSchema)
+	s.db.Exec(sagaSchema)
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3) ON CONFLICT (id) DO UPDATE SET balance=EXCLUDED.balance",
+		testClientID, "client@email.com", testBalance); err != nil {
+		t.Fatal(err)
+	}
 
 	natsServer := startServer()
 
@@ -97,15 +202,16 @@ func setUp(t *testing.T) func() {
 		t.Fatal(err)
 	}
 	s.jsonConn = natsEncConn
-	s.jsonConn.Subscribe("client.get", func(subject, reply, id string) {
-		s.jsonConn.Publish(reply, model.Client{ID: "74dcc973-50a9-4b87-9403-814a69c5359e", Balance: 123456789})
-	})
 	// subscribe to topics
 	s.init()
 	_, err = freelancer.NewService(db, natsEncConn)
 	if err != nil {
 		t.Error(err)
 	}
+	// funds are reserved by the client service
+	if _, err = client.NewService(db, natsEncConn); err != nil {
+		t.Error(err)
+	}
 
 	return func() {
 		// s.db.Exec("DROP TABLE task")
@@ -125,7 +231,7 @@ func populateDB(t *testing.T) string {
 }
 
 func NewTask() model.Task {
-	return model.NewTask(time.Hour*24*2, 133227, model.NewID(), "foo bar")
+	return model.NewTask(time.Hour*24*2, model.NewMoney(133227, model.USD), testClientID, "foo bar")
 }
 
 func TestFlow(t *testing.T) {
@@ -252,6 +358,9 @@ func TestService_List(t *testing.T) {
 	defer destroy()
 
 	clientID := model.NewID()
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3)", clientID, "list@email.com", testBalance); err != nil {
+		t.Fatal(err)
+	}
 
 	task := NewTask()
 	task.ClientID = clientID
@@ -324,7 +433,7 @@ func TestService_Update(t *testing.T) {
 		},
 		{
 			name:    "update-desc-fee",
-			args:    args{t: model.Task{ID: taskID, Description: "bar foo buzz", Fee: 99999}},
+			args:    args{t: model.Task{ID: taskID, Description: "bar foo buzz", Fee: model.NewMoney(99999, model.USD)}},
 			wantErr: false,
 		},
 		{
@@ -344,7 +453,7 @@ func TestService_Update(t *testing.T) {
 		},
 		{
 			name:    "update-all",
-			args:    args{t: model.Task{ID: taskID, FreelancerID: model.NewID(), Description: "bar foo buzzfoo", Fee: 29999}},
+			args:    args{t: model.Task{ID: taskID, FreelancerID: model.NewID(), Description: "bar foo buzzfoo", Fee: model.NewMoney(29999, model.USD)}},
 			wantErr: false,
 		},
 	}
@@ -361,3 +470,235 @@ func TestService_Update(t *testing.T) {
 		})
 	}
 }
+
+func TestService_AutoApprove(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+	s.reviewPeriod = time.Hour
+	s.reminderBefore = time.Minute * 10
+
+	task := NewTask()
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("task.add", task, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	freelancer := model.NewFreelancer("freelancer@email.com", "dev", "golang")
+	if err := s.jsonConn.Request("freelancer.add", &freelancer, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	task.FreelancerID = freelancer.ID
+	task.Status = model.Completed
+	if err := s.jsonConn.Request("task.update", task, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+
+	// reminder is recorded once before the deadline, task is still completed
+	now := time.Now().Add(time.Minute * 55)
+	s.remindReview(now)
+	s.remindReview(now)
+	s.approveCompleted(now)
+	var reminders int
+	query := "SELECT count(*) FROM outbox WHERE subject=$1 AND convert_from(payload, 'UTF8') LIKE '%' || $2 || '%'"
+	if err := s.db.Get(&reminders, query, events.TaskReviewReminder.Subject(), task.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if reminders != 1 {
+		t.Errorf("expected=1 got=%d reminders", reminders)
+	}
+	got, err := s.getTaskByID(task.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Status != model.Completed {
+		t.Errorf("expected=%s got=%s", model.Completed, got.Status)
+	}
+
+	// task is closed and paid after the deadline
+	s.approveCompleted(time.Now().Add(time.Hour * 2))
+	if got, err = s.getTaskByID(task.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Status != model.Closed {
+		t.Errorf("expected=%s got=%s", model.Closed, got.Status)
+	}
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM freelancer WHERE id=$1", freelancer.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if balance != task.Fee {
+		t.Errorf("freelancer balance mismatch, expected=%s got=%s", task.Fee, balance)
+	}
+	// task closed meanwhile is neither approved nor paid twice
+	if err := s.approve(&got, time.Now()); err != errNotCompleted {
+		t.Errorf("expected=%v got=%v", errNotCompleted, err)
+	}
+}
+
+func TestService_AutoApproveHourly(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+	s.reviewPeriod = time.Hour
+
+	task := model.NewHourlyTask(time.Hour*24, model.NewMoney(5000, model.USD), time.Hour*40, testClientID, "hourly work")
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("task.add", task, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	task.FreelancerID = model.NewID()
+	task.Status = model.Completed
+	if err := s.jsonConn.Request("task.update", task, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+
+	// hourly task has no funds held, it is closed without payment
+	s.approveCompleted(time.Now().Add(time.Hour * 2))
+	got, err := s.getTaskByID(task.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got.Status != model.Closed {
+		t.Errorf("expected=%s got=%s", model.Closed, got.Status)
+	}
+	var payments int
+	if err := s.db.Get(&payments, "SELECT count(*) FROM billing WHERE task_id=$1", task.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if payments != 0 {
+		t.Errorf("expected no payments, got %d", payments)
+	}
+}
+
+func TestService_Events(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	received := make(chan events.Envelope, 4)
+	if _, err := s.jsonConn.Subscribe("events.>", func(e *events.Envelope) {
+		received <- *e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	task := NewTask()
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("task.add", task, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	freelancerID := model.NewID()
+	if err := s.jsonConn.Request("task.update", model.Task{ID: task.ID, Status: model.Started, FreelancerID: freelancerID}, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// events are published by the relay once the changes are committed
+	relay := events.NewRelay(s.db, events.NewNATSPublisher(s.jsonConn.Conn), time.Second)
+	if _, err := relay.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, want := range []events.Type{events.TaskCreated, events.PaymentLocked, events.TaskStatusChanged} {
+		select {
+		case e := <-received:
+			if e.Type != want {
+				t.Errorf("expected=%s got=%s", want, e.Type)
+				continue
+			}
+			if e.Version != events.Version {
+				t.Errorf("version mismatch, expected=%d got=%d", events.Version, e.Version)
+			}
+			if want == events.TaskStatusChanged {
+				change := events.StatusChange{}
+				if err := e.Decode(&change); err != nil {
+					t.Error(err)
+				}
+				if change.From != model.Open || change.To != model.Started {
+					t.Errorf("unexpected status change: %+v", change)
+				}
+				// parties of the Task are carried for routing
+				if change.ClientID != task.ClientID || change.FreelancerID != freelancerID {
+					t.Errorf("unexpected parties: %+v", change)
+				}
+			}
+		case <-time.After(time.Second * 5):
+			t.Errorf("%s was not published", want)
+		}
+	}
+}
+
+func TestService_Audit(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := rpc.WithActor(context.Background(), testClientID)
+	task := NewTask()
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskAdd, task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskUpdate, model.Task{ID: task.ID, Description: "foo bar baz"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskDelete, task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []model.AuditEntry{}
+	query := "SELECT id, actor, action, entity, entity_id, before, after, created_at FROM audit_log WHERE entity='task' AND entity_id=$1 ORDER BY seq"
+	if err := s.db.Select(&entries, query, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	actions := []model.AuditAction{model.AuditCreate, model.AuditUpdate, model.AuditDelete}
+	if len(entries) != len(actions) {
+		t.Fatalf("expected %d entries, got %d", len(actions), len(entries))
+	}
+	for i, e := range entries {
+		if e.Action != actions[i] || e.Actor != testClientID {
+			t.Errorf("expected %s by %s, got %s by %s", actions[i], testClientID, e.Action, e.Actor)
+		}
+	}
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(entries[1].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entries[1].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before["Description"] != task.Description || after["Description"] != "foo bar baz" || after["Status"] != nil {
+		t.Errorf("unexpected change before=%v after=%v", before, after)
+	}
+	// funds reserved for the Task are withdrawn on behalf of the same actor
+	var reserved int
+	if err := s.db.Get(&reserved, "SELECT count(*) FROM audit_log WHERE entity='client' AND entity_id=$1 AND action='balance' AND actor=$1", testClientID); err != nil {
+		t.Fatal(err)
+	}
+	if reserved == 0 {
+		t.Error("expected withdrawal of the fee audited")
+	}
+}
@@ -0,0 +1,304 @@
+package timesheet
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	timeout = time.Second * 5
+	week    = time.Hour * 24 * 7
+)
+
+var (
+	// ErrNotHourly represents error returned when time is logged against the Task without hourly contract
+	ErrNotHourly = rpc.Errorf(rpc.CodeInvalid, "task is not hourly")
+	// ErrNotStarted represents error returned when time is logged against the Task that is not started
+	ErrNotStarted = rpc.Errorf(rpc.CodeInvalid, "task is not started")
+	// ErrNotAssigned represents error returned when time is logged by Freelancer not assigned to the Task
+	ErrNotAssigned = rpc.Errorf(rpc.CodePermission, "freelancer is not assigned to the task")
+	// ErrNotOwner represents error returned when Timesheet is approved by someone other than Client of the Task
+	ErrNotOwner = rpc.Errorf(rpc.CodePermission, "client does not own the task")
+	// ErrWeeklyCapExceeded represents error returned when logged time exceeds weekly cap of the contract
+	ErrWeeklyCapExceeded = rpc.Errorf(rpc.CodeInvalid, "weekly cap exceeded")
+	// ErrInvalidDuration represents error returned on empty or longer than a day TimeEntry
+	ErrInvalidDuration = rpc.Errorf(rpc.CodeInvalid, "invalid duration")
+	// ErrWeekBilled represents error returned when time is logged for the week that is already billed
+	ErrWeekBilled = rpc.Errorf(rpc.CodeInvalid, "week is already billed")
+)
+
+// Service represents Timesheet service that will handle
+// time logged against hourly contracts and weekly billing runs
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+
+	billingInterval time.Duration
+	done            chan struct{}
+}
+
+// NewService returns new instance of Timesheet service,
+// approved hours are billed every billingInterval, zero interval disables billing runs
+func NewService(db *sqlx.DB, conn *nats.EncodedConn, billingInterval time.Duration) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn, billingInterval: billingInterval, done: make(chan struct{})}
+	if err := srv.init(); err != nil {
+		return srv, err
+	}
+	if billingInterval > 0 {
+		go srv.runBilling()
+	}
+	return srv, nil
+}
+
+// Close stops billing runs
+func (s *Service) Close() {
+	close(s.done)
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.TimesheetLog, s.Log); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TimesheetGet, s.Get); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TimesheetApprove, s.Approve); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TimesheetReject, s.Reject); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.TimesheetBill, s.Bill); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Log will perform DB insert operation for the given TimeEntry
+func (s *Service) Log(ctx context.Context, e model.TimeEntry) (rpc.Empty, error) {
+	return rpc.Empty{}, s.log(&e)
+}
+
+func (s *Service) log(e *model.TimeEntry) error {
+	if e.Duration <= 0 || e.Duration > time.Hour*24 {
+		return ErrInvalidDuration
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	task := model.Task{}
+	// lock the Task so concurrent entries do not exceed weekly cap
+	if err := tx.Get(&task, "SELECT * FROM task WHERE id = $1 FOR UPDATE", e.TaskID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if task.Contract != model.HourlyContract {
+		tx.Rollback()
+		return ErrNotHourly
+	}
+	if task.Status != model.Started {
+		tx.Rollback()
+		return ErrNotStarted
+	}
+	if e.FreelancerID == "" || e.FreelancerID != task.FreelancerID {
+		tx.Rollback()
+		return ErrNotAssigned
+	}
+	weekStart := model.WeekStart(e.Date)
+	var billed bool
+	if err := tx.Get(&billed, "SELECT EXISTS(SELECT 1 FROM time_entry WHERE task_id = $1 AND status = $2 AND date >= $3 AND date < $4)",
+		task.ID, model.EntryBilled, weekStart, weekStart.Add(week)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if billed {
+		tx.Rollback()
+		return ErrWeekBilled
+	}
+	var logged int64
+	if err := tx.Get(&logged, "SELECT COALESCE(SUM(duration), 0) FROM time_entry WHERE task_id = $1 AND status <> $2 AND date >= $3 AND date < $4",
+		task.ID, model.EntryRejected, weekStart, weekStart.Add(week)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if time.Duration(logged)+e.Duration > task.WeeklyCap {
+		tx.Rollback()
+		return ErrWeeklyCapExceeded
+	}
+	if e.ID == "" {
+		e.ID = model.NewID()
+	}
+	e.Status = model.EntryPending
+	e.CreatedAt = time.Now()
+	insertS := "INSERT INTO time_entry (id, task_id, freelancer_id, date, duration, note, status, created_at) VALUES($1, $2, $3, $4, $5, $6, $7, $8)"
+	if _, err := tx.Exec(insertS, e.ID, e.TaskID, e.FreelancerID, e.Date, e.Duration, e.Note, e.Status, e.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get will perform DB select operation and retrieve Timesheet of the Task for the given week
+func (s *Service) Get(ctx context.Context, ts model.Timesheet) (model.Timesheet, error) {
+	ts.Week = model.WeekStart(ts.Week)
+	ts.Entries = []model.TimeEntry{}
+	query := "SELECT * FROM time_entry WHERE task_id = $1 AND date >= $2 AND date < $3 ORDER BY date ASC, created_at ASC"
+	if err := s.db.SelectContext(ctx, &ts.Entries, query, ts.TaskID, ts.Week, ts.Week.Add(week)); err != nil {
+		return ts, err
+	}
+	ts.Total = 0
+	for _, e := range ts.Entries {
+		if e.Status != model.EntryRejected {
+			ts.Total += e.Duration
+		}
+	}
+	return ts, nil
+}
+
+// Approve will approve all pending TimeEntries of the Timesheet,
+// approved hours are paid in the next billing run
+func (s *Service) Approve(ctx context.Context, ts model.Timesheet) (rpc.Empty, error) {
+	return rpc.Empty{}, s.review(ctx, ts, model.EntryApproved)
+}
+
+// Reject will reject all pending TimeEntries of the Timesheet
+func (s *Service) Reject(ctx context.Context, ts model.Timesheet) (rpc.Empty, error) {
+	return rpc.Empty{}, s.review(ctx, ts, model.EntryRejected)
+}
+
+func (s *Service) review(ctx context.Context, ts model.Timesheet, status model.TimeEntryStatus) error {
+	task := model.Task{}
+	if err := s.db.GetContext(ctx, &task, "SELECT * FROM task WHERE id = $1", ts.TaskID); err != nil {
+		return err
+	}
+	if ts.ClientID == "" || ts.ClientID != task.ClientID {
+		return ErrNotOwner
+	}
+	weekStart := model.WeekStart(ts.Week)
+	updateS := "UPDATE time_entry SET status=$1 WHERE task_id=$2 AND status=$3 AND date >= $4 AND date < $5"
+	_, err := s.db.ExecContext(ctx, updateS, status, ts.TaskID, model.EntryPending, weekStart, weekStart.Add(week))
+	return err
+}
+
+// Bill will run billing of approved hours for the weeks that ended before the given time
+func (s *Service) Bill(ctx context.Context, before time.Time) (rpc.Empty, error) {
+	if before.IsZero() {
+		before = time.Now()
+	}
+	return rpc.Empty{}, s.bill(ctx, before)
+}
+
+func (s *Service) runBilling() {
+	ticker := time.NewTicker(s.billingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if err := s.bill(context.Background(), now); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+}
+
+// bill charges approved hours of every hourly Task per week through task.charge
+// and marks paid TimeEntries as billed.
+// Each charge has unique reference of the billed TimeEntries so repeated runs do not pay twice,
+// while entries approved after the week was billed are charged separately
+func (s *Service) bill(ctx context.Context, before time.Time) error {
+	entries := []model.TimeEntry{}
+	query := "SELECT * FROM time_entry WHERE status = $1 AND date < $2 ORDER BY task_id, date"
+	if err := s.db.Select(&entries, query, model.EntryApproved, model.WeekStart(before)); err != nil {
+		return err
+	}
+	type key struct {
+		taskID string
+		week   time.Time
+	}
+	grouped := map[key][]model.TimeEntry{}
+	var keys []key
+	for _, e := range entries {
+		k := key{taskID: e.TaskID, week: model.WeekStart(e.Date)}
+		if _, ok := grouped[k]; !ok {
+			keys = append(keys, k)
+		}
+		grouped[k] = append(grouped[k], e)
+	}
+
+	var failed int
+	for _, k := range keys {
+		if err := s.billWeek(ctx, k.taskID, k.week, grouped[k]); err != nil {
+			logrus.WithField("task_id", k.taskID).WithField("week", k.week).Error(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d timesheets failed to bill", failed, len(keys))
+	}
+	return nil
+}
+
+func (s *Service) billWeek(ctx context.Context, taskID string, weekStart time.Time, entries []model.TimeEntry) error {
+	task := model.Task{}
+	if err := s.db.Get(&task, "SELECT * FROM task WHERE id = $1", taskID); err != nil {
+		return err
+	}
+	var total time.Duration
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		total += e.Duration
+		ids = append(ids, e.ID)
+	}
+	if task.WeeklyCap > 0 && total > task.WeeklyCap {
+		total = task.WeeklyCap
+	}
+	charge := model.Charge{
+		TaskID:    taskID,
+		Amount:    Amount(task.HourlyRate, total),
+		Reference: reference(taskID, weekStart, ids),
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	payment, err := rpc.Call(ctx, s.jsonConn.Conn, api.TaskCharge, charge)
+	if err != nil {
+		return err
+	}
+	query, args, err := sqlx.In("UPDATE time_entry SET status=?, payment_id=? WHERE id IN (?)", model.EntryBilled, payment.ID, ids)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.db.Rebind(query), args...)
+	return err
+}
+
+// reference returns charge reference of the week's TimeEntries by given IDs, the same entries
+// always give the same reference regardless of their order
+func reference(taskID string, weekStart time.Time, ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return fmt.Sprintf("timesheet:%s:%s:%x", taskID, weekStart.Format("2006-01-02"), sum[:8])
+}
+
+// Amount returns amount to be paid for duration d at the hourly rate,
+// rounded half up to the minor units of the rate's currency
+func Amount(rate model.Money, d time.Duration) model.Money {
+	seconds := int64(d / time.Second)
+	return model.NewMoney((rate.Amount*seconds+1800)/3600, rate.Currency)
+}
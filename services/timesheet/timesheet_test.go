@@ -0,0 +1,276 @@
+package timesheet
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var timeEntrySchema = `CREATE TABLE TIME_ENTRY (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	TASK_ID varchar(36) NOT NULL,
+	FREELANCER_ID varchar(36) NOT NULL,
+	DATE date NOT NULL,
+	DURATION int8 NOT NULL,
+	NOTE text,
+	STATUS varchar,
+	PAYMENT_ID varchar(36),
+	CREATED_AT timestamp
+)`
+
+func startServer() *server.Server {
+	return natstest.RunDefaultServer()
+}
+
+func setUp(t *testing.T) func() {
+	s = &Service{}
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.db = db
+	s.db.Exec(moneyType)
+	s.db.Exec(taskSchema)
+	s.db.Exec(timeEntrySchema)
+
+	natsServer := startServer()
+
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !natsConn.IsConnected() {
+		t.Fatal("no nats connection")
+	}
+
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jsonConn = natsEncConn
+
+	// subscribe to topics
+	s.init()
+	return func() {
+		s.db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+// populateDB inserts started hourly task with the given rate and weekly cap
+func populateDB(t *testing.T, rate model.Money, weeklyCap time.Duration) model.Task {
+	task := model.NewHourlyTask(time.Hour*24*30, rate, weeklyCap, model.NewID(), "golang app")
+	task.FreelancerID = model.NewID()
+	task.Status = model.Started
+
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, freelancer_id, description, deadline, created_at, status, contract, hourly_rate, weekly_cap) "+
+		"VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", task.ID, task.ClientID, task.FreelancerID, task.Description, task.Deadline, task.CreatedAt,
+		task.Status, task.Contract, task.HourlyRate, task.WeeklyCap); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestFlow(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t, model.NewMoney(4000, model.EUR), time.Hour*10)
+	week := model.WeekStart(time.Now()).Add(-time.Hour * 24 * 7)
+
+	// mock task service
+	charges := make(chan model.Charge, 1)
+	s.jsonConn.Subscribe("task.charge", func(subject, reply string, c *model.Charge) {
+		charges <- *c
+		d, _ := json.Marshal(model.Payment{ID: model.NewID(), TaskID: c.TaskID, Amount: c.Amount, Status: model.Paid})
+		s.jsonConn.Publish(reply, model.NATSMsg{Success: true, Data: d})
+	})
+
+	reply := &model.NATSMsg{}
+	entries := []model.TimeEntry{
+		model.NewTimeEntry(task.ID, task.FreelancerID, week, time.Hour*6, "api"),
+		model.NewTimeEntry(task.ID, task.FreelancerID, week.Add(time.Hour*24), time.Hour*3+time.Minute*30, "tests"),
+	}
+	for _, e := range entries {
+		if err := s.jsonConn.Request("timesheet.log", e, reply, time.Second*10); err != nil {
+			t.Error(err)
+			return
+		}
+		if !reply.Success {
+			t.Error(reply.Message)
+			return
+		}
+	}
+	// exceeds weekly cap
+	over := model.NewTimeEntry(task.ID, task.FreelancerID, week.Add(time.Hour*48), time.Hour, "docs")
+	if err := s.jsonConn.Request("timesheet.log", over, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if reply.Success || reply.Message != ErrWeeklyCapExceeded.Error() {
+		t.Errorf("expected=%s got=%s", ErrWeeklyCapExceeded, reply.Message)
+	}
+
+	if err := s.jsonConn.Request("timesheet.approve", model.Timesheet{TaskID: task.ID, ClientID: task.ClientID, Week: week}, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+
+	if err := s.jsonConn.Request("timesheet.bill", time.Now(), reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	select {
+	case c := <-charges:
+		if c.Amount != model.NewMoney(38000, model.EUR) {
+			t.Errorf("expected=%s got=%s", model.NewMoney(38000, model.EUR), c.Amount)
+		}
+		if want := reference(task.ID, week, []string{entries[0].ID, entries[1].ID}); c.Reference != want {
+			t.Errorf("expected=%s got=%s", want, c.Reference)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("task was not charged")
+		return
+	}
+
+	if err := s.jsonConn.Request("timesheet.get", model.Timesheet{TaskID: task.ID, Week: week}, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	ts := model.Timesheet{}
+	if err := json.Unmarshal(reply.Data, &ts); err != nil {
+		t.Error(err)
+		return
+	}
+	if ts.Total != time.Hour*9+time.Minute*30 {
+		t.Errorf("expected=%s got=%s", time.Hour*9+time.Minute*30, ts.Total)
+	}
+	for _, e := range ts.Entries {
+		if e.Status != model.EntryBilled || !e.PaymentID.Valid {
+			t.Errorf("entry %s is not billed", e.ID)
+		}
+	}
+
+	// billed week is closed for logging
+	late := model.NewTimeEntry(task.ID, task.FreelancerID, week.Add(time.Hour*48), time.Minute*30, "docs")
+	if err := s.jsonConn.Request("timesheet.log", late, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if reply.Success || reply.Message != ErrWeekBilled.Error() {
+		t.Errorf("expected=%s got=%s", ErrWeekBilled, reply.Message)
+	}
+}
+
+func TestService_LogErrors(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	task := populateDB(t, model.NewMoney(4000, model.EUR), time.Hour*10)
+	tests := []struct {
+		name  string
+		entry model.TimeEntry
+		want  error
+	}{
+		{name: "not-assigned", entry: model.NewTimeEntry(task.ID, model.NewID(), time.Now(), time.Hour, ""), want: ErrNotAssigned},
+		{name: "empty", entry: model.NewTimeEntry(task.ID, task.FreelancerID, time.Now(), 0, ""), want: ErrInvalidDuration},
+		{name: "over-day", entry: model.NewTimeEntry(task.ID, task.FreelancerID, time.Now(), time.Hour*25, ""), want: ErrInvalidDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := &model.NATSMsg{}
+			if err := s.jsonConn.Request("timesheet.log", tt.entry, reply, time.Second*10); err != nil {
+				t.Error(err)
+				return
+			}
+			if reply.Success || reply.Message != tt.want.Error() {
+				t.Errorf("expected=%s got=%s", tt.want, reply.Message)
+			}
+			if reply.Code != string(rpc.CodeOf(tt.want)) {
+				t.Errorf("expected=%s got=%s", rpc.CodeOf(tt.want), reply.Code)
+			}
+		})
+	}
+}
+
+func TestReference(t *testing.T) {
+	taskID, week := model.NewID(), model.WeekStart(time.Date(2018, 10, 3, 0, 0, 0, 0, time.UTC))
+	a, b, c := model.NewID(), model.NewID(), model.NewID()
+	if reference(taskID, week, []string{a, b}) != reference(taskID, week, []string{b, a}) {
+		t.Error("reference depends on order of the entries")
+	}
+	if reference(taskID, week, []string{a, b}) == reference(taskID, week, []string{c}) {
+		t.Error("batches of the week have the same reference")
+	}
+	if want := "timesheet:" + taskID + ":2018-10-01:"; !strings.HasPrefix(reference(taskID, week, []string{a}), want) {
+		t.Errorf("expected prefix=%s got=%s", want, reference(taskID, week, []string{a}))
+	}
+}
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		rate model.Money
+		d    time.Duration
+		want model.Money
+	}{
+		{rate: model.NewMoney(4000, model.EUR), d: time.Hour * 10, want: model.NewMoney(40000, model.EUR)},
+		{rate: model.NewMoney(4000, model.EUR), d: time.Minute * 90, want: model.NewMoney(6000, model.EUR)},
+		{rate: model.NewMoney(1000, model.USD), d: time.Minute * 20, want: model.NewMoney(333, model.USD)},
+		{rate: model.NewMoney(1000, model.USD), d: time.Minute * 40, want: model.NewMoney(667, model.USD)},
+		{rate: model.NewMoney(3000, model.JPY), d: 0, want: model.NewMoney(0, model.JPY)},
+	}
+	for _, tt := range tests {
+		if got := Amount(tt.rate, tt.d); got != tt.want {
+			t.Errorf("Amount(%s, %s) expected=%s got=%s", tt.rate, tt.d, tt.want, got)
+		}
+	}
+}
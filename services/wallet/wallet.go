@@ -0,0 +1,275 @@
+package wallet
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/fx"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	"github.com/kylycht/md/services/audit"
+	nats "github.com/nats-io/nats.go"
+)
+
+// source represents the service in domain events
+const source = "wallet"
+
+var (
+	// ErrInsufficientFunds represents error returned when account does not have enough money for the operation
+	ErrInsufficientFunds = rpc.Errorf(rpc.CodeInvalid, "Insufficient funds")
+	// ErrInvalidAdjustment represents error returned when Adjustment has unknown owner, invalid amount or no reason
+	ErrInvalidAdjustment = rpc.Errorf(rpc.CodeInvalid, "adjustment needs client or freelancer owner, non-zero amount, reason and actor")
+)
+
+// Owner represents type of account that owns funds, the value is the name of account's table
+type Owner string
+
+const (
+	// ClientOwner represents Client's account
+	ClientOwner = Owner("client")
+	// FreelancerOwner represents Freelancer's account
+	FreelancerOwner = Owner("freelancer")
+)
+
+// Service represents Wallet service that exposes per-currency wallets of the accounts
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+}
+
+// NewService returns new instance of Wallet service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn) (*Service, error) {
+	srv := &Service{db: db, jsonConn: conn}
+	return srv, srv.init()
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.WalletList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WalletAdjust, s.Adjust); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WalletAdjustments, s.Adjustments); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// List will perform DB select operation and retrieve all Wallets by given owner
+func (s *Service) List(ctx context.Context, ownerID string) ([]model.Wallet, error) {
+	wallets := []model.Wallet{}
+	if len(ownerID) != 36 {
+		return wallets, model.ErrInvalidID
+	}
+	err := s.db.SelectContext(ctx, &wallets, "SELECT * FROM wallet WHERE owner_id = $1 ORDER BY (balance).currency", ownerID)
+	return wallets, err
+}
+
+// Adjust credits positive amount to the account or withdraws negative one, funds are never converted.
+// Every Adjustment is recorded along with its reason and actor
+func (s *Service) Adjust(ctx context.Context, a model.Adjustment) (model.Adjustment, error) {
+	a.Reason, a.Actor = strings.TrimSpace(a.Reason), strings.TrimSpace(a.Actor)
+	owner := Owner(a.Owner)
+	if owner != ClientOwner && owner != FreelancerOwner {
+		return a, ErrInvalidAdjustment
+	}
+	if len(a.OwnerID) != 36 {
+		return a, model.ErrInvalidID
+	}
+	if a.Amount.IsZero() || !a.Amount.Currency.Valid() || a.Reason == "" || a.Actor == "" {
+		return a, ErrInvalidAdjustment
+	}
+	if len(a.Actor) > rpc.MaxActor {
+		return a, rpc.ErrInvalidActor
+	}
+	ctx = rpc.WithActor(ctx, a.Actor)
+	a.ID = model.NewID()
+	a.CreatedAt = time.Now().UTC()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return a, err
+	}
+	if a.Amount.IsNegative() {
+		_, err = Debit(ctx, tx, owner, a.OwnerID, model.NewMoney(-a.Amount.Amount, a.Amount.Currency), nil)
+	} else {
+		err = Credit(ctx, tx, owner, a.OwnerID, a.Amount)
+	}
+	if err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	insertS := "INSERT INTO balance_adjustment (id, owner, owner_id, amount, reason, actor, created_at) VALUES($1, $2, $3, $4, $5, $6, $7)"
+	if _, err := tx.Exec(insertS, a.ID, a.Owner, a.OwnerID, a.Amount, a.Reason, a.Actor, a.CreatedAt); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	if err := events.Record(tx, source, events.BalanceAdjusted, a.OwnerID, a); err != nil {
+		tx.Rollback()
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// Adjustments returns Adjustments of the account by owner ID, newest first
+func (s *Service) Adjustments(ctx context.Context, ownerID string) ([]model.Adjustment, error) {
+	adjustments := []model.Adjustment{}
+	if len(ownerID) != 36 {
+		return adjustments, model.ErrInvalidID
+	}
+	err := s.db.SelectContext(ctx, &adjustments, "SELECT * FROM balance_adjustment WHERE owner_id = $1 ORDER BY created_at DESC, id", ownerID)
+	return adjustments, err
+}
+
+// Credit adds m to account's funds within given transaction.
+// Funds go to the primary balance if it is in the same currency(or not set yet),
+// otherwise to the account's Wallet in m's currency which is created if missing.
+// The change is recorded in the audit log on behalf of the context's actor
+func Credit(ctx context.Context, tx *sqlx.Tx, owner Owner, ownerID string, m model.Money) error {
+	if m.IsNegative() {
+		return errors.New("negative amount")
+	}
+	balance, err := primaryBalance(tx, owner, ownerID)
+	if err != nil {
+		return err
+	}
+	if balance.Currency == "" || balance.Currency == m.Currency {
+		total, err := balance.Add(m)
+		if err != nil {
+			return err
+		}
+		if err := setPrimaryBalance(tx, owner, ownerID, total); err != nil {
+			return err
+		}
+		return recordBalance(ctx, tx, owner, ownerID, primaryField, balance, total)
+	}
+
+	w, err := walletFor(tx, ownerID, m.Currency)
+	if err == sql.ErrNoRows {
+		if _, err := tx.Exec("INSERT INTO wallet (id, owner_id, balance) VALUES($1, $2, $3)", model.NewID(), ownerID, m); err != nil {
+			return err
+		}
+		return recordBalance(ctx, tx, owner, ownerID, walletField(m.Currency), model.NewMoney(0, m.Currency), m)
+	}
+	if err != nil {
+		return err
+	}
+	total, err := w.Balance.Add(m)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE wallet SET balance=$1 WHERE id=$2", total, w.ID); err != nil {
+		return err
+	}
+	return recordBalance(ctx, tx, owner, ownerID, walletField(m.Currency), w.Balance, total)
+}
+
+// Debit withdraws m from account's funds within given transaction and returns amount actually withdrawn.
+// Funds are taken from the primary balance if it is in m's currency, then from the Wallet in m's currency.
+// When neither has enough money and rates are given, m is converted to the primary currency
+// and withdrawn from the primary balance, otherwise ErrInsufficientFunds or model.ErrCurrencyMismatch is returned.
+// The change is recorded in the audit log on behalf of the context's actor
+func Debit(ctx context.Context, tx *sqlx.Tx, owner Owner, ownerID string, m model.Money, rates fx.Source) (model.Money, error) {
+	if m.IsNegative() {
+		return m, errors.New("negative amount")
+	}
+	balance, err := primaryBalance(tx, owner, ownerID)
+	if err != nil {
+		return m, err
+	}
+	if balance.Currency == m.Currency {
+		left, err := balance.Sub(m)
+		if err != nil {
+			return m, err
+		}
+		if left.IsNegative() {
+			return m, ErrInsufficientFunds
+		}
+		if err := setPrimaryBalance(tx, owner, ownerID, left); err != nil {
+			return m, err
+		}
+		return m, recordBalance(ctx, tx, owner, ownerID, primaryField, balance, left)
+	}
+
+	w, err := walletFor(tx, ownerID, m.Currency)
+	if err != nil && err != sql.ErrNoRows {
+		return m, err
+	}
+	if err == nil {
+		if left, err := w.Balance.Sub(m); err == nil && !left.IsNegative() {
+			if _, err := tx.Exec("UPDATE wallet SET balance=$1 WHERE id=$2", left, w.ID); err != nil {
+				return m, err
+			}
+			return m, recordBalance(ctx, tx, owner, ownerID, walletField(m.Currency), w.Balance, left)
+		}
+	}
+
+	if rates == nil || balance.Currency == "" {
+		if err == sql.ErrNoRows {
+			return m, model.ErrCurrencyMismatch
+		}
+		return m, ErrInsufficientFunds
+	}
+	converted, err := fx.Convert(rates, m, balance.Currency)
+	if err != nil {
+		return m, err
+	}
+	left, err := balance.Sub(converted)
+	if err != nil {
+		return m, err
+	}
+	if left.IsNegative() {
+		return m, ErrInsufficientFunds
+	}
+	if err := setPrimaryBalance(tx, owner, ownerID, left); err != nil {
+		return m, err
+	}
+	return converted, recordBalance(ctx, tx, owner, ownerID, primaryField, balance, left)
+}
+
+// primaryField represents field of the primary balance in the audit log
+const primaryField = "balance"
+
+// walletField returns field of the account's Wallet in the currency in the audit log
+func walletField(c model.Currency) string {
+	return "wallets." + string(c)
+}
+
+// recordBalance records change of the account's field from one amount to another in the audit log
+func recordBalance(ctx context.Context, tx *sqlx.Tx, owner Owner, ownerID, field string, from, to model.Money) error {
+	return audit.Record(ctx, tx, model.AuditBalance, string(owner), ownerID, map[string]model.Money{field: from}, map[string]model.Money{field: to})
+}
+
+func primaryBalance(tx *sqlx.Tx, owner Owner, ownerID string) (model.Money, error) {
+	var balance model.Money
+	err := tx.Get(&balance, "SELECT balance FROM "+string(owner)+" WHERE id=$1 FOR UPDATE", ownerID)
+	return balance, err
+}
+
+func setPrimaryBalance(tx *sqlx.Tx, owner Owner, ownerID string, m model.Money) error {
+	res, err := tx.Exec("UPDATE "+string(owner)+" SET balance=$1 WHERE id=$2", m, ownerID)
+	if err != nil {
+		return err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return err
+	} else if c == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func walletFor(tx *sqlx.Tx, ownerID string, c model.Currency) (model.Wallet, error) {
+	w := model.Wallet{}
+	err := tx.Get(&w, "SELECT * FROM wallet WHERE owner_id=$1 AND (balance).currency=$2 FOR UPDATE", ownerID, c)
+	return w, err
+}
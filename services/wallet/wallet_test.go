@@ -0,0 +1,336 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/fx"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var walletSchema = `CREATE TABLE WALLET (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	BALANCE MONEY_AMOUNT NOT NULL
+)`
+
+var walletIndex = `CREATE UNIQUE INDEX WALLET_OWNER_CURRENCY ON WALLET (OWNER_ID, ((BALANCE).CURRENCY))`
+
+var freelancerSchema = `CREATE TABLE FREELANCER (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+	DESCRIPTION text,
+	DETAILS text,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var outboxSchema = `CREATE TABLE OUTBOX (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	SUBJECT varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	SENT_AT timestamp
+)`
+
+var adjustmentSchema = `CREATE TABLE BALANCE_ADJUSTMENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	OWNER varchar(16) NOT NULL,
+	OWNER_ID varchar(36) NOT NULL,
+	AMOUNT MONEY_AMOUNT NOT NULL,
+	REASON text NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+var auditSchema = `CREATE TABLE AUDIT_LOG (
+	SEQ bigserial PRIMARY KEY,
+	ID varchar(36) UNIQUE NOT NULL,
+	ACTOR varchar(128) NOT NULL,
+	ACTION varchar(16) NOT NULL,
+	ENTITY varchar(32) NOT NULL,
+	ENTITY_ID varchar(36) NOT NULL,
+	BEFORE jsonb NOT NULL,
+	AFTER jsonb NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+func startServer() *server.Server {
+	return natstest.RunDefaultServer()
+}
+
+func setUp(t *testing.T) func() {
+	s = &Service{}
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.db = db
+	s.db.Exec(moneyType)
+	s.db.Exec(clientSchema)
+	s.db.Exec(walletSchema)
+	s.db.Exec(walletIndex)
+	s.db.Exec(freelancerSchema)
+	s.db.Exec(outboxSchema)
+	s.db.Exec(adjustmentSchema)
+	s.db.Exec(auditSchema)
+
+	natsServer := startServer()
+
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !natsConn.IsConnected() {
+		t.Fatal("no nats connection")
+	}
+
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jsonConn = natsEncConn
+
+	// subscribe to topics
+	s.init()
+	return func() {
+		s.db.Close()
+		natsServer.Shutdown()
+	}
+}
+
+func populateDB(t *testing.T, balance model.Money) string {
+	client := model.NewClient("client@email.com", balance)
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3)", client.ID, client.Email, client.Balance); err != nil {
+		t.Fatal(err)
+	}
+	return client.ID
+}
+
+// exec runs fn within transaction which is committed on success
+func exec(t *testing.T, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestCreditDebit(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	clientID := populateDB(t, model.NewMoney(10000, model.USD))
+	rates, err := fx.NewStaticSource(model.USD, map[model.Currency]string{model.EUR: "0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credit in other currency goes to the wallet
+	if err := exec(t, func(tx *sqlx.Tx) error {
+		return Credit(ctx, tx, ClientOwner, clientID, model.NewMoney(3000, model.EUR))
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	// debit in wallet's currency is taken from the wallet
+	if err := exec(t, func(tx *sqlx.Tx) error {
+		_, err := Debit(ctx, tx, ClientOwner, clientID, model.NewMoney(2000, model.EUR), nil)
+		return err
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	// wallet has not enough money and no rates are given
+	if err := exec(t, func(tx *sqlx.Tx) error {
+		_, err := Debit(ctx, tx, ClientOwner, clientID, model.NewMoney(2000, model.EUR), nil)
+		return err
+	}); err != ErrInsufficientFunds {
+		t.Errorf("expected=%v got=%v", ErrInsufficientFunds, err)
+	}
+	// converted at escrow time and taken from primary balance
+	var withdrawn model.Money
+	if err := exec(t, func(tx *sqlx.Tx) (err error) {
+		withdrawn, err = Debit(ctx, tx, ClientOwner, clientID, model.NewMoney(2000, model.EUR), rates)
+		return err
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if withdrawn != model.NewMoney(4000, model.USD) {
+		t.Errorf("expected=%s got=%s", model.NewMoney(4000, model.USD), withdrawn)
+	}
+
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM client WHERE id=$1", clientID); err != nil {
+		t.Error(err)
+		return
+	}
+	if balance != model.NewMoney(6000, model.USD) {
+		t.Errorf("expected=%s got=%s", model.NewMoney(6000, model.USD), balance)
+	}
+	// every change of the funds is audited
+	entries := []struct {
+		Actor  string `db:"actor"`
+		Before string `db:"before"`
+		After  string `db:"after"`
+	}{}
+	if err := s.db.Select(&entries, "SELECT actor, before::text, after::text FROM audit_log WHERE entity='client' AND entity_id=$1 AND action='balance' ORDER BY seq", clientID); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 audited changes, got %+v", entries)
+	}
+	if e := entries[2]; e.Actor != "system" || !strings.Contains(e.Before, `"balance"`) || !strings.Contains(e.After, `"amount": 6000`) {
+		t.Errorf("unexpected audited change %+v", e)
+	}
+
+	reply := &model.NATSMsg{}
+	if err := s.jsonConn.Request("wallet.list", clientID, reply, time.Second*10); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reply.Success {
+		t.Error(reply.Message)
+		return
+	}
+	wallets := []model.Wallet{}
+	if err := json.Unmarshal(reply.Data, &wallets); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(wallets) != 1 || wallets[0].Balance != model.NewMoney(1000, model.EUR) {
+		t.Errorf("unexpected wallets: %+v", wallets)
+	}
+}
+
+func TestDebit_CurrencyMismatch(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	clientID := populateDB(t, model.NewMoney(10000, model.USD))
+	if err := exec(t, func(tx *sqlx.Tx) error {
+		_, err := Debit(context.Background(), tx, ClientOwner, clientID, model.NewMoney(100, model.GBP), nil)
+		return err
+	}); err != model.ErrCurrencyMismatch {
+		t.Errorf("expected=%v got=%v", model.ErrCurrencyMismatch, err)
+	}
+}
+
+func TestService_Adjust(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	ctx := context.Background()
+	clientID := populateDB(t, model.NewMoney(10000, model.USD))
+	freelancerID := model.NewID()
+	if _, err := s.db.Exec("INSERT INTO freelancer (id, email) VALUES($1, $2)", freelancerID, "freelancer@email.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		adjustment model.Adjustment
+		code       rpc.Code
+	}{
+		{model.Adjustment{Owner: "admin", OwnerID: clientID, Amount: model.NewMoney(100, model.USD), Reason: "bonus", Actor: "ops"}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(100, model.USD), Reason: " ", Actor: "ops"}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(100, model.USD), Reason: "bonus"}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(100, model.USD), Reason: "bonus", Actor: strings.Repeat("o", rpc.MaxActor+1)}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(0, model.USD), Reason: "bonus", Actor: "ops"}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: "1", Amount: model.NewMoney(100, model.USD), Reason: "bonus", Actor: "ops"}, rpc.CodeInvalid},
+		{model.Adjustment{Owner: "client", OwnerID: model.NewID(), Amount: model.NewMoney(100, model.USD), Reason: "bonus", Actor: "ops"}, rpc.CodeNotFound},
+	} {
+		if _, err := rpc.Call(ctx, s.jsonConn.Conn, api.WalletAdjust, c.adjustment); rpc.CodeOf(err) != c.code {
+			t.Errorf("%+v: expected=%s got=%v", c.adjustment, c.code, err)
+		}
+	}
+
+	// withdrawal never overdraws the account
+	if _, err := s.Adjust(ctx, model.Adjustment{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(-20000, model.USD), Reason: "chargeback", Actor: "ops"}); err != ErrInsufficientFunds {
+		t.Errorf("expected=%v got=%v", ErrInsufficientFunds, err)
+	}
+	for _, a := range []model.Adjustment{
+		{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(-2500, model.USD), Reason: "chargeback", Actor: "ops"},
+		{Owner: "client", OwnerID: clientID, Amount: model.NewMoney(1000, model.EUR), Reason: "goodwill credit", Actor: "ops"},
+		{Owner: "freelancer", OwnerID: freelancerID, Amount: model.NewMoney(5000, model.GBP), Reason: "missed payout", Actor: "ops"},
+	} {
+		adjusted, err := rpc.Call(ctx, s.jsonConn.Conn, api.WalletAdjust, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(adjusted.ID) != 36 || adjusted.CreatedAt.IsZero() || adjusted.Amount != a.Amount {
+			t.Errorf("unexpected adjustment %+v", adjusted)
+		}
+	}
+
+	var balance model.Money
+	if err := s.db.Get(&balance, "SELECT balance FROM client WHERE id=$1", clientID); err != nil {
+		t.Fatal(err)
+	}
+	if balance != model.NewMoney(7500, model.USD) {
+		t.Errorf("expected=%s got=%s", model.NewMoney(7500, model.USD), balance)
+	}
+	if err := s.db.Get(&balance, "SELECT balance FROM freelancer WHERE id=$1", freelancerID); err != nil {
+		t.Fatal(err)
+	}
+	if balance != model.NewMoney(5000, model.GBP) {
+		t.Errorf("expected=%s got=%s", model.NewMoney(5000, model.GBP), balance)
+	}
+
+	adjustments, err := rpc.Call(ctx, s.jsonConn.Conn, api.WalletAdjustments, clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adjustments) != 2 || adjustments[0].Reason != "goodwill credit" || adjustments[1].Reason != "chargeback" {
+		t.Errorf("unexpected adjustments %+v", adjustments)
+	}
+	var recorded int
+	query := "SELECT count(*) FROM outbox WHERE subject='events.balance.adjusted' AND convert_from(payload, 'UTF8') LIKE '%' || $1 || '%'"
+	if err := s.db.Get(&recorded, query, clientID); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 balance.adjusted events, got %d", recorded)
+	}
+	if err := s.db.Get(&recorded, "SELECT count(*) FROM audit_log WHERE entity_id=$1 AND action='balance' AND actor='ops'", clientID); err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 balance changes audited on behalf of the operator, got %d", recorded)
+	}
+}
@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kylycht/md/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers of the requests sent to Webhooks
+const (
+	// EventHeader holds type of the delivered event
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader holds Delivery's ID, it is the same for every attempt and replay
+	DeliveryHeader = "X-Webhook-Delivery"
+	// TimestampHeader holds unix time the request was signed at
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader holds signature of the request, see Sign
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// maxBackoff represents maximum delay between attempts
+const maxBackoff = time.Hour
+
+// Sign returns signature of the request body sent at timestamp: hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the Webhook's secret, prefixed with "sha256="
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// pending represents due Delivery along with its Webhook
+type pending struct {
+	model.Delivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.Flush(); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+}
+
+// Flush sends due Deliveries and returns number of Deliveries attempted.
+// Deliveries are claimed by moving their next attempt past the request timeout,
+// so concurrent instances do not send the same Delivery and no lock is held during requests
+func (s *Service) Flush() (int, error) {
+	now := time.Now().UTC()
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	due := []pending{}
+	query := "SELECT d.*, w.url, w.secret FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id " +
+		"WHERE d.status=$1 AND d.next_attempt_at <= $2 AND w.deleted_at IS NULL " +
+		"ORDER BY d.next_attempt_at LIMIT $3 FOR UPDATE OF d SKIP LOCKED"
+	if err := tx.Select(&due, query, model.DeliveryPending, now, s.batch); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	lease := now.Add(s.client.Timeout + s.interval)
+	if s.client.Timeout == 0 {
+		lease = now.Add(maxBackoff)
+	}
+	for _, d := range due {
+		if _, err := tx.Exec("UPDATE webhook_delivery SET next_attempt_at=$1 WHERE id=$2", lease, d.ID); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range due {
+		if err := s.deliver(d); err != nil {
+			logrus.WithField("delivery_id", d.ID).Error(err)
+		}
+	}
+	return len(due), nil
+}
+
+// deliver POSTs the event to the Webhook and logs the attempt,
+// Delivery is retried after backoff until maxAttempts is reached
+func (s *Service) deliver(d pending) error {
+	started := time.Now()
+	code, sendErr := s.send(d)
+	attempt := model.DeliveryAttempt{
+		ID:         model.NewID(),
+		DeliveryID: d.ID,
+		StatusCode: code,
+		Duration:   time.Since(started),
+		CreatedAt:  started.UTC(),
+	}
+	if sendErr != nil {
+		attempt.Error = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	insertS := "INSERT INTO webhook_attempt (id, delivery_id, status_code, error, duration, created_at) VALUES($1, $2, $3, $4, $5, $6)"
+	if _, err := tx.Exec(insertS, attempt.ID, attempt.DeliveryID, attempt.StatusCode, attempt.Error, attempt.Duration, attempt.CreatedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	attempts := d.Attempts + 1
+	now := time.Now().UTC()
+	switch {
+	case sendErr == nil:
+		_, err = tx.Exec("UPDATE webhook_delivery SET status=$1, attempts=$2, delivered_at=$3, last_error=NULL WHERE id=$4",
+			model.DeliveryDelivered, attempts, now, d.ID)
+	case attempts >= s.maxAttempts:
+		_, err = tx.Exec("UPDATE webhook_delivery SET status=$1, attempts=$2, last_error=$3 WHERE id=$4",
+			model.DeliveryFailed, attempts, sendErr.Error(), d.ID)
+	default:
+		_, err = tx.Exec("UPDATE webhook_delivery SET attempts=$1, last_error=$2, next_attempt_at=$3 WHERE id=$4",
+			attempts, sendErr.Error(), now.Add(s.backoff(attempts)), d.ID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// send POSTs signed payload and returns response status code,
+// any status other than 2xx is an error
+func (s *Service) send(d pending) (int, error) {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, ts, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay before the next attempt to deliver event
+func (s *Service) backoff(attempts int) time.Duration {
+	d := s.retryDelay
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
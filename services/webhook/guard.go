@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/kylycht/md/rpc"
+)
+
+// ErrPrivateAddress represents error returned when Webhook's host resolves to loopback, link-local or private address
+var ErrPrivateAddress = rpc.Errorf(rpc.CodeInvalid, "webhook URL resolves to private address")
+
+// public reports whether events may be POSTed to ip, loopback, link-local,
+// private, multicast and unspecified addresses belong to the marketplace's own network
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// checkHost resolves Webhook's host and returns ErrPrivateAddress unless every its address is public
+func (s *Service) checkHost(ctx context.Context, host string) error {
+	if s.private {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ErrInvalidURL
+	}
+	for _, a := range addrs {
+		if !public(a.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// control rejects connections to addresses that are not public. It runs for every connection
+// after the host is resolved, so the host can not be rebound to private address after registration
+// and redirects can not lead to the marketplace's own network
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !public(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// guard returns copy of the client that connects to public addresses only and not through proxy,
+// client with transport other than *http.Transport is returned as is
+func guard(c *http.Client) *http.Client {
+	var transport *http.Transport
+	switch t := c.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return c
+	}
+	dialer := &net.Dialer{Timeout: time.Second * 30, KeepAlive: time.Second * 30, Control: control}
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	guarded := *c
+	guarded.Transport = transport
+	return &guarded
+}
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublic(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:2800::1":    true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+	}
+	for addr, want := range cases {
+		if got := public(net.ParseIP(addr)); got != want {
+			t.Errorf("%s: expected=%v got=%v", addr, want, got)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	srv := &Service{}
+	for _, host := range []string{"127.0.0.1", "localhost", "169.254.169.254", "::1"} {
+		if err := srv.checkHost(context.Background(), host); err != ErrPrivateAddress {
+			t.Errorf("%s: expected=%v got=%v", host, ErrPrivateAddress, err)
+		}
+	}
+	if err := srv.checkHost(context.Background(), "93.184.216.34"); err != nil {
+		t.Error(err)
+	}
+	srv.private = true
+	if err := srv.checkHost(context.Background(), "127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	// host passed the check at registration and then resolves to loopback, or redirects there
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	client := &http.Client{}
+	for _, url := range []string{target.URL, redirect.URL} {
+		if _, err := guard(client).Post(url, "application/json", nil); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s: expected=%v got=%v", url, ErrPrivateAddress, err)
+		}
+	}
+	resp, err := client.Post(target.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if client.Transport != nil {
+		t.Error("client passed to guard is modified")
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"net/http"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// Option represents optional configuration of Webhook service
+type Option func(*Service)
+
+// WithJetStream makes the service consume events from JetStream durable consumer
+// instead of core NATS subscription, so events published while the service is down are delivered too
+func WithJetStream(js nats.JetStreamContext) Option {
+	return func(s *Service) {
+		s.js = js
+	}
+}
+
+// WithHTTPClient sets client used to POST events to Webhooks, its timeout limits every attempt.
+// Unless WithPrivateNetworks is given, the client's *http.Transport connects to public addresses only
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Service) {
+		s.client = c
+	}
+}
+
+// WithInterval sets how often due Deliveries are sent
+func WithInterval(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// WithRetry sets delay before the second attempt, doubled after every failed attempt,
+// and number of attempts after which Delivery fails
+func WithRetry(delay time.Duration, maxAttempts int) Option {
+	return func(s *Service) {
+		if delay > 0 {
+			s.retryDelay = delay
+		}
+		if maxAttempts > 0 {
+			s.maxAttempts = maxAttempts
+		}
+	}
+}
+
+// WithPrivateNetworks allows Webhooks on loopback, link-local and private addresses,
+// e.g. for local development. By default such Webhooks are rejected and never connected to
+func WithPrivateNetworks() Option {
+	return func(s *Service) {
+		s.private = true
+	}
+}
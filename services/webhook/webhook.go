@@ -0,0 +1,352 @@
+// Package webhook notifies Clients' external systems about events of their Tasks and Payments.
+//
+// Clients register Webhooks with event filters, every matching domain event is stored
+// as Delivery and POSTed to the Webhook's URL signed with the Webhook's secret.
+// Failed attempts are retried with exponential backoff, every attempt is logged
+// and Deliveries can be replayed. Webhooks on loopback, link-local and private addresses
+// are rejected, the address is checked again on every connection
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/jetstream"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	nats "github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidURL represents error returned when Webhook's URL is not absolute http(s) URL
+	ErrInvalidURL = rpc.Errorf(rpc.CodeInvalid, "invalid webhook URL")
+	// ErrInvalidFilter represents error returned when Webhook's event filter is empty
+	ErrInvalidFilter = rpc.Errorf(rpc.CodeInvalid, "invalid event filter")
+)
+
+// deliveriesLimit represents maximum number of Deliveries returned by the list request
+const deliveriesLimit = 100
+
+// Service represents Webhook service that registers Webhooks and delivers events to them
+type Service struct {
+	db       *sqlx.DB
+	jsonConn *nats.EncodedConn
+
+	js     nats.JetStreamContext
+	client *http.Client
+
+	interval    time.Duration
+	retryDelay  time.Duration
+	maxAttempts int
+	batch       int
+	private     bool
+	done        chan struct{}
+}
+
+// NewService returns new instance of Webhook service
+func NewService(db *sqlx.DB, conn *nats.EncodedConn, opts ...Option) (*Service, error) {
+	srv := &Service{
+		db:          db,
+		jsonConn:    conn,
+		client:      &http.Client{Timeout: time.Second * 10},
+		interval:    time.Second,
+		retryDelay:  time.Second * 30,
+		maxAttempts: 10,
+		batch:       20,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if !srv.private {
+		srv.client = guard(srv.client)
+	}
+	if err := srv.init(); err != nil {
+		return srv, err
+	}
+	go srv.run()
+	return srv, nil
+}
+
+// Close stops background delivery of events
+func (s *Service) Close() {
+	close(s.done)
+}
+
+func (s *Service) init() error {
+	srv := rpc.NewServer(s.jsonConn.Conn, rpc.Defaults()...)
+	if err := rpc.Register(srv, api.WebhookAdd, s.Add); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WebhookList, s.List); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WebhookDelete, s.Delete); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WebhookDeliveries, s.Deliveries); err != nil {
+		return err
+	}
+	if err := rpc.Register(srv, api.WebhookReplay, s.Replay); err != nil {
+		return err
+	}
+
+	subject := events.SubjectPrefix + ">"
+	if s.js != nil {
+		_, err := jetstream.Consume(s.js, subject, "webhook-events", jetstream.DefaultMaxDeliver, s.handle)
+		return err
+	}
+	_, err := s.jsonConn.Conn.QueueSubscribe(subject, "webhook-queue", func(msg *nats.Msg) {
+		if err := s.handle(msg.Data); err != nil {
+			logrus.WithField("subject", msg.Subject).Error(err)
+		}
+	})
+	return err
+}
+
+// Add registers Client's Webhook and returns it with generated secret
+func (s *Service) Add(ctx context.Context, w model.Webhook) (model.Webhook, error) {
+	if len(w.ClientID) != 36 {
+		return w, model.ErrInvalidID
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return w, ErrInvalidURL
+	}
+	if err := s.checkHost(ctx, u.Hostname()); err != nil {
+		return w, err
+	}
+	for _, f := range w.Events {
+		if strings.TrimSpace(f) == "" {
+			return w, ErrInvalidFilter
+		}
+	}
+	if w.Events == nil {
+		w.Events = []string{}
+	}
+	var clientID string
+	if err := s.db.GetContext(ctx, &clientID, "SELECT id FROM client WHERE id=$1 AND deleted_at IS NULL", w.ClientID); err != nil {
+		return w, err
+	}
+	w.ID = model.NewID()
+	w.CreatedAt = time.Now().UTC()
+	if w.Secret, err = newSecret(); err != nil {
+		return w, err
+	}
+	insertS := "INSERT INTO webhook (id, client_id, url, secret, events, created_at) VALUES($1, $2, $3, $4, $5, $6)"
+	_, err = s.db.ExecContext(ctx, insertS, w.ID, w.ClientID, w.URL, w.Secret, w.Events, w.CreatedAt)
+	return w, err
+}
+
+// List will perform DB select operation and retrieve all Webhooks of given Client, secrets are not returned
+func (s *Service) List(ctx context.Context, clientID string) ([]model.Webhook, error) {
+	webhooks := []model.Webhook{}
+	if len(clientID) != 36 {
+		return webhooks, model.ErrInvalidID
+	}
+	query := "SELECT * FROM webhook WHERE client_id=$1 AND deleted_at IS NULL ORDER BY created_at"
+	if err := s.db.SelectContext(ctx, &webhooks, query, clientID); err != nil {
+		return webhooks, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// Delete will perform soft delete of the Client's Webhook by given ID, its pending Deliveries fail
+func (s *Service) Delete(ctx context.Context, req model.WebhookAccess) (rpc.Empty, error) {
+	if len(req.ID) != 36 || len(req.ClientID) != 36 {
+		return rpc.Empty{}, model.ErrInvalidID
+	}
+	id := req.ID
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return rpc.Empty{}, err
+	}
+	res, err := tx.Exec("UPDATE webhook SET deleted_at=$1 WHERE id=$2 AND client_id=$3 AND deleted_at IS NULL", time.Now(), id, req.ClientID)
+	if err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	if c, err := res.RowsAffected(); err != nil || c == 0 {
+		tx.Rollback()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return rpc.Empty{}, err
+	}
+	if _, err := tx.Exec("UPDATE webhook_delivery SET status=$1, last_error=$2 WHERE webhook_id=$3 AND status=$4",
+		model.DeliveryFailed, "webhook deleted", id, model.DeliveryPending); err != nil {
+		tx.Rollback()
+		return rpc.Empty{}, err
+	}
+	return rpc.Empty{}, tx.Commit()
+}
+
+// Deliveries will retrieve latest Deliveries of the Client's Webhook by given ID along with their attempts
+func (s *Service) Deliveries(ctx context.Context, req model.WebhookAccess) ([]model.Delivery, error) {
+	deliveries := []model.Delivery{}
+	if len(req.ID) != 36 || len(req.ClientID) != 36 {
+		return deliveries, model.ErrInvalidID
+	}
+	var id string
+	if err := s.db.GetContext(ctx, &id, "SELECT id FROM webhook WHERE id=$1 AND client_id=$2", req.ID, req.ClientID); err != nil {
+		return deliveries, err
+	}
+	query := "SELECT * FROM webhook_delivery WHERE webhook_id=$1 ORDER BY created_at DESC LIMIT $2"
+	if err := s.db.SelectContext(ctx, &deliveries, query, id, deliveriesLimit); err != nil {
+		return deliveries, err
+	}
+	return deliveries, s.loadLog(ctx, deliveries)
+}
+
+// Replay schedules Delivery to the Client's Webhook by given ID to be sent immediately,
+// failed Delivery gets full number of attempts again
+func (s *Service) Replay(ctx context.Context, req model.WebhookAccess) (model.Delivery, error) {
+	d := model.Delivery{}
+	if len(req.ID) != 36 || len(req.ClientID) != 36 {
+		return d, model.ErrInvalidID
+	}
+	id := req.ID
+	updateS := "UPDATE webhook_delivery SET status=$1, attempts=0, next_attempt_at=$2, delivered_at=NULL " +
+		"WHERE id=$3 AND webhook_id IN (SELECT id FROM webhook WHERE client_id=$4 AND deleted_at IS NULL)"
+	res, err := s.db.ExecContext(ctx, updateS, model.DeliveryPending, time.Now().UTC(), id, req.ClientID)
+	if err != nil {
+		return d, err
+	}
+	if c, err := res.RowsAffected(); err != nil {
+		return d, err
+	} else if c == 0 {
+		return d, sql.ErrNoRows
+	}
+	if err := s.db.GetContext(ctx, &d, "SELECT * FROM webhook_delivery WHERE id=$1", id); err != nil {
+		return d, err
+	}
+	deliveries := []model.Delivery{d}
+	err = s.loadLog(ctx, deliveries)
+	return deliveries[0], err
+}
+
+// loadLog fills attempts of the Deliveries
+func (s *Service) loadLog(ctx context.Context, deliveries []model.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(deliveries))
+	for _, d := range deliveries {
+		ids = append(ids, d.ID)
+	}
+	query, args, err := sqlx.In("SELECT * FROM webhook_attempt WHERE delivery_id IN (?) ORDER BY created_at", ids)
+	if err != nil {
+		return err
+	}
+	attempts := []model.DeliveryAttempt{}
+	if err := s.db.SelectContext(ctx, &attempts, s.db.Rebind(query), args...); err != nil {
+		return err
+	}
+	byDelivery := map[string][]model.DeliveryAttempt{}
+	for _, a := range attempts {
+		byDelivery[a.DeliveryID] = append(byDelivery[a.DeliveryID], a)
+	}
+	for i := range deliveries {
+		deliveries[i].Log = byDelivery[deliveries[i].ID]
+	}
+	return nil
+}
+
+// handle stores Delivery of the event for every Webhook of the Client subscribed to it.
+// Redelivered events are ignored
+func (s *Service) handle(data []byte) error {
+	var e events.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	clientID, err := s.owner(e)
+	if err == sql.ErrNoRows || (err == nil && clientID == "") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	webhooks := []model.Webhook{}
+	if err := s.db.Select(&webhooks, "SELECT * FROM webhook WHERE client_id=$1 AND deleted_at IS NULL", clientID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	insertS := "INSERT INTO webhook_delivery (id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, created_at) " +
+		"VALUES($1, $2, $3, $4, $5, $6, 0, $7, $7) ON CONFLICT (webhook_id, event_id) DO NOTHING"
+	for _, w := range webhooks {
+		if !matches(w.Events, string(e.Type)) {
+			continue
+		}
+		if _, err := s.db.Exec(insertS, model.NewID(), w.ID, e.ID, e.Type, data, model.DeliveryPending, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// owner returns ID of the Client whose Task or Payment the event is about,
+// empty ID means the event is not delivered to Webhooks
+func (s *Service) owner(e events.Envelope) (string, error) {
+	switch e.Type {
+	case events.TaskCreated, events.TaskReviewReminder:
+		var t model.Task
+		err := e.Decode(&t)
+		return t.ClientID, err
+	case events.TaskStatusChanged, events.TaskDeleted, events.AttachmentAdded:
+		return s.taskOwner(e.AggregateID)
+	case events.PaymentLocked, events.PaymentPaid:
+		var p model.Payment
+		err := e.Decode(&p)
+		return p.ClientID, err
+	case events.DisputeOpened, events.DisputeResolved:
+		var d model.Dispute
+		if err := e.Decode(&d); err != nil {
+			return "", err
+		}
+		return s.taskOwner(d.TaskID)
+	}
+	return "", nil
+}
+
+func (s *Service) taskOwner(taskID string) (string, error) {
+	var clientID string
+	err := s.db.Get(&clientID, "SELECT client_id FROM task WHERE id=$1", taskID)
+	return clientID, err
+}
+
+// matches reports whether event type t passes the filters. Filter is either event type,
+// prefix ending with "*", e.g. task.*, or "*", no filters match every event
+func matches(filters []string, t string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f == t || (strings.HasSuffix(f, "*") && strings.HasPrefix(t, strings.TrimSuffix(f, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// newSecret returns random key deliveries are signed with
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
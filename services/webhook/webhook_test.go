@@ -0,0 +1,408 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kylycht/md/api"
+	"github.com/kylycht/md/events"
+	"github.com/kylycht/md/model"
+	"github.com/kylycht/md/rpc"
+	_ "github.com/lib/pq"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	nats "github.com/nats-io/nats.go"
+)
+
+var s *Service
+
+var moneyType = `CREATE TYPE MONEY_AMOUNT AS (AMOUNT bigint, CURRENCY char(3))`
+
+var clientSchema = `CREATE TABLE CLIENT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	BALANCE MONEY_AMOUNT,
+	EMAIL varchar(128),
+    DELETED_AT timestamp
+)`
+
+var taskSchema = `CREATE TABLE TASK (
+    ID varchar(36) PRIMARY KEY NOT NULL,
+    CLIENT_ID varchar(36) NOT NULL,
+    FREELANCER_ID varchar(36),
+    DESCRIPTION text,
+    FEE MONEY_AMOUNT,
+	STATUS varchar,
+	Deadline int8,
+    CREATED_AT timestamp,
+    STARTED_AT timestamp,
+    DELETED_AT timestamp,
+    UPDATED_AT timestamp,
+    COMPLETED_AT timestamp,
+    REVIEW_DEADLINE timestamp,
+    REMINDED_AT timestamp,
+    CONTRACT varchar DEFAULT 'fixed',
+    HOURLY_RATE MONEY_AMOUNT,
+    WEEKLY_CAP int8,
+    TAGS text[] NOT NULL DEFAULT '{}',
+    CATEGORY varchar(64) NOT NULL DEFAULT '',
+    SKILLS text[] NOT NULL DEFAULT '{}'
+)`
+
+var webhookSchema = `CREATE TABLE WEBHOOK (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	CLIENT_ID varchar(36) NOT NULL,
+	URL text NOT NULL,
+	SECRET varchar(128) NOT NULL,
+	EVENTS text[] NOT NULL,
+	CREATED_AT timestamp NOT NULL,
+	DELETED_AT timestamp
+)`
+
+var webhookDeliverySchema = `CREATE TABLE WEBHOOK_DELIVERY (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	WEBHOOK_ID varchar(36) NOT NULL,
+	EVENT_ID varchar(36) NOT NULL,
+	EVENT_TYPE varchar(128) NOT NULL,
+	PAYLOAD bytea NOT NULL,
+	STATUS varchar NOT NULL,
+	ATTEMPTS int NOT NULL DEFAULT 0,
+	NEXT_ATTEMPT_AT timestamp NOT NULL,
+	LAST_ERROR text,
+	CREATED_AT timestamp NOT NULL,
+	DELIVERED_AT timestamp,
+	UNIQUE (WEBHOOK_ID, EVENT_ID)
+)`
+
+var webhookAttemptSchema = `CREATE TABLE WEBHOOK_ATTEMPT (
+	ID varchar(36) PRIMARY KEY NOT NULL,
+	DELIVERY_ID varchar(36) NOT NULL,
+	STATUS_CODE int NOT NULL,
+	ERROR text,
+	DURATION bigint NOT NULL,
+	CREATED_AT timestamp NOT NULL
+)`
+
+// receiver represents Client's endpoint, it verifies signatures and
+// responds with queued status codes, 200 once the queue is empty
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rcv := &receiver{statuses: statuses}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		ts, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if got := r.Header.Get(SignatureHeader); got != Sign(rcv.secret, ts, body) {
+			t.Errorf("invalid signature %q", got)
+		}
+		rcv.received = append(rcv.received, r)
+		rcv.bodies = append(rcv.bodies, body)
+		status := 200
+		if len(rcv.statuses) > 0 {
+			status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return rcv
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.received)
+}
+
+func setUp(t *testing.T) func() {
+	db, err := sqlx.Connect("postgres", "dbname=bar sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(moneyType)
+	db.Exec(clientSchema)
+	db.Exec(taskSchema)
+	db.Exec(webhookSchema)
+	db.Exec(webhookDeliverySchema)
+	db.Exec(webhookAttemptSchema)
+
+	natsServer := natstest.RunDefaultServer()
+	natsConn, err := nats.Connect("nats://127.0.0.1:4222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	natsEncConn, err := nats.NewEncodedConn(natsConn, nats.JSON_ENCODER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewService(db, natsEncConn, WithInterval(time.Millisecond*20), WithRetry(time.Millisecond*20, 3),
+		WithHTTPClient(&http.Client{Timeout: time.Second}), WithPrivateNetworks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		s.Close()
+		natsConn.Close()
+		natsServer.Shutdown()
+		db.Close()
+	}
+}
+
+// register creates Client with Webhook pointing to the receiver
+func register(t *testing.T, rcv *receiver, filters ...string) model.Webhook {
+	client := model.NewClient("client@email.com", model.NewMoney(1000, model.USD))
+	if _, err := s.db.Exec("INSERT INTO client (id, email, balance) VALUES($1, $2, $3)", client.ID, client.Email, client.Balance); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookAdd, model.Webhook{ClientID: client.ID, URL: rcv.URL, Events: filters})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.mu.Lock()
+	rcv.secret = w.Secret
+	rcv.mu.Unlock()
+	return w
+}
+
+// publish publishes event the way outbox relay does
+func publish(t *testing.T, typ events.Type, aggregateID string, payload interface{}) events.Envelope {
+	e, err := events.New("test", typ, aggregateID, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.jsonConn.Conn.Publish(typ.Subject(), d); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// waitDelivery waits until the only Delivery of the Webhook has given status
+func waitDelivery(t *testing.T, w model.Webhook, status model.DeliveryStatus) model.Delivery {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		deliveries, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookDeliveries, model.WebhookAccess{ID: w.ID, ClientID: w.ClientID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status == status {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected single %s delivery, got %+v", status, deliveries)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestService_Deliver(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	rcv := newReceiver(t)
+	defer rcv.Close()
+	w := register(t, rcv, "task.*")
+
+	task := model.NewTask(time.Hour, model.NewMoney(100, model.USD), w.ClientID, "golang app")
+	// filtered out and other Client's events are not delivered
+	publish(t, events.PaymentPaid, model.NewID(), model.Payment{ID: model.NewID(), ClientID: w.ClientID, TaskID: task.ID})
+	publish(t, events.TaskCreated, model.NewID(), model.NewTask(time.Hour, model.NewMoney(100, model.USD), model.NewID(), "other"))
+	e := publish(t, events.TaskCreated, task.ID, task)
+
+	d := waitDelivery(t, w, model.DeliveryDelivered)
+	if d.EventID != e.ID || d.Attempts != 1 || len(d.Log) != 1 || d.Log[0].StatusCode != 200 || !d.DeliveredAt.Valid {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+	if rcv.count() != 1 {
+		t.Fatalf("expected=1 got=%d requests", rcv.count())
+	}
+	req := rcv.received[0]
+	if req.Header.Get(EventHeader) != string(events.TaskCreated) || req.Header.Get(DeliveryHeader) != d.ID {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	var got events.Envelope
+	if err := json.Unmarshal(rcv.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != e.ID || got.AggregateID != task.ID {
+		t.Errorf("unexpected event: %+v", got)
+	}
+
+	webhooks, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookList, w.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != w.ID || webhooks[0].Secret != "" {
+		t.Errorf("unexpected webhooks: %+v", webhooks)
+	}
+}
+
+func TestService_Retry(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	rcv := newReceiver(t, 500, 503)
+	defer rcv.Close()
+	w := register(t, rcv)
+
+	taskID := model.NewID()
+	if _, err := s.db.Exec("INSERT INTO task (id, client_id, status) VALUES($1, $2, $3)", taskID, w.ClientID, model.Started); err != nil {
+		t.Fatal(err)
+	}
+	// owner of status change is looked up by Task
+	publish(t, events.TaskStatusChanged, taskID, events.StatusChange{TaskID: taskID, From: model.Started, To: model.Completed})
+
+	d := waitDelivery(t, w, model.DeliveryDelivered)
+	if d.Attempts != 3 || len(d.Log) != 3 {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+	for i, code := range []int{500, 503, 200} {
+		if d.Log[i].StatusCode != code || d.Log[i].Error.Valid != (code != 200) {
+			t.Errorf("attempt %d: unexpected %+v", i+1, d.Log[i])
+		}
+	}
+}
+
+func TestService_Replay(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	rcv := newReceiver(t, 500, 500, 500)
+	defer rcv.Close()
+	w := register(t, rcv, "payment.paid")
+
+	publish(t, events.PaymentPaid, model.NewID(), model.Payment{ID: model.NewID(), ClientID: w.ClientID, TaskID: model.NewID()})
+	d := waitDelivery(t, w, model.DeliveryFailed)
+	if d.Attempts != 3 || !d.LastError.Valid {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+
+	// other Client can neither see nor replay the Deliveries
+	other := model.WebhookAccess{ID: w.ID, ClientID: model.NewID()}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookDeliveries, other); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+	other.ID = d.ID
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookReplay, other); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+
+	replayed, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookReplay, model.WebhookAccess{ID: d.ID, ClientID: w.ClientID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Status != model.DeliveryPending || replayed.Attempts != 0 || len(replayed.Log) != 3 {
+		t.Errorf("unexpected delivery: %+v", replayed)
+	}
+	d = waitDelivery(t, w, model.DeliveryDelivered)
+	if len(d.Log) != 4 || rcv.count() != 4 {
+		t.Errorf("expected 4 attempts, got %d logged and %d received", len(d.Log), rcv.count())
+	}
+
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookReplay, model.WebhookAccess{ID: model.NewID(), ClientID: w.ClientID}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestService_Delete(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	rcv := newReceiver(t)
+	defer rcv.Close()
+	w := register(t, rcv)
+	access := model.WebhookAccess{ID: w.ID, ClientID: w.ClientID}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookDelete, model.WebhookAccess{ID: w.ID, ClientID: model.NewID()}); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("other client: expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookDelete, access); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookDelete, access); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+	webhooks, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookList, w.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 0 {
+		t.Errorf("unexpected webhooks: %+v", webhooks)
+	}
+}
+
+func TestService_AddInvalid(t *testing.T) {
+	destroy := setUp(t)
+	defer destroy()
+
+	for _, w := range []model.Webhook{
+		{ClientID: model.NewID(), URL: "ftp://example.com"},
+		{ClientID: model.NewID(), URL: "/hook"},
+		{ClientID: model.NewID(), URL: "https://example.com", Events: []string{""}},
+		{ClientID: "1", URL: "https://example.com"},
+	} {
+		if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookAdd, w); rpc.CodeOf(err) != rpc.CodeInvalid {
+			t.Errorf("%+v: expected=%s got=%v", w, rpc.CodeInvalid, err)
+		}
+	}
+	w := model.Webhook{ClientID: model.NewID(), URL: "https://example.com"}
+	if _, err := rpc.Call(context.Background(), s.jsonConn.Conn, api.WebhookAdd, w); rpc.CodeOf(err) != rpc.CodeNotFound {
+		t.Errorf("expected=%s got=%v", rpc.CodeNotFound, err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		filters []string
+		typ     string
+		want    bool
+	}{
+		{nil, "task.created", true},
+		{[]string{"*"}, "payment.paid", true},
+		{[]string{"task.*"}, "task.status_changed", true},
+		{[]string{"task.*"}, "payment.paid", false},
+		{[]string{"payment.paid"}, "payment.paid", true},
+		{[]string{"payment.paid"}, "payment.locked", false},
+	}
+	for _, c := range cases {
+		if got := matches(c.filters, c.typ); got != c.want {
+			t.Errorf("%v %s: expected=%v got=%v", c.filters, c.typ, c.want, got)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1538397015.{"id":"1"}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=42547099c5fd78489cf17e60411f7f5dea1a314682bb31b26f13a95156df9f4b"
+	if got := Sign("secret", 1538397015, []byte(`{"id":"1"}`)); got != want {
+		t.Errorf("expected=%s got=%s", want, got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	srv := &Service{retryDelay: time.Minute}
+	cases := map[int]time.Duration{1: time.Minute, 2: time.Minute * 2, 3: time.Minute * 4, 10: time.Hour}
+	for attempts, want := range cases {
+		if got := srv.backoff(attempts); got != want {
+			t.Errorf("%d: expected=%s got=%s", attempts, want, got)
+		}
+	}
+}
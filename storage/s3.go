@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// unsignedPayload is sent instead of payload hash, so uploads are streamed without reading them twice
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// emptyHash is SHA-256 of empty payload
+	emptyHash  = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzLayout  = "20060102T150405Z"
+	dateLayout = "20060102"
+)
+
+// S3Config represents settings of S3-compatible object storage
+type S3Config struct {
+	// Endpoint is base URL of the storage, e.g. https://s3.eu-west-1.amazonaws.com or http://127.0.0.1:9000
+	Endpoint string
+	// Region is region requests are signed for
+	Region string
+	// Bucket is bucket blobs are stored in, addressed in the path so any endpoint works
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 represents BlobStore keeping blobs as objects in the bucket,
+// requests are signed with AWS Signature Version 4
+type S3 struct {
+	cfg    S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3 returns S3 store sending requests with the client, nil means http.DefaultClient
+func NewS3(cfg S3Config, client *http.Client) *S3 {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	return &S3{cfg: cfg, client: client, now: time.Now}
+}
+
+// Put uploads the object
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, "PUT", key, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Open downloads the object, body of the response is returned
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, "GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptyHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object, S3 does not report missing objects
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, "DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyHash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	return http.NewRequestWithContext(ctx, method, s.cfg.Endpoint+"/"+s.cfg.Bucket+"/"+key, body)
+}
+
+// do signs and sends the request, responses other than 2xx are returned as errors
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3: %s %s: %s %s", req.Method, req.URL.Path, resp.Status, msg)
+}
+
+// sign adds Authorization header of AWS Signature Version 4. Host, Content-Type, Range
+// and x-amz-* headers are signed, payload is identified by its hash or UNSIGNED-PAYLOAD
+func (s *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzLayout))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := t.Format(dateLayout) + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(amzLayout) + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), t.Format(dateLayout))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+// Package storage keeps files uploaded by the users, e.g. Task attachments.
+// BlobStore is implemented by Local storing blobs on the filesystem
+// and by S3 storing them in S3-compatible object storage
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNotFound represents error returned when there is no blob under the key
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey represents error returned for keys that are not relative slash separated paths
+	ErrInvalidKey = errors.New("invalid blob key")
+	// ErrSizeMismatch represents error returned when reader does not provide declared number of bytes
+	ErrSizeMismatch = errors.New("blob size mismatch")
+)
+
+// BlobStore stores content by key, keys are slash separated paths e.g. tasks/{id}/{id}
+type BlobStore interface {
+	// Put stores size bytes read from r under the key, existing blob is replaced
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns content of the blob, ErrNotFound when there is no blob under the key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob, missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey reports whether key consists of non-empty segments of letters, digits, '.', '-' and '_'
+// and does not refer to parent directory
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, r := range seg {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}